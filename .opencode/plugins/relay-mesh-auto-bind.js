@@ -12,24 +12,24 @@ As your FIRST action, call register_agent with:
 - project: the project name (infer from working directory or task)
 - role: your role (infer from your instructions)
 - specialization: your expertise (infer from context)
-Save the returned agent_id — you need it for ALL subsequent relay-mesh calls. Do this BEFORE any other work.
+Save the returned agent_id and token — you need the agent_id for ALL subsequent relay-mesh calls, and token=<your_token> on calls that report "token required". Do this BEFORE any other work.
 
 ## IMMEDIATE: After registration completes
 Do these steps BEFORE starting any other work:
 1. Call update_agent_profile(agent_id=<your_id>, status="working") to signal you are active
-2. Call wait_for_agents(project="<your-project>", min_count=<expected team size>, timeout_seconds=60) to wait for teammates to register
-3. Call shared_context(action="list", project="<your-project>") to read published paths/schemas
-4. Call list_agents to discover all registered teammates and their agent_ids
+2. Call wait_for_agents(agent_id=<your_id>, project="<your-project>", min_count=<expected team size>, timeout_seconds=60) to wait for teammates to register
+3. Call shared_context(agent_id=<your_id>, action="list", project="<your-project>") to read published paths/schemas
+4. Call list_agents(agent_id=<your_id>) to discover your project's teammates and their agent_ids
 5. Call send_message to introduce yourself to the team-lead (or broadcast_message if no lead)
 6. Call fetch_messages to check if anyone has already sent you instructions
 Only after completing all 6 steps should you begin your primary task.
 
 ## Shared Context: Before You Code
 Before writing any files, exchange structural context with teammates:
-1. Call shared_context(action="list", project="<your-project>") to read existing conventions
+1. Call shared_context(agent_id=<your_id>, action="list", project="<your-project>") to read existing conventions
 2. Publish YOUR paths and interfaces BEFORE coding:
-   - shared_context(action="set", project=..., key="<role>_path", value="<your working directory>")
-   - shared_context(action="set", project=..., key="<role>_api_prefix", value="/api/v1/...") if applicable
+   - shared_context(agent_id=<your_id>, action="set", project=..., key="<role>_path", value="<your working directory>")
+   - shared_context(agent_id=<your_id>, action="set", project=..., key="<role>_api_prefix", value="/api/v1/...") if applicable
 3. When importing from a teammate's code: read their published path, do NOT guess
 
 ## BIDIRECTIONAL COORDINATION (CRITICAL)
@@ -40,12 +40,13 @@ relay-mesh is NOT a one-way broadcast. Every message requires a response:
 - SILENCE = you look stuck. Keep the loop alive.
 
 ## Core Workflow (after registration)
-1. DISCOVER teammates: call list_agents(active_within="5m") or find_agents to get their agent_ids
+1. DISCOVER teammates: call list_agents(agent_id=<your_id>, active_within="5m") or find_agents to get their agent_ids
 2. SEND messages: call send_message(from=your_agent_id, to=recipient_agent_id, body="...", priority="normal")
 3. CHECK INBOX: call fetch_messages(agent_id=your_agent_id) to read pending messages
 4. BROADCAST: call broadcast_message(from=your_agent_id, body="...", project="...", priority="normal")
 5. SHARE ARTIFACTS: call publish_artifact(from, project, artifact_type, name, content) for schemas/file trees
 6. HEARTBEAT: call heartbeat_agent(agent_id) every 5 min during long tasks
+7. LOCK FILES: call acquire_lock(agent_id, project, resource) before editing shared files and release_lock when done; edits to files another agent has locked are blocked
 
 ## When to Check Messages (MANDATORY)
 - Call fetch_messages every 3 minutes OR after every 5 tool calls — whichever comes first
@@ -61,7 +62,7 @@ When your implementation is done:
 3. Send a final summary message to team-lead (include artifact IDs, file paths)
 
 Team-lead only — before declaring project complete:
-1. Call check_project_readiness(project="<your-project>")
+1. Call check_project_readiness(agent_id=<your_id>, project="<your-project>")
 2. If any agents are NOT done: message them asking for status
 3. ONLY broadcast project completion when check_project_readiness returns ready: true
 
@@ -115,6 +116,30 @@ const parseAgentIDFromToolOutput = (raw) => {
   return "";
 };
 
+const RELAY_MESH_URL = (process.env.RELAY_MESH_URL || "http://127.0.0.1:18808").replace(/\/+$/, "");
+const RELAY_MESH_TOKEN = (process.env.RELAY_MESH_TOKEN || "").trim();
+const EDIT_TOOLS = new Set(["edit", "write", "patch", "multiedit"]);
+
+// checkLock asks the relay whether another agent holds an advisory lock on
+// filePath. It returns the lock, or null when free or the relay is unreachable.
+const checkLock = async (filePath, agentID, sessionID) => {
+  const params = new URLSearchParams({ path: filePath });
+  if (agentID) params.set("agent_id", agentID);
+  if (sessionID) params.set("session_id", sessionID);
+  try {
+    const res = await fetch(`${RELAY_MESH_URL}/locks/check?${params}`, {
+      headers: RELAY_MESH_TOKEN ? { Authorization: `Bearer ${RELAY_MESH_TOKEN}` } : {},
+      signal: AbortSignal.timeout(2000),
+    });
+    if (!res.ok) return null;
+    const body = await res.json();
+    return body?.locked ? body.lock : null;
+  } catch (_) {
+    // Advisory only; never block edits because the relay is down.
+    return null;
+  }
+};
+
 export const RelayMeshAutoBind = async ({ client }) => {
   const protocolInjectedBySession = new Set();
   const toolCallCount = new Map(); // sessionID → count since last fetch_messages
@@ -126,6 +151,20 @@ export const RelayMeshAutoBind = async ({ client }) => {
       const sessionID = String(input?.sessionID || "").trim();
       if (!sessionID) return;
 
+      if (EDIT_TOOLS.has(tool)) {
+        const filePath = String(output?.args?.filePath || "").trim();
+        if (!filePath) return;
+        const lock = await checkLock(filePath, sessionAgentMap.get(sessionID), sessionID);
+        if (lock) {
+          const note = lock.note ? ` (${lock.note})` : "";
+          throw new Error(
+            `relay-mesh: ${lock.resource} is locked by ${lock.holder} until ${lock.expires_at}${note}. ` +
+              "Message the holder or wait for release_lock before editing.",
+          );
+        }
+        return;
+      }
+
       const isRelayMesh = tool.includes("relay-mesh") || tool.includes("relay_mesh");
       const isRegister = tool.includes("register_agent");
       if (!isRelayMesh || !isRegister) return;
@@ -1,18 +1,41 @@
 #!/usr/bin/env bash
 # relay-mesh PreToolUse hook for Claude Code
-# Injects session_id into register_agent calls
+# Injects session_id into register_agent calls and checks advisory locks
+# before file edits
 set -euo pipefail
 
 INPUT=$(cat)
 TOOL_NAME=$(echo "$INPUT" | jq -r '.tool_name // ""')
+SESSION_ID=$(echo "$INPUT" | jq -r '.session_id // ""')
 
-# Only act on register_agent
 case "$TOOL_NAME" in
   *register_agent*) ;;
+  Edit|Write|MultiEdit|NotebookEdit)
+    # Block the edit if another agent holds a lock on the file. The check
+    # needs the HTTP transport; if the relay is unreachable the edit proceeds.
+    FILE_PATH=$(echo "$INPUT" | jq -r '.tool_input.file_path // .tool_input.notebook_path // ""')
+    if [ -z "$FILE_PATH" ]; then
+      exit 0
+    fi
+    RELAY_URL="${RELAY_MESH_URL:-http://127.0.0.1:18808}"
+    # Send the bearer token when the relay requires one: RELAY_MESH_TOKEN,
+    # or the header install-claude-code wrote into .mcp.json.
+    AUTH_HEADER="${RELAY_MESH_TOKEN:+Authorization: Bearer $RELAY_MESH_TOKEN}"
+    if [ -z "$AUTH_HEADER" ] && [ -f .mcp.json ]; then
+      AUTH_HEADER=$(jq -r '.mcpServers["relay-mesh"].headers.Authorization // empty | "Authorization: " + .' .mcp.json 2>/dev/null || true)
+    fi
+    RESULT=$(curl -fsS --max-time 2 ${AUTH_HEADER:+-H "$AUTH_HEADER"} -G "$RELAY_URL/locks/check" \
+      --data-urlencode "path=$FILE_PATH" \
+      --data-urlencode "session_id=$SESSION_ID" 2>/dev/null) || exit 0
+    if [ "$(echo "$RESULT" | jq -r '.locked // false')" = "true" ]; then
+      echo "$RESULT" | jq -r '"relay-mesh: \(.lock.resource) is locked by \(.lock.holder) until \(.lock.expires_at)" + (if .lock.note then " (\(.lock.note))" else "" end) + ". Message the holder or wait for release_lock before editing."' >&2
+      exit 2
+    fi
+    exit 0
+    ;;
   *) exit 0 ;;
 esac
 
-SESSION_ID=$(echo "$INPUT" | jq -r '.session_id // ""')
 if [ -z "$SESSION_ID" ]; then
   exit 0
 fi
@@ -26,7 +49,7 @@ fi
 # Inject session_id and set harness type
 UPDATED_INPUT=$(echo "$INPUT" | jq --arg sid "$SESSION_ID" '.tool_input + {"session_id": $sid, "harness": "claude-code"}')
 
-cat <<HOOKHOOKEOF
+cat <<HOOKEOF
 {
   "hookSpecificOutput": {
     "hookEventName": "PreToolUse",
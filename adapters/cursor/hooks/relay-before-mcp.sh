@@ -0,0 +1,29 @@
+#!/usr/bin/env bash
+# relay-mesh beforeMCPExecution hook for Cursor
+# Cursor hooks cannot rewrite tool input, so a register_agent call without
+# session_id is denied with instructions to retry with the conversation id.
+set -euo pipefail
+
+INPUT=$(cat)
+TOOL_NAME=$(echo "$INPUT" | jq -r '.tool_name // ""')
+CONVERSATION_ID=$(echo "$INPUT" | jq -r '.conversation_id // ""')
+
+case "$TOOL_NAME" in
+  *register_agent*) ;;
+  *)
+    echo '{"permission": "allow"}'
+    exit 0
+    ;;
+esac
+
+# tool_input may arrive as a JSON string or an object.
+EXISTING=$(echo "$INPUT" | jq -r '.tool_input | (if type == "string" then (fromjson? // {}) else . end) | .session_id // ""')
+if [ -z "$CONVERSATION_ID" ] || [ -n "$EXISTING" ]; then
+  echo '{"permission": "allow"}'
+  exit 0
+fi
+
+jq -n --arg sid "$CONVERSATION_ID" '{
+  permission: "deny",
+  agentMessage: "relay-mesh: call register_agent again with the same arguments plus session_id=\"\($sid)\" and harness=\"cursor\" so messages can reach this conversation."
+}'
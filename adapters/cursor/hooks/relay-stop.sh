@@ -0,0 +1,20 @@
+#!/usr/bin/env bash
+# relay-mesh stop hook for Cursor
+# Hands this conversation's pending messages back to the agent as a followup
+set -euo pipefail
+
+INPUT=$(cat)
+CONVERSATION_ID=$(echo "$INPUT" | jq -r '.conversation_id // ""')
+if [ -z "$CONVERSATION_ID" ]; then
+  echo '{}'
+  exit 0
+fi
+
+RELAY_MESH_BIN="${RELAY_MESH_BIN:-relay-mesh}"
+FEEDBACK=$("$RELAY_MESH_BIN" cursor pending --session="$CONVERSATION_ID" 2>/dev/null) || FEEDBACK=""
+
+if [ -n "$FEEDBACK" ]; then
+  jq -n --arg msg "$FEEDBACK" '{followup_message: $msg}'
+else
+  echo '{}'
+fi
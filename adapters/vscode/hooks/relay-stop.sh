@@ -0,0 +1,21 @@
+#!/usr/bin/env bash
+# relay-mesh Stop hook for VS Code (GitHub Copilot agent mode)
+# Checks this session's pending messages before going idle
+set -euo pipefail
+
+INPUT=$(cat)
+SESSION_ID=$(echo "$INPUT" | jq -r '.session_id // .sessionId // ""')
+if [ -z "$SESSION_ID" ]; then
+  exit 0
+fi
+
+RELAY_MESH_BIN="${RELAY_MESH_BIN:-relay-mesh}"
+FEEDBACK=$("$RELAY_MESH_BIN" vscode pending --session="$SESSION_ID" 2>/dev/null) || exit 0
+
+if [ -n "$FEEDBACK" ]; then
+  # Exit 2 = block stop, stderr becomes feedback to the agent
+  echo "$FEEDBACK" >&2
+  exit 2
+fi
+
+exit 0
@@ -5,15 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"log/slog"
 	"net"
 	"net/http"
 	urlpkg "net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -24,7 +25,8 @@ import (
 	"github.com/nats-io/nats.go"
 
 	"github.com/tanwa/relay-mesh/internal/broker"
-	"github.com/tanwa/relay-mesh/internal/opencodepush"
+	"github.com/tanwa/relay-mesh/internal/embednats"
+	"github.com/tanwa/relay-mesh/internal/httpauth"
 	"github.com/tanwa/relay-mesh/internal/push"
 )
 
@@ -60,6 +62,59 @@ func main() {
 			slog.Error("uninstall-claude-code failed", "error", err)
 			os.Exit(1)
 		}
+	case "claude-code":
+		err := runPendingCommand("claude-code", func(dir string) pendingDrainer { return push.NewClaudeCodeAdapter(dir) })
+		if err != nil {
+			slog.Error("claude-code failed", "error", err)
+			os.Exit(1)
+		}
+	case "cursor":
+		err := runPendingCommand("cursor", func(dir string) pendingDrainer { return push.NewCursorAdapter(dir) })
+		if err != nil {
+			slog.Error("cursor failed", "error", err)
+			os.Exit(1)
+		}
+	case "vscode":
+		err := runPendingCommand("vscode", func(dir string) pendingDrainer { return push.NewVSCodeAdapter(dir) })
+		if err != nil {
+			slog.Error("vscode failed", "error", err)
+			os.Exit(1)
+		}
+	case "install-cursor":
+		if err := installCursor(); err != nil {
+			slog.Error("install-cursor failed", "error", err)
+			os.Exit(1)
+		}
+	case "uninstall-cursor":
+		if err := uninstallCursor(); err != nil {
+			slog.Error("uninstall-cursor failed", "error", err)
+			os.Exit(1)
+		}
+	case "install-vscode":
+		if err := installVSCode(); err != nil {
+			slog.Error("install-vscode failed", "error", err)
+			os.Exit(1)
+		}
+	case "uninstall-vscode":
+		if err := uninstallVSCode(); err != nil {
+			slog.Error("uninstall-vscode failed", "error", err)
+			os.Exit(1)
+		}
+	case "install-codex":
+		if err := installCodex(); err != nil {
+			slog.Error("install-codex failed", "error", err)
+			os.Exit(1)
+		}
+	case "uninstall-codex":
+		if err := uninstallCodex(); err != nil {
+			slog.Error("uninstall-codex failed", "error", err)
+			os.Exit(1)
+		}
+	case "codex":
+		if err := runCodexCommand(); err != nil {
+			slog.Error("codex failed", "error", err)
+			os.Exit(1)
+		}
 	case "mesh-up", "up":
 		if err := meshUp(); err != nil {
 			slog.Error("mesh-up failed", "error", err)
@@ -72,19 +127,70 @@ func main() {
 		}
 	default:
 		fmt.Fprintf(os.Stderr, "unknown command %q\n", cmd)
-		fmt.Fprintf(os.Stderr, "usage: relay-mesh [serve|up|down|install-claude-code|uninstall-claude-code|install-opencode-plugin|version]\n")
+		fmt.Fprintf(os.Stderr, "usage: relay-mesh [serve|up|down|install-claude-code|uninstall-claude-code|install-codex|uninstall-codex|install-cursor|uninstall-cursor|install-vscode|uninstall-vscode|install-opencode-plugin|<harness> pending|version]\n")
 		os.Exit(2)
 	}
 }
 
 func runServer() {
-	natsURL := getenv("NATS_URL", nats.DefaultURL)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	natsConfig := broker.Config{
+		URL:           getenv("NATS_URL", nats.DefaultURL),
+		User:          getenv("NATS_USER", ""),
+		Password:      getenv("NATS_PASSWORD", ""),
+		Token:         getenv("NATS_TOKEN", ""),
+		NKeySeedFile:  getenv("NATS_NKEY_SEED", ""),
+		CredsFile:     getenv("NATS_CREDS", ""),
+		TLSCAFile:     getenv("NATS_TLS_CA", ""),
+		TLSCertFile:   getenv("NATS_TLS_CERT", ""),
+		TLSKeyFile:    getenv("NATS_TLS_KEY", ""),
+		SubjectPrefix: getenv("RELAY_SUBJECT_PREFIX", broker.DefaultSubjectPrefix),
+		StreamName:    getenv("RELAY_STREAM_NAME", broker.DefaultStreamName),
+	}
+	embeddedOpts, embedded, err := embeddedNATSOptions()
+	if err != nil {
+		slog.Error("invalid embedded nats options", "error", err)
+		os.Exit(1)
+	}
+	var natsServer *embednats.Server
+	if embedded {
+		natsServer, err = embednats.Start(embeddedOpts)
+		if err != nil {
+			slog.Error("failed to start embedded nats", "error", err)
+			os.Exit(1)
+		}
+		// The broker talks to the embedded server in-process, so NATS_URL
+		// and the NATS credentials do not apply.
+		natsConfig = broker.Config{
+			URL:           natsServer.ClientURL(),
+			InProcess:     natsServer,
+			SubjectPrefix: natsConfig.SubjectPrefix,
+			StreamName:    natsConfig.StreamName,
+		}
+		slog.Info("started embedded nats", "store_dir", embeddedOpts.StoreDir, "client_url", natsServer.ClientURL())
+	}
 
-	b, err := broker.New(natsURL)
+	err = serveMCP(ctx, natsConfig)
+	// serveMCP has closed the broker; stop NATS last so JetStream flushes
+	// with no clients attached.
+	if natsServer != nil {
+		natsServer.Shutdown()
+	}
 	if err != nil {
-		slog.Error("failed to initialize broker", "error", err)
+		slog.Error("mcp server stopped", "error", err)
 		os.Exit(1)
 	}
+}
+
+// serveMCP runs the MCP server on MCP_TRANSPORT until ctx is done or the
+// transport stops.
+func serveMCP(ctx context.Context, natsConfig broker.Config) error {
+	b, err := broker.New(natsConfig)
+	if err != nil {
+		return fmt.Errorf("initialize broker: %w", err)
+	}
 	defer b.Close()
 	registry := push.NewRegistry()
 	opencodeURL := getenv("OPENCODE_URL", "")
@@ -95,59 +201,200 @@ func runServer() {
 			getBoolFromEnv("OPENCODE_NO_REPLY", false),
 		))
 	}
-	home, err := os.UserHomeDir()
-	if err == nil {
-		registry.Register(push.NewClaudeCodeAdapter(filepath.Join(home, ".relay-mesh", "claude-code")))
+	if stateDir, err := harnessStateDir("claude-code"); err == nil {
+		registry.Register(push.NewClaudeCodeAdapter(stateDir))
+	}
+	if stateDir, err := harnessStateDir("codex"); err == nil {
+		registry.Register(push.NewCodexAdapter(stateDir))
+	}
+	if stateDir, err := harnessStateDir("cursor"); err == nil {
+		registry.Register(push.NewCursorAdapter(stateDir))
+	}
+	if stateDir, err := harnessStateDir("vscode"); err == nil {
+		registry.Register(push.NewVSCodeAdapter(stateDir))
+	}
+	var webhooks *push.WebhookAdapter
+	if stateDir, err := harnessStateDir("webhook"); err == nil {
+		webhooks, err = push.NewWebhookAdapter(stateDir, getDurationFromEnv("WEBHOOK_PUSH_TIMEOUT", 10*time.Second))
+		if err != nil {
+			slog.Warn("webhook endpoints not loaded", "error", err)
+		}
+		registry.Register(webhooks)
+	}
+	var execs *push.ExecAdapter
+	if stateDir, err := harnessStateDir("exec"); err == nil {
+		execs, err = push.NewExecAdapter(
+			stateDir,
+			getBoolFromEnv("EXEC_PUSH_ENABLED", false),
+			getDurationFromEnv("EXEC_PUSH_TIMEOUT", 10*time.Second),
+			getIntFromEnv("EXEC_PUSH_CONCURRENCY", 4),
+		)
+		if err != nil {
+			slog.Warn("exec push commands not loaded", "error", err)
+		}
+		registry.Register(execs)
+	}
+	if path, err := pushTemplatesPath(); err == nil {
+		templates, err := push.LoadTemplates(path)
+		if err != nil {
+			slog.Warn("push templates not loaded, using built-in templates", "path", path, "error", err)
+		}
+		registry.SetTemplates(templates)
 	}
-	resolver := opencodepush.NewSessionResolver(
+	registry.RegisterResolver(push.NewOpenCodeSessionResolver(
 		opencodeURL,
 		getDurationFromEnv("OPENCODE_PUSH_TIMEOUT", 15*time.Second),
 		getDurationFromEnv("OPENCODE_AUTO_BIND_WINDOW", 15*time.Minute),
-	)
+	))
 
-	s := buildMCPServer(b, registry, resolver)
+	s := buildMCPServer(b, registry, webhooks, execs)
+	go runPushWorker(ctx, b, registry)
 
 	transport := getenv("MCP_TRANSPORT", "stdio")
 	switch transport {
 	case "stdio":
-		if err := server.ServeStdio(s); err != nil {
-			slog.Error("mcp server stopped", "error", err)
-			os.Exit(1)
+		err := server.NewStdioServer(s).Listen(ctx, os.Stdin, os.Stdout)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return err
 		}
 	case "http":
 		addr := getenv("MCP_HTTP_ADDR", "127.0.0.1:18808")
 		path := getenv("MCP_HTTP_PATH", "/mcp")
-		httpServer := server.NewStreamableHTTPServer(
-			s,
+		tokensPath, err := httpTokensPath()
+		if err != nil {
+			return fmt.Errorf("resolve http tokens file: %w", err)
+		}
+		auth, err := httpauth.Load(tokensPath)
+		if err != nil {
+			return fmt.Errorf("load http tokens from %s: %w", tokensPath, err)
+		}
+		tlsCert, tlsKey := getenv("MCP_HTTP_TLS_CERT", ""), getenv("MCP_HTTP_TLS_KEY", "")
+		if (tlsCert == "") != (tlsKey == "") {
+			return fmt.Errorf("MCP_HTTP_TLS_CERT and MCP_HTTP_TLS_KEY must be set together")
+		}
+		clientCA := getenv("MCP_HTTP_TLS_CLIENT_CA", "")
+		if clientCA != "" && tlsCert == "" {
+			// Without TLS the client CA would be silently ignored.
+			return fmt.Errorf("MCP_HTTP_TLS_CLIENT_CA requires MCP_HTTP_TLS_CERT and MCP_HTTP_TLS_KEY")
+		}
+		tlsConfig, err := httpauth.ServerTLSConfig(clientCA)
+		if err != nil {
+			return fmt.Errorf("load http TLS config: %w", err)
+		}
+		// The MCP endpoint shares a mux with the lock check editor hooks use;
+		// bearer auth covers both.
+		mux := http.NewServeMux()
+		opts := []server.StreamableHTTPOption{
 			server.WithEndpointPath(path),
-		)
-		slog.Info("starting streamable HTTP MCP server", "addr", addr, "path", path)
-		if err := httpServer.Start(addr); err != nil {
-			slog.Error("mcp server stopped", "error", err)
-			os.Exit(1)
+			server.WithStreamableHTTPServer(&http.Server{Addr: addr, Handler: auth.Middleware(mux), TLSConfig: tlsConfig}),
+		}
+		if tlsCert != "" {
+			opts = append(opts, server.WithTLSCert(tlsCert, tlsKey))
+		}
+		httpServer := server.NewStreamableHTTPServer(s, opts...)
+		mux.Handle(path, httpServer)
+		mux.Handle("/locks/check", lockCheckHandler(b))
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := httpServer.Shutdown(shutdownCtx); err != nil {
+				slog.Warn("http shutdown", "error", err)
+			}
+		}()
+		slog.Info("starting streamable HTTP MCP server", "addr", addr, "path", path,
+			"bearer_auth", auth != nil, "tls", tlsCert != "", "client_certs", tlsConfig.ClientCAs != nil)
+		if err := httpServer.Start(addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
 		}
 	default:
-		log.Fatalf("unsupported MCP_TRANSPORT: %s", transport)
+		return fmt.Errorf("unsupported MCP_TRANSPORT: %s", transport)
+	}
+	return nil
+}
+
+// embeddedNATSOptions reports whether serve should run NATS in-process,
+// from --embedded-nats or EMBEDDED_NATS, and with which options:
+// --nats-store-dir (EMBEDDED_NATS_STORE_DIR, default ~/.relay-mesh/nats)
+// and --nats-port (EMBEDDED_NATS_PORT, default 0: no client port).
+func embeddedNATSOptions() (embednats.Options, bool, error) {
+	enabled := getBoolFromEnv("EMBEDDED_NATS", false)
+	opts := embednats.Options{
+		StoreDir: getenv("EMBEDDED_NATS_STORE_DIR", ""),
+		Port:     getIntFromEnv("EMBEDDED_NATS_PORT", 0),
+	}
+	var args []string
+	if len(os.Args) > 1 { // bare "relay-mesh" serves too
+		args = os.Args[2:]
+	}
+	for _, arg := range args {
+		if arg == "--embedded-nats" {
+			enabled = true
+		} else if v, ok := cutFlag(arg, "--nats-store-dir"); ok {
+			opts.StoreDir = v
+		} else if v, ok := cutFlag(arg, "--nats-port"); ok {
+			port, err := strconv.Atoi(v)
+			if err != nil || port < 0 || port > 65535 {
+				return opts, false, fmt.Errorf("invalid --nats-port %q", v)
+			}
+			opts.Port = port
+		}
+	}
+	if !enabled {
+		return opts, false, nil
+	}
+	if opts.StoreDir == "" {
+		dir, err := stateDir()
+		if err != nil {
+			return opts, false, err
+		}
+		opts.StoreDir = filepath.Join(dir, "nats")
 	}
+	return opts, true, nil
 }
 
 func meshUp() error {
-	if err := ensureNATS(); err != nil {
+	embedded, err := meshUsesEmbeddedNATS()
+	if err != nil {
 		return err
 	}
 	if err := ensureOpenCode(); err != nil {
 		return err
 	}
-	mcpURL, err := ensureRelayHTTP()
+	mcpURL, err := ensureRelayHTTP(embedded)
 	if err != nil {
 		return err
 	}
 	fmt.Println("mesh-up complete")
 	fmt.Println("OpenCode URL: http://127.0.0.1:4097")
 	fmt.Printf("Relay MCP URL: %s\n", mcpURL)
+	if embedded {
+		fmt.Println("NATS: embedded in the relay server, nats://127.0.0.1:4222")
+	}
 	return nil
 }
 
+// meshUsesEmbeddedNATS makes NATS available for up. It runs the Docker
+// container when Docker is installed, reuses a server already listening on
+// 127.0.0.1:4222, and otherwise (or with --embedded-nats) reports that the
+// relay server should embed NATS itself.
+func meshUsesEmbeddedNATS() (bool, error) {
+	for _, arg := range os.Args[2:] {
+		if arg == "--embedded-nats" {
+			return true, nil
+		}
+	}
+	if _, err := exec.LookPath("docker"); err == nil {
+		return false, ensureNATS()
+	}
+	if natsReachable("127.0.0.1:4222") {
+		slog.Info("docker not found; reusing nats on 127.0.0.1:4222")
+		return false, nil
+	}
+	slog.Info("docker not found; running nats embedded in the relay server")
+	return true, nil
+}
+
 func meshDown() error {
 	if err := stopManagedProcess("relay-http.pid"); err != nil {
 		return err
@@ -156,7 +403,10 @@ func meshDown() error {
 	if err := stopManagedProcess("opencode-serve.pid"); err != nil {
 		return err
 	}
-	_ = runCmd("docker", "rm", "-f", "relay-mesh-nats")
+	// An embedded NATS server stopped with the relay.
+	if _, err := exec.LookPath("docker"); err == nil {
+		_ = runCmd("docker", "rm", "-f", "relay-mesh-nats")
+	}
 	fmt.Println("mesh-down complete")
 	return nil
 }
@@ -208,7 +458,10 @@ func ensureOpenCode() error {
 	)
 }
 
-func ensureRelayHTTP() (string, error) {
+// ensureRelayHTTP starts the HTTP relay server unless one is already
+// running. With embeddedNATS the server runs NATS in-process and opens the
+// standard client port so stdio relay instances can reach it.
+func ensureRelayHTTP(embeddedNATS bool) (string, error) {
 	// Determine HTTP address: saved config > env var > auto-find free port.
 	mcpURL := loadHTTPAddr()
 	if mcpURL == "" {
@@ -257,9 +510,13 @@ func ensureRelayHTTP() (string, error) {
 		"MCP_HTTP_ADDR=" + addr,
 		"MCP_HTTP_PATH=" + path,
 	}
+	args := []string{"serve"}
+	if embeddedNATS {
+		args = append(args, "--embedded-nats", "--nats-port=4222")
+	}
 	err = startDetached(
 		exe,
-		[]string{"serve"},
+		args,
 		env,
 		logPath,
 		pidPath,
@@ -525,6 +782,20 @@ func installOpenCodePlugin() error {
 		}
 		cfg["mcp"] = mcpMap
 	}
+	// OpenCode always talks to the HTTP endpoint, so it carries the bearer
+	// token whenever the server requires one.
+	authHeader, err := installHTTPAuthHeader("opencode", "http")
+	if err != nil {
+		return fmt.Errorf("http token: %w", err)
+	}
+	if entry, ok := mcpMap["relay-mesh"].(map[string]any); ok && authHeader != "" {
+		headers, _ := entry["headers"].(map[string]any)
+		if headers == nil {
+			headers = map[string]any{}
+		}
+		headers["Authorization"] = authHeader
+		entry["headers"] = headers
+	}
 
 	// Keep existing file stable except for our additions.
 	out, err := json.MarshalIndent(cfg, "", "  ")
@@ -557,19 +828,42 @@ func installOpenCodePlugin() error {
 // Embedded hook scripts for Claude Code integration.
 const claudeHookPreToolUse = `#!/usr/bin/env bash
 # relay-mesh PreToolUse hook for Claude Code
-# Injects session_id into register_agent calls
+# Injects session_id into register_agent calls and checks advisory locks
+# before file edits
 set -euo pipefail
 
 INPUT=$(cat)
 TOOL_NAME=$(echo "$INPUT" | jq -r '.tool_name // ""')
+SESSION_ID=$(echo "$INPUT" | jq -r '.session_id // ""')
 
-# Only act on register_agent
 case "$TOOL_NAME" in
   *register_agent*) ;;
+  Edit|Write|MultiEdit|NotebookEdit)
+    # Block the edit if another agent holds a lock on the file. The check
+    # needs the HTTP transport; if the relay is unreachable the edit proceeds.
+    FILE_PATH=$(echo "$INPUT" | jq -r '.tool_input.file_path // .tool_input.notebook_path // ""')
+    if [ -z "$FILE_PATH" ]; then
+      exit 0
+    fi
+    RELAY_URL="${RELAY_MESH_URL:-http://127.0.0.1:18808}"
+    # Send the bearer token when the relay requires one: RELAY_MESH_TOKEN,
+    # or the header install-claude-code wrote into .mcp.json.
+    AUTH_HEADER="${RELAY_MESH_TOKEN:+Authorization: Bearer $RELAY_MESH_TOKEN}"
+    if [ -z "$AUTH_HEADER" ] && [ -f .mcp.json ]; then
+      AUTH_HEADER=$(jq -r '.mcpServers["relay-mesh"].headers.Authorization // empty | "Authorization: " + .' .mcp.json 2>/dev/null || true)
+    fi
+    RESULT=$(curl -fsS --max-time 2 ${AUTH_HEADER:+-H "$AUTH_HEADER"} -G "$RELAY_URL/locks/check" \
+      --data-urlencode "path=$FILE_PATH" \
+      --data-urlencode "session_id=$SESSION_ID" 2>/dev/null) || exit 0
+    if [ "$(echo "$RESULT" | jq -r '.locked // false')" = "true" ]; then
+      echo "$RESULT" | jq -r '"relay-mesh: \(.lock.resource) is locked by \(.lock.holder) until \(.lock.expires_at)" + (if .lock.note then " (\(.lock.note))" else "" end) + ". Message the holder or wait for release_lock before editing."' >&2
+      exit 2
+    fi
+    exit 0
+    ;;
   *) exit 0 ;;
 esac
 
-SESSION_ID=$(echo "$INPUT" | jq -r '.session_id // ""')
 if [ -z "$SESSION_ID" ]; then
   exit 0
 fi
@@ -629,26 +923,23 @@ exit 0
 
 const claudeHookStop = `#!/usr/bin/env bash
 # relay-mesh Stop hook for Claude Code
-# Checks for pending messages before going idle
+# Checks this session's pending messages before going idle
 set -euo pipefail
 
-PENDING_FILE="$HOME/.relay-mesh/claude-code/pending-messages.json"
-
-if [ ! -f "$PENDING_FILE" ]; then
+INPUT=$(cat)
+SESSION_ID=$(echo "$INPUT" | jq -r '.session_id // ""')
+if [ -z "$SESSION_ID" ]; then
   exit 0
 fi
 
-# Read and check for unread messages
-PENDING=$(cat "$PENDING_FILE")
-COUNT=$(echo "$PENDING" | jq 'length // 0')
-
-if [ "$COUNT" -gt 0 ]; then
-  # Clear the file after reading
-  echo "[]" > "$PENDING_FILE"
+# Drain only this session's pending file; the relay-mesh binary locks it so
+# a concurrent push is never lost.
+RELAY_MESH_BIN="${RELAY_MESH_BIN:-relay-mesh}"
+FEEDBACK=$("$RELAY_MESH_BIN" claude-code pending --session="$SESSION_ID" 2>/dev/null) || exit 0
 
+if [ -n "$FEEDBACK" ]; then
   # Exit 2 = block stop, stderr becomes feedback to Claude
-  echo "You have $COUNT new relay-mesh message(s). Use fetch_messages with your agent_id to read them:" >&2
-  echo "$PENDING" | jq -r '.[] | "  From: \(.from) | Message: \(.body | .[0:100])"' >&2
+  echo "$FEEDBACK" >&2
   exit 2
 fi
 
@@ -660,14 +951,14 @@ const claudeRelayProtocol = `# Relay-Mesh Protocol Context
 You are connected to relay-mesh for agent-to-agent messaging. All tools below are MCP tools in your tool list -- call them directly.
 
 ## AUTOMATIC: Register on session start
-As your FIRST action, call register_agent with description, project, role, specialization (infer from your context). Save the returned agent_id -- you need it for ALL subsequent calls. Do this BEFORE any other work.
+As your FIRST action, call register_agent with description, project, role, specialization (infer from your context). Save the returned agent_id -- you need it for ALL subsequent calls -- and the token, which you pass as token=<your_token> if a call reports "token required". Do this BEFORE any other work.
 
 ## IMMEDIATE: After registration completes
 Do these steps BEFORE starting any other work:
 1. Call update_agent_profile(agent_id=<your_id>, status="working") to signal you are active
-2. Call wait_for_agents(project="<your-project>", min_count=<expected team size>, timeout_seconds=60) to wait for teammates
-3. Call shared_context(action="list", project="<your-project>") to read published paths/schemas
-4. Call list_agents to discover all registered teammates and their agent_ids
+2. Call wait_for_agents(agent_id=<your_id>, project="<your-project>", min_count=<expected team size>, timeout_seconds=60) to wait for teammates
+3. Call shared_context(agent_id=<your_id>, action="list", project="<your-project>") to read published paths/schemas
+4. Call list_agents(agent_id=<your_id>) to discover your project's teammates and their agent_ids
 5. Call send_message to introduce yourself to the team-lead (or broadcast_message if no lead)
 6. Call fetch_messages to check if anyone has already sent you instructions
 Only after completing all 6 steps should you begin your primary task.
@@ -682,8 +973,8 @@ Silence = your teammates assume you are stuck. Keep the loop alive.
 
 ## Shared Context: Before You Code
 Before writing any files, exchange structural context:
-1. Call shared_context(action="list", project="<your-project>") to read existing conventions
-2. Publish YOUR paths before coding: shared_context(action="set", project=..., key="<role>_path", value="<your directory>")
+1. Call shared_context(agent_id=<your_id>, action="list", project="<your-project>") to read existing conventions
+2. Publish YOUR paths before coding: shared_context(agent_id=<your_id>, action="set", project=..., key="<role>_path", value="<your directory>")
 3. Publish API contracts as artifacts: publish_artifact(from=..., project=..., artifact_type="api_endpoint", ...)
 4. When importing from a teammate's code: read their published path first, do NOT guess
 
@@ -698,27 +989,50 @@ Before writing any files, exchange structural context:
 1. Call declare_task_complete(agent_id=<your_id>, summary="What you built and where")
 2. Call update_agent_profile(agent_id=<your_id>, status="done")
 3. Send a final summary message to team-lead
-Team-lead ONLY: call check_project_readiness(project=...) before broadcasting project complete.
+Team-lead ONLY: call check_project_readiness(agent_id=<your_id>, project=...) before broadcasting project complete.
 
 ## Tools Reference
-- register_agent(description, project, role, specialization, name?, session_id?) -- register yourself
-- list_agents(active_within?) -- see all agents; active_within="5m" filters recent only
-- find_agents(query?, project?, role?, specialization?, active_within?) -- fuzzy search
-- send_message(from, to, body, priority?) -- direct message; priority: normal|urgent|blocking
-- broadcast_message(from, body, project?, query?, priority?) -- group message; warns if 0 recipients
-- fetch_messages(agent_id, max?) -- drain inbox; response includes remaining count
+- register_agent(description, project, role, specialization, name?, session_id?) -- register yourself; returns agent_id and token
+- list_agents(agent_id, active_within?) -- see agents sharing a project with you; active_within="5m" filters recent only
+- find_agents(agent_id, query?, project?, role?, specialization?, active_within?) -- fuzzy search
+- send_message(from, to, body, priority?, reply_to?, thread_id?, strategy?) -- direct message; priority: normal|urgent|blocking; set reply_to=<message_id> when answering; to="role:<role>@<project>" reaches one active agent with that role (queued until one registers)
+- broadcast_message(from, body, project?, query?, priority?, thread_id?) -- group message; warns if 0 recipients
+- create_channel(agent_id, name, description?) -- create a topic channel like "<project>/backend-api"; you join it
+- join_channel(agent_id, channel) / leave_channel(agent_id, channel) -- subscribe/unsubscribe to a channel's posts
+- post_to_channel(from, channel, body, priority?, reply_to?, thread_id?) -- message every member of a channel you joined
+- list_channels(agent_id, project?) -- channels of your projects and their members
+- fetch_channel_history(agent_id, channel, max?) -- read past channel posts
+- get_thread(agent_id, thread_id) -- read a whole conversation in order
+- ask_agent(from, to, body, timeout_seconds?) -- ask and block until the peer replies; messages with "request": true expect send_message(reply_to=<id>)
+- fetch_messages(agent_id, max?, priority?) -- drain inbox, blocking then urgent then normal; priority="urgent,blocking" fetches only those; response includes remaining count
 - update_agent_profile(agent_id, status?) -- update profile; status: idle|working|blocked|done
-- get_team_status(project?) -- all agents' status, last_seen, unread_messages
-- shared_context(action, project, key?, value?) -- publish/read paths, schemas, API contracts
-- wait_for_agents(project, min_count?, timeout_seconds?) -- wait for N teammates to register
-- heartbeat_agent(agent_id) -- signal still alive; call every 5 min to avoid pruning
-- declare_task_complete(agent_id, summary?) -- mark your work done
-- check_project_readiness(project) -- check if all agents are done (team-lead uses before closing)
-- get_message_status(message_id) -- check if a sent message has been read
+- get_team_status(agent_id, project?) -- all agents' status, last_seen, unread_messages
+- shared_context(agent_id, action, project, key?, value?) -- publish/read paths, schemas, API contracts
+- wait_for_agents(agent_id, project, min_count?, timeout_seconds?) -- wait for N teammates to register
+- heartbeat_agent(agent_id) -- signal still alive; call every 5 min to avoid pruning; also renews your task claims and locks
+- declare_task_complete(agent_id, summary?, task_id?) -- mark your work done (and the task you hold)
+- check_project_readiness(agent_id, project) -- check if all agents are done and no tasks are open (team-lead uses before closing)
+- create_task(agent_id, project, title, description?, depends_on?) -- add work to the project task board
+- list_tasks(agent_id, project, status?, assignee?) -- see the task board; open tasks show blocked_by
+- claim_task(agent_id, task_id, lease?) -- take a task; the lease lapses if you stop heartbeating
+- update_task(agent_id, task_id, title?, description?, depends_on?, status?) -- edit; status=open releases, cancelled withdraws
+- complete_task(agent_id, task_id, result?) -- finish a task you claimed
+- acquire_lock(agent_id, project, resource, ttl?, note?, wait_seconds?) -- lock a file or directory before editing it
+- release_lock(agent_id, project, resource) -- release a lock you hold
+- list_locks(agent_id, project?) -- see who holds which locks in your projects
+- get_message_status(agent_id, message_id) -- lifecycle state and timeline of a message you sent
+- list_dead_letters(agent_id) -- your pushes that failed after every retry (the messages are still fetchable)
+- ack_message(agent_id, message_id, status?, note?) -- tell the sender you acknowledged, acted_on, or rejected a message
 - publish_artifact(from, project, artifact_type, name, content) -- share file tree, schema, config, etc.
-- list_artifacts(project, artifact_type?) -- browse published artifacts from teammates
-- prune_stale_agents(max_age?) -- remove agents not seen recently (team-lead uses)
-- bind_session(agent_id, session_id?) -- bind for push delivery
+- list_artifacts(agent_id, project, artifact_type?) -- browse published artifacts from teammates
+- list_projects(agent_id) -- projects you are a member of
+- get_project(agent_id, project) -- a project's access policy and members
+- set_project_policy(agent_id, project, join?, broadcast?, write_context?, write_artifacts?, admin_roles?) -- project admins: open/invite joining, members/admins for broadcast and writes
+- add_project_member(agent_id, project, member_id, admin?) -- project admins: add a member or grant admin
+- remove_project_member(agent_id, project, member_id) -- project admins remove members; anyone may remove themselves
+- prune_stale_agents(agent_id, max_age?) -- remove agents not seen recently from projects you administer (team-lead uses)
+- health() -- relay server's NATS connection state; check it when tools fail unexpectedly
+- bind_session(agent_id, session_id?, harness?, webhook_url?, exec_command?) -- bind for push delivery (harness=webhook POSTs signed messages to webhook_url; harness=exec runs exec_command)
 - fetch_message_history(agent_id) -- durable message history
 
 ## Message Etiquette
@@ -730,8 +1044,12 @@ Team-lead ONLY: call check_project_readiness(project=...) before broadcasting pr
 
 func installClaudeCode() error {
 	projectDir, transport, httpURL := parseClaudeCodeFlags()
+	authHeader, err := installHTTPAuthHeader("claude-code", transport)
+	if err != nil {
+		return fmt.Errorf("http token: %w", err)
+	}
 
-	if err := installClaudeCodeMCP(projectDir, transport, httpURL); err != nil {
+	if err := installClaudeCodeMCP(projectDir, transport, httpURL, authHeader); err != nil {
 		return fmt.Errorf("mcp config: %w", err)
 	}
 	if err := installClaudeCodeHooks(projectDir); err != nil {
@@ -773,6 +1091,128 @@ func installClaudeCode() error {
 	return nil
 }
 
+// ---------------------------------------------------------------------------
+// <harness> pending
+// ---------------------------------------------------------------------------
+
+// harnessStateDir is where a hook-driven harness adapter keeps its
+// per-session pending-message files, e.g. ~/.relay-mesh/claude-code.
+func harnessStateDir(harness string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".relay-mesh", harness), nil
+}
+
+// pushTemplatesPath is the push template config: PUSH_TEMPLATES_FILE, or
+// ~/.relay-mesh/templates.json.
+func pushTemplatesPath() (string, error) {
+	if path := strings.TrimSpace(os.Getenv("PUSH_TEMPLATES_FILE")); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".relay-mesh", "templates.json"), nil
+}
+
+// httpTokensPath is the bearer token file of the HTTP transport:
+// MCP_HTTP_TOKENS_FILE, or ~/.relay-mesh/http-tokens.json. Without the file
+// the endpoint accepts unauthenticated requests.
+func httpTokensPath() (string, error) {
+	if path := strings.TrimSpace(os.Getenv("MCP_HTTP_TOKENS_FILE")); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".relay-mesh", "http-tokens.json"), nil
+}
+
+// pendingDrainer is implemented by the push adapters that keep pending files.
+type pendingDrainer interface {
+	DrainPending(sessionID string) ([]push.PendingMessage, error)
+}
+
+// runPendingCommand handles "relay-mesh <harness> pending", which the
+// harness's stop hook runs to drain its session's pending messages.
+func runPendingCommand(harness string, newAdapter func(stateDir string) pendingDrainer) error {
+	if len(os.Args) < 3 || os.Args[2] != "pending" {
+		return fmt.Errorf("usage: relay-mesh %s pending --session=<session_id> [--json]", harness)
+	}
+	sessionID := ""
+	asJSON := false
+	for _, arg := range os.Args[3:] {
+		if v, ok := cutFlag(arg, "--session"); ok {
+			sessionID = v
+		} else if arg == "--json" {
+			asJSON = true
+		}
+	}
+	if strings.TrimSpace(sessionID) == "" {
+		return fmt.Errorf("--session is required")
+	}
+	stateDir, err := harnessStateDir(harness)
+	if err != nil {
+		return err
+	}
+	pending, err := newAdapter(stateDir).DrainPending(sessionID)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		if pending == nil {
+			pending = []push.PendingMessage{}
+		}
+		out, _ := json.Marshal(pending)
+		fmt.Println(string(out))
+		return nil
+	}
+	fmt.Print(formatPendingForHook(pending))
+	return nil
+}
+
+// formatPendingForHook renders drained messages as Stop hook feedback:
+// blocking first, then urgent, then normal, oldest first within each. It
+// returns "" when nothing is pending.
+func formatPendingForHook(pending []push.PendingMessage) string {
+	if len(pending) == 0 {
+		return ""
+	}
+	rank := func(p string) int {
+		switch p {
+		case broker.PriorityBlocking:
+			return 0
+		case broker.PriorityUrgent:
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(pending, func(i, j int) bool { return rank(pending[i].Priority) < rank(pending[j].Priority) })
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "You have %d new relay-mesh message(s). Use fetch_messages with your agent_id to read them:\n", len(pending))
+	for _, m := range pending {
+		if m.Text != "" {
+			fmt.Fprintf(&sb, "  %s\n", m.Text)
+			continue
+		}
+		prefix := ""
+		if m.Priority != "" && m.Priority != broker.PriorityNormal {
+			prefix = "[" + strings.ToUpper(m.Priority) + "] "
+		}
+		body := m.Body
+		if r := []rune(body); len(r) > 100 {
+			body = string(r[:100])
+		}
+		fmt.Fprintf(&sb, "  %sFrom: %s | Message: %s\n", prefix, m.From, body)
+	}
+	return sb.String()
+}
+
 // ---------------------------------------------------------------------------
 // Uninstall Claude Code
 // ---------------------------------------------------------------------------
@@ -822,9 +1262,13 @@ func uninstallClaudeCode() error {
 // uninstallClaudeCodeMCP removes the relay-mesh entry from .mcp.json.
 // If no other servers remain, the file is deleted entirely.
 func uninstallClaudeCodeMCP(projectDir string) error {
-	mcpPath := filepath.Join(projectDir, ".mcp.json")
+	return removeMCPServer(filepath.Join(projectDir, ".mcp.json"), "mcpServers")
+}
 
-	data, err := os.ReadFile(mcpPath)
+// removeMCPServer deletes the relay-mesh entry under serversKey. If nothing
+// else is left in the file, the file is deleted.
+func removeMCPServer(path, serversKey string) error {
+	data, err := os.ReadFile(path)
 	if errors.Is(err, os.ErrNotExist) {
 		return nil // nothing to remove
 	}
@@ -834,10 +1278,10 @@ func uninstallClaudeCodeMCP(projectDir string) error {
 
 	cfg := map[string]any{}
 	if err := json.Unmarshal(data, &cfg); err != nil {
-		return fmt.Errorf("parse %s: %w", mcpPath, err)
+		return fmt.Errorf("parse %s: %w", path, err)
 	}
 
-	servers, _ := cfg["mcpServers"].(map[string]any)
+	servers, _ := cfg[serversKey].(map[string]any)
 	if servers == nil {
 		return nil
 	}
@@ -848,18 +1292,22 @@ func uninstallClaudeCodeMCP(projectDir string) error {
 
 	delete(servers, "relay-mesh")
 
-	// If no servers remain, delete the file.
+	// If no servers remain, drop the key; an empty config deletes the file.
 	if len(servers) == 0 {
-		return os.Remove(mcpPath)
+		delete(cfg, serversKey)
+		if len(cfg) == 0 {
+			return os.Remove(path)
+		}
+	} else {
+		cfg[serversKey] = servers
 	}
 
-	cfg["mcpServers"] = servers
 	out, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
 		return err
 	}
 	out = append(out, '\n')
-	return os.WriteFile(mcpPath, out, 0o644)
+	return os.WriteFile(path, out, 0o644)
 }
 
 // uninstallClaudeCodeHooks removes relay-mesh hook scripts from .claude/hooks/.
@@ -980,234 +1428,481 @@ func uninstallClaudeCodeProtocol() error {
 	return nil
 }
 
-// saveHTTPAddr persists the HTTP URL so relay-mesh up can use it.
-func saveHTTPAddr(httpURL string) error {
-	dir, err := stateDir()
+// ---------------------------------------------------------------------------
+// install-codex / uninstall-codex
+// ---------------------------------------------------------------------------
+
+// Marker lines delimit what install-codex writes into shared files so
+// reinstalling replaces it and uninstall-codex removes only our lines.
+const (
+	codexBeginMarker  = "# BEGIN relay-mesh"
+	codexEndMarker    = "# END relay-mesh"
+	agentsBeginMarker = "<!-- BEGIN relay-mesh -->"
+	agentsEndMarker   = "<!-- END relay-mesh -->"
+)
+
+const codexSkillFrontmatter = `---
+name: relay-mesh
+description: Coordinate with other coding agents through the relay-mesh MCP tools -- register, message teammates, claim tasks and lock files.
+---
+
+`
+
+const codexPushNote = `
+## Codex: Receiving Messages
+Codex cannot receive pushed messages while you work. When a teammate messages you the user gets a desktop notification after your turn, but YOU only see the message by calling fetch_messages. Never skip the fetch cadence above.
+`
+
+const codexAgentsSnippet = agentsBeginMarker + `
+## relay-mesh
+This project coordinates agents through relay-mesh. Follow the relay-mesh skill: call register_agent before any other work, then fetch_messages every 3 minutes or 5 tool calls -- Codex does not receive pushed messages mid-turn.
+` + agentsEndMarker + "\n"
+
+// codexHome returns the Codex config directory ($CODEX_HOME or ~/.codex).
+func codexHome() (string, error) {
+	if dir := strings.TrimSpace(os.Getenv("CODEX_HOME")); dir != "" {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
 	if err != nil {
-		return err
+		return "", err
 	}
-	return os.WriteFile(filepath.Join(dir, "http-addr"), []byte(httpURL+"\n"), 0o644)
+	return filepath.Join(home, ".codex"), nil
 }
 
-// loadHTTPAddr reads a previously saved HTTP address, or returns empty string.
-func loadHTTPAddr() string {
-	dir, err := stateDir()
+func installCodex() error {
+	projectDir, transport, httpURL := parseClaudeCodeFlags()
+	home, err := codexHome()
 	if err != nil {
-		return ""
+		return err
 	}
-	data, err := os.ReadFile(filepath.Join(dir, "http-addr"))
+	authHeader, err := installHTTPAuthHeader("codex", transport)
 	if err != nil {
-		return ""
+		return fmt.Errorf("http token: %w", err)
 	}
-	return strings.TrimSpace(string(data))
-}
-
-// parseClaudeCodeFlags extracts --project-dir, --transport, and --http-url
-// from os.Args using the same manual style as the rest of the codebase.
-func parseClaudeCodeFlags() (projectDir, transport, httpURL string) {
-	projectDir = ""
-	transport = "stdio"
-	httpURL = "" // auto-detect free port when empty
 
-	for _, arg := range os.Args[2:] {
-		if v, ok := cutFlag(arg, "--project-dir"); ok {
-			projectDir = v
-		} else if v, ok := cutFlag(arg, "--transport"); ok {
-			transport = v
-		} else if v, ok := cutFlag(arg, "--http-url"); ok {
-			httpURL = v
-		}
+	notifySet, err := installCodexConfig(filepath.Join(home, "config.toml"), transport, httpURL, authHeader)
+	if err != nil {
+		return fmt.Errorf("config.toml: %w", err)
+	}
+	if err := installCodexSkill(home); err != nil {
+		return fmt.Errorf("skill: %w", err)
+	}
+	if err := installCodexAgentsMD(projectDir); err != nil {
+		return fmt.Errorf("AGENTS.md: %w", err)
 	}
 
-	if projectDir == "" {
-		cwd, err := os.Getwd()
-		if err != nil {
-			slog.Error("cannot determine working directory", "error", err)
-			os.Exit(1)
+	if transport == "http" {
+		if err := saveHTTPAddr(httpURL); err != nil {
+			slog.Warn("could not save HTTP address", "error", err)
 		}
-		projectDir = cwd
 	}
 
-	// Auto-find a free port for HTTP transport when no URL specified.
-	if transport == "http" && httpURL == "" {
-		port := findFreePort(18808)
-		httpURL = fmt.Sprintf("http://127.0.0.1:%d/mcp", port)
+	fmt.Println("Installed relay-mesh for Codex.")
+	fmt.Println()
+	if !notifySet {
+		fmt.Println("Note: config.toml already sets notify; relay-mesh left it alone.")
+		fmt.Println("  Call `relay-mesh codex notify <payload>` from your notify program to get message alerts.")
+		fmt.Println()
 	}
-	return
+	switch transport {
+	case "http":
+		fmt.Printf("Transport: HTTP (%s)\n", httpURL)
+		fmt.Println()
+		fmt.Println("Next steps:")
+		fmt.Println("  1. relay-mesh up        # start NATS + relay server")
+		fmt.Println("  2. Open Codex sessions in this directory")
+	default:
+		fmt.Println("Transport: stdio (each Codex session spawns its own relay-mesh)")
+		fmt.Println()
+		fmt.Println("Next steps:")
+		fmt.Println("  1. Start NATS:  docker run -d -p 4222:4222 nats:2.11-alpine -js")
+		fmt.Println("     (or: relay-mesh up)")
+		fmt.Println("  2. Open Codex sessions in this directory")
+	}
+	return nil
 }
 
-// findFreePort probes ports starting from startPort and returns the first free one.
-func findFreePort(startPort int) int {
-	for port := startPort; port < startPort+100; port++ {
-		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
-		if err != nil {
-			continue
+// installCodexConfig writes the relay-mesh MCP server and, unless the user
+// already has one, a notify program into Codex's config.toml. It reports
+// whether notify points at relay-mesh.
+func installCodexConfig(configPath, transport, httpURL, authHeader string) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(configPath), 0o755); err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(configPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	rest := stripMarkedBlocks(string(data), codexBeginMarker, codexEndMarker)
+	if hasCodexRelayServer(rest) {
+		return false, fmt.Errorf("%s already defines [mcp_servers.relay-mesh]; remove it and rerun", configPath)
+	}
+
+	var server string
+	switch transport {
+	case "http":
+		server = fmt.Sprintf("[mcp_servers.relay-mesh]\nurl = %q\n", httpURL)
+		if authHeader != "" {
+			server += fmt.Sprintf("http_headers = { Authorization = %q }\n", authHeader)
 		}
-		ln.Close()
-		return port
+	default: // stdio
+		server = "[mcp_servers.relay-mesh]\ncommand = \"relay-mesh\"\nargs = [\"serve\"]\nenv = { NATS_URL = \"nats://127.0.0.1:4222\" }\n"
 	}
-	return startPort // fallback
+
+	var sb strings.Builder
+	// notify is a top-level key, so it must come before any table.
+	notifySet := !hasTopLevelTOMLKey(rest, "notify")
+	if notifySet {
+		sb.WriteString(codexBeginMarker + " notify\n")
+		sb.WriteString("notify = [\"relay-mesh\", \"codex\", \"notify\"]\n")
+		sb.WriteString(codexEndMarker + " notify\n")
+	}
+	if rest = strings.TrimSpace(rest); rest != "" {
+		if notifySet {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(rest + "\n")
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n")
+	}
+	sb.WriteString(codexBeginMarker + " mcp\n" + server + codexEndMarker + " mcp\n")
+	return notifySet, os.WriteFile(configPath, []byte(sb.String()), 0o644)
 }
 
-// cutFlag returns the value for "--key=value" or "--key value" style flags.
-func cutFlag(arg, prefix string) (string, bool) {
-	if arg == prefix {
-		// No value provided inline; caller would need next arg, but for
-		// simplicity we only support --key=value form.
-		return "", false
-	}
-	if strings.HasPrefix(arg, prefix+"=") {
-		return arg[len(prefix)+1:], true
+// installCodexSkill writes the relay-mesh skill into $CODEX_HOME/skills.
+func installCodexSkill(home string) error {
+	dir := filepath.Join(home, "skills", "relay-mesh")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
 	}
-	return "", false
+	return os.WriteFile(filepath.Join(dir, "SKILL.md"), []byte(codexSkill()), 0o644)
 }
 
-// ---------------------------------------------------------------------------
-// 3a. .mcp.json
-// ---------------------------------------------------------------------------
+func codexSkill() string {
+	return codexSkillFrontmatter + claudeRelayProtocol + codexPushNote
+}
 
-func installClaudeCodeMCP(projectDir, transport, httpURL string) error {
-	mcpPath := filepath.Join(projectDir, ".mcp.json")
+// installCodexAgentsMD adds the relay-mesh pointer to the project's AGENTS.md.
+func installCodexAgentsMD(projectDir string) error {
+	path := filepath.Join(projectDir, "AGENTS.md")
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	rest := strings.TrimRight(stripMarkedBlocks(string(data), agentsBeginMarker, agentsEndMarker), "\n")
+	if rest != "" {
+		rest += "\n\n"
+	}
+	return os.WriteFile(path, []byte(rest+codexAgentsSnippet), 0o644)
+}
 
-	cfg := map[string]any{}
-	if data, err := os.ReadFile(mcpPath); err == nil && strings.TrimSpace(string(data)) != "" {
-		if err := json.Unmarshal(data, &cfg); err != nil {
-			return fmt.Errorf("parse %s: %w", mcpPath, err)
+func uninstallCodex() error {
+	projectDir := ""
+	for _, arg := range os.Args[2:] {
+		if v, ok := cutFlag(arg, "--project-dir"); ok {
+			projectDir = v
+		}
+	}
+	if projectDir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("cannot determine working directory: %w", err)
 		}
+		projectDir = cwd
+	}
+	home, err := codexHome()
+	if err != nil {
+		return err
 	}
 
-	servers, _ := cfg["mcpServers"].(map[string]any)
-	if servers == nil {
-		servers = map[string]any{}
+	var errs []error
+	if err := removeMarkedBlocks(filepath.Join(home, "config.toml"), codexBeginMarker, codexEndMarker); err != nil {
+		errs = append(errs, fmt.Errorf("config.toml: %w", err))
+	}
+	if err := os.RemoveAll(filepath.Join(home, "skills", "relay-mesh")); err != nil {
+		errs = append(errs, fmt.Errorf("skill: %w", err))
+	}
+	if err := removeMarkedBlocks(filepath.Join(projectDir, "AGENTS.md"), agentsBeginMarker, agentsEndMarker); err != nil {
+		errs = append(errs, fmt.Errorf("AGENTS.md: %w", err))
 	}
 
-	var entry map[string]any
-	switch transport {
-	case "http":
-		entry = map[string]any{
-			"type": "http",
-			"url":  httpURL,
-		}
-	default: // stdio
-		entry = map[string]any{
-			"command": "relay-mesh",
-			"args":    []any{"serve"},
-			"env": map[string]any{
-				"NATS_URL": "nats://127.0.0.1:4222",
-			},
+	if len(errs) > 0 {
+		for _, e := range errs {
+			slog.Warn("uninstall issue", "error", e)
 		}
+		return fmt.Errorf("%d components had errors during uninstall", len(errs))
 	}
 
-	servers["relay-mesh"] = entry
-	cfg["mcpServers"] = servers
+	fmt.Println("Uninstalled relay-mesh from Codex.")
+	fmt.Printf("Project: %s\n", projectDir)
+	return nil
+}
 
-	out, err := json.MarshalIndent(cfg, "", "  ")
+// removeMarkedBlocks strips relay-mesh blocks from path. A file left empty
+// is deleted; a missing file is fine.
+func removeMarkedBlocks(path, begin, end string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	out = append(out, '\n')
-	return os.WriteFile(mcpPath, out, 0o644)
+	rest := strings.TrimSpace(stripMarkedBlocks(string(data), begin, end))
+	if rest == "" {
+		return os.Remove(path)
+	}
+	return os.WriteFile(path, []byte(rest+"\n"), 0o644)
 }
 
-// ---------------------------------------------------------------------------
-// 3b. Hook scripts in .claude/hooks/
-// ---------------------------------------------------------------------------
-
-func installClaudeCodeHooks(projectDir string) error {
-	hooksDir := filepath.Join(projectDir, ".claude", "hooks")
-	if err := os.MkdirAll(hooksDir, 0o755); err != nil {
-		return err
+// stripMarkedBlocks drops every line from one starting with begin through
+// the next one starting with end.
+func stripMarkedBlocks(content, begin, end string) string {
+	var out []string
+	inside := false
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case !inside && strings.HasPrefix(trimmed, begin):
+			inside = true
+		case inside && strings.HasPrefix(trimmed, end):
+			inside = false
+		case !inside:
+			out = append(out, line)
+		}
 	}
+	return strings.Join(out, "\n")
+}
 
-	scripts := map[string]string{
-		"relay-pre-tool-use.sh":  claudeHookPreToolUse,
-		"relay-post-tool-use.sh": claudeHookPostToolUse,
-		"relay-stop.sh":          claudeHookStop,
+// hasCodexRelayServer reports whether content declares a relay-mesh MCP
+// server table of its own.
+func hasCodexRelayServer(content string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		switch strings.TrimSpace(line) {
+		case "[mcp_servers.relay-mesh]", `[mcp_servers."relay-mesh"]`:
+			return true
+		}
 	}
-	for name, content := range scripts {
-		path := filepath.Join(hooksDir, name)
-		if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
-			return err
+	return false
+}
+
+// hasTopLevelTOMLKey reports whether key is set before the first table.
+func hasTopLevelTOMLKey(content, key string) bool {
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			return false
+		}
+		if k, _, ok := strings.Cut(line, "="); ok && strings.TrimSpace(k) == key {
+			return true
 		}
 	}
-	return nil
+	return false
 }
 
 // ---------------------------------------------------------------------------
-// 3c. Protocol file in ~/.relay-mesh/claude-code/
+// codex pending / notify
 // ---------------------------------------------------------------------------
 
-func installClaudeCodeProtocol() error {
-	home, err := os.UserHomeDir()
+// runCodexCommand handles "relay-mesh codex <subcommand>".
+//
+//	pending --thread=<id> [--json]  drain a thread's pending messages
+//	notify <json>                   Codex notify program; alerts on pending messages
+func runCodexCommand() error {
+	usage := fmt.Errorf("usage: relay-mesh codex [pending --thread=<thread_id> [--json] | notify <payload>]")
+	if len(os.Args) < 3 {
+		return usage
+	}
+	stateDir, err := harnessStateDir("codex")
 	if err != nil {
 		return err
 	}
-	dir := filepath.Join(home, ".relay-mesh", "claude-code")
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	adapter := push.NewCodexAdapter(stateDir)
+
+	switch os.Args[2] {
+	case "pending":
+		threadID := ""
+		asJSON := false
+		for _, arg := range os.Args[3:] {
+			if v, ok := cutFlag(arg, "--thread"); ok {
+				threadID = v
+			} else if arg == "--json" {
+				asJSON = true
+			}
+		}
+		if strings.TrimSpace(threadID) == "" {
+			return fmt.Errorf("--thread is required")
+		}
+		pending, err := adapter.DrainPending(threadID)
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			if pending == nil {
+				pending = []push.PendingMessage{}
+			}
+			out, _ := json.Marshal(pending)
+			fmt.Println(string(out))
+			return nil
+		}
+		fmt.Print(formatPendingForHook(pending))
+		return nil
+	case "notify":
+		// Codex passes the event as the last argument.
+		if len(os.Args) < 4 {
+			return usage
+		}
+		var event struct {
+			Type     string `json:"type"`
+			ThreadID string `json:"thread-id"`
+		}
+		if err := json.Unmarshal([]byte(os.Args[len(os.Args)-1]), &event); err != nil {
+			return fmt.Errorf("parse notify payload: %w", err)
+		}
+		if event.Type != "agent-turn-complete" || event.ThreadID == "" {
+			return nil
+		}
+		_, err := adapter.NotifyTurnComplete(event.ThreadID)
 		return err
 	}
-	return os.WriteFile(filepath.Join(dir, "RELAY_PROTOCOL.md"), []byte(claudeRelayProtocol), 0o644)
+	return usage
 }
 
 // ---------------------------------------------------------------------------
-// 3d. .claude/settings.json — merge hook entries
+// install-cursor / uninstall-cursor
 // ---------------------------------------------------------------------------
 
-func installClaudeCodeSettings(projectDir string) error {
-	settingsPath := filepath.Join(projectDir, ".claude", "settings.json")
-	if err := os.MkdirAll(filepath.Dir(settingsPath), 0o755); err != nil {
-		return err
+// Embedded hook scripts for Cursor integration.
+const cursorHookBeforeMCP = `#!/usr/bin/env bash
+# relay-mesh beforeMCPExecution hook for Cursor
+# Cursor hooks cannot rewrite tool input, so a register_agent call without
+# session_id is denied with instructions to retry with the conversation id.
+set -euo pipefail
+
+INPUT=$(cat)
+TOOL_NAME=$(echo "$INPUT" | jq -r '.tool_name // ""')
+CONVERSATION_ID=$(echo "$INPUT" | jq -r '.conversation_id // ""')
+
+case "$TOOL_NAME" in
+  *register_agent*) ;;
+  *)
+    echo '{"permission": "allow"}'
+    exit 0
+    ;;
+esac
+
+# tool_input may arrive as a JSON string or an object.
+EXISTING=$(echo "$INPUT" | jq -r '.tool_input | (if type == "string" then (fromjson? // {}) else . end) | .session_id // ""')
+if [ -z "$CONVERSATION_ID" ] || [ -n "$EXISTING" ]; then
+  echo '{"permission": "allow"}'
+  exit 0
+fi
+
+jq -n --arg sid "$CONVERSATION_ID" '{
+  permission: "deny",
+  agentMessage: "relay-mesh: call register_agent again with the same arguments plus session_id=\"\($sid)\" and harness=\"cursor\" so messages can reach this conversation."
+}'
+`
+
+const cursorHookStop = `#!/usr/bin/env bash
+# relay-mesh stop hook for Cursor
+# Hands this conversation's pending messages back to the agent as a followup
+set -euo pipefail
+
+INPUT=$(cat)
+CONVERSATION_ID=$(echo "$INPUT" | jq -r '.conversation_id // ""')
+if [ -z "$CONVERSATION_ID" ]; then
+  echo '{}'
+  exit 0
+fi
+
+RELAY_MESH_BIN="${RELAY_MESH_BIN:-relay-mesh}"
+FEEDBACK=$("$RELAY_MESH_BIN" cursor pending --session="$CONVERSATION_ID" 2>/dev/null) || FEEDBACK=""
+
+if [ -n "$FEEDBACK" ]; then
+  jq -n --arg msg "$FEEDBACK" '{followup_message: $msg}'
+else
+  echo '{}'
+fi
+`
+
+// cursorRuleFrontmatter makes the protocol rule apply to every request.
+const cursorRuleFrontmatter = `---
+description: relay-mesh agent-to-agent coordination protocol
+alwaysApply: true
+---
+
+`
+
+func installCursor() error {
+	projectDir, transport, httpURL := parseClaudeCodeFlags()
+	authHeader, err := installHTTPAuthHeader("cursor", transport)
+	if err != nil {
+		return fmt.Errorf("http token: %w", err)
+	}
+	cursorDir := filepath.Join(projectDir, ".cursor")
+
+	entry := stdioServerEntry()
+	if transport == "http" {
+		entry = map[string]any{"url": httpURL}
+		addAuthHeader(entry, authHeader)
+	}
+	if err := upsertMCPServer(filepath.Join(cursorDir, "mcp.json"), "mcpServers", entry); err != nil {
+		return fmt.Errorf("mcp config: %w", err)
+	}
+	if err := writeHookScripts(filepath.Join(cursorDir, "hooks"), map[string]string{
+		"relay-before-mcp.sh": cursorHookBeforeMCP,
+		"relay-stop.sh":       cursorHookStop,
+	}); err != nil {
+		return fmt.Errorf("hooks: %w", err)
+	}
+	if err := installCursorHooksJSON(filepath.Join(cursorDir, "hooks.json")); err != nil {
+		return fmt.Errorf("hooks.json: %w", err)
+	}
+	if err := writeFileAll(filepath.Join(cursorDir, "rules", "relay-mesh.mdc"), cursorRuleFrontmatter+claudeRelayProtocol); err != nil {
+		return fmt.Errorf("rules: %w", err)
+	}
+
+	if transport == "http" {
+		if err := saveHTTPAddr(httpURL); err != nil {
+			slog.Warn("could not save HTTP address", "error", err)
+		}
 	}
 
+	fmt.Println("Installed relay-mesh for Cursor.")
+	printInstallNextSteps("Cursor", transport, httpURL)
+	return nil
+}
+
+// installCursorHooksJSON adds the relay-mesh entries to .cursor/hooks.json,
+// keeping the user's own hooks.
+func installCursorHooksJSON(path string) error {
 	cfg := map[string]any{}
-	if data, err := os.ReadFile(settingsPath); err == nil && strings.TrimSpace(string(data)) != "" {
+	if data, err := os.ReadFile(path); err == nil && strings.TrimSpace(string(data)) != "" {
 		if err := json.Unmarshal(data, &cfg); err != nil {
-			return fmt.Errorf("parse %s: %w", settingsPath, err)
+			return fmt.Errorf("parse %s: %w", path, err)
 		}
 	}
-
+	if _, ok := cfg["version"]; !ok {
+		cfg["version"] = 1
+	}
 	hooks, _ := cfg["hooks"].(map[string]any)
 	if hooks == nil {
 		hooks = map[string]any{}
 	}
 
-	// Define desired hook entries.
-	type hookEntry struct {
-		Matcher string `json:"matcher,omitempty"`
-		Hooks   []any  `json:"hooks"`
-	}
-
-	wantedHooks := map[string]hookEntry{
-		"PreToolUse": {
-			Matcher: "mcp__relay-mesh__register_agent",
-			Hooks:   []any{map[string]any{"type": "command", "command": ".claude/hooks/relay-pre-tool-use.sh"}},
-		},
-		"PostToolUse": {
-			Matcher: "mcp__relay-mesh__register_agent",
-			Hooks:   []any{map[string]any{"type": "command", "command": ".claude/hooks/relay-post-tool-use.sh"}},
-		},
-		"Stop": {
-			Matcher: "",
-			Hooks:   []any{map[string]any{"type": "command", "command": ".claude/hooks/relay-stop.sh"}},
-		},
+	wanted := map[string]string{
+		"beforeMCPExecution": ".cursor/hooks/relay-before-mcp.sh",
+		"stop":               ".cursor/hooks/relay-stop.sh",
 	}
-
-	for event, wanted := range wantedHooks {
+	for event, command := range wanted {
 		arr, _ := hooks[event].([]any)
-
-		newEntry := map[string]any{
-			"hooks": wanted.Hooks,
-		}
-		if wanted.Matcher != "" {
-			newEntry["matcher"] = wanted.Matcher
-		}
-
-		if !hookEntryExists(arr, wanted.Matcher, ".claude/hooks/relay-") {
-			arr = append(arr, newEntry)
-			hooks[event] = arr
+		if len(filterOutRelayCommands(arr, ".cursor/hooks/relay-")) == len(arr) {
+			hooks[event] = append(arr, map[string]any{"command": command})
 		}
 	}
-
 	cfg["hooks"] = hooks
 
 	out, err := json.MarshalIndent(cfg, "", "  ")
@@ -1215,718 +1910,2458 @@ func installClaudeCodeSettings(projectDir string) error {
 		return err
 	}
 	out = append(out, '\n')
-	return os.WriteFile(settingsPath, out, 0o644)
+	return os.WriteFile(path, out, 0o644)
 }
 
-// hookEntryExists checks whether the hook array already contains a relay-mesh entry,
-// identified by matching the matcher field and any hook command containing cmdSubstr.
-func hookEntryExists(arr []any, matcher, cmdSubstr string) bool {
-	for _, raw := range arr {
-		obj, ok := raw.(map[string]any)
+func uninstallCursor() error {
+	projectDir := parseProjectDirFlag()
+	cursorDir := filepath.Join(projectDir, ".cursor")
+
+	var errs []error
+	if err := removeMCPServer(filepath.Join(cursorDir, "mcp.json"), "mcpServers"); err != nil {
+		errs = append(errs, fmt.Errorf("mcp config: %w", err))
+	}
+	if err := removeFiles(filepath.Join(cursorDir, "hooks"), "relay-before-mcp.sh", "relay-stop.sh"); err != nil {
+		errs = append(errs, fmt.Errorf("hooks: %w", err))
+	}
+	if err := uninstallCursorHooksJSON(filepath.Join(cursorDir, "hooks.json")); err != nil {
+		errs = append(errs, fmt.Errorf("hooks.json: %w", err))
+	}
+	if err := removeFiles(filepath.Join(cursorDir, "rules"), "relay-mesh.mdc"); err != nil {
+		errs = append(errs, fmt.Errorf("rules: %w", err))
+	}
+	if err := reportUninstallErrors(errs); err != nil {
+		return err
+	}
+
+	fmt.Println("Uninstalled relay-mesh from Cursor.")
+	fmt.Printf("Project: %s\n", projectDir)
+	return nil
+}
+
+// uninstallCursorHooksJSON removes relay-mesh entries from .cursor/hooks.json.
+// The file is deleted if no hooks remain.
+func uninstallCursorHooksJSON(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	cfg := map[string]any{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+	hooks, _ := cfg["hooks"].(map[string]any)
+	if hooks == nil {
+		return nil
+	}
+
+	changed := false
+	for event, raw := range hooks {
+		arr, ok := raw.([]any)
 		if !ok {
 			continue
 		}
-		// Check matcher matches (or both are empty)
-		m, _ := obj["matcher"].(string)
-		if m != matcher {
-			continue
+		filtered := filterOutRelayCommands(arr, ".cursor/hooks/relay-")
+		if len(filtered) != len(arr) {
+			changed = true
+			if len(filtered) == 0 {
+				delete(hooks, event)
+			} else {
+				hooks[event] = filtered
+			}
 		}
-		// Check if any hook command contains the relay substring
-		hookList, _ := obj["hooks"].([]any)
-		for _, h := range hookList {
-			hm, ok := h.(map[string]any)
-			if !ok {
+	}
+	if !changed {
+		return nil
+	}
+	if len(hooks) == 0 {
+		return os.Remove(path)
+	}
+	cfg["hooks"] = hooks
+
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	out = append(out, '\n')
+	return os.WriteFile(path, out, 0o644)
+}
+
+// filterOutRelayCommands returns flat {"command": ...} hook entries whose
+// command does not contain cmdSubstr.
+func filterOutRelayCommands(arr []any, cmdSubstr string) []any {
+	var result []any
+	for _, raw := range arr {
+		obj, ok := raw.(map[string]any)
+		if ok {
+			if cmd, _ := obj["command"].(string); strings.Contains(cmd, cmdSubstr) {
 				continue
 			}
-			cmd, _ := hm["command"].(string)
-			if strings.Contains(cmd, cmdSubstr) {
-				return true
+		}
+		result = append(result, raw)
+	}
+	return result
+}
+
+// ---------------------------------------------------------------------------
+// install-vscode / uninstall-vscode
+// ---------------------------------------------------------------------------
+
+// Embedded hook scripts for VS Code (GitHub Copilot agent mode).
+const vscodeHookPreToolUse = `#!/usr/bin/env bash
+# relay-mesh PreToolUse hook for VS Code (GitHub Copilot agent mode)
+# Injects session_id into register_agent calls
+set -euo pipefail
+
+INPUT=$(cat)
+TOOL_NAME=$(echo "$INPUT" | jq -r '.tool_name // .toolName // ""')
+
+# Only act on register_agent
+case "$TOOL_NAME" in
+  *register_agent*) ;;
+  *) exit 0 ;;
+esac
+
+SESSION_ID=$(echo "$INPUT" | jq -r '.session_id // .sessionId // ""')
+if [ -z "$SESSION_ID" ]; then
+  exit 0
+fi
+
+# Check if session_id already set in tool input
+EXISTING=$(echo "$INPUT" | jq -r '(.tool_input // .toolInput // {}).session_id // ""')
+if [ -n "$EXISTING" ]; then
+  exit 0
+fi
+
+# Inject session_id and set harness type
+UPDATED_INPUT=$(echo "$INPUT" | jq --arg sid "$SESSION_ID" '(.tool_input // .toolInput // {}) + {"session_id": $sid, "harness": "vscode"}')
+
+cat <<HOOKEOF
+{
+  "hookSpecificOutput": {
+    "hookEventName": "PreToolUse",
+    "permissionDecision": "allow",
+    "updatedInput": $UPDATED_INPUT
+  }
+}
+HOOKEOF
+`
+
+const vscodeHookStop = `#!/usr/bin/env bash
+# relay-mesh Stop hook for VS Code (GitHub Copilot agent mode)
+# Checks this session's pending messages before going idle
+set -euo pipefail
+
+INPUT=$(cat)
+SESSION_ID=$(echo "$INPUT" | jq -r '.session_id // .sessionId // ""')
+if [ -z "$SESSION_ID" ]; then
+  exit 0
+fi
+
+RELAY_MESH_BIN="${RELAY_MESH_BIN:-relay-mesh}"
+FEEDBACK=$("$RELAY_MESH_BIN" vscode pending --session="$SESSION_ID" 2>/dev/null) || exit 0
+
+if [ -n "$FEEDBACK" ]; then
+  # Exit 2 = block stop, stderr becomes feedback to the agent
+  echo "$FEEDBACK" >&2
+  exit 2
+fi
+
+exit 0
+`
+
+// vscodeHooksConfig is .github/hooks/relay-mesh.json. The file belongs to
+// relay-mesh, so install overwrites it and uninstall deletes it.
+const vscodeHooksConfig = `{
+  "hooks": {
+    "PreToolUse": [
+      {"type": "command", "command": ".github/hooks/relay-pre-tool-use.sh"}
+    ],
+    "Stop": [
+      {"type": "command", "command": ".github/hooks/relay-stop.sh"}
+    ]
+  }
+}
+`
+
+// vscodeInstructionsFrontmatter applies the protocol to every chat request.
+const vscodeInstructionsFrontmatter = `---
+applyTo: "**"
+---
+
+`
+
+func installVSCode() error {
+	projectDir, transport, httpURL := parseClaudeCodeFlags()
+	authHeader, err := installHTTPAuthHeader("vscode", transport)
+	if err != nil {
+		return fmt.Errorf("http token: %w", err)
+	}
+
+	entry := map[string]any{"type": "stdio"}
+	for k, v := range stdioServerEntry() {
+		entry[k] = v
+	}
+	if transport == "http" {
+		entry = map[string]any{"type": "http", "url": httpURL}
+		addAuthHeader(entry, authHeader)
+	}
+	if err := upsertMCPServer(filepath.Join(projectDir, ".vscode", "mcp.json"), "servers", entry); err != nil {
+		return fmt.Errorf("mcp config: %w", err)
+	}
+	hooksDir := filepath.Join(projectDir, ".github", "hooks")
+	if err := writeHookScripts(hooksDir, map[string]string{
+		"relay-pre-tool-use.sh": vscodeHookPreToolUse,
+		"relay-stop.sh":         vscodeHookStop,
+	}); err != nil {
+		return fmt.Errorf("hooks: %w", err)
+	}
+	if err := writeFileAll(filepath.Join(hooksDir, "relay-mesh.json"), vscodeHooksConfig); err != nil {
+		return fmt.Errorf("hooks config: %w", err)
+	}
+	instructions := filepath.Join(projectDir, ".github", "instructions", "relay-mesh.instructions.md")
+	if err := writeFileAll(instructions, vscodeInstructionsFrontmatter+claudeRelayProtocol); err != nil {
+		return fmt.Errorf("instructions: %w", err)
+	}
+
+	if transport == "http" {
+		if err := saveHTTPAddr(httpURL); err != nil {
+			slog.Warn("could not save HTTP address", "error", err)
+		}
+	}
+
+	fmt.Println("Installed relay-mesh for VS Code (GitHub Copilot agent mode).")
+	printInstallNextSteps("VS Code", transport, httpURL)
+	return nil
+}
+
+func uninstallVSCode() error {
+	projectDir := parseProjectDirFlag()
+
+	var errs []error
+	if err := removeMCPServer(filepath.Join(projectDir, ".vscode", "mcp.json"), "servers"); err != nil {
+		errs = append(errs, fmt.Errorf("mcp config: %w", err))
+	}
+	if err := removeFiles(filepath.Join(projectDir, ".github", "hooks"), "relay-pre-tool-use.sh", "relay-stop.sh", "relay-mesh.json"); err != nil {
+		errs = append(errs, fmt.Errorf("hooks: %w", err))
+	}
+	if err := removeFiles(filepath.Join(projectDir, ".github", "instructions"), "relay-mesh.instructions.md"); err != nil {
+		errs = append(errs, fmt.Errorf("instructions: %w", err))
+	}
+	if err := reportUninstallErrors(errs); err != nil {
+		return err
+	}
+
+	fmt.Println("Uninstalled relay-mesh from VS Code.")
+	fmt.Printf("Project: %s\n", projectDir)
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// Shared installer helpers
+// ---------------------------------------------------------------------------
+
+// parseProjectDirFlag returns --project-dir, defaulting to the working
+// directory.
+func parseProjectDirFlag() string {
+	for _, arg := range os.Args[2:] {
+		if v, ok := cutFlag(arg, "--project-dir"); ok && v != "" {
+			return v
+		}
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		slog.Error("cannot determine working directory", "error", err)
+		os.Exit(1)
+	}
+	return cwd
+}
+
+// writeHookScripts writes executable hook scripts into dir.
+func writeHookScripts(dir string, scripts map[string]string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, content := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileAll writes content to path, creating parent directories.
+func writeFileAll(path, content string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0o644)
+}
+
+// removeFiles deletes names from dir, ignoring ones that are already gone.
+func removeFiles(dir string, names ...string) error {
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportUninstallErrors logs each uninstall error and summarizes them.
+func reportUninstallErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	for _, e := range errs {
+		slog.Warn("uninstall issue", "error", e)
+	}
+	return fmt.Errorf("%d components had errors during uninstall", len(errs))
+}
+
+// printInstallNextSteps prints the transport summary after an install.
+func printInstallNextSteps(harness, transport, httpURL string) {
+	fmt.Println()
+	switch transport {
+	case "http":
+		fmt.Printf("Transport: HTTP (%s)\n", httpURL)
+		fmt.Println()
+		fmt.Println("Next steps:")
+		fmt.Println("  1. relay-mesh up        # start NATS + relay server")
+		fmt.Printf("  2. Open %s in this directory\n", harness)
+	default:
+		fmt.Printf("Transport: stdio (each %s session spawns its own relay-mesh)\n", harness)
+		fmt.Println()
+		fmt.Println("Next steps:")
+		fmt.Println("  1. Start NATS:  docker run -d -p 4222:4222 nats:2.11-alpine -js")
+		fmt.Println("     (or: relay-mesh up)")
+		fmt.Printf("  2. Open %s in this directory\n", harness)
+	}
+	fmt.Println("  Agents register automatically and can message each other.")
+}
+
+// saveHTTPAddr persists the HTTP URL so relay-mesh up can use it.
+func saveHTTPAddr(httpURL string) error {
+	dir, err := stateDir()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "http-addr"), []byte(httpURL+"\n"), 0o644)
+}
+
+// loadHTTPAddr reads a previously saved HTTP address, or returns empty string.
+func loadHTTPAddr() string {
+	dir, err := stateDir()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "http-addr"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// parseClaudeCodeFlags extracts --project-dir, --transport, and --http-url
+// from os.Args using the same manual style as the rest of the codebase.
+func parseClaudeCodeFlags() (projectDir, transport, httpURL string) {
+	projectDir = ""
+	transport = "stdio"
+	httpURL = "" // auto-detect free port when empty
+
+	for _, arg := range os.Args[2:] {
+		if v, ok := cutFlag(arg, "--project-dir"); ok {
+			projectDir = v
+		} else if v, ok := cutFlag(arg, "--transport"); ok {
+			transport = v
+		} else if v, ok := cutFlag(arg, "--http-url"); ok {
+			httpURL = v
+		}
+	}
+
+	if projectDir == "" {
+		cwd, err := os.Getwd()
+		if err != nil {
+			slog.Error("cannot determine working directory", "error", err)
+			os.Exit(1)
+		}
+		projectDir = cwd
+	}
+
+	// Auto-find a free port for HTTP transport when no URL specified.
+	if transport == "http" && httpURL == "" {
+		port := findFreePort(18808)
+		httpURL = fmt.Sprintf("http://127.0.0.1:%d/mcp", port)
+	}
+	return
+}
+
+// findFreePort probes ports starting from startPort and returns the first free one.
+func findFreePort(startPort int) int {
+	for port := startPort; port < startPort+100; port++ {
+		ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+		if err != nil {
+			continue
+		}
+		ln.Close()
+		return port
+	}
+	return startPort // fallback
+}
+
+// cutFlag returns the value for "--key=value" or "--key value" style flags.
+func cutFlag(arg, prefix string) (string, bool) {
+	if arg == prefix {
+		// No value provided inline; caller would need next arg, but for
+		// simplicity we only support --key=value form.
+		return "", false
+	}
+	if strings.HasPrefix(arg, prefix+"=") {
+		return arg[len(prefix)+1:], true
+	}
+	return "", false
+}
+
+// installHTTPAuthHeader returns the Authorization header an installer
+// writes into a harness's HTTP MCP config: --token=<token> if given,
+// otherwise the token labeled harness in the HTTP tokens file, generated on
+// first install. It returns "" for stdio, or when the server does not
+// require tokens because the tokens file does not exist.
+func installHTTPAuthHeader(harness, transport string) (string, error) {
+	if transport != "http" {
+		return "", nil
+	}
+	for _, arg := range os.Args[2:] {
+		if v, ok := cutFlag(arg, "--token"); ok && strings.TrimSpace(v) != "" {
+			return "Bearer " + strings.TrimSpace(v), nil
+		}
+	}
+	path, err := httpTokensPath()
+	if err != nil {
+		return "", err
+	}
+	token, err := httpauth.EnsureToken(path, harness)
+	if err != nil || token == "" {
+		return "", err
+	}
+	return "Bearer " + token, nil
+}
+
+// ---------------------------------------------------------------------------
+// 3a. .mcp.json
+// ---------------------------------------------------------------------------
+
+func installClaudeCodeMCP(projectDir, transport, httpURL, authHeader string) error {
+	var entry map[string]any
+	switch transport {
+	case "http":
+		entry = map[string]any{
+			"type": "http",
+			"url":  httpURL,
+		}
+		addAuthHeader(entry, authHeader)
+	default: // stdio
+		entry = stdioServerEntry()
+	}
+	return upsertMCPServer(filepath.Join(projectDir, ".mcp.json"), "mcpServers", entry)
+}
+
+// addAuthHeader adds the bearer token header to an HTTP MCP server entry.
+func addAuthHeader(entry map[string]any, authHeader string) {
+	if authHeader != "" {
+		entry["headers"] = map[string]any{"Authorization": authHeader}
+	}
+}
+
+// stdioServerEntry is the MCP server entry that spawns relay-mesh over stdio.
+func stdioServerEntry() map[string]any {
+	return map[string]any{
+		"command": "relay-mesh",
+		"args":    []any{"serve"},
+		"env": map[string]any{
+			"NATS_URL": "nats://127.0.0.1:4222",
+		},
+	}
+}
+
+// upsertMCPServer sets the relay-mesh entry under serversKey in a JSON MCP
+// config, keeping every other server and setting.
+func upsertMCPServer(path, serversKey string, entry map[string]any) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	cfg := map[string]any{}
+	if data, err := os.ReadFile(path); err == nil && strings.TrimSpace(string(data)) != "" {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+	}
+
+	servers, _ := cfg[serversKey].(map[string]any)
+	if servers == nil {
+		servers = map[string]any{}
+	}
+	servers["relay-mesh"] = entry
+	cfg[serversKey] = servers
+
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	out = append(out, '\n')
+	return os.WriteFile(path, out, 0o644)
+}
+
+// ---------------------------------------------------------------------------
+// 3b. Hook scripts in .claude/hooks/
+// ---------------------------------------------------------------------------
+
+func installClaudeCodeHooks(projectDir string) error {
+	hooksDir := filepath.Join(projectDir, ".claude", "hooks")
+	if err := os.MkdirAll(hooksDir, 0o755); err != nil {
+		return err
+	}
+
+	scripts := map[string]string{
+		"relay-pre-tool-use.sh":  claudeHookPreToolUse,
+		"relay-post-tool-use.sh": claudeHookPostToolUse,
+		"relay-stop.sh":          claudeHookStop,
+	}
+	for name, content := range scripts {
+		path := filepath.Join(hooksDir, name)
+		if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ---------------------------------------------------------------------------
+// 3c. Protocol file in ~/.relay-mesh/claude-code/
+// ---------------------------------------------------------------------------
+
+func installClaudeCodeProtocol() error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(home, ".relay-mesh", "claude-code")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "RELAY_PROTOCOL.md"), []byte(claudeRelayProtocol), 0o644)
+}
+
+// ---------------------------------------------------------------------------
+// 3d. .claude/settings.json — merge hook entries
+// ---------------------------------------------------------------------------
+
+func installClaudeCodeSettings(projectDir string) error {
+	settingsPath := filepath.Join(projectDir, ".claude", "settings.json")
+	if err := os.MkdirAll(filepath.Dir(settingsPath), 0o755); err != nil {
+		return err
+	}
+
+	cfg := map[string]any{}
+	if data, err := os.ReadFile(settingsPath); err == nil && strings.TrimSpace(string(data)) != "" {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("parse %s: %w", settingsPath, err)
+		}
+	}
+
+	hooks, _ := cfg["hooks"].(map[string]any)
+	if hooks == nil {
+		hooks = map[string]any{}
+	}
+
+	// Define desired hook entries.
+	type hookEntry struct {
+		Matcher string `json:"matcher,omitempty"`
+		Hooks   []any  `json:"hooks"`
+	}
+
+	wantedHooks := map[string]hookEntry{
+		"PreToolUse": {
+			Matcher: "mcp__relay-mesh__register_agent|Edit|Write|MultiEdit|NotebookEdit",
+			Hooks:   []any{map[string]any{"type": "command", "command": ".claude/hooks/relay-pre-tool-use.sh"}},
+		},
+		"PostToolUse": {
+			Matcher: "mcp__relay-mesh__register_agent",
+			Hooks:   []any{map[string]any{"type": "command", "command": ".claude/hooks/relay-post-tool-use.sh"}},
+		},
+		"Stop": {
+			Matcher: "",
+			Hooks:   []any{map[string]any{"type": "command", "command": ".claude/hooks/relay-stop.sh"}},
+		},
+	}
+
+	for event, wanted := range wantedHooks {
+		arr, _ := hooks[event].([]any)
+
+		newEntry := map[string]any{
+			"hooks": wanted.Hooks,
+		}
+		if wanted.Matcher != "" {
+			newEntry["matcher"] = wanted.Matcher
+		}
+
+		if !hookEntryExists(arr, wanted.Matcher, ".claude/hooks/relay-") {
+			arr = append(arr, newEntry)
+			hooks[event] = arr
+		}
+	}
+
+	cfg["hooks"] = hooks
+
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	out = append(out, '\n')
+	return os.WriteFile(settingsPath, out, 0o644)
+}
+
+// hookEntryExists checks whether the hook array already contains a relay-mesh entry,
+// identified by matching the matcher field and any hook command containing cmdSubstr.
+func hookEntryExists(arr []any, matcher, cmdSubstr string) bool {
+	for _, raw := range arr {
+		obj, ok := raw.(map[string]any)
+		if !ok {
+			continue
+		}
+		// Check matcher matches (or both are empty)
+		m, _ := obj["matcher"].(string)
+		if m != matcher {
+			continue
+		}
+		// Check if any hook command contains the relay substring
+		hookList, _ := obj["hooks"].([]any)
+		for _, h := range hookList {
+			hm, ok := h.(map[string]any)
+			if !ok {
+				continue
+			}
+			cmd, _ := hm["command"].(string)
+			if strings.Contains(cmd, cmdSubstr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func buildMCPServer(b *broker.Broker, registry *push.Registry, webhooks *push.WebhookAdapter, execs *push.ExecAdapter) *server.MCPServer {
+	// Notifications go back over the client's own MCP session, so the
+	// adapter forgets agents whose session disconnects.
+	var notifier *push.MCPAdapter
+	hooks := &server.Hooks{}
+	hooks.AddOnUnregisterSession(func(ctx context.Context, session server.ClientSession) {
+		notifier.UnbindSession(session.SessionID())
+	})
+	s := server.NewMCPServer(
+		"relay-mesh",
+		"0.1.0",
+		server.WithToolCapabilities(true),
+		server.WithResourceCapabilities(false, false),
+		server.WithPromptCapabilities(false),
+		server.WithHooks(hooks),
+	)
+	notifier = push.NewMCPAdapter(s)
+	if registry != nil {
+		registry.Register(notifier)
+	}
+
+	registerTool := mcp.NewTool(
+		"register_agent",
+		mcp.WithDescription("Register an agent profile and return an agent_id."),
+		mcp.WithString("name", mcp.Description("Optional display name for this agent.")),
+		mcp.WithString("description", mcp.Required(), mcp.Description("Who this agent is and what they handle.")),
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project name/context for this agent.")),
+		mcp.WithString("role", mcp.Required(), mcp.Description("Role in project (e.g., backend engineer).")),
+		mcp.WithString("github", mcp.Description("GitHub handle/org this agent operates in.")),
+		mcp.WithString("branch", mcp.Description("Current or primary git branch.")),
+		mcp.WithString("specialization", mcp.Required(), mcp.Description("Primary specialization/skill domain.")),
+		mcp.WithString("session_id", mcp.Description("Optional session id to bind immediately (auto-detected via hooks).")),
+		mcp.WithString("token", mcp.Description("Your current agent token. Required to re-register an agent already bound to session_id from a different MCP connection.")),
+		mcp.WithString("directory", mcp.Description("Your working directory or git root. Used to find your OpenCode session when session_id is not given.")),
+		mcp.WithString("harness", mcp.Description("Harness type: opencode, claude-code, codex, cursor, vscode, generic. Auto-detected if omitted.")),
+	)
+	listTool := mcp.NewTool(
+		"list_agents",
+		mcp.WithDescription("List registered agents that share a project with you, and their profiles."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("active_within", mcp.Description("Only return agents seen within this duration (e.g. 5m, 1h). Empty means all.")),
+	)
+	updateProfileTool := mcp.NewTool(
+		"update_agent_profile",
+		mcp.WithDescription("Update agent profile fields when new info becomes known."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent id to update.")),
+		tokenParam,
+		mcp.WithString("name", mcp.Description("Updated display name.")),
+		mcp.WithString("description", mcp.Description("Updated description.")),
+		mcp.WithString("project", mcp.Description("Updated project. Refused for an existing project you could not join (invite-only, or your role is one of its admin roles).")),
+		mcp.WithString("role", mcp.Description("Updated role.")),
+		mcp.WithString("github", mcp.Description("Updated GitHub handle/org.")),
+		mcp.WithString("branch", mcp.Description("Updated branch.")),
+		mcp.WithString("specialization", mcp.Description("Updated specialization.")),
+		mcp.WithString("status", mcp.Description("Agent status: idle, working, blocked, or done.")),
+	)
+	findAgentsTool := mcp.NewTool(
+		"find_agents",
+		mcp.WithDescription("Find relevant agents by query/profile filters among agents that share a project with you."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("query", mcp.Description("Free text search across profile fields.")),
+		mcp.WithString("project", mcp.Description("Project filter (fuzzy matching).")),
+		mcp.WithString("role", mcp.Description("Exact role filter.")),
+		mcp.WithString("specialization", mcp.Description("Exact specialization filter.")),
+		mcp.WithString("max", mcp.Description("Max number of agents to return (default 20).")),
+		mcp.WithString("active_within", mcp.Description("Only return agents seen within this duration (e.g. 5m, 1h). Empty means all.")),
+	)
+	sendTool := mcp.NewTool(
+		"send_message",
+		mcp.WithDescription("Send a message from one agent to another using NATS."),
+		mcp.WithString("from", mcp.Required(), mcp.Description("Sender agent_id.")),
+		tokenParam,
+		mcp.WithString("to", mcp.Required(), mcp.Description("Recipient agent_id, or role:<role>@<project> to reach one active agent with that role. Role messages with no active member wait until one registers.")),
+		mcp.WithString("strategy", mcp.Description("How to pick the agent for a role address: round_robin (default), least_unread, or most_recent.")),
+		mcp.WithString("body", mcp.Required(), mcp.Description("Message body.")),
+		mcp.WithString("priority", mcp.Description("Message priority: normal (default), urgent, or blocking.")),
+		mcp.WithString("reply_to", mcp.Description("message_id this message answers. The reply joins that message's thread.")),
+		mcp.WithString("thread_id", mcp.Description("Thread to post into. Omit to start a new thread (or inherit it from reply_to).")),
+	)
+	broadcastTool := mcp.NewTool(
+		"broadcast_message",
+		mcp.WithDescription("Broadcast a message to relevant agents using profile filters."),
+		mcp.WithString("from", mcp.Required(), mcp.Description("Sender agent_id.")),
+		tokenParam,
+		mcp.WithString("body", mcp.Required(), mcp.Description("Message body.")),
+		mcp.WithString("query", mcp.Description("Free text search across profile fields.")),
+		mcp.WithString("project", mcp.Description("Exact project filter.")),
+		mcp.WithString("role", mcp.Description("Exact role filter.")),
+		mcp.WithString("specialization", mcp.Description("Exact specialization filter.")),
+		mcp.WithString("max", mcp.Description("Max recipients (default 20).")),
+		mcp.WithString("priority", mcp.Description("Message priority: normal (default), urgent, or blocking.")),
+		mcp.WithString("reply_to", mcp.Description("message_id this broadcast answers. All copies join that message's thread.")),
+		mcp.WithString("thread_id", mcp.Description("Thread to post into. Omit to start a new thread shared by all recipients.")),
+	)
+	createChannelTool := mcp.NewTool(
+		"create_channel",
+		mcp.WithDescription("Create a named topic channel (e.g. \"my-app/backend-api\"). You become its first member."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("name", mcp.Required(), mcp.Description("Channel name: \"/\"-separated segments, conventionally <project>/<topic>.")),
+		mcp.WithString("description", mcp.Description("What the channel is for.")),
+	)
+	joinChannelTool := mcp.NewTool(
+		"join_channel",
+		mcp.WithDescription("Join a channel to receive its posts in your inbox."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("channel", mcp.Required(), mcp.Description("Channel name.")),
+	)
+	leaveChannelTool := mcp.NewTool(
+		"leave_channel",
+		mcp.WithDescription("Leave a channel and stop receiving its posts."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("channel", mcp.Required(), mcp.Description("Channel name.")),
+	)
+	postToChannelTool := mcp.NewTool(
+		"post_to_channel",
+		mcp.WithDescription("Post a message to every member of a channel you have joined."),
+		mcp.WithString("from", mcp.Required(), mcp.Description("Sender agent_id (must be a member).")),
+		tokenParam,
+		mcp.WithString("channel", mcp.Required(), mcp.Description("Channel name.")),
+		mcp.WithString("body", mcp.Required(), mcp.Description("Message body.")),
+		mcp.WithString("priority", mcp.Description("Message priority: normal (default), urgent, or blocking.")),
+		mcp.WithString("reply_to", mcp.Description("message_id this post answers. The post joins that message's thread.")),
+		mcp.WithString("thread_id", mcp.Description("Thread to post into. Omit to start a new thread.")),
+	)
+	listChannelsTool := mcp.NewTool(
+		"list_channels",
+		mcp.WithDescription("List channels of your projects with their members."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Description("Only channels named <project>/...")),
+	)
+	channelHistoryTool := mcp.NewTool(
+		"fetch_channel_history",
+		mcp.WithDescription("Fetch a channel's posts from durable JetStream history, oldest first."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id; you must be a member of the channel's project.")),
+		tokenParam,
+		mcp.WithString("channel", mcp.Required(), mcp.Description("Channel name.")),
+		mcp.WithString("max", mcp.Description("Max number of posts to return (default 20).")),
+	)
+	askTool := mcp.NewTool(
+		"ask_agent",
+		mcp.WithDescription("Send a request to another agent and block until they answer with send_message reply_to=<request id>. Use when you cannot continue without the answer."),
+		mcp.WithString("from", mcp.Required(), mcp.Description("Sender agent_id.")),
+		tokenParam,
+		mcp.WithString("to", mcp.Required(), mcp.Description("Recipient agent_id, or role:<role>@<project>.")),
+		mcp.WithString("strategy", mcp.Description("How to pick the agent for a role address: round_robin (default), least_unread, or most_recent.")),
+		mcp.WithString("body", mcp.Required(), mcp.Description("The question or request.")),
+		mcp.WithString("priority", mcp.Description("Message priority: normal (default), urgent, or blocking.")),
+		mcp.WithString("thread_id", mcp.Description("Thread to post into. Omit to start a new thread.")),
+		mcp.WithString("timeout_seconds", mcp.Description("Max seconds to wait for the reply (default 60, max 600).")),
+	)
+	fetchTool := mcp.NewTool(
+		"fetch_messages",
+		mcp.WithDescription("Fetch pending messages for an agent. Blocking messages come first, then urgent, then normal; oldest first within each priority."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent id to fetch for.")),
+		tokenParam,
+		mcp.WithString("max", mcp.Description("Max number of messages to fetch (default 10).")),
+		mcp.WithString("priority", mcp.Description("Only fetch these priorities, comma-separated (e.g. \"urgent,blocking\"). Others stay queued.")),
+	)
+	fetchHistoryTool := mcp.NewTool(
+		"fetch_message_history",
+		mcp.WithDescription("Fetch durable JetStream message history for an agent without draining the inbox."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent id to fetch history for.")),
+		tokenParam,
+		mcp.WithString("max", mcp.Description("Max number of historical messages to return (default 20).")),
+	)
+	getThreadTool := mcp.NewTool(
+		"get_thread",
+		mcp.WithDescription("Fetch a whole conversation thread from durable JetStream history, oldest first. Only messages you sent or received and posts to your projects' channels are returned."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("thread_id", mcp.Required(), mcp.Description("Thread id from a message's thread_id field.")),
+		mcp.WithString("max", mcp.Description("Max number of messages to return (default 100).")),
+	)
+	bindSessionTool := mcp.NewTool(
+		"bind_session",
+		mcp.WithDescription("Bind an agent_id to a harness session for automatic push delivery."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent id to bind.")),
+		tokenParam,
+		mcp.WithString("session_id", mcp.Description("Session id. If omitted, server attempts to detect from request headers. Defaults to webhook_url for harness=webhook.")),
+		mcp.WithString("harness", mcp.Description("Harness type: opencode, claude-code, codex, cursor, vscode, webhook, exec, generic. Auto-detected if omitted.")),
+		mcp.WithString("webhook_url", mcp.Description("harness=webhook: http(s) URL that receives a signed JSON POST per message.")),
+		mcp.WithString("webhook_secret", mcp.Description("harness=webhook: HMAC-SHA256 key for the X-Relay-Mesh-Signature header. Generated and returned if omitted.")),
+		mcp.WithString("webhook_timeout", mcp.Description("harness=webhook: request timeout (e.g. 5s). Default 10s.")),
+		mcp.WithNumber("webhook_expect_status", mcp.Description("harness=webhook: response status that counts as delivered. Default: any 2xx.")),
+		mcp.WithString("exec_command", mcp.Description("harness=exec: sh command run per message, e.g. tmux send-keys -t {session_id} \"$RELAY_BODY\" Enter. Placeholders {agent_id} {session_id} {message_id} {from} {priority} {channel} {thread_id} are shell-quoted; the message JSON is on stdin. Requires EXEC_PUSH_ENABLED on the server.")),
+		mcp.WithString("exec_timeout", mcp.Description("harness=exec: command timeout (e.g. 5s). Default 10s.")),
+	)
+	getBindingTool := mcp.NewTool(
+		"get_session_binding",
+		mcp.WithDescription("Get your bound session and harness. The session id is redacted."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+	)
+	getTeamStatusTool := mcp.NewTool(
+		"get_team_status",
+		mcp.WithDescription("Get current status of all agents on a project (idle/working/blocked/done), last activity, and unread message count. Call before declaring project complete."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Description("Project name filter. Leave empty to return every agent you share a project with.")),
+	)
+	sharedContextTool := mcp.NewTool(
+		"shared_context",
+		mcp.WithDescription("Publish or read shared key-value context visible to all members of the project. Use to share file paths, API endpoints, and schemas before coding. Read before importing to avoid path mismatches."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("action", mcp.Required(), mcp.Description("Action: set, get, or list.")),
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project name.")),
+		mcp.WithString("key", mcp.Description("Key to set or get (required for set/get).")),
+		mcp.WithString("value", mcp.Description("Value to store (for set). Empty string deletes the key.")),
+	)
+	waitForAgentsTool := mcp.NewTool(
+		"wait_for_agents",
+		mcp.WithDescription("Wait until min_count agents have registered for a project. Call right after registering, before your first broadcast, to prevent 0-recipient race conditions."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project name to watch.")),
+		mcp.WithString("min_count", mcp.Description("Minimum number of agents to wait for (default 2).")),
+		mcp.WithString("timeout_seconds", mcp.Description("Max seconds to wait (default 60).")),
+	)
+	declareCompleteTool := mcp.NewTool(
+		"declare_task_complete",
+		mcp.WithDescription("Declare that your assigned work is complete. Sets your status to 'done' so the team-lead can track overall progress."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("summary", mcp.Description("Brief summary of what you completed.")),
+		mcp.WithString("task_id", mcp.Description("Task you hold to mark done along with your status.")),
+	)
+	createTaskTool := mcp.NewTool(
+		"create_task",
+		mcp.WithDescription("Add an open task to a project's task board so teammates can claim it."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project the task belongs to.")),
+		mcp.WithString("title", mcp.Required(), mcp.Description("Short task title.")),
+		mcp.WithString("description", mcp.Description("What needs to be done.")),
+		mcp.WithString("depends_on", mcp.Description("Comma-separated task ids that must be done before this task can be claimed.")),
+	)
+	claimTaskTool := mcp.NewTool(
+		"claim_task",
+		mcp.WithDescription("Claim an open task. The claim is a lease renewed by heartbeat_agent; if you stop heartbeating it expires and the task reopens."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("task_id", mcp.Required(), mcp.Description("Task to claim.")),
+		mcp.WithString("lease", mcp.Description("Lease duration between heartbeats (e.g. 10m, 1h). Default 10m.")),
+	)
+	updateTaskTool := mcp.NewTool(
+		"update_task",
+		mcp.WithDescription("Edit a task you created or hold. status=open releases your claim; status=cancelled withdraws the task."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("task_id", mcp.Required(), mcp.Description("Task to update.")),
+		mcp.WithString("title", mcp.Description("New title.")),
+		mcp.WithString("description", mcp.Description("New description.")),
+		mcp.WithString("depends_on", mcp.Description("Replacement comma-separated dependency list (empty clears it).")),
+		mcp.WithString("status", mcp.Description("open (release claim) or cancelled.")),
+	)
+	completeTaskTool := mcp.NewTool(
+		"complete_task",
+		mcp.WithDescription("Mark a task you have claimed as done. Dependent tasks become claimable."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("task_id", mcp.Required(), mcp.Description("Task to complete.")),
+		mcp.WithString("result", mcp.Description("What was delivered: file paths, artifact ids, notes.")),
+	)
+	listTasksTool := mcp.NewTool(
+		"list_tasks",
+		mcp.WithDescription("List a project's task board. Open tasks include blocked_by when dependencies are unfinished."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id; you must be a member of the project.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project name.")),
+		mcp.WithString("status", mcp.Description("Filter: open, claimed, done, or cancelled.")),
+		mcp.WithString("assignee", mcp.Description("Filter by assignee agent_id.")),
+	)
+	acquireLockTool := mcp.NewTool(
+		"acquire_lock",
+		mcp.WithDescription("Take an advisory lock on a file or directory before editing it so teammates do not edit it concurrently. A directory lock covers everything under it. The lock is a lease renewed by heartbeat_agent and released if you are pruned."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project the resource belongs to.")),
+		mcp.WithString("resource", mcp.Required(), mcp.Description("Path relative to the project root (e.g. internal/broker/broker.go or internal/broker).")),
+		mcp.WithString("ttl", mcp.Description("Lease duration between heartbeats (e.g. 10m, 1h). Default 10m.")),
+		mcp.WithString("note", mcp.Description("Why you hold the lock, shown to teammates.")),
+		mcp.WithString("wait_seconds", mcp.Description("If the resource is held, keep retrying for up to this many seconds instead of failing immediately.")),
+	)
+	releaseLockTool := mcp.NewTool(
+		"release_lock",
+		mcp.WithDescription("Release an advisory lock you hold as soon as you finish editing."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project the resource belongs to.")),
+		mcp.WithString("resource", mcp.Required(), mcp.Description("Path you locked.")),
+	)
+	listLocksTool := mcp.NewTool(
+		"list_locks",
+		mcp.WithDescription("List live advisory locks with holder, note and expiry."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Description("Project name. Omit to list every project you belong to.")),
+	)
+	checkReadinessTool := mcp.NewTool(
+		"check_project_readiness",
+		mcp.WithDescription("Check whether all agents on a project have declared completion and no tasks are left open or claimed. Team-lead MUST call this before broadcasting project complete."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project name to check.")),
+	)
+	heartbeatTool := mcp.NewTool(
+		"heartbeat_agent",
+		mcp.WithDescription("Ping the broker to signal this agent is still active. Call periodically (every 5 min) to prevent stale-agent pruning."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+	)
+	getMessageStatusTool := mcp.NewTool(
+		"get_message_status",
+		mcp.WithDescription("Check the lifecycle of a message you sent: state (queued|pushed|fetched|acknowledged|acted_on|rejected) and the full timeline of transitions."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id (the sender or recipient).")),
+		tokenParam,
+		mcp.WithString("message_id", mcp.Required(), mcp.Description("Message id returned by send_message.")),
+	)
+	deadLettersTool := mcp.NewTool(
+		"list_dead_letters",
+		mcp.WithDescription("List your pushes that failed after exhausting their retries, newest first. The messages themselves stay queued for fetch_messages."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id (the recipient).")),
+		tokenParam,
+	)
+	ackMessageTool := mcp.NewTool(
+		"ack_message",
+		mcp.WithDescription("Tell the sender what you did with a message you received. Only the recipient can ack. States only move forward; acted_on and rejected are final."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id (the message recipient).")),
+		tokenParam,
+		mcp.WithString("message_id", mcp.Required(), mcp.Description("Id of the message being acknowledged.")),
+		mcp.WithString("status", mcp.Description("acknowledged (default), acted_on, or rejected.")),
+		mcp.WithString("note", mcp.Description("Optional note for the sender, e.g. why a message was rejected.")),
+	)
+	pruneAgentsTool := mcp.NewTool(
+		"prune_stale_agents",
+		mcp.WithDescription("Remove agents in projects you administer that have not sent a heartbeat within the given window. Returns count of pruned agents."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id; you must be a project admin.")),
+		tokenParam,
+		mcp.WithString("max_age", mcp.Description("Max idle duration before pruning (e.g. 30m, 1h). Default 30m, minimum 1m.")),
+	)
+	publishArtifactTool := mcp.NewTool(
+		"publish_artifact",
+		mcp.WithDescription("Publish a structured artifact (file tree, API schema, Dockerfile, etc.) for teammates to consume."),
+		mcp.WithString("from", mcp.Required(), mcp.Description("Publisher agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project name.")),
+		mcp.WithString("artifact_type", mcp.Required(), mcp.Description("Type: file_tree, api_endpoint, schema, config, dockerfile, or other.")),
+		mcp.WithString("name", mcp.Required(), mcp.Description("Artifact name (e.g. 'backend_routes', 'db_schema').")),
+		mcp.WithString("content", mcp.Required(), mcp.Description("Artifact content (JSON, YAML, markdown, plain text).")),
+	)
+	listArtifactsTool := mcp.NewTool(
+		"list_artifacts",
+		mcp.WithDescription("List artifacts published for a project, optionally filtered by type."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project name.")),
+		mcp.WithString("artifact_type", mcp.Description("Filter by type (e.g. schema, dockerfile). Empty returns all.")),
+	)
+	getProjectTool := mcp.NewTool(
+		"get_project",
+		mcp.WithDescription("Show a project's access policy and members. Only members can see a project."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project name.")),
+	)
+	listProjectsTool := mcp.NewTool(
+		"list_projects",
+		mcp.WithDescription("List the projects you are a member of."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+	)
+	setProjectPolicyTool := mcp.NewTool(
+		"set_project_policy",
+		mcp.WithDescription("Change who may join a project, broadcast into it, or write its shared context and artifacts. Project admins only. Omitted fields are left unchanged."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project name.")),
+		mcp.WithString("join", mcp.Description("open (agents registering with the project join it) or invite (admins add members).")),
+		mcp.WithString("broadcast", mcp.Description("Who may broadcast into the project: members or admins.")),
+		mcp.WithString("write_context", mcp.Description("Who may set shared_context: members or admins.")),
+		mcp.WithString("write_artifacts", mcp.Description("Who may publish artifacts: members or admins.")),
+		mcp.WithString("admin_roles", mcp.Description("Comma-separated roles whose members are admins (e.g. team-lead). Only an admin may take on one of these roles or add an agent that has one.")),
+	)
+	addProjectMemberTool := mcp.NewTool(
+		"add_project_member",
+		mcp.WithDescription("Add an agent to a project, or change whether it is an admin. Project admins only."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project name.")),
+		mcp.WithString("member_id", mcp.Required(), mcp.Description("Agent to add.")),
+		mcp.WithString("admin", mcp.Description("true to make the member an admin (default false).")),
+	)
+	healthTool := mcp.NewTool(
+		"health",
+		mcp.WithDescription("Report the relay server's NATS connection state (connected, reconnects, last disconnect and error), subject prefix, stream and agent count."),
+	)
+	removeProjectMemberTool := mcp.NewTool(
+		"remove_project_member",
+		mcp.WithDescription("Remove an agent from a project. Admins may remove anyone; members may remove themselves."),
+		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
+		tokenParam,
+		mcp.WithString("project", mcp.Required(), mcp.Description("Project name.")),
+		mcp.WithString("member_id", mcp.Required(), mcp.Description("Agent to remove.")),
+	)
+
+	s.AddTool(registerTool, registerHandler(b, registry, notifier))
+	s.AddTool(listTool, listHandler(b))
+	s.AddTool(updateProfileTool, updateProfileHandler(b))
+	s.AddTool(findAgentsTool, findAgentsHandler(b))
+	s.AddTool(sendTool, sendHandler(b, registry))
+	s.AddTool(broadcastTool, broadcastHandler(b, registry))
+	s.AddTool(askTool, askHandler(b, registry))
+	s.AddTool(fetchTool, fetchHandler(b))
+	s.AddTool(fetchHistoryTool, fetchHistoryHandler(b))
+	s.AddTool(getThreadTool, getThreadHandler(b))
+	s.AddTool(createChannelTool, createChannelHandler(b))
+	s.AddTool(joinChannelTool, joinChannelHandler(b))
+	s.AddTool(leaveChannelTool, leaveChannelHandler(b))
+	s.AddTool(postToChannelTool, postToChannelHandler(b, registry))
+	s.AddTool(listChannelsTool, listChannelsHandler(b))
+	s.AddTool(channelHistoryTool, channelHistoryHandler(b))
+	s.AddTool(bindSessionTool, bindSessionHandler(b, webhooks, execs))
+	s.AddTool(getBindingTool, getSessionBindingHandler(b))
+	s.AddTool(getTeamStatusTool, getTeamStatusHandler(b))
+	s.AddTool(sharedContextTool, sharedContextHandler(b))
+	s.AddTool(waitForAgentsTool, waitForAgentsHandler(b))
+	s.AddTool(declareCompleteTool, declareCompleteHandler(b))
+	s.AddTool(checkReadinessTool, checkReadinessHandler(b))
+	s.AddTool(createTaskTool, createTaskHandler(b))
+	s.AddTool(claimTaskTool, claimTaskHandler(b))
+	s.AddTool(updateTaskTool, updateTaskHandler(b))
+	s.AddTool(completeTaskTool, completeTaskHandler(b))
+	s.AddTool(listTasksTool, listTasksHandler(b))
+	s.AddTool(acquireLockTool, acquireLockHandler(b))
+	s.AddTool(releaseLockTool, releaseLockHandler(b))
+	s.AddTool(listLocksTool, listLocksHandler(b))
+	s.AddTool(heartbeatTool, heartbeatHandler(b))
+	s.AddTool(getMessageStatusTool, getMessageStatusHandler(b))
+	s.AddTool(deadLettersTool, deadLettersHandler(b))
+	s.AddTool(ackMessageTool, ackMessageHandler(b))
+	s.AddTool(pruneAgentsTool, pruneAgentsHandler(b))
+	s.AddTool(publishArtifactTool, publishArtifactHandler(b))
+	s.AddTool(listArtifactsTool, listArtifactsHandler(b))
+	s.AddTool(getProjectTool, getProjectHandler(b))
+	s.AddTool(listProjectsTool, listProjectsHandler(b))
+	s.AddTool(setProjectPolicyTool, setProjectPolicyHandler(b))
+	s.AddTool(addProjectMemberTool, addProjectMemberHandler(b))
+	s.AddTool(removeProjectMemberTool, removeProjectMemberHandler(b))
+	s.AddTool(healthTool, healthHandler(b))
+	s.AddResourceTemplate(
+		mcp.NewResourceTemplate(
+			push.InboxURI("{agent_id}"),
+			"Agent inbox",
+			mcp.WithTemplateDescription("Unread message count for an agent. Updated via notifications/resources/updated when a message arrives."),
+			mcp.WithTemplateMIMEType("application/json"),
+		),
+		inboxResourceHandler(b),
+	)
+	return s
+}
+
+// inboxResourceHandler serves relay-mesh://inbox/{agent_id}. It reports the
+// unread count without consuming anything; agents still call fetch_messages.
+func inboxResourceHandler(b *broker.Broker) server.ResourceTemplateHandlerFunc {
+	return func(ctx context.Context, req mcp.ReadResourceRequest) ([]mcp.ResourceContents, error) {
+		agentID, ok := push.InboxAgentID(req.Params.URI)
+		if !ok {
+			return nil, fmt.Errorf("invalid inbox uri: %s", req.Params.URI)
+		}
+		body, _ := json.Marshal(map[string]any{
+			"agent_id": agentID,
+			"unread":   b.UnreadCount(agentID),
+		})
+		return []mcp.ResourceContents{mcp.TextResourceContents{
+			URI:      req.Params.URI,
+			MIMEType: "application/json",
+			Text:     string(body),
+		}}, nil
+	}
+}
+
+// tokenParam is the credential argument of every tool that acts as an agent.
+var tokenParam = mcp.WithString("token", mcp.Description("Your agent token from register_agent. Not needed on the MCP connection you registered over."))
+
+// authorizeAgent checks that the caller may act as agentID: it passed the
+// agent's token, or it registered the agent over this MCP session. Over
+// HTTP, a project-scoped bearer token must also cover the agent's project
+// and any project the call names.
+func authorizeAgent(ctx context.Context, b *broker.Broker, req mcp.CallToolRequest, agentID string) error {
+	mcpSessionID := ""
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		mcpSessionID = session.SessionID()
+	}
+	if err := b.Authorize(agentID, req.GetString("token", ""), mcpSessionID); err != nil {
+		return err
+	}
+	if profile, ok := b.GetAgentProfile(agentID); ok {
+		if err := authorizeProject(ctx, profile.Project); err != nil {
+			return err
+		}
+	}
+	if project := strings.TrimSpace(req.GetString("project", "")); project != "" {
+		return authorizeProject(ctx, project)
+	}
+	return nil
+}
+
+// authorizeProject checks that the HTTP bearer token of the request, if it
+// is scoped to projects, covers project.
+func authorizeProject(ctx context.Context, project string) error {
+	id, ok := httpauth.FromContext(ctx)
+	if !ok || !id.Scoped() {
+		return nil
+	}
+	project = broker.NormalizeProject(project)
+	if project == "" {
+		return fmt.Errorf("%w: token %s is scoped to projects %s; name a project", broker.ErrForbidden, id.Label, strings.Join(id.Projects, ", "))
+	}
+	for _, p := range id.Projects {
+		if broker.NormalizeProject(p) == project {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: token %s is not scoped to project %s", broker.ErrForbidden, id.Label, project)
+}
+
+func registerHandler(b *broker.Broker, registry *push.Registry, notifier *push.MCPAdapter) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		profile := broker.AgentProfile{
+			Name:           req.GetString("name", ""),
+			Description:    req.GetString("description", ""),
+			Project:        req.GetString("project", ""),
+			Role:           req.GetString("role", ""),
+			GitHub:         req.GetString("github", ""),
+			Branch:         req.GetString("branch", ""),
+			Specialization: req.GetString("specialization", ""),
+		}
+
+		// Detect harness type
+		harness := strings.TrimSpace(req.GetString("harness", ""))
+		if harness == "" {
+			harness = detectHarness()
+		}
+
+		// Detect session_id EARLY (before register call)
+		sessionID := strings.TrimSpace(req.GetString("session_id", ""))
+		if sessionID == "" {
+			sessionID = detectSessionID(req.Header)
+		}
+		if sessionID == "" && harness == "codex" {
+			// Codex exports its thread id to the stdio MCP server process.
+			sessionID = strings.TrimSpace(os.Getenv("CODEX_THREAD_ID"))
+		}
+		var autoBindErr error
+		if sessionID == "" && registry != nil {
+			hint := push.SessionHint{
+				Project:   profile.Project,
+				Directory: strings.TrimSpace(req.GetString("directory", "")),
+			}
+			autoSessionID, autoHarness, resolveErr := registry.ResolveSession(harness, hint, b.ListBoundSessionIDs())
+			var ambiguous *push.AmbiguousSessionError
+			switch {
+			case errors.As(resolveErr, &ambiguous) && harness == ambiguous.Harness:
+				// The caller named the harness, so a binding was expected.
+				return mcp.NewToolResultError(ambiguous.Error()), nil
+			case resolveErr != nil:
+				slog.Warn("auto bind resolver failed", "error", resolveErr)
+				autoBindErr = resolveErr
+			case autoSessionID != "":
+				sessionID = autoSessionID
+				harness = autoHarness
+			}
+		}
+
+		// A project-scoped HTTP token may only register into its projects,
+		// and may not take over an agent registered elsewhere.
+		if err := authorizeProject(ctx, profile.Project); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if existingID, ok := b.AgentForSession(sessionID); ok {
+			if existing, ok := b.GetAgentProfile(existingID); ok {
+				if err := authorizeProject(ctx, existing.Project); err != nil {
+					return mcp.NewToolResultError(err.Error()), nil
+				}
+			}
+		}
+
+		// An empty sessionID registers a new agent. A known one re-registers
+		// it and rotates its token, but only for a caller that already acts
+		// as that agent: session IDs are not secret.
+		session := server.ClientSessionFromContext(ctx)
+		mcpSessionID := ""
+		if session != nil {
+			mcpSessionID = session.SessionID()
+		}
+		id, token, created, err := b.RegisterOrUpdateBySession(sessionID, profile, req.GetString("token", ""), mcpSessionID)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		slog.Info("agent registered", "agent_id", id, "new", created, "name", profile.Name, "project", profile.Project, "role", profile.Role)
+		if session != nil {
+			b.BindAuthSession(mcpSessionID, id)
+			if notifier != nil {
+				notifier.Bind(id, mcpSessionID)
+			}
+		}
+
+		out := map[string]string{"agent_id": id, "token": token}
+		if sessionID != "" {
+			// RegisterOrUpdateBySession already binds the session internally,
+			// but we still need to set the harness via BindSession.
+			if err := b.BindSession(id, sessionID, harness); err == nil {
+				out["session_id"] = sessionID
+				out["harness"] = harness
+			}
+		} else if harness != "" {
+			out["harness"] = harness
+		}
+		if autoBindErr != nil {
+			// Registered but unbound: tell the agent how to bind.
+			out["auto_bind_error"] = autoBindErr.Error()
+		}
+		if _, err := b.GetProject(id, profile.Project); errors.Is(err, broker.ErrForbidden) {
+			// Registered, but the project is invite-only or the role is
+			// one of its admin roles.
+			out["project_access"] = "not a member of " + profile.Project + ": ask a project admin to add you with add_project_member"
+		}
+		body, _ := json.Marshal(out)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func updateProfileHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		patch := broker.AgentProfile{
+			Name:           req.GetString("name", ""),
+			Description:    req.GetString("description", ""),
+			Project:        req.GetString("project", ""),
+			Role:           req.GetString("role", ""),
+			GitHub:         req.GetString("github", ""),
+			Branch:         req.GetString("branch", ""),
+			Specialization: req.GetString("specialization", ""),
+			Status:         req.GetString("status", ""),
+		}
+		updated, err := b.UpdateAgentProfile(agentID, patch)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		body, _ := json.Marshal(updated)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func findAgentsHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		maxText := req.GetString("max", "20")
+		max, err := strconv.Atoi(maxText)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("invalid max: %s", maxText)), nil
+		}
+		filter := broker.AgentSearchFilter{
+			Query:          req.GetString("query", ""),
+			Project:        req.GetString("project", ""),
+			Role:           req.GetString("role", ""),
+			Specialization: req.GetString("specialization", ""),
+			Limit:          max,
+			Viewer:         agentID,
+		}
+		if raw := strings.TrimSpace(req.GetString("active_within", "")); raw != "" {
+			if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+				filter.ActiveWithin = d
+			}
+		}
+		body, _ := json.Marshal(b.FindAgents(filter))
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func listHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		filter := broker.AgentSearchFilter{Limit: 1000, Viewer: agentID}
+		if raw := strings.TrimSpace(req.GetString("active_within", "")); raw != "" {
+			if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+				filter.ActiveWithin = d
+			}
+		}
+		body, _ := json.Marshal(b.FindAgents(filter))
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func sendHandler(b *broker.Broker, registry *push.Registry) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		from := req.GetString("from", "")
+		to := req.GetString("to", "")
+		msgBody := req.GetString("body", "")
+		if from == "" || to == "" || msgBody == "" {
+			return mcp.NewToolResultError("from, to, and body are required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, from); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		opts := broker.SendOptions{
+			Priority: strings.TrimSpace(req.GetString("priority", "")),
+			ReplyTo:  strings.TrimSpace(req.GetString("reply_to", "")),
+			ThreadID: strings.TrimSpace(req.GetString("thread_id", "")),
+			Strategy: strings.TrimSpace(req.GetString("strategy", "")),
+		}
+		msg, err := b.SendWithOptions(from, to, msgBody, opts)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("message sent", "id", msg.ID, "from", from, "to", msg.To, "thread_id", msg.ThreadID, "body", msgBody)
+		if _, err := pushMessage(b, registry, msg); err != nil {
+			slog.Error("push enqueue failed", "agent_id", msg.To, "error", err)
+		}
+		out := map[string]any{
+			"id":               msg.ID,
+			"from":             msg.From,
+			"to":               msg.To,
+			"body":             msg.Body,
+			"thread_id":        msg.ThreadID,
+			"created_at":       msg.CreatedAt,
+			"recipient_unread": b.UnreadCount(msg.To),
+		}
+		if msg.ReplyTo != "" {
+			out["reply_to"] = msg.ReplyTo
+		}
+		if broker.IsRoleAddress(msg.To) {
+			out["status"] = "queued"
+			out["message"] = fmt.Sprintf("No active agent has %s; the message waits in the role queue until one registers.", msg.To)
+		}
+		body, _ := json.Marshal(out)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func askHandler(b *broker.Broker, registry *push.Registry) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		from := req.GetString("from", "")
+		to := req.GetString("to", "")
+		msgBody := req.GetString("body", "")
+		if from == "" || to == "" || msgBody == "" {
+			return mcp.NewToolResultError("from, to, and body are required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, from); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		timeoutSec := 60
+		if raw := strings.TrimSpace(req.GetString("timeout_seconds", "")); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid timeout_seconds: %s", raw)), nil
 			}
+			timeoutSec = min(n, 600)
+		}
+
+		pending, err := b.Ask(from, to, msgBody, broker.SendOptions{
+			Priority: strings.TrimSpace(req.GetString("priority", "")),
+			ThreadID: strings.TrimSpace(req.GetString("thread_id", "")),
+			Strategy: strings.TrimSpace(req.GetString("strategy", "")),
+		})
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
+		defer pending.Close()
+		request := pending.Message
+		slog.Info("request sent", "id", request.ID, "from", from, "to", request.To, "timeout_seconds", timeoutSec)
+		if _, err := pushMessage(b, registry, request); err != nil {
+			slog.Error("push enqueue failed", "agent_id", request.To, "error", err)
+		}
+
+		reply, err := pending.Wait(ctx, time.Duration(timeoutSec)*time.Second)
+		switch {
+		case errors.Is(err, broker.ErrReplyTimeout):
+			body, _ := json.Marshal(map[string]any{
+				"status":          "timeout",
+				"request_id":      request.ID,
+				"thread_id":       request.ThreadID,
+				"timeout_seconds": timeoutSec,
+				"message":         fmt.Sprintf("No reply from %s within %ds. The request stays in their inbox; a later answer arrives via fetch_messages with reply_to=%s.", request.To, timeoutSec, request.ID),
+			})
+			return mcp.NewToolResultText(string(body)), nil
+		case err != nil:
+			return mcp.NewToolResultError(fmt.Sprintf("waiting for reply to %s: %v", request.ID, err)), nil
+		}
+
+		slog.Info("request answered", "id", request.ID, "reply_id", reply.ID, "from", reply.From)
+		body, _ := json.Marshal(map[string]any{
+			"status":     "replied",
+			"request_id": request.ID,
+			"thread_id":  request.ThreadID,
+			"reply":      reply,
+		})
+		return mcp.NewToolResultText(string(body)), nil
 	}
-	return false
 }
 
-func buildMCPServer(b *broker.Broker, registry *push.Registry, resolver *opencodepush.SessionResolver) *server.MCPServer {
-	s := server.NewMCPServer(
-		"relay-mesh",
-		"0.1.0",
-		server.WithToolCapabilities(true),
-		server.WithResourceCapabilities(false, false),
-		server.WithPromptCapabilities(false),
-	)
+func fetchHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 
-	registerTool := mcp.NewTool(
-		"register_agent",
-		mcp.WithDescription("Register an agent profile and return an agent_id."),
-		mcp.WithString("name", mcp.Description("Optional display name for this agent.")),
-		mcp.WithString("description", mcp.Required(), mcp.Description("Who this agent is and what they handle.")),
-		mcp.WithString("project", mcp.Required(), mcp.Description("Project name/context for this agent.")),
-		mcp.WithString("role", mcp.Required(), mcp.Description("Role in project (e.g., backend engineer).")),
-		mcp.WithString("github", mcp.Description("GitHub handle/org this agent operates in.")),
-		mcp.WithString("branch", mcp.Description("Current or primary git branch.")),
-		mcp.WithString("specialization", mcp.Required(), mcp.Description("Primary specialization/skill domain.")),
-		mcp.WithString("session_id", mcp.Description("Optional session id to bind immediately (auto-detected via hooks).")),
-		mcp.WithString("harness", mcp.Description("Harness type: opencode, claude-code, codex, generic. Auto-detected if omitted.")),
-	)
-	listTool := mcp.NewTool(
-		"list_agents",
-		mcp.WithDescription("List all registered agents and their profiles."),
-		mcp.WithString("active_within", mcp.Description("Only return agents seen within this duration (e.g. 5m, 1h). Empty means all.")),
-	)
-	updateProfileTool := mcp.NewTool(
-		"update_agent_profile",
-		mcp.WithDescription("Update agent profile fields when new info becomes known."),
-		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent id to update.")),
-		mcp.WithString("name", mcp.Description("Updated display name.")),
-		mcp.WithString("description", mcp.Description("Updated description.")),
-		mcp.WithString("project", mcp.Description("Updated project.")),
-		mcp.WithString("role", mcp.Description("Updated role.")),
-		mcp.WithString("github", mcp.Description("Updated GitHub handle/org.")),
-		mcp.WithString("branch", mcp.Description("Updated branch.")),
-		mcp.WithString("specialization", mcp.Description("Updated specialization.")),
-		mcp.WithString("status", mcp.Description("Agent status: idle, working, blocked, or done.")),
-	)
-	findAgentsTool := mcp.NewTool(
-		"find_agents",
-		mcp.WithDescription("Find relevant agents by query/profile filters."),
-		mcp.WithString("query", mcp.Description("Free text search across profile fields.")),
-		mcp.WithString("project", mcp.Description("Project filter (fuzzy matching).")),
-		mcp.WithString("role", mcp.Description("Exact role filter.")),
-		mcp.WithString("specialization", mcp.Description("Exact specialization filter.")),
-		mcp.WithString("max", mcp.Description("Max number of agents to return (default 20).")),
-		mcp.WithString("active_within", mcp.Description("Only return agents seen within this duration (e.g. 5m, 1h). Empty means all.")),
-	)
-	sendTool := mcp.NewTool(
-		"send_message",
-		mcp.WithDescription("Send a message from one agent to another using NATS."),
-		mcp.WithString("from", mcp.Required(), mcp.Description("Sender agent_id.")),
-		mcp.WithString("to", mcp.Required(), mcp.Description("Recipient agent_id.")),
-		mcp.WithString("body", mcp.Required(), mcp.Description("Message body.")),
-		mcp.WithString("priority", mcp.Description("Message priority: normal (default), urgent, or blocking.")),
-	)
-	broadcastTool := mcp.NewTool(
-		"broadcast_message",
-		mcp.WithDescription("Broadcast a message to relevant agents using profile filters."),
-		mcp.WithString("from", mcp.Required(), mcp.Description("Sender agent_id.")),
-		mcp.WithString("body", mcp.Required(), mcp.Description("Message body.")),
-		mcp.WithString("query", mcp.Description("Free text search across profile fields.")),
-		mcp.WithString("project", mcp.Description("Exact project filter.")),
-		mcp.WithString("role", mcp.Description("Exact role filter.")),
-		mcp.WithString("specialization", mcp.Description("Exact specialization filter.")),
-		mcp.WithString("max", mcp.Description("Max recipients (default 20).")),
-		mcp.WithString("priority", mcp.Description("Message priority: normal (default), urgent, or blocking.")),
-	)
-	fetchTool := mcp.NewTool(
-		"fetch_messages",
-		mcp.WithDescription("Fetch pending messages for an agent."),
-		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent id to fetch for.")),
-		mcp.WithString("max", mcp.Description("Max number of messages to fetch (default 10).")),
-	)
-	fetchHistoryTool := mcp.NewTool(
-		"fetch_message_history",
-		mcp.WithDescription("Fetch durable JetStream message history for an agent without draining in-memory queue."),
-		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent id to fetch history for.")),
-		mcp.WithString("max", mcp.Description("Max number of historical messages to return (default 20).")),
-	)
-	bindSessionTool := mcp.NewTool(
-		"bind_session",
-		mcp.WithDescription("Bind an agent_id to a harness session for automatic push delivery."),
-		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent id to bind.")),
-		mcp.WithString("session_id", mcp.Description("Session id. If omitted, server attempts to detect from request headers.")),
-		mcp.WithString("harness", mcp.Description("Harness type: opencode, claude-code, codex, generic. Auto-detected if omitted.")),
-	)
-	getBindingTool := mcp.NewTool(
-		"get_session_binding",
-		mcp.WithDescription("Get the currently bound session and harness for an agent_id."),
-		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Agent id to resolve.")),
-	)
-	getTeamStatusTool := mcp.NewTool(
-		"get_team_status",
-		mcp.WithDescription("Get current status of all agents on a project (idle/working/blocked/done), last activity, and unread message count. Call before declaring project complete."),
-		mcp.WithString("project", mcp.Description("Project name filter. Leave empty to return all agents.")),
-	)
-	sharedContextTool := mcp.NewTool(
-		"shared_context",
-		mcp.WithDescription("Publish or read shared key-value context visible to all agents on the project. Use to share file paths, API endpoints, and schemas before coding. Read before importing to avoid path mismatches."),
-		mcp.WithString("action", mcp.Required(), mcp.Description("Action: set, get, or list.")),
-		mcp.WithString("project", mcp.Required(), mcp.Description("Project name.")),
-		mcp.WithString("key", mcp.Description("Key to set or get (required for set/get).")),
-		mcp.WithString("value", mcp.Description("Value to store (for set). Empty string deletes the key.")),
-	)
-	waitForAgentsTool := mcp.NewTool(
-		"wait_for_agents",
-		mcp.WithDescription("Wait until min_count agents have registered for a project. Call right after registering, before your first broadcast, to prevent 0-recipient race conditions."),
-		mcp.WithString("project", mcp.Required(), mcp.Description("Project name to watch.")),
-		mcp.WithString("min_count", mcp.Description("Minimum number of agents to wait for (default 2).")),
-		mcp.WithString("timeout_seconds", mcp.Description("Max seconds to wait (default 60).")),
-	)
-	declareCompleteTool := mcp.NewTool(
-		"declare_task_complete",
-		mcp.WithDescription("Declare that your assigned work is complete. Sets your status to 'done' so the team-lead can track overall progress."),
-		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
-		mcp.WithString("summary", mcp.Description("Brief summary of what you completed.")),
-	)
-	checkReadinessTool := mcp.NewTool(
-		"check_project_readiness",
-		mcp.WithDescription("Check whether all agents on a project have declared completion. Team-lead MUST call this before broadcasting project complete."),
-		mcp.WithString("project", mcp.Required(), mcp.Description("Project name to check.")),
-	)
-	heartbeatTool := mcp.NewTool(
-		"heartbeat_agent",
-		mcp.WithDescription("Ping the broker to signal this agent is still active. Call periodically (every 5 min) to prevent stale-agent pruning."),
-		mcp.WithString("agent_id", mcp.Required(), mcp.Description("Your agent_id.")),
-	)
-	getMessageStatusTool := mcp.NewTool(
-		"get_message_status",
-		mcp.WithDescription("Check delivery and read status for a message you sent."),
-		mcp.WithString("message_id", mcp.Required(), mcp.Description("Message id returned by send_message.")),
-	)
-	pruneAgentsTool := mcp.NewTool(
-		"prune_stale_agents",
-		mcp.WithDescription("Remove agents that have not sent a heartbeat within the given window. Returns count of pruned agents."),
-		mcp.WithString("max_age", mcp.Description("Max idle duration before pruning (e.g. 30m, 1h). Default 30m.")),
-	)
-	publishArtifactTool := mcp.NewTool(
-		"publish_artifact",
-		mcp.WithDescription("Publish a structured artifact (file tree, API schema, Dockerfile, etc.) for teammates to consume."),
-		mcp.WithString("from", mcp.Required(), mcp.Description("Publisher agent_id.")),
-		mcp.WithString("project", mcp.Required(), mcp.Description("Project name.")),
-		mcp.WithString("artifact_type", mcp.Required(), mcp.Description("Type: file_tree, api_endpoint, schema, config, dockerfile, or other.")),
-		mcp.WithString("name", mcp.Required(), mcp.Description("Artifact name (e.g. 'backend_routes', 'db_schema').")),
-		mcp.WithString("content", mcp.Required(), mcp.Description("Artifact content (JSON, YAML, markdown, plain text).")),
-	)
-	listArtifactsTool := mcp.NewTool(
-		"list_artifacts",
-		mcp.WithDescription("List artifacts published for a project, optionally filtered by type."),
-		mcp.WithString("project", mcp.Required(), mcp.Description("Project name.")),
-		mcp.WithString("artifact_type", mcp.Description("Filter by type (e.g. schema, dockerfile). Empty returns all.")),
-	)
+		maxText := req.GetString("max", "10")
+		max, err := strconv.Atoi(maxText)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("invalid max: %s", maxText)), nil
+		}
 
-	s.AddTool(registerTool, registerHandler(b, resolver))
-	s.AddTool(listTool, listHandler(b))
-	s.AddTool(updateProfileTool, updateProfileHandler(b))
-	s.AddTool(findAgentsTool, findAgentsHandler(b))
-	s.AddTool(sendTool, sendHandler(b, registry))
-	s.AddTool(broadcastTool, broadcastHandler(b, registry))
-	s.AddTool(fetchTool, fetchHandler(b))
-	s.AddTool(fetchHistoryTool, fetchHistoryHandler(b))
-	s.AddTool(bindSessionTool, bindSessionHandler(b))
-	s.AddTool(getBindingTool, getSessionBindingHandler(b))
-	s.AddTool(getTeamStatusTool, getTeamStatusHandler(b))
-	s.AddTool(sharedContextTool, sharedContextHandler(b))
-	s.AddTool(waitForAgentsTool, waitForAgentsHandler(b))
-	s.AddTool(declareCompleteTool, declareCompleteHandler(b))
-	s.AddTool(checkReadinessTool, checkReadinessHandler(b))
-	s.AddTool(heartbeatTool, heartbeatHandler(b))
-	s.AddTool(getMessageStatusTool, getMessageStatusHandler(b))
-	s.AddTool(pruneAgentsTool, pruneAgentsHandler(b))
-	s.AddTool(publishArtifactTool, publishArtifactHandler(b))
-	s.AddTool(listArtifactsTool, listArtifactsHandler(b))
-	return s
+		opts := broker.FetchOptions{Priorities: splitList(req.GetString("priority", ""))}
+
+		messages, err := b.FetchWithOptions(agentID, max, opts)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		for _, m := range messages {
+			slog.Info("message delivered", "agent_id", agentID, "id", m.ID, "from", m.From, "body", m.Body)
+		}
+		out := map[string]any{
+			"messages":  messages,
+			"count":     len(messages),
+			"remaining": b.UnreadCount(agentID),
+		}
+		body, _ := json.Marshal(out)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func fetchHistoryHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		maxText := req.GetString("max", "20")
+		max, err := strconv.Atoi(maxText)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("invalid max: %s", maxText)), nil
+		}
+
+		messages, err := b.FetchHistory(agentID, max)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		body, _ := json.Marshal(messages)
+		return mcp.NewToolResultText(string(body)), nil
+	}
 }
 
-func registerHandler(b *broker.Broker, resolver *opencodepush.SessionResolver) server.ToolHandlerFunc {
+func broadcastHandler(b *broker.Broker, registry *push.Registry) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		profile := broker.AgentProfile{
-			Name:           req.GetString("name", ""),
-			Description:    req.GetString("description", ""),
+		from := req.GetString("from", "")
+		bodyText := req.GetString("body", "")
+		if from == "" || bodyText == "" {
+			return mcp.NewToolResultError("from and body are required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, from); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		maxText := req.GetString("max", "20")
+		max, err := strconv.Atoi(maxText)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("invalid max: %s", maxText)), nil
+		}
+		filter := broker.AgentSearchFilter{
+			Query:          req.GetString("query", ""),
 			Project:        req.GetString("project", ""),
 			Role:           req.GetString("role", ""),
-			GitHub:         req.GetString("github", ""),
-			Branch:         req.GetString("branch", ""),
 			Specialization: req.GetString("specialization", ""),
+			Limit:          max,
+		}
+
+		opts := broker.SendOptions{
+			Priority: strings.TrimSpace(req.GetString("priority", "")),
+			ReplyTo:  strings.TrimSpace(req.GetString("reply_to", "")),
+			ThreadID: strings.TrimSpace(req.GetString("thread_id", "")),
+		}
+		messages, err := b.BroadcastWithOptions(from, bodyText, opts, filter)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("broadcast sent", "from", from, "recipients", len(messages), "body", bodyText)
+
+		if len(messages) == 0 {
+			body, _ := json.Marshal(map[string]any{
+				"status":     "warning",
+				"message":    "Broadcast sent but reached 0 recipients. No agents match your filters yet. Call wait_for_agents first, or retry after teammates have registered.",
+				"recipients": 0,
+			})
+			return mcp.NewToolResultText(string(body)), nil
+		}
+
+		for _, m := range messages {
+			pushed, err := pushMessage(b, registry, m)
+			if err != nil {
+				slog.Warn("broadcast push enqueue failed", "from", from, "to", m.To, "error", err)
+			} else if pushed {
+				slog.Info("broadcast push queued", "from", from, "to", m.To)
+			}
+		}
+		out := map[string]any{
+			"status":     "ok",
+			"recipients": len(messages),
+			"thread_id":  messages[0].ThreadID,
+			"messages":   messages,
+		}
+		body, _ := json.Marshal(out)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func createChannelHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		name := req.GetString("name", "")
+		if agentID == "" || strings.TrimSpace(name) == "" {
+			return mcp.NewToolResultError("agent_id and name are required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		c, err := b.CreateChannel(agentID, name, req.GetString("description", ""))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("channel created", "channel", c.Name, "by", agentID)
+		body, _ := json.Marshal(c)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func joinChannelHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return channelMembershipHandler(b, "joined", b.JoinChannel)
+}
+
+func leaveChannelHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return channelMembershipHandler(b, "left", b.LeaveChannel)
+}
+
+func channelMembershipHandler(b *broker.Broker, verb string, apply func(agentID, channel string) (broker.Channel, error)) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		channel := req.GetString("channel", "")
+		if agentID == "" || strings.TrimSpace(channel) == "" {
+			return mcp.NewToolResultError("agent_id and channel are required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		c, err := apply(agentID, channel)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("channel "+verb, "channel", c.Name, "agent_id", agentID)
+		body, _ := json.Marshal(c)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func postToChannelHandler(b *broker.Broker, registry *push.Registry) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		from := req.GetString("from", "")
+		channel := req.GetString("channel", "")
+		bodyText := req.GetString("body", "")
+		if from == "" || channel == "" || bodyText == "" {
+			return mcp.NewToolResultError("from, channel and body are required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, from); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		opts := broker.SendOptions{
+			Priority: strings.TrimSpace(req.GetString("priority", "")),
+			ReplyTo:  strings.TrimSpace(req.GetString("reply_to", "")),
+			ThreadID: strings.TrimSpace(req.GetString("thread_id", "")),
+		}
+		post, messages, err := b.PostToChannel(from, channel, bodyText, opts)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("channel post sent", "from", from, "channel", post.Channel, "recipients", len(messages), "body", bodyText)
+
+		pushed := 0
+		for _, m := range messages {
+			ok, err := pushMessage(b, registry, m)
+			if err != nil {
+				slog.Warn("channel push enqueue failed", "channel", post.Channel, "to", m.To, "error", err)
+			} else if ok {
+				pushed++
+			}
+		}
+		out := map[string]any{
+			"status":     "ok",
+			"id":         post.ID,
+			"channel":    post.Channel,
+			"thread_id":  post.ThreadID,
+			"recipients": len(messages),
+			"pushed":     pushed,
+		}
+		body, _ := json.Marshal(out)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func listChannelsHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		channels, err := b.ListChannels(agentID, req.GetString("project", ""))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		body, _ := json.Marshal(channels)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func channelHistoryHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		channel := req.GetString("channel", "")
+		if agentID == "" || strings.TrimSpace(channel) == "" {
+			return mcp.NewToolResultError("agent_id and channel are required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		maxText := req.GetString("max", "20")
+		max, err := strconv.Atoi(maxText)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("invalid max: %s", maxText)), nil
+		}
+
+		messages, err := b.ChannelHistory(agentID, channel, max)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		out := map[string]any{
+			"channel":  channel,
+			"messages": messages,
+			"count":    len(messages),
+		}
+		body, _ := json.Marshal(out)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func getThreadHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		threadID := strings.TrimSpace(req.GetString("thread_id", ""))
+		if agentID == "" || threadID == "" {
+			return mcp.NewToolResultError("agent_id and thread_id are required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+
+		maxText := req.GetString("max", "100")
+		max, err := strconv.Atoi(maxText)
+		if err != nil {
+			return mcp.NewToolResultError(fmt.Sprintf("invalid max: %s", maxText)), nil
+		}
+
+		messages, err := b.GetThread(agentID, threadID, max)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		out := map[string]any{
+			"thread_id": threadID,
+			"count":     len(messages),
+			"messages":  messages,
+		}
+		body, _ := json.Marshal(out)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func bindSessionHandler(b *broker.Broker, webhooks *push.WebhookAdapter, execs *push.ExecAdapter) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
 
-		// Detect harness type
 		harness := strings.TrimSpace(req.GetString("harness", ""))
-		if harness == "" {
-			harness = detectHarness()
+		webhookURL := strings.TrimSpace(req.GetString("webhook_url", ""))
+		execCommand := strings.TrimSpace(req.GetString("exec_command", ""))
+		if harness == "" && webhookURL != "" {
+			harness = "webhook"
+		}
+		if harness == "" && execCommand != "" {
+			harness = "exec"
 		}
 
-		// Detect session_id EARLY (before register call)
-		sessionID := strings.TrimSpace(req.GetString("session_id", ""))
-		if sessionID == "" {
+		sessionID := req.GetString("session_id", "")
+		if strings.TrimSpace(sessionID) == "" {
 			sessionID = detectSessionID(req.Header)
 		}
-		if sessionID == "" && resolver != nil && resolver.Enabled() {
-			bound := b.ListBoundSessionIDs()
-			autoSessionID, resolveErr := resolver.FindLatestUnboundSession(bound)
-			if resolveErr != nil {
-				slog.Warn("auto bind resolver failed", "error", resolveErr)
-			} else if autoSessionID != "" {
-				sessionID = autoSessionID
+		if strings.TrimSpace(sessionID) == "" {
+			// A webhook agent's session is its endpoint; an exec agent
+			// needs none unless its command uses {session_id}.
+			switch harness {
+			case "webhook":
+				sessionID = webhookURL
+			case "exec":
+				sessionID = "exec:" + agentID
 			}
 		}
+		if strings.TrimSpace(sessionID) == "" {
+			return mcp.NewToolResultError("session_id is required (or must be present in request headers)"), nil
+		}
 
-		var id string
-		var created bool
-		if sessionID != "" {
-			var err error
-			id, created, err = b.RegisterOrUpdateBySession(sessionID, profile)
+		if harness == "" {
+			harness = detectHarness()
+		}
+
+		out := map[string]string{}
+		// undo keeps the adapter's state file in step with the binding if
+		// the bind itself fails.
+		undo := func() {}
+		switch harness {
+		case "webhook":
+			if webhooks == nil {
+				return mcp.NewToolResultError("webhook push is not available on this server"), nil
+			}
+			if webhookURL == "" {
+				webhookURL = sessionID
+			}
+			prev, had := webhooks.Endpoint(agentID)
+			webhook, err := webhooks.Register(agentID, push.WebhookEndpoint{
+				URL:          webhookURL,
+				Secret:       strings.TrimSpace(req.GetString("webhook_secret", "")),
+				Timeout:      strings.TrimSpace(req.GetString("webhook_timeout", "")),
+				ExpectStatus: req.GetInt("webhook_expect_status", 0),
+			})
 			if err != nil {
 				return mcp.NewToolResultError(err.Error()), nil
 			}
-		} else {
-			var err error
-			id, err = b.RegisterAgent(profile)
+			undo = func() {
+				if had {
+					_, _ = webhooks.Register(agentID, prev)
+				} else {
+					webhooks.Unregister(agentID)
+				}
+			}
+			out["webhook_url"] = webhook.URL
+			out["webhook_secret"] = webhook.Secret
+		case "exec":
+			if execs == nil {
+				return mcp.NewToolResultError("exec push is not available on this server"), nil
+			}
+			prev, had := execs.Command(agentID)
+			command, err := execs.Register(agentID, push.ExecCommand{
+				Command: execCommand,
+				Timeout: strings.TrimSpace(req.GetString("exec_timeout", "")),
+			})
 			if err != nil {
 				return mcp.NewToolResultError(err.Error()), nil
 			}
-			created = true
+			undo = func() {
+				if had {
+					_, _ = execs.Register(agentID, prev)
+				} else {
+					execs.Unregister(agentID)
+				}
+			}
+			out["exec_command"] = command.Command
 		}
 
-		slog.Info("agent registered", "agent_id", id, "new", created, "name", profile.Name, "project", profile.Project, "role", profile.Role)
+		if err := b.BindSession(agentID, sessionID, harness); err != nil {
+			undo()
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("session bound", "agent_id", agentID, "session_id", sessionID, "harness", harness)
+		out["agent_id"] = agentID
+		out["session_id"] = sessionID
+		out["harness"] = harness
+		body, _ := json.Marshal(out)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
 
-		out := map[string]string{"agent_id": id}
-		if sessionID != "" {
-			// RegisterOrUpdateBySession already binds the session internally,
-			// but we still need to set the harness via BindSession.
-			if err := b.BindSession(id, sessionID, harness); err == nil {
-				out["session_id"] = sessionID
-				out["harness"] = harness
-			}
-		} else if harness != "" {
-			out["harness"] = harness
+func getSessionBindingHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		sessionID, harness, ok := b.GetSessionBindingWithHarness(agentID)
+		if !ok {
+			return mcp.NewToolResultError("no session bound for agent_id"), nil
+		}
+		out := map[string]string{
+			"agent_id":   agentID,
+			"session_id": redactSessionID(sessionID),
+			"harness":    harness,
 		}
 		body, _ := json.Marshal(out)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func updateProfileHandler(b *broker.Broker) server.ToolHandlerFunc {
+func getTeamStatusHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		agentID := req.GetString("agent_id", "")
 		if agentID == "" {
 			return mcp.NewToolResultError("agent_id is required"), nil
 		}
-		patch := broker.AgentProfile{
-			Name:           req.GetString("name", ""),
-			Description:    req.GetString("description", ""),
-			Project:        req.GetString("project", ""),
-			Role:           req.GetString("role", ""),
-			GitHub:         req.GetString("github", ""),
-			Branch:         req.GetString("branch", ""),
-			Specialization: req.GetString("specialization", ""),
-			Status:         req.GetString("status", ""),
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-		updated, err := b.UpdateAgentProfile(agentID, patch)
+		project := req.GetString("project", "")
+		statuses, err := b.GetTeamStatus(agentID, project)
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
-		body, _ := json.Marshal(updated)
+		body, _ := json.Marshal(statuses)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func findAgentsHandler(b *broker.Broker) server.ToolHandlerFunc {
+func sharedContextHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		maxText := req.GetString("max", "20")
-		max, err := strconv.Atoi(maxText)
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("invalid max: %s", maxText)), nil
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
 		}
-		filter := broker.AgentSearchFilter{
-			Query:          req.GetString("query", ""),
-			Project:        req.GetString("project", ""),
-			Role:           req.GetString("role", ""),
-			Specialization: req.GetString("specialization", ""),
-			Limit:          max,
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-		if raw := strings.TrimSpace(req.GetString("active_within", "")); raw != "" {
-			if d, err := time.ParseDuration(raw); err == nil && d > 0 {
-				filter.ActiveWithin = d
+		action := strings.TrimSpace(req.GetString("action", ""))
+		project := req.GetString("project", "")
+		key := req.GetString("key", "")
+		value := req.GetString("value", "")
+
+		switch action {
+		case "set":
+			if err := b.SharedContextSet(agentID, project, key, value); err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
 			}
+			out := map[string]any{"ok": true, "project": project, "key": key, "value": value}
+			body, _ := json.Marshal(out)
+			return mcp.NewToolResultText(string(body)), nil
+		case "get":
+			v, found, err := b.SharedContextGet(agentID, project, key)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			out := map[string]any{"found": found, "value": v}
+			body, _ := json.Marshal(out)
+			return mcp.NewToolResultText(string(body)), nil
+		case "list":
+			m, err := b.SharedContextList(agentID, project)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+			body, _ := json.Marshal(m)
+			return mcp.NewToolResultText(string(body)), nil
+		default:
+			return mcp.NewToolResultError("action must be set, get, or list"), nil
 		}
-		body, _ := json.Marshal(b.FindAgents(filter))
-		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func listHandler(b *broker.Broker) server.ToolHandlerFunc {
+func waitForAgentsHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		if raw := strings.TrimSpace(req.GetString("active_within", "")); raw != "" {
-			if d, err := time.ParseDuration(raw); err == nil && d > 0 {
-				filter := broker.AgentSearchFilter{Limit: 1000, ActiveWithin: d}
-				body, _ := json.Marshal(b.FindAgents(filter))
-				return mcp.NewToolResultText(string(body)), nil
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		project := req.GetString("project", "")
+		if project == "" {
+			return mcp.NewToolResultError("project is required"), nil
+		}
+		minCount := 2
+		if s := req.GetString("min_count", ""); s != "" {
+			if n, err := strconv.Atoi(s); err == nil {
+				minCount = n
+			}
+		}
+		timeoutSec := 60
+		if s := req.GetString("timeout_seconds", ""); s != "" {
+			if n, err := strconv.Atoi(s); err == nil {
+				timeoutSec = n
 			}
 		}
-		body, _ := json.Marshal(b.ListAgents())
+		agents, met, err := b.WaitForAgents(agentID, project, minCount, timeoutSec)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		out := map[string]any{
+			"met":    met,
+			"count":  len(agents),
+			"agents": agents,
+		}
+		body, _ := json.Marshal(out)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func sendHandler(b *broker.Broker, registry *push.Registry) server.ToolHandlerFunc {
+func declareCompleteHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		from := req.GetString("from", "")
-		to := req.GetString("to", "")
-		msgBody := req.GetString("body", "")
-		if from == "" || to == "" || msgBody == "" {
-			return mcp.NewToolResultError("from, to, and body are required"), nil
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
 		}
-
-		priority := strings.TrimSpace(req.GetString("priority", ""))
-		msg, err := b.Send(from, to, msgBody, priority)
-		if err != nil {
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
-		slog.Info("message sent", "id", msg.ID, "from", from, "to", to, "body", msgBody)
-		if registry != nil {
-			if sessionID, harness, ok := b.GetSessionBindingWithHarness(to); ok && harness != "generic" {
-				pushMsg := push.Message{
-					ID:        msg.ID,
-					From:      msg.From,
-					To:        msg.To,
-					Body:      msg.Body,
-					CreatedAt: msg.CreatedAt.Format(time.RFC3339),
-				}
-				if err := registry.Push(harness, sessionID, to, pushMsg); err != nil {
-					slog.Error("push delivery failed", "agent_id", to, "harness", harness, "error", err)
-				}
+		summary := req.GetString("summary", "")
+		var task *broker.Task
+		if taskID := strings.TrimSpace(req.GetString("task_id", "")); taskID != "" {
+			t, err := b.CompleteTask(agentID, taskID, summary)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
 			}
+			task = &t
 		}
-		out := map[string]any{
-			"id":               msg.ID,
-			"from":             msg.From,
-			"to":               msg.To,
-			"body":             msg.Body,
-			"created_at":       msg.CreatedAt,
-			"recipient_unread": b.UnreadCount(to),
+		if _, err := b.UpdateAgentProfile(agentID, broker.AgentProfile{Status: "done"}); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("agent declared task complete", "agent_id", agentID, "summary", summary)
+		out := map[string]any{"ok": true, "agent_id": agentID, "status": "done", "summary": summary}
+		if task != nil {
+			out["task"] = task
 		}
 		body, _ := json.Marshal(out)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func fetchHandler(b *broker.Broker) server.ToolHandlerFunc {
+func checkReadinessHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		agentID := req.GetString("agent_id", "")
 		if agentID == "" {
 			return mcp.NewToolResultError("agent_id is required"), nil
 		}
-
-		maxText := req.GetString("max", "10")
-		max, err := strconv.Atoi(maxText)
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		project := req.GetString("project", "")
+		if project == "" {
+			return mcp.NewToolResultError("project is required"), nil
+		}
+		statuses, err := b.GetTeamStatus(agentID, project)
 		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("invalid max: %s", maxText)), nil
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-
-		messages, err := b.Fetch(agentID, max)
+		doneCount := 0
+		type pendingEntry struct {
+			ID     string `json:"id"`
+			Name   string `json:"name"`
+			Status string `json:"status"`
+		}
+		pending := make([]pendingEntry, 0)
+		for _, s := range statuses {
+			if s.Status == "done" {
+				doneCount++
+			} else {
+				pending = append(pending, pendingEntry{ID: s.ID, Name: s.Name, Status: s.Status})
+			}
+		}
+		tasks, err := b.ListTasks(agentID, project, broker.TaskFilter{})
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
-		for _, m := range messages {
-			slog.Info("message delivered", "agent_id", agentID, "id", m.ID, "from", m.From, "body", m.Body)
+		openTasks := make([]broker.Task, 0)
+		for _, t := range tasks {
+			if t.Status == broker.TaskOpen || t.Status == broker.TaskClaimed {
+				openTasks = append(openTasks, t)
+			}
 		}
 		out := map[string]any{
-			"messages":  messages,
-			"count":     len(messages),
-			"remaining": b.UnreadCount(agentID),
+			"ready":          len(pending) == 0 && len(openTasks) == 0 && len(statuses) > 0,
+			"total_agents":   len(statuses),
+			"done_count":     doneCount,
+			"pending_agents": pending,
+			"open_tasks":     openTasks,
 		}
 		body, _ := json.Marshal(out)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func fetchHistoryHandler(b *broker.Broker) server.ToolHandlerFunc {
+func createTaskHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		agentID := req.GetString("agent_id", "")
-		if agentID == "" {
-			return mcp.NewToolResultError("agent_id is required"), nil
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		project := req.GetString("project", "")
+		title := req.GetString("title", "")
+		if agentID == "" || project == "" || title == "" {
+			return mcp.NewToolResultError("agent_id, project and title are required"), nil
 		}
-
-		maxText := req.GetString("max", "20")
-		max, err := strconv.Atoi(maxText)
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("invalid max: %s", maxText)), nil
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-
-		messages, err := b.FetchHistory(agentID, max)
+		task, err := b.CreateTask(agentID, project, title, req.GetString("description", ""), splitList(req.GetString("depends_on", "")))
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
-		body, _ := json.Marshal(messages)
+		slog.Info("task created", "id", task.ID, "project", task.Project, "by", agentID)
+		body, _ := json.Marshal(task)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func broadcastHandler(b *broker.Broker, registry *push.Registry) server.ToolHandlerFunc {
+func claimTaskHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		from := req.GetString("from", "")
-		bodyText := req.GetString("body", "")
-		if from == "" || bodyText == "" {
-			return mcp.NewToolResultError("from and body are required"), nil
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		taskID := strings.TrimSpace(req.GetString("task_id", ""))
+		if agentID == "" || taskID == "" {
+			return mcp.NewToolResultError("agent_id and task_id are required"), nil
 		}
-
-		maxText := req.GetString("max", "20")
-		max, err := strconv.Atoi(maxText)
-		if err != nil {
-			return mcp.NewToolResultError(fmt.Sprintf("invalid max: %s", maxText)), nil
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-		filter := broker.AgentSearchFilter{
-			Query:          req.GetString("query", ""),
-			Project:        req.GetString("project", ""),
-			Role:           req.GetString("role", ""),
-			Specialization: req.GetString("specialization", ""),
-			Limit:          max,
+		var lease time.Duration
+		if raw := strings.TrimSpace(req.GetString("lease", "")); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil || d <= 0 {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid lease: %s", raw)), nil
+			}
+			lease = d
 		}
-
-		priority := strings.TrimSpace(req.GetString("priority", ""))
-		messages, err := b.Broadcast(from, bodyText, priority, filter)
+		task, err := b.ClaimTask(agentID, taskID, lease)
 		if err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
-		slog.Info("broadcast sent", "from", from, "recipients", len(messages), "body", bodyText)
+		slog.Info("task claimed", "id", task.ID, "agent_id", agentID, "lease", task.Lease)
+		body, _ := json.Marshal(task)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
 
-		if len(messages) == 0 {
-			body, _ := json.Marshal(map[string]any{
-				"status":     "warning",
-				"message":    "Broadcast sent but reached 0 recipients. No agents match your filters yet. Call wait_for_agents first, or retry after teammates have registered.",
-				"recipients": 0,
-			})
-			return mcp.NewToolResultText(string(body)), nil
+func updateTaskHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		taskID := strings.TrimSpace(req.GetString("task_id", ""))
+		if agentID == "" || taskID == "" {
+			return mcp.NewToolResultError("agent_id and task_id are required"), nil
 		}
-
-		if registry != nil {
-			for _, m := range messages {
-				if sessionID, harness, ok := b.GetSessionBindingWithHarness(m.To); ok && harness != "generic" {
-					pushMsg := push.Message{
-						ID:        m.ID,
-						From:      m.From,
-						To:        m.To,
-						Body:      m.Body,
-						CreatedAt: m.CreatedAt.Format(time.RFC3339),
-					}
-					if err := registry.Push(harness, sessionID, m.To, pushMsg); err != nil {
-						slog.Warn("broadcast push delivery failed", "from", from, "to", m.To, "harness", harness, "error", err)
-					} else {
-						slog.Info("broadcast push delivered", "from", from, "to", m.To, "harness", harness)
-					}
-				}
-			}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-		out := map[string]any{
-			"status":     "ok",
-			"recipients": len(messages),
-			"messages":   messages,
+		// Only fields present in the call are changed.
+		args := req.GetArguments()
+		var patch broker.TaskPatch
+		if _, ok := args["title"]; ok {
+			v := req.GetString("title", "")
+			patch.Title = &v
 		}
-		body, _ := json.Marshal(out)
+		if _, ok := args["description"]; ok {
+			v := req.GetString("description", "")
+			patch.Description = &v
+		}
+		if _, ok := args["depends_on"]; ok {
+			v := splitList(req.GetString("depends_on", ""))
+			patch.DependsOn = &v
+		}
+		if _, ok := args["status"]; ok {
+			v := req.GetString("status", "")
+			patch.Status = &v
+		}
+		task, err := b.UpdateTask(agentID, taskID, patch)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("task updated", "id", task.ID, "agent_id", agentID, "status", task.Status)
+		body, _ := json.Marshal(task)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func bindSessionHandler(b *broker.Broker) server.ToolHandlerFunc {
+func completeTaskHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		agentID := req.GetString("agent_id", "")
-		if agentID == "" {
-			return mcp.NewToolResultError("agent_id is required"), nil
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		taskID := strings.TrimSpace(req.GetString("task_id", ""))
+		if agentID == "" || taskID == "" {
+			return mcp.NewToolResultError("agent_id and task_id are required"), nil
 		}
-
-		sessionID := req.GetString("session_id", "")
-		if strings.TrimSpace(sessionID) == "" {
-			sessionID = detectSessionID(req.Header)
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-		if strings.TrimSpace(sessionID) == "" {
-			return mcp.NewToolResultError("session_id is required (or must be present in request headers)"), nil
+		task, err := b.CompleteTask(agentID, taskID, req.GetString("result", ""))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
+		slog.Info("task completed", "id", task.ID, "agent_id", agentID)
+		body, _ := json.Marshal(task)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
 
-		harness := strings.TrimSpace(req.GetString("harness", ""))
-		if harness == "" {
-			harness = detectHarness()
+func listTasksHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		project := strings.TrimSpace(req.GetString("project", ""))
+		if agentID == "" || project == "" {
+			return mcp.NewToolResultError("agent_id and project are required"), nil
 		}
-
-		if err := b.BindSession(agentID, sessionID, harness); err != nil {
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
-		slog.Info("session bound", "agent_id", agentID, "session_id", sessionID, "harness", harness)
-		out := map[string]string{
-			"agent_id":   agentID,
-			"session_id": sessionID,
-			"harness":    harness,
+		tasks, err := b.ListTasks(agentID, project, broker.TaskFilter{
+			Status:   strings.TrimSpace(req.GetString("status", "")),
+			Assignee: strings.TrimSpace(req.GetString("assignee", "")),
+		})
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-		body, _ := json.Marshal(out)
+		body, _ := json.Marshal(tasks)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func getSessionBindingHandler(b *broker.Broker) server.ToolHandlerFunc {
+func acquireLockHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		agentID := req.GetString("agent_id", "")
-		if agentID == "" {
-			return mcp.NewToolResultError("agent_id is required"), nil
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		project := strings.TrimSpace(req.GetString("project", ""))
+		resource := strings.TrimSpace(req.GetString("resource", ""))
+		if agentID == "" || project == "" || resource == "" {
+			return mcp.NewToolResultError("agent_id, project and resource are required"), nil
 		}
-		sessionID, harness, ok := b.GetSessionBindingWithHarness(agentID)
-		if !ok {
-			return mcp.NewToolResultError("no session bound for agent_id"), nil
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-		out := map[string]string{
-			"agent_id":   agentID,
-			"session_id": sessionID,
-			"harness":    harness,
+		var ttl time.Duration
+		if raw := strings.TrimSpace(req.GetString("ttl", "")); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil || d <= 0 {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid ttl: %s", raw)), nil
+			}
+			ttl = d
+		}
+		waitSec := 0
+		if raw := strings.TrimSpace(req.GetString("wait_seconds", "")); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid wait_seconds: %s", raw)), nil
+			}
+			waitSec = n
+		}
+		note := req.GetString("note", "")
+		var lock broker.Lock
+		var err error
+		if waitSec > 0 {
+			lock, err = b.AcquireLockWait(agentID, project, resource, ttl, note, time.Duration(waitSec)*time.Second)
+		} else {
+			lock, err = b.AcquireLock(agentID, project, resource, ttl, note)
 		}
-		body, _ := json.Marshal(out)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("lock acquired", "project", lock.Project, "resource", lock.Resource, "agent_id", agentID, "ttl", lock.TTL)
+		body, _ := json.Marshal(lock)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func getTeamStatusHandler(b *broker.Broker) server.ToolHandlerFunc {
+func releaseLockHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		project := req.GetString("project", "")
-		statuses := b.GetTeamStatus(project)
-		body, _ := json.Marshal(statuses)
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		project := strings.TrimSpace(req.GetString("project", ""))
+		resource := strings.TrimSpace(req.GetString("resource", ""))
+		if agentID == "" || project == "" || resource == "" {
+			return mcp.NewToolResultError("agent_id, project and resource are required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		if err := b.ReleaseLock(agentID, project, resource); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("lock released", "project", project, "resource", resource, "agent_id", agentID)
+		body, _ := json.Marshal(map[string]any{"status": "released", "project": project, "resource": resource})
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func sharedContextHandler(b *broker.Broker) server.ToolHandlerFunc {
+func listLocksHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		action := strings.TrimSpace(req.GetString("action", ""))
-		project := req.GetString("project", "")
-		key := req.GetString("key", "")
-		value := req.GetString("value", "")
-
-		switch action {
-		case "set":
-			if err := b.SharedContextSet(project, key, value); err != nil {
-				return mcp.NewToolResultError(err.Error()), nil
-			}
-			out := map[string]any{"ok": true, "project": project, "key": key, "value": value}
-			body, _ := json.Marshal(out)
-			return mcp.NewToolResultText(string(body)), nil
-		case "get":
-			v, found := b.SharedContextGet(project, key)
-			out := map[string]any{"found": found, "value": v}
-			body, _ := json.Marshal(out)
-			return mcp.NewToolResultText(string(body)), nil
-		case "list":
-			m := b.SharedContextList(project)
-			body, _ := json.Marshal(m)
-			return mcp.NewToolResultText(string(body)), nil
-		default:
-			return mcp.NewToolResultError("action must be set, get, or list"), nil
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		locks, err := b.ListLocks(agentID, req.GetString("project", ""))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
+		body, _ := json.Marshal(locks)
+		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func waitForAgentsHandler(b *broker.Broker) server.ToolHandlerFunc {
-	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		project := req.GetString("project", "")
-		if project == "" {
-			return mcp.NewToolResultError("project is required"), nil
+// lockCheckHandler serves GET /locks/check for editor hooks, which cannot
+// call MCP tools. It reports whether path is locked by another agent. The
+// caller is identified by agent_id or by the harness session_id; the
+// checked project must be covered by the bearer token's scopes.
+func lockCheckHandler(b *broker.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
 		}
-		minCount := 2
-		if s := req.GetString("min_count", ""); s != "" {
-			if n, err := strconv.Atoi(s); err == nil {
-				minCount = n
-			}
+		q := r.URL.Query()
+		path := strings.TrimSpace(q.Get("path"))
+		if path == "" {
+			http.Error(w, "path is required", http.StatusBadRequest)
+			return
 		}
-		timeoutSec := 60
-		if s := req.GetString("timeout_seconds", ""); s != "" {
-			if n, err := strconv.Atoi(s); err == nil {
-				timeoutSec = n
-			}
+		agentID := strings.TrimSpace(q.Get("agent_id"))
+		if agentID == "" {
+			agentID, _ = b.AgentForSession(q.Get("session_id"))
 		}
-		agents, met := b.WaitForAgents(project, minCount, timeoutSec)
-		out := map[string]any{
-			"met":    met,
-			"count":  len(agents),
-			"agents": agents,
+		project := strings.TrimSpace(q.Get("project"))
+		if profile, ok := b.GetAgentProfile(agentID); ok && project == "" {
+			project = profile.Project
 		}
-		body, _ := json.Marshal(out)
-		return mcp.NewToolResultText(string(body)), nil
+		if err := authorizeProject(r.Context(), project); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		out := map[string]any{"locked": false}
+		if lock, ok := b.CheckLock(agentID, project, path); ok {
+			out = map[string]any{"locked": true, "lock": lock}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(out)
 	}
 }
 
-func declareCompleteHandler(b *broker.Broker) server.ToolHandlerFunc {
+// splitList parses a comma-separated tool argument, dropping empty items.
+func splitList(raw string) []string {
+	var out []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func heartbeatHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		agentID := req.GetString("agent_id", "")
 		if agentID == "" {
 			return mcp.NewToolResultError("agent_id is required"), nil
 		}
-		summary := req.GetString("summary", "")
-		if _, err := b.UpdateAgentProfile(agentID, broker.AgentProfile{Status: "done"}); err != nil {
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
-		slog.Info("agent declared task complete", "agent_id", agentID, "summary", summary)
-		out := map[string]any{"ok": true, "agent_id": agentID, "status": "done", "summary": summary}
+		if err := b.Heartbeat(agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		out := map[string]any{"ok": true, "agent_id": agentID, "timestamp": time.Now().UTC()}
 		body, _ := json.Marshal(out)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func checkReadinessHandler(b *broker.Broker) server.ToolHandlerFunc {
+func getMessageStatusHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		project := req.GetString("project", "")
-		if project == "" {
-			return mcp.NewToolResultError("project is required"), nil
-		}
-		statuses := b.GetTeamStatus(project)
-		doneCount := 0
-		type pendingEntry struct {
-			ID     string `json:"id"`
-			Name   string `json:"name"`
-			Status string `json:"status"`
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		msgID := strings.TrimSpace(req.GetString("message_id", ""))
+		if agentID == "" || msgID == "" {
+			return mcp.NewToolResultError("agent_id and message_id are required"), nil
 		}
-		pending := make([]pendingEntry, 0)
-		for _, s := range statuses {
-			if s.Status == "done" {
-				doneCount++
-			} else {
-				pending = append(pending, pendingEntry{ID: s.ID, Name: s.Name, Status: s.Status})
-			}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
-		out := map[string]any{
-			"ready":          len(pending) == 0 && len(statuses) > 0,
-			"total_agents":   len(statuses),
-			"done_count":     doneCount,
-			"pending_agents": pending,
+		rec, ok := b.GetMessageStatus(agentID, msgID)
+		if !ok {
+			return mcp.NewToolResultError(fmt.Sprintf("message not found: %s", msgID)), nil
 		}
-		body, _ := json.Marshal(out)
+		body, _ := json.Marshal(rec)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func heartbeatHandler(b *broker.Broker) server.ToolHandlerFunc {
+func deadLettersHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-		agentID := req.GetString("agent_id", "")
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
 		if agentID == "" {
 			return mcp.NewToolResultError("agent_id is required"), nil
 		}
-		if err := b.Heartbeat(agentID); err != nil {
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
 			return mcp.NewToolResultError(err.Error()), nil
 		}
-		out := map[string]any{"ok": true, "agent_id": agentID, "timestamp": time.Now().UTC()}
-		body, _ := json.Marshal(out)
+		jobs := b.DeadLetters(agentID)
+		for i := range jobs {
+			jobs[i].SessionID = redactSessionID(jobs[i].SessionID)
+		}
+		body, _ := json.Marshal(jobs)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
-func getMessageStatusHandler(b *broker.Broker) server.ToolHandlerFunc {
+// redactSessionID keeps only the ends of a session id. Session ids can be
+// MCP session ids, which authorize calls, or webhook URLs, so tool output
+// shows just enough to recognize one.
+func redactSessionID(id string) string {
+	if len(id) <= 12 {
+		return strings.Repeat("*", len(id))
+	}
+	return id[:4] + "..." + id[len(id)-4:]
+}
+
+func ackMessageHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
 		msgID := strings.TrimSpace(req.GetString("message_id", ""))
-		if msgID == "" {
-			return mcp.NewToolResultError("message_id is required"), nil
+		if agentID == "" || msgID == "" {
+			return mcp.NewToolResultError("agent_id and message_id are required"), nil
 		}
-		rec, ok := b.GetMessageStatus(msgID)
-		if !ok {
-			return mcp.NewToolResultError(fmt.Sprintf("message not found: %s", msgID)), nil
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		rec, err := b.AckMessage(agentID, msgID, req.GetString("status", ""), req.GetString("note", ""))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
 		}
+		slog.Info("message acknowledged", "id", msgID, "agent_id", agentID, "state", rec.State)
 		body, _ := json.Marshal(rec)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
+// minPruneAge keeps prune_stale_agents from removing agents that are
+// merely between heartbeats.
+const minPruneAge = time.Minute
+
 func pruneAgentsHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := strings.TrimSpace(req.GetString("agent_id", ""))
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 		maxAge := 30 * time.Minute
 		if raw := strings.TrimSpace(req.GetString("max_age", "")); raw != "" {
 			if d, err := time.ParseDuration(raw); err == nil && d > 0 {
-				maxAge = d
+				maxAge = max(d, minPruneAge)
 			}
 		}
-		count := b.PruneStaleAgents(maxAge)
-		slog.Info("pruned stale agents", "count", count, "max_age", maxAge)
+		count, err := b.PruneProjectStaleAgents(agentID, maxAge)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("pruned stale agents", "count", count, "max_age", maxAge, "by", agentID)
 		out := map[string]any{"pruned": count, "max_age": maxAge.String()}
 		body, _ := json.Marshal(out)
 		return mcp.NewToolResultText(string(body)), nil
@@ -1940,6 +4375,9 @@ func publishArtifactHandler(b *broker.Broker) server.ToolHandlerFunc {
 		artifactType := strings.TrimSpace(req.GetString("artifact_type", ""))
 		name := strings.TrimSpace(req.GetString("name", ""))
 		content := req.GetString("content", "")
+		if err := authorizeAgent(ctx, b, req, from); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 
 		artifact, err := b.PublishArtifact(from, project, artifactType, name, content)
 		if err != nil {
@@ -1953,17 +4391,275 @@ func publishArtifactHandler(b *broker.Broker) server.ToolHandlerFunc {
 
 func listArtifactsHandler(b *broker.Broker) server.ToolHandlerFunc {
 	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 		project := strings.TrimSpace(req.GetString("project", ""))
 		artifactType := strings.TrimSpace(req.GetString("artifact_type", ""))
 		if project == "" {
 			return mcp.NewToolResultError("project is required"), nil
 		}
-		artifacts := b.ListArtifacts(project, artifactType)
+		artifacts, err := b.ListArtifacts(agentID, project, artifactType)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
 		body, _ := json.Marshal(artifacts)
 		return mcp.NewToolResultText(string(body)), nil
 	}
 }
 
+func getProjectHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		p, err := b.GetProject(agentID, req.GetString("project", ""))
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		body, _ := json.Marshal(p)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func listProjectsHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		body, _ := json.Marshal(b.ListProjects(agentID))
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func setProjectPolicyHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		var patch broker.ProjectPolicyPatch
+		args := req.GetArguments()
+		if _, ok := args["join"]; ok {
+			v := req.GetString("join", "")
+			patch.Join = &v
+		}
+		if _, ok := args["broadcast"]; ok {
+			v := req.GetString("broadcast", "")
+			patch.Broadcast = &v
+		}
+		if _, ok := args["write_context"]; ok {
+			v := req.GetString("write_context", "")
+			patch.WriteContext = &v
+		}
+		if _, ok := args["write_artifacts"]; ok {
+			v := req.GetString("write_artifacts", "")
+			patch.WriteArtifacts = &v
+		}
+		if _, ok := args["admin_roles"]; ok {
+			v := splitList(req.GetString("admin_roles", ""))
+			patch.AdminRoles = &v
+		}
+		p, err := b.SetProjectPolicy(agentID, req.GetString("project", ""), patch)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("project policy updated", "project", p.Name, "by", agentID)
+		body, _ := json.Marshal(p)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func addProjectMemberHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		memberID := req.GetString("member_id", "")
+		if memberID == "" {
+			return mcp.NewToolResultError("member_id is required"), nil
+		}
+		admin := false
+		if raw := strings.TrimSpace(req.GetString("admin", "")); raw != "" {
+			v, err := strconv.ParseBool(raw)
+			if err != nil {
+				return mcp.NewToolResultError(fmt.Sprintf("invalid admin: %s", raw)), nil
+			}
+			admin = v
+		}
+		p, err := b.AddProjectMember(agentID, req.GetString("project", ""), memberID, admin)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("project member added", "project", p.Name, "member", memberID, "admin", admin, "by", agentID)
+		body, _ := json.Marshal(p)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func removeProjectMemberHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		agentID := req.GetString("agent_id", "")
+		if agentID == "" {
+			return mcp.NewToolResultError("agent_id is required"), nil
+		}
+		if err := authorizeAgent(ctx, b, req, agentID); err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		memberID := req.GetString("member_id", "")
+		if memberID == "" {
+			return mcp.NewToolResultError("member_id is required"), nil
+		}
+		p, err := b.RemoveProjectMember(agentID, req.GetString("project", ""), memberID)
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		slog.Info("project member removed", "project", p.Name, "member", memberID, "by", agentID)
+		body, _ := json.Marshal(p)
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+func healthHandler(b *broker.Broker) server.ToolHandlerFunc {
+	return func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		body, _ := json.Marshal(b.Health())
+		return mcp.NewToolResultText(string(body)), nil
+	}
+}
+
+// pushMessage queues a push to the recipient's bound harness session and
+// to the MCP client session it registered from. It reports false when the
+// recipient has neither. runPushWorker does the delivery, so a slow
+// harness never holds up the tool call.
+func pushMessage(b *broker.Broker, registry *push.Registry, m broker.Message) (bool, error) {
+	if registry == nil {
+		return false, nil
+	}
+	queued := false
+	var errs []error
+	if sessionID, harness, ok := b.GetSessionBindingWithHarness(m.To); ok && harness != "generic" {
+		if _, err := b.EnqueuePush(m, harness, sessionID); err != nil {
+			errs = append(errs, fmt.Errorf("queue %s push: %w", harness, err))
+		} else {
+			queued = true
+		}
+	}
+	// The MCP session is tracked by the adapter, which also gives the
+	// generic harness push.
+	if sessionID, ok := registry.TrackedSession("mcp", m.To); ok {
+		if _, err := b.EnqueuePush(m, "mcp", sessionID); err != nil {
+			errs = append(errs, fmt.Errorf("queue mcp push: %w", err))
+		} else {
+			queued = true
+		}
+	}
+	return queued, errors.Join(errs...)
+}
+
+// pushWorkerPoll is how often the push worker looks for retries that have
+// come due; new pushes wake it immediately.
+const pushWorkerPoll = time.Second
+
+// pushWorkerConcurrency caps how many pushes are in flight at once.
+const pushWorkerConcurrency = 16
+
+// runPushWorker delivers queued pushes until ctx is done. Each push runs
+// in its own goroutine; a failure is retried with the adapter's backoff
+// and dead-lettered once its retry policy is exhausted.
+func runPushWorker(ctx context.Context, b *broker.Broker, registry *push.Registry) {
+	ticker := time.NewTicker(pushWorkerPoll)
+	defer ticker.Stop()
+	slots := make(chan struct{}, pushWorkerConcurrency)
+	for {
+		if free := cap(slots) - len(slots); free > 0 {
+			for _, job := range b.ClaimDuePushes(time.Now(), free) {
+				slots <- struct{}{}
+				go func(job broker.PushJob) {
+					defer func() { <-slots }()
+					deliverPush(b, registry, job)
+				}(job)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-b.PushWake():
+		}
+	}
+}
+
+// deliverPush makes one attempt at a queued push and records the outcome.
+func deliverPush(b *broker.Broker, registry *push.Registry, job broker.PushJob) {
+	m := job.Message
+	err := registry.Push(job.Harness, job.SessionID, m.To, toPushMessage(b, m))
+	if err == nil {
+		if err := b.CompletePush(job.ID); err != nil {
+			slog.Warn("record push failed", "job_id", job.ID, "error", err)
+		}
+		slog.Info("push delivered", "harness", job.Harness, "agent_id", m.To, "message_id", m.ID, "attempts", job.Attempts+1)
+		return
+	}
+	policy := registry.RetryPolicy(job.Harness)
+	attempts := job.Attempts + 1
+	// A disabled adapter stays disabled until restart; retrying cannot help.
+	if policy.Exhausted(attempts) || errors.Is(err, push.ErrAdapterDisabled) {
+		slog.Error("push dead-lettered", "harness", job.Harness, "agent_id", m.To, "message_id", m.ID, "attempts", attempts, "error", err)
+		if err := b.DeadLetterPush(job.ID, err.Error()); err != nil {
+			slog.Warn("record push failed", "job_id", job.ID, "error", err)
+		}
+		return
+	}
+	delay := policy.Backoff(attempts)
+	slog.Warn("push failed, retrying", "harness", job.Harness, "agent_id", m.To, "message_id", m.ID, "attempts", attempts, "retry_in", delay, "error", err)
+	if err := b.RetryPush(job.ID, err.Error(), time.Now().Add(delay)); err != nil {
+		slog.Warn("record push failed", "job_id", job.ID, "error", err)
+	}
+}
+
+// toPushMessage converts a broker message into the push adapter envelope,
+// adding the sender's and recipient's profile names for templates.
+func toPushMessage(b *broker.Broker, m broker.Message) push.Message {
+	pm := push.Message{
+		ID:        m.ID,
+		From:      m.From,
+		To:        m.To,
+		Body:      m.Body,
+		Priority:  m.Priority,
+		Channel:   m.Channel,
+		ReplyTo:   m.ReplyTo,
+		ThreadID:  m.ThreadID,
+		Request:   m.Request,
+		CreatedAt: m.CreatedAt.Format(time.RFC3339),
+	}
+	if p, ok := b.GetAgentProfile(m.From); ok {
+		pm.FromName, pm.FromRole = p.Name, p.Role
+	}
+	if p, ok := b.GetAgentProfile(m.To); ok {
+		pm.ToName = p.Name
+	}
+	return pm
+}
+
 func detectHarness() string {
 	if os.Getenv("CODEX_THREAD_ID") != "" {
 		return "codex"
@@ -2013,6 +4709,14 @@ func getDurationFromEnv(key string, fallback time.Duration) time.Duration {
 	return d
 }
 
+func getIntFromEnv(key string, fallback int) int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key)))
+	if err != nil || n <= 0 {
+		return fallback
+	}
+	return n
+}
+
 func getBoolFromEnv(key string, fallback bool) bool {
 	raw := strings.TrimSpace(os.Getenv(key))
 	if raw == "" {
@@ -0,0 +1,94 @@
+package broker
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnauthorized is returned when a caller cannot prove it is the agent
+// it wants to act as.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// tokenPrefix marks agent tokens so they are recognizable in configs and
+// logs.
+const tokenPrefix = "rmt-"
+
+// IssueToken generates a new secret token for agentID, revoking any
+// previous one. Only a hash of the token is kept.
+func (b *Broker) IssueToken(agentID string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	token := tokenPrefix + hex.EncodeToString(buf)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a := b.agents[agentID]
+	if a == nil {
+		return "", fmt.Errorf("agent not found: %s", agentID)
+	}
+	prev := a.TokenHash
+	a.TokenHash = hashToken(token)
+	if err := b.saveAgent(a); err != nil {
+		a.TokenHash = prev
+		return "", err
+	}
+	return token, nil
+}
+
+// BindAuthSession lets calls made over the MCP session mcpSessionID act
+// as agentID without passing its token. Bindings are kept in memory only,
+// so they end with the server process.
+func (b *Broker) BindAuthSession(mcpSessionID, agentID string) {
+	mcpSessionID = strings.TrimSpace(mcpSessionID)
+	if mcpSessionID == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.agents[agentID]; ok {
+		b.authSessions[agentID] = mcpSessionID
+	}
+}
+
+// Authorize checks that a caller may act as agentID: it presents the
+// agent's current token, or calls over the MCP session the agent was
+// registered on.
+func (b *Broker) Authorize(agentID, token, mcpSessionID string) error {
+	agentID = strings.TrimSpace(agentID)
+	if agentID == "" {
+		return fmt.Errorf("agent_id is required")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a := b.agents[agentID]
+	if a == nil {
+		return fmt.Errorf("agent not found: %s", agentID)
+	}
+	return b.authorizeLocked(a, token, mcpSessionID)
+}
+
+// authorizeLocked is Authorize for a known agent. b.mu must be held.
+func (b *Broker) authorizeLocked(a *agentState, token, mcpSessionID string) error {
+	if token = strings.TrimSpace(token); token != "" {
+		if a.TokenHash != "" && subtle.ConstantTimeCompare([]byte(a.TokenHash), []byte(hashToken(token))) == 1 {
+			return nil
+		}
+		return fmt.Errorf("%w: invalid token for %s", ErrUnauthorized, a.ID)
+	}
+	if sid := strings.TrimSpace(mcpSessionID); sid != "" && b.authSessions[a.ID] == sid {
+		return nil
+	}
+	return fmt.Errorf("%w: token required to act as %s (register_agent returns it)", ErrUnauthorized, a.ID)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,130 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenAuthorizesOnlyItsAgent(t *testing.T) {
+	b := newTestBroker(t)
+	alice, aliceToken, _, err := b.RegisterOrUpdateBySession("", testProfile("alice"), "", "")
+	if err != nil {
+		t.Fatalf("register alice: %v", err)
+	}
+	bob, bobToken, _, err := b.RegisterOrUpdateBySession("", testProfile("bob"), "", "")
+	if err != nil {
+		t.Fatalf("register bob: %v", err)
+	}
+
+	if err := b.Authorize(alice, aliceToken, ""); err != nil {
+		t.Fatalf("expected alice's token to authorize alice: %v", err)
+	}
+	if err := b.Authorize(alice, bobToken, ""); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected bob's token to be rejected for alice, got %v", err)
+	}
+	if err := b.Authorize(bob, "", ""); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected a missing token to be rejected, got %v", err)
+	}
+	if err := b.Authorize("ag-missing", aliceToken, ""); err == nil {
+		t.Fatal("expected unknown agent to be rejected")
+	}
+}
+
+func TestAuthSessionActsWithoutToken(t *testing.T) {
+	b := newTestBroker(t)
+	alice, _ := b.RegisterAgent(testProfile("alice"))
+	bob, _ := b.RegisterAgent(testProfile("bob"))
+
+	b.BindAuthSession("mcp-1", alice)
+	if err := b.Authorize(alice, "", "mcp-1"); err != nil {
+		t.Fatalf("expected bound MCP session to authorize: %v", err)
+	}
+	if err := b.Authorize(bob, "", "mcp-1"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected session to be limited to its agent, got %v", err)
+	}
+	if err := b.Authorize(alice, "", "mcp-2"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected other sessions to need the token, got %v", err)
+	}
+	if err := b.Authorize(alice, "rmt-wrong", "mcp-1"); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected a wrong token to fail even on the bound session, got %v", err)
+	}
+}
+
+func TestReRegistrationRotatesToken(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+	id, first, _, err := b1.RegisterOrUpdateBySession("sess-a", testProfile("alice"), "", "")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	b1.Close()
+
+	// Tokens survive a restart.
+	b2 := newTestBrokerOn(t, s)
+	if err := b2.Authorize(id, first, ""); err != nil {
+		t.Fatalf("expected token to survive restart: %v", err)
+	}
+
+	again, second, created, err := b2.RegisterOrUpdateBySession("sess-a", testProfile("alice"), first, "")
+	if err != nil || again != id || created {
+		t.Fatalf("expected re-registration of %s, got %s created=%v err=%v", id, again, created, err)
+	}
+	if second == first {
+		t.Fatal("expected a new token")
+	}
+	if err := b2.Authorize(id, first, ""); !errors.Is(err, ErrUnauthorized) {
+		t.Fatalf("expected rotated-out token to be rejected, got %v", err)
+	}
+	if err := b2.Authorize(id, second, ""); err != nil {
+		t.Fatalf("expected new token to authorize: %v", err)
+	}
+}
+
+func TestReRegistrationRequiresCredentials(t *testing.T) {
+	b := newTestBroker(t)
+	id, token, _, err := b.RegisterOrUpdateBySession("sess-a", testProfile("alice"), "", "")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	b.BindAuthSession("mcp-1", id)
+
+	for _, tc := range []struct{ token, mcpSession string }{
+		{"", ""},
+		{"", "mcp-2"},
+		{"rmt-wrong", "mcp-1"},
+	} {
+		if _, _, _, err := b.RegisterOrUpdateBySession("sess-a", testProfile("mallory"), tc.token, tc.mcpSession); !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("token=%q session=%q: expected ErrUnauthorized, got %v", tc.token, tc.mcpSession, err)
+		}
+	}
+	if err := b.Authorize(id, token, ""); err != nil {
+		t.Fatalf("expected the rejected attempts to leave the token valid: %v", err)
+	}
+	if p, _ := b.GetAgentProfile(id); p.Name != "alice" {
+		t.Fatalf("expected the profile untouched, got %q", p.Name)
+	}
+
+	// The MCP session the agent registered over may re-register it.
+	again, _, created, err := b.RegisterOrUpdateBySession("sess-a", testProfile("alice"), "", "mcp-1")
+	if err != nil || again != id || created {
+		t.Fatalf("expected re-registration over the bound session, got %s created=%v err=%v", again, created, err)
+	}
+}
+
+func TestPruneInvalidatesToken(t *testing.T) {
+	b := newTestBroker(t)
+	id, token, _, err := b.RegisterOrUpdateBySession("sess-a", testProfile("alice"), "", "")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	b.BindAuthSession("mcp-1", id)
+
+	time.Sleep(time.Millisecond)
+	if n := b.PruneStaleAgents(time.Nanosecond); n != 1 {
+		t.Fatalf("expected 1 pruned agent, got %d", n)
+	}
+	if err := b.Authorize(id, token, "mcp-1"); err == nil {
+		t.Fatal("expected pruned agent's token to be invalid")
+	}
+}
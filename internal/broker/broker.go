@@ -1,9 +1,12 @@
 package broker
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
@@ -14,8 +17,22 @@ import (
 	"github.com/nats-io/nats.go"
 )
 
-const subjectPrefix = "relay.agent"
-const streamName = "RELAY_MESSAGES"
+const registryBucket = "RELAY_AGENTS"
+
+// messageRetention is how long the stream keeps messages, and with them
+// their delivery records.
+const messageRetention = 7 * 24 * time.Hour
+
+// fetchWait bounds how long Fetch waits for the inbox consumer to deliver
+// messages it already reported as pending.
+const fetchWait = 2 * time.Second
+
+// fetchScanLimit caps how many pending messages Fetch pulls at once to
+// find the most pressing ones.
+const fetchScanLimit = 256
+
+// ErrReplyTimeout is returned by PendingAsk.Wait when no reply arrives in time.
+var ErrReplyTimeout = errors.New("timed out waiting for reply")
 
 // Message is the minimal NATS message envelope for this POC.
 type Message struct {
@@ -24,16 +41,101 @@ type Message struct {
 	To        string    `json:"to"`
 	Body      string    `json:"body"`
 	Priority  string    `json:"priority,omitempty"` // "normal" | "urgent" | "blocking"
+	ReplyTo   string    `json:"reply_to,omitempty"` // message_id this message answers
+	ThreadID  string    `json:"thread_id,omitempty"`
+	Request   bool      `json:"request,omitempty"` // sender is blocked waiting for a reply_to answer
+	Channel   string    `json:"channel,omitempty"` // set on channel posts and their inbox copies
 	CreatedAt time.Time `json:"created_at"`
 }
 
-// DeliveryRecord tracks send and read timestamps for a message.
+// SendOptions carries optional message fields for SendWithOptions.
+// A message with neither ReplyTo nor ThreadID starts a new thread.
+type SendOptions struct {
+	Priority string
+	ReplyTo  string
+	ThreadID string
+	Request  bool
+	Strategy string // routing for role:<role>@<project> recipients; see RouteRoundRobin
+
+	channel string // set by PostToChannel on per-member copies
+}
+
+// Message priorities, most to least pressing.
+const (
+	PriorityBlocking = "blocking"
+	PriorityUrgent   = "urgent"
+	PriorityNormal   = "normal"
+)
+
+// priorityRank orders priorities for Fetch; lower is delivered first.
+var priorityRank = map[string]int{
+	PriorityBlocking: 0,
+	PriorityUrgent:   1,
+	PriorityNormal:   2,
+}
+
+// NormalizePriority defaults an empty priority to normal and rejects
+// unknown values.
+func NormalizePriority(priority string) (string, error) {
+	priority = strings.ToLower(strings.TrimSpace(priority))
+	if priority == "" {
+		return PriorityNormal, nil
+	}
+	if _, ok := priorityRank[priority]; !ok {
+		return "", fmt.Errorf("invalid priority %q: must be normal, urgent, or blocking", priority)
+	}
+	return priority, nil
+}
+
+// Message lifecycle states, in order. acted_on and rejected are terminal.
+const (
+	StateQueued       = "queued"
+	StatePushed       = "pushed"
+	StateFetched      = "fetched"
+	StateAcknowledged = "acknowledged"
+	StateActedOn      = "acted_on"
+	StateRejected     = "rejected"
+)
+
+var stateRank = map[string]int{
+	StateQueued:       0,
+	StatePushed:       1,
+	StateFetched:      2,
+	StateAcknowledged: 3,
+	StateActedOn:      4,
+	StateRejected:     4,
+}
+
+// DeliveryEvent is one step in a message's lifecycle.
+type DeliveryEvent struct {
+	State string    `json:"state"`
+	At    time.Time `json:"at"`
+	Note  string    `json:"note,omitempty"`
+}
+
+// DeliveryRecord tracks a message through its lifecycle. State is the
+// furthest state reached; Timeline lists every transition in order.
 type DeliveryRecord struct {
-	MessageID     string     `json:"message_id"`
-	To            string     `json:"to"`
-	SentAt        time.Time  `json:"sent_at"`
-	ReadAt        *time.Time `json:"read_at,omitempty"`
-	PushDelivered bool       `json:"push_delivered"`
+	MessageID     string          `json:"message_id"`
+	From          string          `json:"from"`
+	To            string          `json:"to"`
+	Priority      string          `json:"priority,omitempty"`
+	State         string          `json:"state"`
+	SentAt        time.Time       `json:"sent_at"`
+	ReadAt        *time.Time      `json:"read_at,omitempty"`
+	PushDelivered bool            `json:"push_delivered"`
+	PushAttempts  int             `json:"push_attempts,omitempty"`
+	PushError     string          `json:"push_error,omitempty"` // last failed attempt, cleared on success
+	Timeline      []DeliveryEvent `json:"timeline"`
+}
+
+// advance records a lifecycle event. The event always lands in the
+// timeline, but State only moves forward. Caller holds b.mu.
+func (r *DeliveryRecord) advance(state, note string, at time.Time) {
+	r.Timeline = append(r.Timeline, DeliveryEvent{State: state, At: at, Note: note})
+	if stateRank[state] > stateRank[r.State] {
+		r.State = state
+	}
 }
 
 // Artifact is a structured deliverable published by an agent for teammates.
@@ -77,6 +179,7 @@ type AgentSearchFilter struct {
 	Specialization string
 	Limit          int
 	ActiveWithin   time.Duration // if > 0, only return agents seen within this window
+	Viewer         string        // if set, only return agents sharing a project with this agent
 }
 
 type agentState struct {
@@ -85,26 +188,75 @@ type agentState struct {
 	Subject   string
 	SessionID string
 	Harness   string // "opencode", "claude-code", "codex", "generic"
-	Queue     []Message
 	LastSeen  time.Time
 	LastFetch time.Time
+	TokenHash string // sha256 of the agent's token; empty until one is issued
+
+	savedSeen time.Time // LastSeen as last persisted
+}
+
+// agentRecord is the persisted form of an agent in the registry KV bucket.
+type agentRecord struct {
+	ID        string       `json:"id"`
+	Profile   AgentProfile `json:"profile"`
+	Subject   string       `json:"subject"`
+	SessionID string       `json:"session_id,omitempty"`
+	Harness   string       `json:"harness,omitempty"`
+	LastSeen  time.Time    `json:"last_seen"`
+	LastFetch time.Time    `json:"last_fetch"`
+	TokenHash string       `json:"token_hash,omitempty"`
 }
 
 // Broker stores anonymous agent routing state and uses NATS as transport.
+// The agent registry is persisted in a JetStream KV bucket so registrations
+// and session bindings survive a broker restart.
 type Broker struct {
 	mu            sync.Mutex
 	nc            *nats.Conn
 	js            nats.JetStreamContext
+	conn          *connState // NATS connection events, for Health
+	subjectPrefix string     // roots every subject; see Config.SubjectPrefix
+	streamName    string
+	registry      nats.KeyValue
+	channelKV     nats.KeyValue
+	taskKV        nats.KeyValue
+	lockKV        nats.KeyValue
+	pushQueueKV   nats.KeyValue
+	projectKV     nats.KeyValue
+	deliveryKV    nats.KeyValue
 	agents        map[string]*agentState
-	subs          map[string]*nats.Subscription
-	sessionIndex  map[string]string            // session_id → agent_id
-	contextStore  map[string]map[string]string // project → key → value
-	deliveryLog   map[string]*DeliveryRecord   // message_id → delivery record
-	artifactStore map[string][]Artifact        // project → artifacts
+	subs          map[string]*nats.Subscription // agent_id → inbox pull subscription
+	sessionIndex  map[string]string             // session_id → agent_id
+	contextStore  map[string]map[string]string  // project → key → value
+	deliveryLog   map[string]*DeliveryRecord    // message_id → delivery record
+	threadIndex   map[string]threadRef          // message_id → thread
+	artifactStore map[string][]Artifact         // project → artifacts
+	channels      map[string]*Channel           // channel name → channel
+	roleCursor    map[string]int                // role address → round-robin position
+	tasks         map[string]*Task              // task_id → task
+	locks         map[string]*Lock              // lock key → lock
+	pushJobs      map[string]*PushJob           // job id → queued or dead push
+	pushWake      chan struct{}                 // signalled by EnqueuePush
+	authSessions  map[string]string             // agent_id → MCP session allowed to act as it
+	projects      map[string]*Project           // project name → access control record
+	roleDrainMu   sync.Mutex                    // serializes role queue drains
+
+	deliveriesTrimmed time.Time // last trimDeliveries sweep
 }
 
-func New(natsURL string) (*Broker, error) {
-	nc, err := nats.Connect(natsURL)
+// New connects to NATS as cfg describes and restores the broker's state
+// from JetStream.
+func New(cfg Config) (*Broker, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	conn := &connState{}
+	opts, err := cfg.options(conn)
+	if err != nil {
+		return nil, err
+	}
+	nc, err := nats.Connect(cfg.URL, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("connect to nats: %w", err)
 	}
@@ -113,20 +265,163 @@ func New(natsURL string) (*Broker, error) {
 		_ = nc.Drain()
 		return nil, fmt.Errorf("init jetstream context: %w", err)
 	}
-	if err := ensureStream(js); err != nil {
+	if err := ensureStream(js, cfg.StreamName, cfg.SubjectPrefix); err != nil {
 		_ = nc.Drain()
 		return nil, err
 	}
-	return &Broker{
+	registry, err := ensureBucket(js, registryBucket, "relay-mesh agent registry")
+	if err != nil {
+		_ = nc.Drain()
+		return nil, err
+	}
+	channelKV, err := ensureBucket(js, channelBucket, "relay-mesh channels")
+	if err != nil {
+		_ = nc.Drain()
+		return nil, err
+	}
+	taskKV, err := ensureBucket(js, taskBucket, "relay-mesh task board")
+	if err != nil {
+		_ = nc.Drain()
+		return nil, err
+	}
+	lockKV, err := ensureBucket(js, lockBucket, "relay-mesh advisory locks")
+	if err != nil {
+		_ = nc.Drain()
+		return nil, err
+	}
+	pushQueueKV, err := ensureBucket(js, pushQueueBucket, "relay-mesh push retry queue")
+	if err != nil {
+		_ = nc.Drain()
+		return nil, err
+	}
+	projectKV, err := ensureBucket(js, projectBucket, "relay-mesh project access control")
+	if err != nil {
+		_ = nc.Drain()
+		return nil, err
+	}
+	deliveryKV, err := ensureBucketTTL(js, deliveryBucket, "relay-mesh message delivery records", messageRetention)
+	if err != nil {
+		_ = nc.Drain()
+		return nil, err
+	}
+	b := &Broker{
 		nc:            nc,
 		js:            js,
+		conn:          conn,
+		subjectPrefix: cfg.SubjectPrefix,
+		streamName:    cfg.StreamName,
+		registry:      registry,
+		channelKV:     channelKV,
+		taskKV:        taskKV,
+		lockKV:        lockKV,
+		pushQueueKV:   pushQueueKV,
+		projectKV:     projectKV,
+		deliveryKV:    deliveryKV,
 		agents:        make(map[string]*agentState),
 		subs:          make(map[string]*nats.Subscription),
 		sessionIndex:  make(map[string]string),
 		contextStore:  make(map[string]map[string]string),
 		deliveryLog:   make(map[string]*DeliveryRecord),
+		threadIndex:   make(map[string]threadRef),
 		artifactStore: make(map[string][]Artifact),
-	}, nil
+		channels:      make(map[string]*Channel),
+		roleCursor:    make(map[string]int),
+		tasks:         make(map[string]*Task),
+		locks:         make(map[string]*Lock),
+		pushJobs:      make(map[string]*PushJob),
+		pushWake:      make(chan struct{}, 1),
+		authSessions:  make(map[string]string),
+		projects:      make(map[string]*Project),
+	}
+	if err := b.rehydrate(); err != nil {
+		b.Close()
+		return nil, err
+	}
+	b.mu.Lock()
+	err = b.loadChannels()
+	if err == nil {
+		err = b.loadTasks()
+	}
+	if err == nil {
+		err = b.loadLocks()
+	}
+	if err == nil {
+		err = b.loadPushJobs()
+	}
+	if err == nil {
+		err = b.loadProjects()
+	}
+	if err == nil {
+		err = b.loadDeliveries()
+	}
+	ids := make([]string, 0, len(b.agents))
+	for id := range b.agents {
+		ids = append(ids, id)
+	}
+	b.mu.Unlock()
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	// Role messages queued while nobody served the role go to rehydrated
+	// agents that serve it now.
+	sort.Strings(ids)
+	for _, id := range ids {
+		_, _ = b.drainRoleQueue(id)
+	}
+	return b, nil
+}
+
+// rehydrate restores agents, session bindings and subscriptions from the
+// registry bucket. Records that fail to decode are skipped.
+func (b *Broker) rehydrate() error {
+	keys, err := b.registry.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("list registry keys: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range keys {
+		entry, err := b.registry.Get(key)
+		if err != nil {
+			continue
+		}
+		var rec agentRecord
+		if err := json.Unmarshal(entry.Value(), &rec); err != nil || rec.ID == "" {
+			continue
+		}
+		// The subject follows the configured prefix, which may have changed
+		// since the record was written.
+		rec.Subject = b.agentSubject(rec.ID)
+		state := &agentState{
+			ID:        rec.ID,
+			Profile:   rec.Profile,
+			Subject:   rec.Subject,
+			SessionID: rec.SessionID,
+			Harness:   rec.Harness,
+			LastSeen:  rec.LastSeen,
+			LastFetch: rec.LastFetch,
+			TokenHash: rec.TokenHash,
+			savedSeen: rec.LastSeen,
+		}
+		// A missing consumer means the inbox was lost; recreate it empty
+		// rather than replaying the agent's whole history as unread.
+		sub, err := b.subscribeAgent(state.ID, state.Subject, nats.DeliverNewPolicy)
+		if err != nil {
+			return err
+		}
+		b.agents[state.ID] = state
+		b.subs[state.ID] = sub
+		if state.SessionID != "" {
+			b.sessionIndex[state.SessionID] = state.ID
+		}
+	}
+	return nil
 }
 
 func (b *Broker) Close() {
@@ -143,7 +438,19 @@ func (b *Broker) Close() {
 	}
 }
 
+// RegisterAgent registers a new agent and hands it any messages waiting
+// in its role queue.
 func (b *Broker) RegisterAgent(profile AgentProfile) (string, error) {
+	id, err := b.registerAgent(profile)
+	if err != nil {
+		return "", err
+	}
+	// Undelivered role messages stay queued for the next registration.
+	_, _ = b.drainRoleQueue(id)
+	return id, nil
+}
+
+func (b *Broker) registerAgent(profile AgentProfile) (string, error) {
 	profile = normalizeProfile(profile)
 	if err := validateProfile(profile); err != nil {
 		return "", err
@@ -154,7 +461,7 @@ func (b *Broker) RegisterAgent(profile AgentProfile) (string, error) {
 		return "", err
 	}
 
-	subject := fmt.Sprintf("%s.%s", subjectPrefix, id)
+	subject := b.agentSubject(id)
 	if strings.TrimSpace(profile.Name) == "" {
 		profile.Name = id
 	}
@@ -166,34 +473,135 @@ func (b *Broker) RegisterAgent(profile AgentProfile) (string, error) {
 		profile.Status = "idle"
 	}
 	state := &agentState{ID: id, Profile: profile, Subject: subject, LastSeen: time.Now().UTC()}
-	sub, err := b.nc.Subscribe(subject, func(msg *nats.Msg) {
-		var incoming Message
-		if err := json.Unmarshal(msg.Data, &incoming); err != nil {
-			return
-		}
-
-		b.mu.Lock()
-		defer b.mu.Unlock()
-		a := b.agents[id]
-		if a == nil {
-			return
-		}
-		a.Queue = append(a.Queue, incoming)
-	})
+	sub, err := b.subscribeAgent(id, subject, nats.DeliverAllPolicy)
 	if err != nil {
-		return "", fmt.Errorf("subscribe: %w", err)
+		return "", err
 	}
-	if err := b.nc.Flush(); err != nil {
+	if err := b.saveAgent(state); err != nil {
 		_ = sub.Unsubscribe()
-		return "", fmt.Errorf("flush subscription: %w", err)
+		_ = b.js.DeleteConsumer(b.streamName, inboxConsumer(id))
+		return "", err
 	}
 
 	b.agents[id] = state
 	b.subs[id] = sub
+	b.joinProfileProject(state)
 	return id, nil
 }
 
-func (b *Broker) RegisterOrUpdateBySession(sessionID string, profile AgentProfile) (agentID string, created bool, err error) {
+// subscribeAgent binds a pull subscription to the agent's durable inbox
+// consumer, creating the consumer with the given deliver policy if it does
+// not exist yet. Caller must hold b.mu.
+func (b *Broker) subscribeAgent(id, subject string, deliver nats.DeliverPolicy) (*nats.Subscription, error) {
+	durable := inboxConsumer(id)
+	info, err := b.js.ConsumerInfo(b.streamName, durable)
+	switch {
+	case err == nil && info.Config.FilterSubject != subject:
+		// The subject prefix changed; follow it.
+		cfg := info.Config
+		cfg.FilterSubject = subject
+		if _, err := b.js.UpdateConsumer(b.streamName, &cfg); err != nil {
+			return nil, fmt.Errorf("update inbox consumer: %w", err)
+		}
+	case err != nil:
+		if !errors.Is(err, nats.ErrConsumerNotFound) {
+			return nil, fmt.Errorf("inbox consumer info: %w", err)
+		}
+		_, err := b.js.AddConsumer(b.streamName, &nats.ConsumerConfig{
+			Durable:       durable,
+			Description:   "relay-mesh inbox for " + id,
+			FilterSubject: subject,
+			DeliverPolicy: deliver,
+			AckPolicy:     nats.AckExplicitPolicy,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("create inbox consumer: %w", err)
+		}
+	}
+	sub, err := b.js.PullSubscribe(subject, durable, nats.Bind(b.streamName, durable))
+	if err != nil {
+		return nil, fmt.Errorf("subscribe: %w", err)
+	}
+	return sub, nil
+}
+
+// saveAgent writes the agent's current state to the registry bucket.
+// Caller must hold b.mu.
+func (b *Broker) saveAgent(a *agentState) error {
+	data, err := json.Marshal(a.record())
+	if err != nil {
+		return fmt.Errorf("marshal agent record: %w", err)
+	}
+	if _, err := b.registry.Put(a.ID, data); err != nil {
+		return fmt.Errorf("persist agent: %w", err)
+	}
+	a.savedSeen = a.LastSeen
+	return nil
+}
+
+func (a *agentState) record() agentRecord {
+	return agentRecord{
+		ID:        a.ID,
+		Profile:   a.Profile,
+		Subject:   a.Subject,
+		SessionID: a.SessionID,
+		Harness:   a.Harness,
+		LastSeen:  a.LastSeen,
+		LastFetch: a.LastFetch,
+		TokenHash: a.TokenHash,
+	}
+}
+
+// lastSeenSaveInterval bounds how often activity alone rewrites an
+// agent's registry record.
+const lastSeenSaveInterval = time.Minute
+
+// touchAgent records activity by a. Sends and fetches happen far more
+// often than pruning needs, so LastSeen is persisted at most once per
+// lastSeenSaveInterval, through the returned write. Caller holds b.mu and
+// flushes the writes after releasing it.
+func (b *Broker) touchAgent(a *agentState, fetched bool) []kvWrite {
+	now := time.Now().UTC()
+	a.LastSeen = now
+	if fetched {
+		a.LastFetch = now
+	}
+	if now.Sub(a.savedSeen) < lastSeenSaveInterval {
+		return nil
+	}
+	a.savedSeen = now
+	id := a.ID
+	return []kvWrite{newKVWrite(b.registry, id, func() any {
+		if a := b.agents[id]; a != nil {
+			return a.record()
+		}
+		return nil
+	})}
+}
+
+// RegisterOrUpdateBySession registers an agent, or updates the one already
+// registered for sessionID, and issues it a fresh token. Updating an
+// existing agent requires its current token or the MCP session it was
+// registered over (see Authorize), since session IDs are not secret;
+// otherwise it fails with ErrUnauthorized.
+func (b *Broker) RegisterOrUpdateBySession(sessionID string, profile AgentProfile, token, mcpSessionID string) (agentID, newToken string, created bool, err error) {
+	agentID, created, err = b.registerOrUpdateBySession(sessionID, profile, token, mcpSessionID)
+	if err != nil {
+		return "", "", false, err
+	}
+	if !created {
+		// RegisterAgent drains for new agents; a re-bound agent may have
+		// taken on a role with messages waiting.
+		_, _ = b.drainRoleQueue(agentID)
+	}
+	newToken, err = b.IssueToken(agentID)
+	if err != nil {
+		return "", "", false, err
+	}
+	return agentID, newToken, created, nil
+}
+
+func (b *Broker) registerOrUpdateBySession(sessionID string, profile AgentProfile, token, mcpSessionID string) (agentID string, created bool, err error) {
 	sessionID = strings.TrimSpace(sessionID)
 	if sessionID == "" {
 		id, err := b.RegisterAgent(profile)
@@ -213,25 +621,25 @@ func (b *Broker) RegisterOrUpdateBySession(sessionID string, profile AgentProfil
 			if err != nil {
 				return "", false, err
 			}
-			b.mu.Lock()
-			b.sessionIndex[sessionID] = id
-			if a := b.agents[id]; a != nil {
-				a.SessionID = sessionID
+			if err := b.indexSession(sessionID, id); err != nil {
+				return "", false, err
 			}
-			b.mu.Unlock()
 			return id, true, nil
 		}
 
-		// Dedup: update existing agent's profile.
-		applyProfilePatch(&agent.Profile, profile)
-		agent.Profile = normalizeProfile(agent.Profile)
-		if err := validateProfile(agent.Profile); err != nil {
+		if err := b.authorizeLocked(agent, token, mcpSessionID); err != nil {
+			b.mu.Unlock()
+			return "", false, err
+		}
+		// Dedup: update existing agent's profile and re-bind the session to
+		// preserve the harness binding.
+		if err := b.patchAgent(agent, profile, func(a *agentState) {
+			a.SessionID = sessionID
+			a.LastSeen = time.Now().UTC()
+		}); err != nil {
 			b.mu.Unlock()
 			return "", false, err
 		}
-		// Re-bind session to preserve harness binding.
-		agent.SessionID = sessionID
-		agent.LastSeen = time.Now().UTC()
 		b.mu.Unlock()
 		return existingID, false, nil
 	}
@@ -242,15 +650,25 @@ func (b *Broker) RegisterOrUpdateBySession(sessionID string, profile AgentProfil
 	if err != nil {
 		return "", false, err
 	}
-	b.mu.Lock()
-	b.sessionIndex[sessionID] = id
-	if a := b.agents[id]; a != nil {
-		a.SessionID = sessionID
+	if err := b.indexSession(sessionID, id); err != nil {
+		return "", false, err
 	}
-	b.mu.Unlock()
 	return id, true, nil
 }
 
+// indexSession binds sessionID to a freshly registered agent and persists it.
+func (b *Broker) indexSession(sessionID, agentID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a := b.agents[agentID]
+	if a == nil {
+		return fmt.Errorf("agent not found: %s", agentID)
+	}
+	b.sessionIndex[sessionID] = agentID
+	a.SessionID = sessionID
+	return b.saveAgent(a)
+}
+
 func (b *Broker) ListAgents() []map[string]string {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -273,7 +691,29 @@ func (b *Broker) ListAgents() []map[string]string {
 	return out
 }
 
+// GetAgentProfile returns a registered agent's profile.
+func (b *Broker) GetAgentProfile(agentID string) (AgentProfile, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	a, ok := b.agents[agentID]
+	if !ok {
+		return AgentProfile{}, false
+	}
+	return a.Profile, true
+}
+
+// UpdateAgentProfile patches an agent's profile. An agent that takes on a
+// role (or stops being done) picks up messages waiting for that role.
 func (b *Broker) UpdateAgentProfile(agentID string, patch AgentProfile) (map[string]string, error) {
+	out, err := b.updateAgentProfile(agentID, patch)
+	if err != nil {
+		return nil, err
+	}
+	_, _ = b.drainRoleQueue(out["id"])
+	return out, nil
+}
+
+func (b *Broker) updateAgentProfile(agentID string, patch AgentProfile) (map[string]string, error) {
 	agentID = strings.TrimSpace(agentID)
 	if agentID == "" {
 		return nil, fmt.Errorf("agent_id is required")
@@ -287,9 +727,7 @@ func (b *Broker) UpdateAgentProfile(agentID string, patch AgentProfile) (map[str
 		return nil, fmt.Errorf("agent not found: %s", agentID)
 	}
 
-	applyProfilePatch(&agent.Profile, patch)
-	agent.Profile = normalizeProfile(agent.Profile)
-	if err := validateProfile(agent.Profile); err != nil {
+	if err := b.patchAgent(agent, patch, nil); err != nil {
 		return nil, err
 	}
 
@@ -307,6 +745,33 @@ func (b *Broker) UpdateAgentProfile(agentID string, patch AgentProfile) (map[str
 	}, nil
 }
 
+// patchAgent applies a profile patch, and any other changes edit makes, to
+// a copy of agent, then validates and saves the copy before it replaces
+// agent in memory, so a failed update changes nothing. Caller holds b.mu.
+func (b *Broker) patchAgent(agent *agentState, patch AgentProfile, edit func(*agentState)) error {
+	next := *agent
+	applyProfilePatch(&next.Profile, patch)
+	next.Profile = normalizeProfile(next.Profile)
+	if err := validateProfile(next.Profile); err != nil {
+		return err
+	}
+	if err := b.checkProjectChange(agent, next.Profile); err != nil {
+		return err
+	}
+	if err := b.checkRoleChange(agent, next.Profile.Role); err != nil {
+		return err
+	}
+	if edit != nil {
+		edit(&next)
+	}
+	if err := b.saveAgent(&next); err != nil {
+		return err
+	}
+	*agent = next
+	b.joinProfileProject(agent)
+	return nil
+}
+
 func (b *Broker) FindAgents(filter AgentSearchFilter) []map[string]string {
 	filter = normalizeFilter(filter)
 	b.mu.Lock()
@@ -321,6 +786,9 @@ func (b *Broker) FindAgents(filter AgentSearchFilter) []map[string]string {
 	totalTokens := len(tokenize(filter.Query))
 
 	for _, a := range b.agents {
+		if filter.Viewer != "" && !b.visibleTo(filter.Viewer, a.ID) {
+			continue
+		}
 		if filter.ActiveWithin > 0 && time.Since(a.LastSeen) > filter.ActiveWithin {
 			continue
 		}
@@ -396,11 +864,15 @@ func (b *Broker) BindSession(agentID, sessionID, harness string) error {
 	if agent == nil {
 		return fmt.Errorf("agent not found: %s", agentID)
 	}
+	if prev := agent.SessionID; prev != "" && prev != sessionID && b.sessionIndex[prev] == agentID {
+		delete(b.sessionIndex, prev)
+	}
 	agent.SessionID = sessionID
+	b.sessionIndex[sessionID] = agentID
 	if harness != "" {
 		agent.Harness = harness
 	}
-	return nil
+	return b.saveAgent(agent)
 }
 
 func (b *Broker) GetSessionBinding(agentID string) (string, bool) {
@@ -439,31 +911,86 @@ func (b *Broker) ListBoundSessionIDs() map[string]struct{} {
 }
 
 func (b *Broker) Send(from, to, body, priority string) (Message, error) {
+	return b.SendWithOptions(from, to, body, SendOptions{Priority: priority})
+}
+
+// SendWithOptions sends a direct message. When opts.ReplyTo is set the
+// message joins the replied-to message's thread.
+func (b *Broker) SendWithOptions(from, to, body string, opts SendOptions) (Message, error) {
+	id, err := randomID("msg")
+	if err != nil {
+		return Message{}, err
+	}
+	return b.sendWithID(id, from, to, body, opts)
+}
+
+func (b *Broker) sendWithID(id, from, to, body string, opts SendOptions) (Message, error) {
 	b.mu.Lock()
 	fromAgent := b.agents[from]
 	toAgent := b.agents[to]
+	var writes []kvWrite
 	if fromAgent != nil {
-		fromAgent.LastSeen = time.Now().UTC()
+		writes = b.touchAgent(fromAgent, false)
 	}
 	b.mu.Unlock()
+	_ = b.flushWrites(writes)
 
 	if fromAgent == nil {
 		return Message{}, fmt.Errorf("sender agent not found: %s", from)
 	}
-	if toAgent == nil {
+
+	var roleAddr RoleAddress
+	if toAgent == nil && IsRoleAddress(to) {
+		addr, err := ParseRoleAddress(to)
+		if err != nil {
+			return Message{}, err
+		}
+		b.mu.Lock()
+		_, err = b.requireProjectAccess(from, addr.Project, AccessMembers)
+		b.mu.Unlock()
+		if err != nil {
+			return Message{}, err
+		}
+		strategy, err := NormalizeRouteStrategy(opts.Strategy)
+		if err != nil {
+			return Message{}, err
+		}
+		roleAddr = addr
+		toAgent = b.routeRole(from, addr, strategy)
+	} else if toAgent == nil {
 		return Message{}, fmt.Errorf("target agent not found: %s", to)
 	}
 
-	id, err := randomID("msg")
+	priority, err := NormalizePriority(opts.Priority)
+	if err != nil {
+		return Message{}, err
+	}
+	opts.Priority = priority
+
+	threadID, err := b.resolveThread(opts)
 	if err != nil {
 		return Message{}, err
 	}
+	opts.ThreadID = threadID
+	if toAgent == nil {
+		return b.queueForRole(id, from, roleAddr, body, opts)
+	}
+	return b.publish(id, from, toAgent.ID, toAgent.Subject, body, opts)
+}
+
+// publish stores a message on subject for recipient `to` (an agent ID or
+// a role address). opts.ThreadID must already be resolved.
+func (b *Broker) publish(id, from, to, subject, body string, opts SendOptions) (Message, error) {
 	m := Message{
 		ID:        id,
 		From:      from,
 		To:        to,
 		Body:      body,
-		Priority:  priority,
+		Priority:  opts.Priority,
+		ReplyTo:   strings.TrimSpace(opts.ReplyTo),
+		ThreadID:  opts.ThreadID,
+		Request:   opts.Request,
+		Channel:   opts.channel,
 		CreatedAt: time.Now().UTC(),
 	}
 	data, err := json.Marshal(m)
@@ -471,62 +998,267 @@ func (b *Broker) Send(from, to, body, priority string) (Message, error) {
 		return Message{}, fmt.Errorf("marshal message: %w", err)
 	}
 
-	// Record delivery before NATS publish (callback fires async).
+	// Record delivery before publish so status is visible immediately.
 	b.mu.Lock()
-	b.deliveryLog[id] = &DeliveryRecord{
+	if m.CreatedAt.Sub(b.deliveriesTrimmed) >= deliveryTrimInterval {
+		b.trimDeliveries(m.CreatedAt)
+	}
+	rec := &DeliveryRecord{
 		MessageID: id,
+		From:      from,
 		To:        to,
-		SentAt:    time.Now().UTC(),
+		Priority:  m.Priority,
+		State:     StateQueued,
+		SentAt:    m.CreatedAt,
+		Timeline:  []DeliveryEvent{{State: StateQueued, At: m.CreatedAt}},
 	}
+	if err := b.saveDelivery(rec); err != nil {
+		b.mu.Unlock()
+		return Message{}, err
+	}
+	b.deliveryLog[id] = rec
+	b.threadIndex[id] = threadRef{threadID: m.ThreadID, at: m.CreatedAt}
 	b.mu.Unlock()
 
-	if _, err := b.js.Publish(toAgent.Subject, data); err != nil {
+	if _, err := b.js.Publish(subject, data); err != nil {
 		b.mu.Lock()
+		_ = b.deliveryKV.Delete(id)
 		delete(b.deliveryLog, id)
+		delete(b.threadIndex, id)
 		b.mu.Unlock()
 		return Message{}, fmt.Errorf("jetstream publish: %w", err)
 	}
+	if m.ReplyTo != "" {
+		// Wake any asker blocked on this reply; nobody listening is fine.
+		_ = b.nc.Publish(b.replySubject(m.ReplyTo), data)
+
+		// A reply from the recipient implies they accepted the parent.
+		b.mu.Lock()
+		if parent, ok := b.deliveryLog[m.ReplyTo]; ok && parent.To == from && stateRank[parent.State] < stateRank[StateAcknowledged] {
+			parent.advance(StateAcknowledged, "replied with "+id, m.CreatedAt)
+			_ = b.saveDelivery(parent)
+		}
+		b.mu.Unlock()
+	}
 
 	return m, nil
 }
 
-func (b *Broker) FetchHistory(agentID string, max int) ([]Message, error) {
-	if max <= 0 {
-		max = 20
-	}
+// PendingAsk is a request sent by Ask whose reply has not been awaited yet.
+type PendingAsk struct {
+	Message Message
+	sub     *nats.Subscription
+	broker  *Broker
+}
 
-	b.mu.Lock()
-	agent := b.agents[agentID]
-	b.mu.Unlock()
-	if agent == nil {
-		return nil, fmt.Errorf("agent not found: %s", agentID)
+// Ask sends body to `to` flagged as a request. The returned PendingAsk
+// is already listening for the reply, so callers can push the request to
+// the recipient before calling Wait. Callers must Close it.
+func (b *Broker) Ask(from, to, body string, opts SendOptions) (*PendingAsk, error) {
+	id, err := randomID("msg")
+	if err != nil {
+		return nil, err
 	}
-
-	info, err := b.js.StreamInfo(streamName)
+	sub, err := b.nc.SubscribeSync(b.replySubject(id))
 	if err != nil {
-		return nil, fmt.Errorf("stream info: %w", err)
+		return nil, fmt.Errorf("subscribe reply: %w", err)
 	}
-	if info == nil || info.State.Msgs == 0 {
-		return []Message{}, nil
+	if err := b.nc.Flush(); err != nil {
+		_ = sub.Unsubscribe()
+		return nil, fmt.Errorf("flush reply subscription: %w", err)
 	}
 
-	out := make([]Message, 0, max)
-	firstSeq := info.State.FirstSeq
-	lastSeq := info.State.LastSeq
+	opts.Request = true
+	m, err := b.sendWithID(id, from, to, body, opts)
+	if err != nil {
+		_ = sub.Unsubscribe()
+		return nil, err
+	}
+	return &PendingAsk{Message: m, sub: sub, broker: b}, nil
+}
 
-	for seq := lastSeq; seq >= firstSeq && len(out) < max; seq-- {
-		stored, err := b.js.GetMsg(streamName, seq)
+// Wait blocks until the request's recipient replies with reply_to set to the
+// request id. It returns ErrReplyTimeout once timeout elapses and ctx.Err()
+// if ctx is done first.
+func (p *PendingAsk) Wait(ctx context.Context, timeout time.Duration) (Message, error) {
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		raw, err := p.sub.NextMsgWithContext(waitCtx)
 		if err != nil {
-			continue
+			if ctx.Err() != nil {
+				return Message{}, ctx.Err()
+			}
+			if waitCtx.Err() != nil {
+				return Message{}, ErrReplyTimeout
+			}
+			return Message{}, fmt.Errorf("wait for reply: %w", err)
 		}
-		var msg Message
-		if err := json.Unmarshal(stored.Data, &msg); err != nil {
+		var reply Message
+		if err := json.Unmarshal(raw.Data, &reply); err != nil {
 			continue
 		}
-		if msg.To != agentID {
+		// Only the addressed agent can answer the request.
+		if reply.From != p.recipient() {
 			continue
 		}
-		out = append(out, msg)
+		return reply, nil
+	}
+}
+
+// recipient returns the agent the request was delivered to. Requests sent
+// to a role address get their recipient when the role queue is drained.
+func (p *PendingAsk) recipient() string {
+	if !IsRoleAddress(p.Message.To) {
+		return p.Message.To
+	}
+	p.broker.mu.Lock()
+	defer p.broker.mu.Unlock()
+	if rec, ok := p.broker.deliveryLog[p.Message.ID]; ok {
+		return rec.To
+	}
+	return p.Message.To
+}
+
+// Close stops listening for the reply.
+func (p *PendingAsk) Close() {
+	_ = p.sub.Unsubscribe()
+}
+
+// resolveThread returns the thread a message with opts belongs to. Replies
+// inherit the thread of the message they answer; otherwise an explicit
+// ThreadID is kept and a new thread ID is generated when none is given.
+func (b *Broker) resolveThread(opts SendOptions) (string, error) {
+	replyTo := strings.TrimSpace(opts.ReplyTo)
+	threadID := strings.TrimSpace(opts.ThreadID)
+	if replyTo == "" {
+		if threadID != "" {
+			return threadID, nil
+		}
+		return randomID("th")
+	}
+
+	parentThread, ok := b.lookupThread(replyTo)
+	if !ok {
+		return "", fmt.Errorf("reply_to message not found: %s", replyTo)
+	}
+	if threadID != "" && threadID != parentThread {
+		return "", fmt.Errorf("thread_id %s does not match thread of reply_to message (%s)", threadID, parentThread)
+	}
+	return parentThread, nil
+}
+
+// lookupThread finds the thread of a message, falling back to JetStream
+// for messages sent before the last restart.
+func (b *Broker) lookupThread(messageID string) (string, bool) {
+	b.mu.Lock()
+	ref, ok := b.threadIndex[messageID]
+	b.mu.Unlock()
+	if ok {
+		return ref.threadID, true
+	}
+
+	found, err := b.scanMessages(1, func(m Message) bool { return m.ID == messageID })
+	if err != nil || len(found) == 0 {
+		return "", false
+	}
+	threadID := found[0].ThreadID
+	if threadID == "" {
+		// Messages from before threading existed start their own thread.
+		threadID = found[0].ID
+	}
+	b.mu.Lock()
+	b.threadIndex[messageID] = threadRef{threadID: threadID, at: found[0].CreatedAt}
+	b.mu.Unlock()
+	return threadID, true
+}
+
+func (b *Broker) FetchHistory(agentID string, max int) ([]Message, error) {
+	if max <= 0 {
+		max = 20
+	}
+
+	b.mu.Lock()
+	agent := b.agents[agentID]
+	b.mu.Unlock()
+	if agent == nil {
+		return nil, fmt.Errorf("agent not found: %s", agentID)
+	}
+
+	return b.scanMessages(max, func(m Message) bool { return m.To == agentID })
+}
+
+// GetThread returns up to max messages of a thread from JetStream, oldest
+// first. Messages from before threading existed are matched by their own ID.
+// viewer only sees messages it sent or received, and posts to channels of
+// its projects.
+func (b *Broker) GetThread(viewer, threadID string, max int) ([]Message, error) {
+	viewer = strings.TrimSpace(viewer)
+	threadID = strings.TrimSpace(threadID)
+	if threadID == "" {
+		return nil, fmt.Errorf("thread_id is required")
+	}
+	if max <= 0 {
+		max = 100
+	}
+	b.mu.Lock()
+	if b.agents[viewer] == nil {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("agent not found: %s", viewer)
+	}
+	projects := make(map[string]bool)
+	for name, p := range b.projects {
+		if _, ok := p.Members[viewer]; ok {
+			projects[name] = true
+		}
+	}
+	b.mu.Unlock()
+	return b.scanMessages(max, func(m Message) bool {
+		if m.Channel != "" && m.To != "" {
+			// Channel posts appear once, as the channel record, not per member.
+			return false
+		}
+		if m.ThreadID != threadID && (m.ThreadID != "" || m.ID != threadID) {
+			return false
+		}
+		if m.Channel != "" {
+			return projects[channelProject(m.Channel)]
+		}
+		return m.From == viewer || m.To == viewer
+	})
+}
+
+// scanMessages walks the message stream from newest to oldest and returns
+// the latest max messages accepted by match, ordered oldest to newest.
+func (b *Broker) scanMessages(max int, match func(Message) bool) ([]Message, error) {
+	info, err := b.js.StreamInfo(b.streamName)
+	if err != nil {
+		return nil, fmt.Errorf("stream info: %w", err)
+	}
+	if info == nil || info.State.Msgs == 0 {
+		return []Message{}, nil
+	}
+
+	out := make([]Message, 0, max)
+	firstSeq := info.State.FirstSeq
+	lastSeq := info.State.LastSeq
+
+	for seq := lastSeq; seq >= firstSeq && len(out) < max; seq-- {
+		stored, err := b.js.GetMsg(b.streamName, seq)
+		if err != nil {
+			if seq == firstSeq {
+				break
+			}
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal(stored.Data, &msg); err == nil && match(msg) {
+			out = append(out, msg)
+		}
 		if seq == firstSeq {
 			break
 		}
@@ -540,6 +1272,13 @@ func (b *Broker) FetchHistory(agentID string, max int) ([]Message, error) {
 }
 
 func (b *Broker) Broadcast(from, body, priority string, filter AgentSearchFilter) ([]Message, error) {
+	return b.BroadcastWithOptions(from, body, SendOptions{Priority: priority}, filter)
+}
+
+// BroadcastWithOptions sends body to every agent matching filter that the
+// sender may broadcast to under its projects' policies. All copies share
+// one thread so replies from any recipient land in the same thread.
+func (b *Broker) BroadcastWithOptions(from, body string, opts SendOptions, filter AgentSearchFilter) ([]Message, error) {
 	filter = normalizeFilter(filter)
 	if strings.TrimSpace(from) == "" {
 		return nil, fmt.Errorf("sender agent_id is required")
@@ -547,6 +1286,11 @@ func (b *Broker) Broadcast(from, body, priority string, filter AgentSearchFilter
 	if strings.TrimSpace(body) == "" {
 		return nil, fmt.Errorf("body is required")
 	}
+	priority, err := NormalizePriority(opts.Priority)
+	if err != nil {
+		return nil, err
+	}
+	opts.Priority = priority
 
 	b.mu.Lock()
 	if b.agents[from] == nil {
@@ -554,6 +1298,16 @@ func (b *Broker) Broadcast(from, body, priority string, filter AgentSearchFilter
 		return nil, fmt.Errorf("sender agent not found: %s", from)
 	}
 	b.agents[from].LastSeen = time.Now().UTC()
+	if p := b.projects[normalizeProjectName(filter.Project)]; p != nil {
+		if _, ok := p.Members[from]; !ok {
+			b.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s is not a member of project %s", ErrForbidden, from, p.Name)
+		}
+		if err := b.checkProjectPolicy(p, from, p.Policy.Broadcast, "broadcast"); err != nil {
+			b.mu.Unlock()
+			return nil, err
+		}
+	}
 	type targetCandidate struct {
 		id    string
 		score int
@@ -561,7 +1315,7 @@ func (b *Broker) Broadcast(from, body, priority string, filter AgentSearchFilter
 	targets := make([]targetCandidate, 0)
 	totalTokens := len(tokenize(filter.Query))
 	for id, a := range b.agents {
-		if id == from {
+		if id == from || !b.canBroadcastTo(from, id) {
 			continue
 		}
 		if filter.ActiveWithin > 0 && time.Since(a.LastSeen) > filter.ActiveWithin {
@@ -585,9 +1339,15 @@ func (b *Broker) Broadcast(from, body, priority string, filter AgentSearchFilter
 	})
 	b.mu.Unlock()
 
+	threadID, err := b.resolveThread(opts)
+	if err != nil {
+		return nil, err
+	}
+	opts.ThreadID = threadID
+
 	out := make([]Message, 0, min(filter.Limit, len(targets)))
 	for _, to := range targets {
-		msg, err := b.Send(from, to.id, body, priority)
+		msg, err := b.SendWithOptions(from, to.id, body, opts)
 		if err != nil {
 			return out, err
 		}
@@ -600,81 +1360,195 @@ func (b *Broker) Broadcast(from, body, priority string, filter AgentSearchFilter
 }
 
 func (b *Broker) Fetch(agentID string, max int) ([]Message, error) {
+	return b.FetchWithOptions(agentID, max, FetchOptions{})
+}
+
+// FetchOptions narrows what FetchWithOptions returns.
+type FetchOptions struct {
+	// Priorities restricts the fetch to these priorities; empty means all.
+	// Messages of other priorities stay queued.
+	Priorities []string
+}
+
+// FetchWithOptions pulls up to max pending messages, most pressing
+// priority first and oldest first within a priority. Messages it does not
+// return are left in the inbox.
+func (b *Broker) FetchWithOptions(agentID string, max int, opts FetchOptions) ([]Message, error) {
 	if max <= 0 {
 		max = 10
 	}
+	var allowed map[string]bool
+	if len(opts.Priorities) > 0 {
+		allowed = make(map[string]bool, len(opts.Priorities))
+		for _, p := range opts.Priorities {
+			priority, err := NormalizePriority(p)
+			if err != nil {
+				return nil, err
+			}
+			allowed[priority] = true
+		}
+	}
 
 	b.mu.Lock()
-	defer b.mu.Unlock()
-
 	agent := b.agents[agentID]
-	if agent == nil {
+	sub := b.subs[agentID]
+	if agent == nil || sub == nil {
+		b.mu.Unlock()
 		return nil, fmt.Errorf("agent not found: %s", agentID)
 	}
-	now := time.Now().UTC()
-	agent.LastSeen = now
-	agent.LastFetch = now
-	if len(agent.Queue) == 0 {
+	writes := b.touchAgent(agent, true)
+	now := agent.LastFetch
+	b.mu.Unlock()
+	_ = b.flushWrites(writes)
+
+	pending, err := inboxDepth(sub)
+	if err != nil {
+		return nil, err
+	}
+	if pending == 0 {
 		return []Message{}, nil
 	}
-	if max > len(agent.Queue) {
-		max = len(agent.Queue)
+	// Pull the whole backlog (bounded) so priority ordering is not limited
+	// to the oldest max messages; the rest are released with Nak.
+	if pending > fetchScanLimit {
+		pending = fetchScanLimit
+	}
+
+	batch, err := sub.Fetch(pending, nats.MaxWait(fetchWait))
+	if err != nil && !errors.Is(err, nats.ErrTimeout) {
+		return nil, fmt.Errorf("fetch inbox: %w", err)
+	}
+
+	type candidate struct {
+		raw *nats.Msg
+		msg Message
+		seq uint64
+	}
+	candidates := make([]candidate, 0, len(batch))
+	for _, m := range batch {
+		var msg Message
+		if err := json.Unmarshal(m.Data, &msg); err != nil {
+			// Undecodable payloads would be redelivered forever.
+			_ = m.Term()
+			continue
+		}
+		var seq uint64
+		if meta, err := m.Metadata(); err == nil {
+			seq = meta.Sequence.Stream
+		}
+		candidates = append(candidates, candidate{raw: m, msg: msg, seq: seq})
 	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ri, rj := rankPriority(candidates[i].msg.Priority), rankPriority(candidates[j].msg.Priority)
+		if ri != rj {
+			return ri < rj
+		}
+		return candidates[i].seq < candidates[j].seq
+	})
 
-	out := make([]Message, max)
-	copy(out, agent.Queue[:max])
-	agent.Queue = agent.Queue[max:]
+	out := make([]Message, 0, max)
+	var ackErr error
+	for _, c := range candidates {
+		if ackErr != nil || len(out) >= max || (allowed != nil && !allowed[rankedPriority(c.msg.Priority)]) {
+			_ = c.raw.Nak()
+			continue
+		}
+		if err := c.raw.AckSync(); err != nil {
+			ackErr = fmt.Errorf("ack inbox message: %w", err)
+			_ = c.raw.Nak()
+			continue
+		}
+		out = append(out, c.msg)
+	}
 
 	// Mark fetched messages as read in delivery log.
+	b.mu.Lock()
 	for i := range out {
 		if rec, ok := b.deliveryLog[out[i].ID]; ok {
 			t := now
 			rec.ReadAt = &t
+			rec.advance(StateFetched, "", now)
+			_ = b.saveDelivery(rec)
 		}
 	}
-	return out, nil
+	b.mu.Unlock()
+	return out, ackErr
 }
 
+// rankedPriority maps legacy messages without a priority to normal.
+func rankedPriority(priority string) string {
+	if _, ok := priorityRank[priority]; ok {
+		return priority
+	}
+	return PriorityNormal
+}
 
-// UnreadCount returns the number of pending messages in an agent's queue.
+func rankPriority(priority string) int {
+	return priorityRank[rankedPriority(priority)]
+}
+
+// UnreadCount returns the number of pending messages in an agent's inbox.
 func (b *Broker) UnreadCount(agentID string) int {
 	b.mu.Lock()
-	defer b.mu.Unlock()
-	a := b.agents[agentID]
-	if a == nil {
+	sub := b.subs[agentID]
+	b.mu.Unlock()
+	if sub == nil {
 		return 0
 	}
-	return len(a.Queue)
+	n, err := inboxDepth(sub)
+	if err != nil {
+		return 0
+	}
+	return n
 }
 
-// GetTeamStatus returns a snapshot of all agents matching the project filter.
-// If project is empty, all agents are returned.
-func (b *Broker) GetTeamStatus(project string) []AgentStatusEntry {
+// GetTeamStatus returns a snapshot of the agents visible to viewer that
+// match the project filter. If project is empty, all visible agents are
+// returned. Naming a project the viewer is not a member of is forbidden.
+func (b *Broker) GetTeamStatus(viewer, project string) ([]AgentStatusEntry, error) {
+	viewer = strings.TrimSpace(viewer)
 	project = strings.ToLower(strings.TrimSpace(project))
 	b.mu.Lock()
-	defer b.mu.Unlock()
+	if b.agents[viewer] == nil {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("agent not found: %s", viewer)
+	}
+	if p := b.projects[normalizeProjectName(project)]; p != nil {
+		if _, ok := p.Members[viewer]; !ok {
+			b.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s is not a member of project %s", ErrForbidden, viewer, p.Name)
+		}
+	}
 	out := make([]AgentStatusEntry, 0, len(b.agents))
 	for _, a := range b.agents {
+		if !b.visibleTo(viewer, a.ID) {
+			continue
+		}
 		if project != "" && !strings.Contains(strings.ToLower(a.Profile.Project), project) {
 			continue
 		}
 		out = append(out, AgentStatusEntry{
-			ID:             a.ID,
-			Name:           a.Profile.Name,
-			Role:           a.Profile.Role,
-			Project:        a.Profile.Project,
-			Status:         a.Profile.Status,
-			LastSeen:       a.LastSeen,
-			LastFetch:      a.LastFetch,
-			UnreadMessages: len(a.Queue),
+			ID:        a.ID,
+			Name:      a.Profile.Name,
+			Role:      a.Profile.Role,
+			Project:   a.Profile.Project,
+			Status:    a.Profile.Status,
+			LastSeen:  a.LastSeen,
+			LastFetch: a.LastFetch,
 		})
 	}
-	return out
+	b.mu.Unlock()
+
+	// Inbox depth is a consumer info round trip; do it outside the lock.
+	for i := range out {
+		out[i].UnreadMessages = b.UnreadCount(out[i].ID)
+	}
+	return out, nil
 }
 
-// SharedContextSet stores a key-value pair scoped to a project.
-// Passing an empty value deletes the key.
-func (b *Broker) SharedContextSet(project, key, value string) error {
+// SharedContextSet stores a key-value pair scoped to a project, if agentID
+// may write the project's context. Passing an empty value deletes the key.
+func (b *Broker) SharedContextSet(agentID, project, key, value string) error {
 	project = normalizeProjectName(project)
 	key = strings.TrimSpace(key)
 	if project == "" {
@@ -685,6 +1559,13 @@ func (b *Broker) SharedContextSet(project, key, value string) error {
 	}
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	p, err := b.requireProjectAccess(agentID, project, AccessMembers)
+	if err != nil {
+		return err
+	}
+	if err := b.checkProjectPolicy(p, agentID, p.Policy.WriteContext, "write shared context"); err != nil {
+		return err
+	}
 	if b.contextStore[project] == nil {
 		b.contextStore[project] = make(map[string]string)
 	}
@@ -696,36 +1577,41 @@ func (b *Broker) SharedContextSet(project, key, value string) error {
 	return nil
 }
 
-// SharedContextGet retrieves a value from the shared project context.
-func (b *Broker) SharedContextGet(project, key string) (string, bool) {
+// SharedContextGet retrieves a value from the shared context of a project
+// agentID is a member of.
+func (b *Broker) SharedContextGet(agentID, project, key string) (string, bool, error) {
 	project = normalizeProjectName(project)
 	key = strings.TrimSpace(key)
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	if b.contextStore[project] == nil {
-		return "", false
+	if _, err := b.requireProjectAccess(agentID, project, AccessMembers); err != nil {
+		return "", false, err
 	}
 	v, ok := b.contextStore[project][key]
-	return v, ok
+	return v, ok, nil
 }
 
-// SharedContextList returns a copy of all key-value pairs for a project.
-func (b *Broker) SharedContextList(project string) map[string]string {
+// SharedContextList returns a copy of all key-value pairs for a project
+// agentID is a member of.
+func (b *Broker) SharedContextList(agentID, project string) (map[string]string, error) {
 	project = normalizeProjectName(project)
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	if _, err := b.requireProjectAccess(agentID, project, AccessMembers); err != nil {
+		return nil, err
+	}
 	src := b.contextStore[project]
 	out := make(map[string]string, len(src))
 	for k, v := range src {
 		out[k] = v
 	}
-	return out
+	return out, nil
 }
 
-// WaitForAgents blocks until at least minCount agents are registered for the
-// project, or until timeoutSec seconds have elapsed. Returns the agents found
-// and whether the threshold was met.
-func (b *Broker) WaitForAgents(project string, minCount int, timeoutSec int) ([]AgentStatusEntry, bool) {
+// WaitForAgents blocks until at least minCount agents visible to viewer are
+// registered for the project, or until timeoutSec seconds have elapsed.
+// Returns the agents found and whether the threshold was met.
+func (b *Broker) WaitForAgents(viewer, project string, minCount int, timeoutSec int) ([]AgentStatusEntry, bool, error) {
 	if minCount <= 0 {
 		minCount = 2
 	}
@@ -734,12 +1620,15 @@ func (b *Broker) WaitForAgents(project string, minCount int, timeoutSec int) ([]
 	}
 	deadline := time.Now().Add(time.Duration(timeoutSec) * time.Second)
 	for {
-		agents := b.GetTeamStatus(project)
+		agents, err := b.GetTeamStatus(viewer, project)
+		if err != nil {
+			return nil, false, err
+		}
 		if len(agents) >= minCount {
-			return agents, true
+			return agents, true, nil
 		}
 		if time.Now().After(deadline) {
-			return agents, false
+			return agents, false, nil
 		}
 		time.Sleep(2 * time.Second)
 	}
@@ -752,54 +1641,262 @@ func (b *Broker) Heartbeat(agentID string) error {
 		return fmt.Errorf("agent_id is required")
 	}
 	b.mu.Lock()
-	defer b.mu.Unlock()
 	a := b.agents[agentID]
 	if a == nil {
+		b.mu.Unlock()
 		return fmt.Errorf("agent not found: %s", agentID)
 	}
 	a.LastSeen = time.Now().UTC()
-	return nil
+	b.renewAgentLeases(agentID, a.LastSeen)
+	writes := b.renewAgentLocks(agentID, a.LastSeen)
+	err := b.saveAgent(a)
+	b.mu.Unlock()
+	if flushErr := b.flushWrites(writes); err == nil {
+		err = flushErr
+	}
+	return err
 }
 
 // PruneStaleAgents removes agents that haven't been seen within maxAge.
 // Returns the number of agents pruned.
 func (b *Broker) PruneStaleAgents(maxAge time.Duration) int {
+	b.mu.Lock()
+	pruned := b.pruneStaleAgents(maxAge, func(*agentState) bool { return true })
+	b.mu.Unlock()
+	b.finishPrune(pruned)
+	return len(pruned.ids)
+}
+
+// PruneProjectStaleAgents is PruneStaleAgents limited to agents whose
+// project adminID administers. adminID itself is never pruned.
+func (b *Broker) PruneProjectStaleAgents(adminID string, maxAge time.Duration) (int, error) {
+	adminID = strings.TrimSpace(adminID)
+	b.mu.Lock()
+	if b.agents[adminID] == nil {
+		b.mu.Unlock()
+		return 0, fmt.Errorf("agent not found: %s", adminID)
+	}
+	administers := func(a *agentState) bool {
+		p := b.projects[a.Profile.Project]
+		return a.ID != adminID && p != nil && b.isProjectAdmin(p, adminID)
+	}
+	admin := false
+	for _, p := range b.projects {
+		if b.isProjectAdmin(p, adminID) {
+			admin = true
+			break
+		}
+	}
+	if !admin {
+		b.mu.Unlock()
+		return 0, fmt.Errorf("%w: %s is not a project admin", ErrForbidden, adminID)
+	}
+	pruned := b.pruneStaleAgents(maxAge, administers)
+	b.mu.Unlock()
+	b.finishPrune(pruned)
+	return len(pruned.ids), nil
+}
+
+// prunedAgents is the JetStream I/O left once pruned agents are gone from
+// memory.
+type prunedAgents struct {
+	ids    []string
+	subs   []*nats.Subscription
+	writes []kvWrite
+}
+
+// pruneStaleAgents removes the stale agents match selects from memory and
+// returns the I/O for finishPrune. Caller holds b.mu.
+func (b *Broker) pruneStaleAgents(maxAge time.Duration, match func(*agentState) bool) prunedAgents {
 	if maxAge <= 0 {
 		maxAge = 30 * time.Minute
 	}
-	b.mu.Lock()
-	defer b.mu.Unlock()
 	cutoff := time.Now().Add(-maxAge)
-	pruned := 0
+	var pruned prunedAgents
+	channels := make(map[string]bool)
+	projects := make(map[string]bool)
+	tasks := make(map[string]bool)
+	locks := make(map[string]bool)
 	for id, a := range b.agents {
-		if a.LastSeen.Before(cutoff) {
-			if sub, ok := b.subs[id]; ok {
-				_ = sub.Unsubscribe()
-				delete(b.subs, id)
-			}
-			if a.SessionID != "" {
-				delete(b.sessionIndex, a.SessionID)
-			}
-			delete(b.agents, id)
-			pruned++
+		if !a.LastSeen.Before(cutoff) || !match(a) {
+			continue
+		}
+		if sub, ok := b.subs[id]; ok {
+			pruned.subs = append(pruned.subs, sub)
+			delete(b.subs, id)
+		}
+		if a.SessionID != "" {
+			delete(b.sessionIndex, a.SessionID)
 		}
+		// The token goes with the record; drop the session grant too.
+		delete(b.authSessions, id)
+		for _, name := range b.dropChannelMember(id) {
+			channels[name] = true
+		}
+		for _, name := range b.dropProjectMember(id) {
+			projects[name] = true
+		}
+		for _, taskID := range b.releaseAgentTasks(id) {
+			tasks[taskID] = true
+		}
+		for _, key := range b.releaseAgentLocks(id) {
+			locks[key] = true
+		}
+		delete(b.agents, id)
+		pruned.ids = append(pruned.ids, id)
+	}
+	for name := range channels {
+		pruned.writes = append(pruned.writes, b.channelWrite(name))
+	}
+	for name := range projects {
+		pruned.writes = append(pruned.writes, b.projectWrite(name))
+	}
+	for id := range tasks {
+		pruned.writes = append(pruned.writes, b.taskWrite(id))
+	}
+	for key := range locks {
+		pruned.writes = append(pruned.writes, b.lockWrite(key))
 	}
 	return pruned
 }
 
-// GetMessageStatus returns the delivery record for a message, if tracked.
-func (b *Broker) GetMessageStatus(messageID string) (*DeliveryRecord, bool) {
+// finishPrune deletes the pruned agents' consumers and registry entries
+// and persists the state they left. Caller does not hold b.mu.
+func (b *Broker) finishPrune(p prunedAgents) {
+	for _, sub := range p.subs {
+		_ = sub.Unsubscribe()
+	}
+	for _, id := range p.ids {
+		_ = b.js.DeleteConsumer(b.streamName, inboxConsumer(id))
+		_ = b.registry.Purge(id)
+	}
+	_ = b.flushWrites(p.writes)
+}
+
+// kvWrite is a KV update rendered under b.mu and written after it is
+// released. data is nil to delete the key; current renders the value
+// again from memory.
+type kvWrite struct {
+	kv      nats.KeyValue
+	key     string
+	data    []byte
+	current func() []byte
+}
+
+// newKVWrite renders value, which returns nil once the key should be
+// deleted. Caller holds b.mu.
+func newKVWrite(kv nats.KeyValue, key string, value func() any) kvWrite {
+	current := func() []byte {
+		v := value()
+		if v == nil {
+			return nil
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+		return data
+	}
+	return kvWrite{kv: kv, key: key, data: current(), current: current}
+}
+
+func (w kvWrite) apply(data []byte) error {
+	if data == nil {
+		if err := w.kv.Delete(w.key); err != nil {
+			return fmt.Errorf("delete %s: %w", w.key, err)
+		}
+		return nil
+	}
+	if _, err := w.kv.Put(w.key, data); err != nil {
+		return fmt.Errorf("persist %s: %w", w.key, err)
+	}
+	return nil
+}
+
+// flushWrites applies deferred writes without holding b.mu and returns
+// the first error. A key changed in memory since it was rendered may have
+// been saved before the stale write landed, so it is written again under
+// the lock. Caller does not hold b.mu.
+func (b *Broker) flushWrites(writes []kvWrite) error {
+	if len(writes) == 0 {
+		return nil
+	}
+	var firstErr error
+	for _, w := range writes {
+		if err := w.apply(w.data); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, w := range writes {
+		if data := w.current(); !bytes.Equal(data, w.data) {
+			if err := w.apply(data); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// GetMessageStatus returns the delivery record for a message, if tracked
+// and viewer is its sender or recipient.
+func (b *Broker) GetMessageStatus(viewer, messageID string) (*DeliveryRecord, bool) {
+	viewer = strings.TrimSpace(viewer)
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	rec, ok := b.deliveryLog[messageID]
-	if !ok {
+	if !ok || (viewer != rec.From && viewer != rec.To) {
 		return nil, false
 	}
 	cp := *rec
+	cp.Timeline = append([]DeliveryEvent(nil), rec.Timeline...)
 	return &cp, true
 }
 
-// PublishArtifact stores a structured artifact for the project.
+// AckMessage lets the recipient report what it did with a message.
+// state is acknowledged (default), acted_on or rejected. States only move
+// forward and acted_on/rejected are final.
+func (b *Broker) AckMessage(agentID, messageID, state, note string) (*DeliveryRecord, error) {
+	agentID = strings.TrimSpace(agentID)
+	messageID = strings.TrimSpace(messageID)
+	state = strings.TrimSpace(state)
+	if state == "" {
+		state = StateAcknowledged
+	}
+	switch state {
+	case StateAcknowledged, StateActedOn, StateRejected:
+	default:
+		return nil, fmt.Errorf("invalid ack status %q: must be acknowledged, acted_on, or rejected", state)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if agent := b.agents[agentID]; agent != nil {
+		agent.LastSeen = time.Now().UTC()
+	}
+	rec, ok := b.deliveryLog[messageID]
+	if !ok {
+		return nil, fmt.Errorf("message not found: %s", messageID)
+	}
+	if rec.To != agentID {
+		return nil, fmt.Errorf("only the recipient can acknowledge message %s", messageID)
+	}
+	if stateRank[state] <= stateRank[rec.State] {
+		return nil, fmt.Errorf("message %s is already %s", messageID, rec.State)
+	}
+	rec.advance(state, strings.TrimSpace(note), time.Now().UTC())
+	if err := b.saveDelivery(rec); err != nil {
+		return nil, err
+	}
+
+	cp := *rec
+	cp.Timeline = append([]DeliveryEvent(nil), rec.Timeline...)
+	return &cp, nil
+}
+
+// PublishArtifact stores a structured artifact for the project, if from
+// may write the project's artifacts.
 func (b *Broker) PublishArtifact(from, project, artifactType, name, content string) (Artifact, error) {
 	project = normalizeProjectName(project)
 	from = strings.TrimSpace(from)
@@ -831,22 +1928,33 @@ func (b *Broker) PublishArtifact(from, project, artifactType, name, content stri
 		CreatedAt:    time.Now().UTC(),
 	}
 	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, err := b.requireProjectAccess(from, project, AccessMembers)
+	if err != nil {
+		return Artifact{}, err
+	}
+	if err := b.checkProjectPolicy(p, from, p.Policy.WriteArtifacts, "publish artifacts"); err != nil {
+		return Artifact{}, err
+	}
 	b.artifactStore[project] = append(b.artifactStore[project], a)
-	b.mu.Unlock()
 	return a, nil
 }
 
-// ListArtifacts returns artifacts for a project, optionally filtered by type.
-func (b *Broker) ListArtifacts(project, artifactType string) []Artifact {
+// ListArtifacts returns artifacts for a project agentID is a member of,
+// optionally filtered by type.
+func (b *Broker) ListArtifacts(agentID, project, artifactType string) ([]Artifact, error) {
 	project = normalizeProjectName(project)
 	artifactType = strings.TrimSpace(artifactType)
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	if _, err := b.requireProjectAccess(agentID, project, AccessMembers); err != nil {
+		return nil, err
+	}
 	all := b.artifactStore[project]
 	if artifactType == "" {
 		out := make([]Artifact, len(all))
 		copy(out, all)
-		return out
+		return out, nil
 	}
 	out := make([]Artifact, 0)
 	for _, a := range all {
@@ -854,7 +1962,7 @@ func (b *Broker) ListArtifacts(project, artifactType string) []Artifact {
 			out = append(out, a)
 		}
 	}
-	return out
+	return out, nil
 }
 
 func randomID(prefix string) (string, error) {
@@ -865,17 +1973,17 @@ func randomID(prefix string) (string, error) {
 	return prefix + "-" + hex.EncodeToString(buf), nil
 }
 
-func ensureStream(js nats.JetStreamContext) error {
+func ensureStream(js nats.JetStreamContext, name, prefix string) error {
 	cfg := &nats.StreamConfig{
-		Name:      streamName,
-		Subjects:  []string{subjectPrefix + ".>"},
+		Name:      name,
+		Subjects:  []string{prefix + ".agent.>", prefix + ".channel.>", prefix + ".role.>"},
 		Storage:   nats.FileStorage,
 		Retention: nats.LimitsPolicy,
 		Discard:   nats.DiscardOld,
-		MaxAge:    7 * 24 * time.Hour,
+		MaxAge:    messageRetention,
 	}
 
-	if _, err := js.StreamInfo(streamName); err == nil {
+	if _, err := js.StreamInfo(name); err == nil {
 		if _, err := js.UpdateStream(cfg); err != nil {
 			return fmt.Errorf("update jetstream stream: %w", err)
 		}
@@ -887,6 +1995,86 @@ func ensureStream(js nats.JetStreamContext) error {
 	return nil
 }
 
+// agentSubject is the stream subject of an agent's inbox.
+func (b *Broker) agentSubject(agentID string) string {
+	return b.subjectPrefix + ".agent." + agentID
+}
+
+// replySubject carries copies of replies on core NATS so blocked askers
+// are woken up. It is deliberately outside the message stream.
+func (b *Broker) replySubject(messageID string) string {
+	return b.subjectPrefix + ".reply." + messageID
+}
+
+// inboxConsumer returns the durable consumer name for an agent's inbox.
+func inboxConsumer(agentID string) string {
+	return "inbox-" + agentID
+}
+
+// inboxDepth reports messages not yet fetched from an inbox consumer,
+// including delivered-but-unacked ones that will be redelivered.
+func inboxDepth(sub *nats.Subscription) (int, error) {
+	info, err := sub.ConsumerInfo()
+	if err != nil {
+		return 0, fmt.Errorf("inbox consumer info: %w", err)
+	}
+	return int(info.NumPending) + info.NumAckPending, nil
+}
+
+func ensureBucket(js nats.JetStreamContext, bucket, description string) (nats.KeyValue, error) {
+	return ensureBucketTTL(js, bucket, description, 0)
+}
+
+// ensureBucketTTL is ensureBucket for a bucket whose entries expire ttl
+// after their last update. A ttl of 0 keeps entries forever.
+func ensureBucketTTL(js nats.JetStreamContext, bucket, description string, ttl time.Duration) (nats.KeyValue, error) {
+	kv, err := js.KeyValue(bucket)
+	if err == nil {
+		if ttl > 0 {
+			if err := setBucketTTL(js, kv, ttl); err != nil {
+				return nil, err
+			}
+		}
+		return kv, nil
+	}
+	if !errors.Is(err, nats.ErrBucketNotFound) {
+		return nil, fmt.Errorf("open %s bucket: %w", bucket, err)
+	}
+	kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+		Bucket:      bucket,
+		Description: description,
+		History:     1,
+		TTL:         ttl,
+		Storage:     nats.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create %s bucket: %w", bucket, err)
+	}
+	return kv, nil
+}
+
+// setBucketTTL gives an existing bucket ttl, for buckets created before
+// their entries expired or with another ttl.
+func setBucketTTL(js nats.JetStreamContext, kv nats.KeyValue, ttl time.Duration) error {
+	status, err := kv.Status()
+	if err != nil {
+		return fmt.Errorf("%s bucket status: %w", kv.Bucket(), err)
+	}
+	if status.TTL() == ttl {
+		return nil
+	}
+	info, err := js.StreamInfo("KV_" + kv.Bucket())
+	if err != nil {
+		return fmt.Errorf("%s bucket info: %w", kv.Bucket(), err)
+	}
+	cfg := info.Config
+	cfg.MaxAge = ttl
+	if _, err := js.UpdateStream(&cfg); err != nil {
+		return fmt.Errorf("set %s bucket ttl: %w", kv.Bucket(), err)
+	}
+	return nil
+}
+
 func normalizeProfile(p AgentProfile) AgentProfile {
 	p.Name = strings.TrimSpace(p.Name)
 	p.Description = strings.TrimSpace(p.Description)
@@ -899,6 +2087,12 @@ func normalizeProfile(p AgentProfile) AgentProfile {
 	return p
 }
 
+// NormalizeProject returns the canonical form of a project name, the one
+// the broker stores and compares.
+func NormalizeProject(s string) string {
+	return normalizeProjectName(s)
+}
+
 func normalizeProjectName(s string) string {
 	s = strings.TrimSpace(s)
 	if s == "" {
@@ -1,11 +1,15 @@
 package broker
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"strings"
 	"testing"
 	"time"
 
 	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
 )
 
 func testProfile(name string) AgentProfile {
@@ -23,14 +27,24 @@ func testProfile(name string) AgentProfile {
 func runNATSServer(t *testing.T) *natsserver.Server {
 	t.Helper()
 
-	s, err := natsserver.NewServer(&natsserver.Options{
+	return runNATSServerWith(t, func(*natsserver.Options) {})
+}
+
+// runNATSServerWith starts a JetStream server after letting configure
+// adjust its options, e.g. to require authentication.
+func runNATSServerWith(t *testing.T, configure func(*natsserver.Options)) *natsserver.Server {
+	t.Helper()
+
+	opts := &natsserver.Options{
 		Host:      "127.0.0.1",
 		Port:      -1,
 		NoLog:     true,
 		NoSigs:    true,
 		JetStream: true,
 		StoreDir:  t.TempDir(),
-	})
+	}
+	configure(opts)
+	s, err := natsserver.NewServer(opts)
 	if err != nil {
 		t.Fatalf("new nats server: %v", err)
 	}
@@ -52,8 +66,15 @@ func runNATSServer(t *testing.T) *natsserver.Server {
 func newTestBroker(t *testing.T) *Broker {
 	t.Helper()
 
-	s := runNATSServer(t)
-	b, err := New(s.ClientURL())
+	return newTestBrokerOn(t, runNATSServer(t))
+}
+
+// newTestBrokerOn connects a broker to an existing server, e.g. to simulate
+// a broker restart against the same JetStream state.
+func newTestBrokerOn(t *testing.T, s *natsserver.Server) *Broker {
+	t.Helper()
+
+	b, err := New(Config{URL: s.ClientURL()})
 	if err != nil {
 		t.Fatalf("create broker: %v", err)
 	}
@@ -70,15 +91,7 @@ func waitForQueuedMessages(t *testing.T, b *Broker, agentID string, minCount int
 
 	deadline := time.Now().Add(3 * time.Second)
 	for time.Now().Before(deadline) {
-		b.mu.Lock()
-		agent := b.agents[agentID]
-		count := 0
-		if agent != nil {
-			count = len(agent.Queue)
-		}
-		b.mu.Unlock()
-
-		if count >= minCount {
+		if b.UnreadCount(agentID) >= minCount {
 			return
 		}
 		time.Sleep(10 * time.Millisecond)
@@ -593,7 +606,7 @@ func TestRegisterOrUpdateBySession(t *testing.T) {
 	}
 
 	// First registration with a session_id creates a new agent.
-	id1, created, err := b.RegisterOrUpdateBySession("sess-abc", profile)
+	id1, token, created, err := b.RegisterOrUpdateBySession("sess-abc", profile, "", "")
 	if err != nil {
 		t.Fatalf("first register: %v", err)
 	}
@@ -612,7 +625,7 @@ func TestRegisterOrUpdateBySession(t *testing.T) {
 		Role:           "developer",
 		Specialization: "distributed-systems",
 	}
-	id2, created2, err := b.RegisterOrUpdateBySession("sess-abc", updatedProfile)
+	id2, _, created2, err := b.RegisterOrUpdateBySession("sess-abc", updatedProfile, token, "")
 	if err != nil {
 		t.Fatalf("second register: %v", err)
 	}
@@ -645,6 +658,22 @@ func TestRegisterOrUpdateBySession(t *testing.T) {
 	}
 }
 
+func TestFailedProfileUpdateLeavesAgentUnchanged(t *testing.T) {
+	b := newTestBroker(t)
+	id, err := b.RegisterAgent(testProfile("alice"))
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	// With the connection gone the registry write fails.
+	b.nc.Close()
+	if _, err := b.UpdateAgentProfile(id, AgentProfile{Role: "reviewer"}); err == nil {
+		t.Fatal("expected the update to fail without a registry")
+	}
+	if p, _ := b.GetAgentProfile(id); p.Role != "developer" {
+		t.Fatalf("expected the in-memory profile to be unchanged, got role %q", p.Role)
+	}
+}
+
 func TestRegisterOrUpdateBySession_EmptySession(t *testing.T) {
 	b := newTestBroker(t)
 
@@ -657,7 +686,7 @@ func TestRegisterOrUpdateBySession_EmptySession(t *testing.T) {
 	}
 
 	// Empty session_id should create new agents each time.
-	id1, created1, err := b.RegisterOrUpdateBySession("", profile)
+	id1, _, created1, err := b.RegisterOrUpdateBySession("", profile, "", "")
 	if err != nil {
 		t.Fatalf("first register: %v", err)
 	}
@@ -665,7 +694,7 @@ func TestRegisterOrUpdateBySession_EmptySession(t *testing.T) {
 		t.Fatal("expected created=true")
 	}
 
-	id2, created2, err := b.RegisterOrUpdateBySession("", profile)
+	id2, _, created2, err := b.RegisterOrUpdateBySession("", profile, "", "")
 	if err != nil {
 		t.Fatalf("second register: %v", err)
 	}
@@ -738,7 +767,10 @@ func TestAgentStatus(t *testing.T) {
 	}
 
 	// Default status should be "idle".
-	statuses := b.GetTeamStatus("proj")
+	statuses, err := b.GetTeamStatus(id, "proj")
+	if err != nil {
+		t.Fatalf("team status: %v", err)
+	}
 	if len(statuses) != 1 {
 		t.Fatalf("expected 1 agent, got %d", len(statuses))
 	}
@@ -750,7 +782,7 @@ func TestAgentStatus(t *testing.T) {
 	if _, err := b.UpdateAgentProfile(id, AgentProfile{Status: "working"}); err != nil {
 		t.Fatalf("update: %v", err)
 	}
-	statuses = b.GetTeamStatus("proj")
+	statuses, _ = b.GetTeamStatus(id, "proj")
 	if statuses[0].Status != "working" {
 		t.Fatalf("expected working, got %q", statuses[0].Status)
 	}
@@ -758,34 +790,37 @@ func TestAgentStatus(t *testing.T) {
 
 func TestSharedContext(t *testing.T) {
 	b := newTestBroker(t)
+	id, _ := b.RegisterAgent(AgentProfile{Description: "a", Project: "my-project", Role: "r", Specialization: "s"})
 
-	if err := b.SharedContextSet("my-project", "backend_path", "webapp/backend"); err != nil {
+	if err := b.SharedContextSet(id, "my-project", "backend_path", "webapp/backend"); err != nil {
 		t.Fatal(err)
 	}
-	v, ok := b.SharedContextGet("my-project", "backend_path")
-	if !ok || v != "webapp/backend" {
-		t.Fatalf("expected webapp/backend, got %q ok=%v", v, ok)
+	v, ok, err := b.SharedContextGet(id, "my-project", "backend_path")
+	if err != nil || !ok || v != "webapp/backend" {
+		t.Fatalf("expected webapp/backend, got %q ok=%v err=%v", v, ok, err)
 	}
 
-	m := b.SharedContextList("my-project")
-	if m["backend_path"] != "webapp/backend" {
-		t.Fatalf("list returned wrong value: %v", m)
+	m, err := b.SharedContextList(id, "my-project")
+	if err != nil || m["backend_path"] != "webapp/backend" {
+		t.Fatalf("list returned wrong value: %v (%v)", m, err)
 	}
 
 	// Delete via empty value.
-	if err := b.SharedContextSet("my-project", "backend_path", ""); err != nil {
+	if err := b.SharedContextSet(id, "my-project", "backend_path", ""); err != nil {
 		t.Fatal(err)
 	}
-	_, ok = b.SharedContextGet("my-project", "backend_path")
+	_, ok, _ = b.SharedContextGet(id, "my-project", "backend_path")
 	if ok {
 		t.Fatal("expected key to be deleted")
 	}
 
 	// Project isolation.
-	b.SharedContextSet("proj-a", "k", "v1")
-	b.SharedContextSet("proj-b", "k", "v2")
-	va, _ := b.SharedContextGet("proj-a", "k")
-	vb, _ := b.SharedContextGet("proj-b", "k")
+	a, _ := b.RegisterAgent(AgentProfile{Description: "a", Project: "proj-a", Role: "r", Specialization: "s"})
+	c, _ := b.RegisterAgent(AgentProfile{Description: "b", Project: "proj-b", Role: "r", Specialization: "s"})
+	b.SharedContextSet(a, "proj-a", "k", "v1")
+	b.SharedContextSet(c, "proj-b", "k", "v2")
+	va, _, _ := b.SharedContextGet(a, "proj-a", "k")
+	vb, _, _ := b.SharedContextGet(c, "proj-b", "k")
 	if va != "v1" || vb != "v2" {
 		t.Fatalf("project isolation broken: proj-a=%q proj-b=%q", va, vb)
 	}
@@ -812,10 +847,17 @@ func TestUnreadCount(t *testing.T) {
 
 func TestGetTeamStatus_ProjectFilter(t *testing.T) {
 	b := newTestBroker(t)
-	b.RegisterAgent(AgentProfile{Description: "a", Project: "project-alpha", Role: "r", Specialization: "s"})
-	b.RegisterAgent(AgentProfile{Description: "b", Project: "project-beta", Role: "r", Specialization: "s"})
+	alpha, _ := b.RegisterAgent(AgentProfile{Description: "a", Project: "project-alpha", Role: "r", Specialization: "s"})
+	beta, _ := b.RegisterAgent(AgentProfile{Description: "b", Project: "project-beta", Role: "r", Specialization: "s"})
+	// Let alpha see both projects so the filter is what narrows the result.
+	if _, err := b.AddProjectMember(beta, "project-beta", alpha, false); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
 
-	results := b.GetTeamStatus("alpha")
+	results, err := b.GetTeamStatus(alpha, "alpha")
+	if err != nil {
+		t.Fatalf("team status: %v", err)
+	}
 	if len(results) != 1 {
 		t.Fatalf("expected 1 result for 'alpha' filter, got %d", len(results))
 	}
@@ -826,10 +868,13 @@ func TestGetTeamStatus_ProjectFilter(t *testing.T) {
 
 func TestWaitForAgents_Success(t *testing.T) {
 	b := newTestBroker(t)
-	b.RegisterAgent(AgentProfile{Description: "a", Project: "p", Role: "r", Specialization: "s"})
+	id, _ := b.RegisterAgent(AgentProfile{Description: "a", Project: "p", Role: "r", Specialization: "s"})
 	b.RegisterAgent(AgentProfile{Description: "b", Project: "p", Role: "r", Specialization: "s"})
 
-	agents, met := b.WaitForAgents("p", 2, 5)
+	agents, met, err := b.WaitForAgents(id, "p", 2, 5)
+	if err != nil {
+		t.Fatalf("wait: %v", err)
+	}
 	if !met {
 		t.Fatal("expected threshold to be met")
 	}
@@ -880,7 +925,7 @@ func TestDeliveryReceipt(t *testing.T) {
 	}
 
 	// Status should be visible with ReadAt unset.
-	rec, ok := b.GetMessageStatus(msg.ID)
+	rec, ok := b.GetMessageStatus(fromID, msg.ID)
 	if !ok {
 		t.Fatal("expected delivery record")
 	}
@@ -895,7 +940,7 @@ func TestDeliveryReceipt(t *testing.T) {
 	waitForQueuedMessages(t, b, toID, 1)
 	b.Fetch(toID, 10)
 
-	rec2, ok := b.GetMessageStatus(msg.ID)
+	rec2, ok := b.GetMessageStatus(fromID, msg.ID)
 	if !ok {
 		t.Fatal("expected delivery record after fetch")
 	}
@@ -904,6 +949,88 @@ func TestDeliveryReceipt(t *testing.T) {
 	}
 }
 
+func TestMessageLifecycle(t *testing.T) {
+	b := newTestBroker(t)
+	fromID, _ := b.RegisterAgent(AgentProfile{Description: "sender", Project: "p", Role: "r", Specialization: "s"})
+	toID, _ := b.RegisterAgent(AgentProfile{Description: "receiver", Project: "p", Role: "r", Specialization: "s"})
+
+	msg, err := b.Send(fromID, toID, "deploy now", "blocking")
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	rec, _ := b.GetMessageStatus(fromID, msg.ID)
+	if rec.State != StateQueued || rec.From != fromID || rec.Priority != "blocking" {
+		t.Fatalf("unexpected initial record: %+v", rec)
+	}
+
+	if _, err := b.AckMessage(fromID, msg.ID, StateAcknowledged, ""); err == nil {
+		t.Fatal("expected sender ack to be rejected")
+	}
+
+	job, _ := b.EnqueuePush(msg, "opencode", "sess-1")
+	b.ClaimDuePushes(time.Now(), 10)
+	if err := b.CompletePush(job.ID); err != nil {
+		t.Fatalf("complete push: %v", err)
+	}
+	waitForQueuedMessages(t, b, toID, 1)
+	b.Fetch(toID, 10)
+
+	rec, err = b.AckMessage(toID, msg.ID, "", "on it")
+	if err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if rec.State != StateAcknowledged {
+		t.Fatalf("expected acknowledged, got %s", rec.State)
+	}
+	if _, err := b.AckMessage(toID, msg.ID, StateAcknowledged, ""); err == nil {
+		t.Fatal("expected repeated ack to be rejected")
+	}
+	if _, err := b.AckMessage(toID, msg.ID, "done", ""); err == nil {
+		t.Fatal("expected invalid status to be rejected")
+	}
+	if _, err := b.AckMessage(toID, msg.ID, StateActedOn, "deployed"); err != nil {
+		t.Fatalf("act: %v", err)
+	}
+	if _, err := b.AckMessage(toID, msg.ID, StateRejected, ""); err == nil {
+		t.Fatal("expected terminal state to be final")
+	}
+
+	rec, _ = b.GetMessageStatus(fromID, msg.ID)
+	if !rec.PushDelivered || rec.State != StateActedOn {
+		t.Fatalf("unexpected final record: %+v", rec)
+	}
+	want := []string{StateQueued, StatePushed, StateFetched, StateAcknowledged, StateActedOn}
+	if len(rec.Timeline) != len(want) {
+		t.Fatalf("expected %d timeline events, got %+v", len(want), rec.Timeline)
+	}
+	for i, state := range want {
+		if rec.Timeline[i].State != state {
+			t.Fatalf("timeline[%d]: expected %s, got %s", i, state, rec.Timeline[i].State)
+		}
+	}
+	if rec.Timeline[4].Note != "deployed" {
+		t.Fatalf("expected note on acted_on event, got %q", rec.Timeline[4].Note)
+	}
+}
+
+func TestReplyAcknowledgesParent(t *testing.T) {
+	b := newTestBroker(t)
+	aID, _ := b.RegisterAgent(AgentProfile{Description: "a", Project: "p", Role: "r", Specialization: "s"})
+	bID, _ := b.RegisterAgent(AgentProfile{Description: "b", Project: "p", Role: "r", Specialization: "s"})
+
+	parent, err := b.Send(aID, bID, "question", "normal")
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if _, err := b.SendWithOptions(bID, aID, "answer", SendOptions{ReplyTo: parent.ID}); err != nil {
+		t.Fatalf("reply: %v", err)
+	}
+	rec, _ := b.GetMessageStatus(aID, parent.ID)
+	if rec.State != StateAcknowledged {
+		t.Fatalf("expected reply to acknowledge parent, got %s", rec.State)
+	}
+}
+
 func TestPriorityPassthrough(t *testing.T) {
 	b := newTestBroker(t)
 	fromID, _ := b.RegisterAgent(AgentProfile{Description: "sender", Project: "p", Role: "r", Specialization: "s"})
@@ -924,6 +1051,89 @@ func TestPriorityPassthrough(t *testing.T) {
 	}
 }
 
+func TestFetchOrdersByPriority(t *testing.T) {
+	b := newTestBroker(t)
+	fromID, _ := b.RegisterAgent(AgentProfile{Description: "sender", Project: "p", Role: "r", Specialization: "s"})
+	toID, _ := b.RegisterAgent(AgentProfile{Description: "receiver", Project: "p", Role: "r", Specialization: "s"})
+
+	for _, m := range []struct{ body, priority string }{
+		{"n1", ""},
+		{"u1", "urgent"},
+		{"n2", "normal"},
+		{"b1", "blocking"},
+		{"u2", "urgent"},
+	} {
+		if _, err := b.Send(fromID, toID, m.body, m.priority); err != nil {
+			t.Fatalf("send %s: %v", m.body, err)
+		}
+	}
+	waitForQueuedMessages(t, b, toID, 5)
+
+	msgs, err := b.Fetch(toID, 2)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].Body != "b1" || msgs[1].Body != "u1" {
+		t.Fatalf("expected [b1 u1], got %+v", msgs)
+	}
+	if n := b.UnreadCount(toID); n != 3 {
+		t.Fatalf("expected 3 unread after partial fetch, got %d", n)
+	}
+
+	msgs, err = b.Fetch(toID, 10)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	var got []string
+	for _, m := range msgs {
+		got = append(got, m.Body)
+	}
+	if strings.Join(got, ",") != "u2,n1,n2" {
+		t.Fatalf("expected u2,n1,n2, got %v", got)
+	}
+	if msgs[1].Priority != "normal" {
+		t.Fatalf("expected empty priority to normalize to normal, got %q", msgs[1].Priority)
+	}
+}
+
+func TestFetchPriorityFilter(t *testing.T) {
+	b := newTestBroker(t)
+	fromID, _ := b.RegisterAgent(AgentProfile{Description: "sender", Project: "p", Role: "r", Specialization: "s"})
+	toID, _ := b.RegisterAgent(AgentProfile{Description: "receiver", Project: "p", Role: "r", Specialization: "s"})
+
+	b.Send(fromID, toID, "n1", "normal")
+	b.Send(fromID, toID, "u1", "urgent")
+	waitForQueuedMessages(t, b, toID, 2)
+
+	msgs, err := b.FetchWithOptions(toID, 10, FetchOptions{Priorities: []string{"urgent", "blocking"}})
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Body != "u1" {
+		t.Fatalf("expected only u1, got %+v", msgs)
+	}
+	if n := b.UnreadCount(toID); n != 1 {
+		t.Fatalf("expected filtered-out message to stay queued, got %d unread", n)
+	}
+
+	if _, err := b.FetchWithOptions(toID, 10, FetchOptions{Priorities: []string{"low"}}); err == nil {
+		t.Fatal("expected invalid priority filter to be rejected")
+	}
+}
+
+func TestSendRejectsUnknownPriority(t *testing.T) {
+	b := newTestBroker(t)
+	fromID, _ := b.RegisterAgent(AgentProfile{Description: "sender", Project: "p", Role: "r", Specialization: "s"})
+	toID, _ := b.RegisterAgent(AgentProfile{Description: "receiver", Project: "p", Role: "r", Specialization: "s"})
+
+	if _, err := b.Send(fromID, toID, "hi", "critical"); err == nil {
+		t.Fatal("expected unknown priority to be rejected")
+	}
+	if _, err := b.Broadcast(fromID, "hi", "critical", AgentSearchFilter{}); err == nil {
+		t.Fatal("expected unknown broadcast priority to be rejected")
+	}
+}
+
 func TestPublishAndListArtifacts(t *testing.T) {
 	b := newTestBroker(t)
 	id, _ := b.RegisterAgent(AgentProfile{Description: "a", Project: "myproject", Role: "r", Specialization: "s"})
@@ -937,25 +1147,27 @@ func TestPublishAndListArtifacts(t *testing.T) {
 	}
 
 	// List all artifacts.
-	all := b.ListArtifacts("myproject", "")
+	all, err := b.ListArtifacts(id, "myproject", "")
+	if err != nil {
+		t.Fatalf("list artifacts: %v", err)
+	}
 	if len(all) != 1 {
 		t.Fatalf("expected 1 artifact, got %d", len(all))
 	}
 
 	// Filter by type.
-	schemas := b.ListArtifacts("myproject", "schema")
+	schemas, _ := b.ListArtifacts(id, "myproject", "schema")
 	if len(schemas) != 1 {
 		t.Fatalf("expected 1 schema artifact, got %d", len(schemas))
 	}
-	dockerfiles := b.ListArtifacts("myproject", "dockerfile")
+	dockerfiles, _ := b.ListArtifacts(id, "myproject", "dockerfile")
 	if len(dockerfiles) != 0 {
 		t.Fatalf("expected 0 dockerfile artifacts, got %d", len(dockerfiles))
 	}
 
-	// Missing project returns empty.
-	empty := b.ListArtifacts("noproject", "")
-	if len(empty) != 0 {
-		t.Fatalf("expected 0 artifacts for missing project, got %d", len(empty))
+	// Projects the agent is not a member of are off limits.
+	if _, err := b.ListArtifacts(id, "noproject", ""); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden for missing project, got %v", err)
 	}
 }
 
@@ -976,3 +1188,519 @@ func TestActiveWithinFilter(t *testing.T) {
 		t.Fatalf("expected 0 results with expired active_within, got %d", len(results))
 	}
 }
+
+func TestRegistrySurvivesRestart(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+
+	aliceID, err := b1.RegisterAgent(testProfile("alice"))
+	if err != nil {
+		t.Fatalf("register alice: %v", err)
+	}
+	bobID, bobToken, _, err := b1.RegisterOrUpdateBySession("sess-bob", testProfile("bob"), "", "")
+	if err != nil {
+		t.Fatalf("register bob: %v", err)
+	}
+	if err := b1.BindSession(bobID, "sess-bob", "claude-code"); err != nil {
+		t.Fatalf("bind bob: %v", err)
+	}
+	if _, err := b1.UpdateAgentProfile(aliceID, AgentProfile{Status: "working"}); err != nil {
+		t.Fatalf("update alice: %v", err)
+	}
+	b1.Close()
+
+	b2 := newTestBrokerOn(t, s)
+	if agents := b2.ListAgents(); len(agents) != 2 {
+		t.Fatalf("expected 2 rehydrated agents, got %d", len(agents))
+	}
+	for _, a := range b2.ListAgents() {
+		if a["id"] == aliceID && a["status"] != "working" {
+			t.Fatalf("expected alice status to survive restart, got %q", a["status"])
+		}
+	}
+	sessionID, harness, ok := b2.GetSessionBindingWithHarness(bobID)
+	if !ok || sessionID != "sess-bob" || harness != "claude-code" {
+		t.Fatalf("expected bob binding to survive restart, got %q/%q/%v", sessionID, harness, ok)
+	}
+
+	// Re-registering the same session must reuse the rehydrated agent.
+	again, _, created, err := b2.RegisterOrUpdateBySession("sess-bob", testProfile("bob"), bobToken, "")
+	if err != nil {
+		t.Fatalf("re-register bob: %v", err)
+	}
+	if created || again != bobID {
+		t.Fatalf("expected existing agent %s, got %s (created=%v)", bobID, again, created)
+	}
+
+	// Subscriptions are restored, so delivery works without re-registering.
+	if _, err := b2.Send(aliceID, bobID, "after restart", ""); err != nil {
+		t.Fatalf("send after restart: %v", err)
+	}
+	waitForQueuedMessages(t, b2, bobID, 1)
+
+	if n := b2.PruneStaleAgents(time.Nanosecond); n != 2 {
+		t.Fatalf("expected 2 pruned, got %d", n)
+	}
+	b2.Close()
+
+	b3 := newTestBrokerOn(t, s)
+	if agents := b3.ListAgents(); len(agents) != 0 {
+		t.Fatalf("expected pruned agents to stay removed, got %d", len(agents))
+	}
+}
+
+func TestSendAndFetchPersistLastSeen(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+
+	aliceID, err := b1.RegisterAgent(testProfile("alice"))
+	if err != nil {
+		t.Fatalf("register alice: %v", err)
+	}
+	bobID, err := b1.RegisterAgent(testProfile("bob"))
+	if err != nil {
+		t.Fatalf("register bob: %v", err)
+	}
+	// Persist an hour-old LastSeen for both, as if they had gone quiet.
+	old := time.Now().UTC().Add(-time.Hour)
+	b1.mu.Lock()
+	for _, id := range []string{aliceID, bobID} {
+		a := b1.agents[id]
+		a.LastSeen, a.LastFetch = old, old
+		if err := b1.saveAgent(a); err != nil {
+			b1.mu.Unlock()
+			t.Fatalf("save %s: %v", id, err)
+		}
+	}
+	b1.mu.Unlock()
+
+	if _, err := b1.Send(aliceID, bobID, "hello", ""); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if _, err := b1.Fetch(bobID, 10); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	b1.Close()
+
+	b2 := newTestBrokerOn(t, s)
+	b2.mu.Lock()
+	defer b2.mu.Unlock()
+	if seen := b2.agents[aliceID].LastSeen; !seen.After(old.Add(time.Minute)) {
+		t.Fatalf("expected the sender's LastSeen to survive restart, got %v", seen)
+	}
+	if fetched := b2.agents[bobID].LastFetch; !fetched.After(old.Add(time.Minute)) {
+		t.Fatalf("expected the fetcher's LastFetch to survive restart, got %v", fetched)
+	}
+}
+
+func TestQueuedMessagesSurviveRestart(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+
+	fromID, err := b1.RegisterAgent(testProfile("alice"))
+	if err != nil {
+		t.Fatalf("register sender: %v", err)
+	}
+	toID, err := b1.RegisterAgent(testProfile("bob"))
+	if err != nil {
+		t.Fatalf("register receiver: %v", err)
+	}
+	for _, body := range []string{"one", "two", "three"} {
+		if _, err := b1.Send(fromID, toID, body, ""); err != nil {
+			t.Fatalf("send %q: %v", body, err)
+		}
+	}
+	got, err := b1.Fetch(toID, 1)
+	if err != nil || len(got) != 1 || got[0].Body != "one" {
+		t.Fatalf("expected first message before restart, got %#v (err=%v)", got, err)
+	}
+	b1.Close()
+
+	b2 := newTestBrokerOn(t, s)
+	if n := b2.UnreadCount(toID); n != 2 {
+		t.Fatalf("expected 2 unread after restart, got %d", n)
+	}
+	got, err = b2.Fetch(toID, 10)
+	if err != nil {
+		t.Fatalf("fetch after restart: %v", err)
+	}
+	if len(got) != 2 || got[0].Body != "two" || got[1].Body != "three" {
+		t.Fatalf("expected remaining messages in order after restart, got %#v", got)
+	}
+	if n := b2.UnreadCount(toID); n != 0 {
+		t.Fatalf("expected empty inbox after fetch, got %d", n)
+	}
+}
+
+func TestDeliveryRecordsSurviveRestart(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+	fromID, _ := b1.RegisterAgent(testProfile("alice"))
+	toID, _ := b1.RegisterAgent(testProfile("bob"))
+	msg, err := b1.Send(fromID, toID, "deploy now", "blocking")
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	b1.Close()
+
+	b2 := newTestBrokerOn(t, s)
+	rec, ok := b2.GetMessageStatus(fromID, msg.ID)
+	if !ok || rec.State != StateQueued || rec.Priority != "blocking" {
+		t.Fatalf("expected queued record after restart, got %+v (%v)", rec, ok)
+	}
+	waitForQueuedMessages(t, b2, toID, 1)
+	if _, err := b2.Fetch(toID, 10); err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if _, err := b2.AckMessage(toID, msg.ID, StateAcknowledged, ""); err != nil {
+		t.Fatalf("ack after restart: %v", err)
+	}
+	b2.Close()
+
+	b3 := newTestBrokerOn(t, s)
+	rec, ok = b3.GetMessageStatus(fromID, msg.ID)
+	if !ok || rec.State != StateAcknowledged || rec.ReadAt == nil {
+		t.Fatalf("expected acknowledged record after second restart, got %+v (%v)", rec, ok)
+	}
+	want := []string{StateQueued, StateFetched, StateAcknowledged}
+	if len(rec.Timeline) != len(want) {
+		t.Fatalf("expected timeline %v, got %+v", want, rec.Timeline)
+	}
+	for i, state := range want {
+		if rec.Timeline[i].State != state {
+			t.Fatalf("expected timeline %v, got %+v", want, rec.Timeline)
+		}
+	}
+}
+
+func TestExpiredDeliveryRecordsLeaveMemory(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+	fromID, _ := b1.RegisterAgent(testProfile("alice"))
+	toID, _ := b1.RegisterAgent(testProfile("bob"))
+	old, err := b1.Send(fromID, toID, "stale", "")
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	// Age the message past retention, as if it had left the stream.
+	b1.mu.Lock()
+	rec := b1.deliveryLog[old.ID]
+	rec.SentAt = rec.SentAt.Add(-messageRetention - time.Hour)
+	if err := b1.saveDelivery(rec); err != nil {
+		b1.mu.Unlock()
+		t.Fatalf("save: %v", err)
+	}
+	ref := b1.threadIndex[old.ID]
+	ref.at = rec.SentAt
+	b1.threadIndex[old.ID] = ref
+	b1.mu.Unlock()
+	b1.Close()
+
+	// Expired records are not loaded on restart.
+	b2 := newTestBrokerOn(t, s)
+	if _, ok := b2.GetMessageStatus(fromID, old.ID); ok {
+		t.Fatal("expected an expired record to be skipped on load")
+	}
+
+	// A publish sweeps records that expired while the broker ran.
+	fresh, err := b2.Send(fromID, toID, "fresh", "")
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	b2.mu.Lock()
+	b2.deliveryLog[fresh.ID].SentAt = time.Now().Add(-messageRetention - time.Hour)
+	b2.threadIndex[fresh.ID] = threadRef{threadID: fresh.ThreadID, at: time.Now().Add(-messageRetention - time.Hour)}
+	b2.deliveriesTrimmed = time.Now().Add(-deliveryTrimInterval)
+	b2.mu.Unlock()
+	if _, err := b2.Send(fromID, toID, "newer", ""); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	b2.mu.Lock()
+	_, inLog := b2.deliveryLog[fresh.ID]
+	_, inIndex := b2.threadIndex[fresh.ID]
+	b2.mu.Unlock()
+	if inLog || inIndex {
+		t.Fatalf("expected the expired message to be evicted (log=%v, index=%v)", inLog, inIndex)
+	}
+}
+
+func TestDeliveryBucketTTLAppliedToExistingBucket(t *testing.T) {
+	s := runNATSServer(t)
+	nc, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("jetstream: %v", err)
+	}
+	if _, err := js.CreateKeyValue(&nats.KeyValueConfig{Bucket: deliveryBucket}); err != nil {
+		t.Fatalf("create bucket without ttl: %v", err)
+	}
+
+	b := newTestBrokerOn(t, s)
+	status, err := b.deliveryKV.Status()
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	if status.TTL() != messageRetention {
+		t.Fatalf("expected the bucket ttl to match message retention, got %v", status.TTL())
+	}
+}
+
+func TestPruneDeletesInboxConsumer(t *testing.T) {
+	b := newTestBroker(t)
+	id, err := b.RegisterAgent(testProfile("ephemeral"))
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := b.js.ConsumerInfo(b.streamName, inboxConsumer(id)); err != nil {
+		t.Fatalf("expected inbox consumer after register: %v", err)
+	}
+	if n := b.PruneStaleAgents(time.Nanosecond); n != 1 {
+		t.Fatalf("expected 1 pruned, got %d", n)
+	}
+	if _, err := b.js.ConsumerInfo(b.streamName, inboxConsumer(id)); err == nil {
+		t.Fatal("expected inbox consumer to be deleted after prune")
+	}
+}
+
+func TestPrunePersistsReleasedState(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(testProfile("lead"))
+	dev, _ := b.RegisterAgent(testProfile("dev"))
+	if _, err := b.CreateChannel(dev, "relay-mesh/backend", ""); err != nil {
+		t.Fatalf("create channel: %v", err)
+	}
+	task, _ := b.CreateTask(lead, "relay-mesh", "Ship it", "", nil)
+	if _, err := b.ClaimTask(dev, task.ID, time.Hour); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if _, err := b.AcquireLock(dev, "relay-mesh", "go.mod", time.Hour, ""); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	b.mu.Lock()
+	var lockKey string
+	for key := range b.locks {
+		lockKey = key
+	}
+	b.agents[dev].LastSeen = time.Now().Add(-24 * time.Hour)
+	b.mu.Unlock()
+
+	if n := b.PruneStaleAgents(time.Hour); n != 1 {
+		t.Fatalf("expected 1 pruned, got %d", n)
+	}
+	entry, err := b.channelKV.Get("relay-mesh/backend")
+	if err != nil {
+		t.Fatalf("get channel: %v", err)
+	}
+	var c Channel
+	if err := json.Unmarshal(entry.Value(), &c); err != nil || len(c.Members) != 0 {
+		t.Fatalf("expected persisted channel without members, got %+v (%v)", c, err)
+	}
+	entry, err = b.taskKV.Get(task.ID)
+	if err != nil {
+		t.Fatalf("get task: %v", err)
+	}
+	var tk Task
+	if err := json.Unmarshal(entry.Value(), &tk); err != nil || tk.Status != TaskOpen {
+		t.Fatalf("expected persisted task to reopen, got %+v (%v)", tk, err)
+	}
+	if _, err := b.lockKV.Get(lockKey); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("expected persisted lock to be deleted, got %v", err)
+	}
+	if _, err := b.registry.Get(dev); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("expected registry entry to be purged, got %v", err)
+	}
+}
+
+func TestFlushWritesRedoesStaleWrites(t *testing.T) {
+	b := newTestBroker(t)
+	alice, _ := b.RegisterAgent(testProfile("alice"))
+	if _, err := b.CreateChannel(alice, "relay-mesh/backend", ""); err != nil {
+		t.Fatalf("create channel: %v", err)
+	}
+
+	b.mu.Lock()
+	w := b.channelWrite("relay-mesh/backend")
+	// A concurrent change lands after the write was rendered.
+	b.channels["relay-mesh/backend"].Description = "newer"
+	b.mu.Unlock()
+	b.flushWrites([]kvWrite{w})
+
+	entry, err := b.channelKV.Get("relay-mesh/backend")
+	if err != nil {
+		t.Fatalf("get channel: %v", err)
+	}
+	var c Channel
+	if err := json.Unmarshal(entry.Value(), &c); err != nil || c.Description != "newer" {
+		t.Fatalf("expected the newer channel to win, got %+v (%v)", c, err)
+	}
+}
+
+func TestThreadedReplies(t *testing.T) {
+	s := runNATSServer(t)
+	b := newTestBrokerOn(t, s)
+
+	aliceID, _ := b.RegisterAgent(testProfile("alice"))
+	bobID, _ := b.RegisterAgent(testProfile("bob"))
+
+	question, err := b.Send(aliceID, bobID, "which port?", "")
+	if err != nil {
+		t.Fatalf("send question: %v", err)
+	}
+	if !strings.HasPrefix(question.ThreadID, "th-") {
+		t.Fatalf("expected new thread id, got %q", question.ThreadID)
+	}
+	other, err := b.Send(aliceID, bobID, "unrelated", "")
+	if err != nil {
+		t.Fatalf("send unrelated: %v", err)
+	}
+	if other.ThreadID == question.ThreadID {
+		t.Fatal("expected unrelated message to start its own thread")
+	}
+
+	answer, err := b.SendWithOptions(bobID, aliceID, "8080", SendOptions{ReplyTo: question.ID})
+	if err != nil {
+		t.Fatalf("send answer: %v", err)
+	}
+	if answer.ThreadID != question.ThreadID || answer.ReplyTo != question.ID {
+		t.Fatalf("expected answer in question thread, got %#v", answer)
+	}
+
+	if _, err := b.SendWithOptions(bobID, aliceID, "x", SendOptions{ReplyTo: "msg-missing"}); err == nil ||
+		!strings.Contains(err.Error(), "reply_to message not found") {
+		t.Fatalf("expected unknown reply_to error, got %v", err)
+	}
+	if _, err := b.SendWithOptions(bobID, aliceID, "x", SendOptions{ReplyTo: question.ID, ThreadID: other.ThreadID}); err == nil {
+		t.Fatal("expected mismatched thread_id to be rejected")
+	}
+
+	thread, err := b.GetThread(aliceID, question.ThreadID, 0)
+	if err != nil {
+		t.Fatalf("get thread: %v", err)
+	}
+	if len(thread) != 2 || thread[0].ID != question.ID || thread[1].ID != answer.ID {
+		t.Fatalf("unexpected thread contents: %#v", thread)
+	}
+
+	// After a restart the thread is resolved from JetStream.
+	b.Close()
+	b2 := newTestBrokerOn(t, s)
+	followUp, err := b2.SendWithOptions(aliceID, bobID, "thanks", SendOptions{ReplyTo: answer.ID})
+	if err != nil {
+		t.Fatalf("reply after restart: %v", err)
+	}
+	if followUp.ThreadID != question.ThreadID {
+		t.Fatalf("expected follow-up in original thread, got %q", followUp.ThreadID)
+	}
+	thread, err = b2.GetThread(aliceID, question.ThreadID, 0)
+	if err != nil {
+		t.Fatalf("get thread after restart: %v", err)
+	}
+	if len(thread) != 3 {
+		t.Fatalf("expected 3 messages in thread, got %d", len(thread))
+	}
+}
+
+func TestBroadcastSharesThread(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(testProfile("lead"))
+	b.RegisterAgent(testProfile("dev1"))
+	b.RegisterAgent(testProfile("dev2"))
+
+	msgs, err := b.Broadcast(lead, "standup", "", AgentSearchFilter{Project: "relay-mesh"})
+	if err != nil {
+		t.Fatalf("broadcast: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 recipients, got %d", len(msgs))
+	}
+	if msgs[0].ThreadID == "" || msgs[0].ThreadID != msgs[1].ThreadID {
+		t.Fatalf("expected broadcast copies to share a thread, got %q and %q", msgs[0].ThreadID, msgs[1].ThreadID)
+	}
+}
+
+func TestAskReceivesReply(t *testing.T) {
+	b := newTestBroker(t)
+	aliceID, _ := b.RegisterAgent(testProfile("alice"))
+	bobID, _ := b.RegisterAgent(testProfile("bob"))
+	carolID, _ := b.RegisterAgent(testProfile("carol"))
+
+	pending, err := b.Ask(aliceID, bobID, "which port?", SendOptions{})
+	if err != nil {
+		t.Fatalf("ask: %v", err)
+	}
+	defer pending.Close()
+	if !pending.Message.Request {
+		t.Fatal("expected request flag on asked message")
+	}
+
+	// The responder reports through t.Errorf, so a failure shows up as
+	// itself rather than as a Wait timeout; the test waits for it to end.
+	done := make(chan struct{})
+	defer func() { <-done }()
+	go func() {
+		defer close(done)
+		for deadline := time.Now().Add(3 * time.Second); b.UnreadCount(bobID) == 0 && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		msgs, err := b.Fetch(bobID, 1)
+		if err != nil {
+			t.Errorf("responder fetch: %v", err)
+			return
+		}
+		if len(msgs) != 1 || !msgs[0].Request {
+			t.Errorf("expected one request for bob, got %+v", msgs)
+			return
+		}
+		// A reply from someone other than the addressee is ignored.
+		if _, err := b.SendWithOptions(carolID, aliceID, "9090", SendOptions{ReplyTo: msgs[0].ID}); err != nil {
+			t.Errorf("send stray reply: %v", err)
+			return
+		}
+		if _, err := b.SendWithOptions(bobID, aliceID, "8080", SendOptions{ReplyTo: msgs[0].ID}); err != nil {
+			t.Errorf("send reply: %v", err)
+		}
+	}()
+
+	reply, err := pending.Wait(context.Background(), 5*time.Second)
+	if err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if reply.Body != "8080" || reply.From != bobID || reply.ThreadID != pending.Message.ThreadID {
+		t.Fatalf("unexpected reply: %#v", reply)
+	}
+}
+
+func TestAskTimeoutAndCancel(t *testing.T) {
+	b := newTestBroker(t)
+	aliceID, _ := b.RegisterAgent(testProfile("alice"))
+	bobID, _ := b.RegisterAgent(testProfile("bob"))
+
+	if _, err := b.Ask(aliceID, "ag-missing", "hello?", SendOptions{}); err == nil ||
+		!strings.Contains(err.Error(), "target agent not found") {
+		t.Fatalf("expected target not found, got %v", err)
+	}
+
+	pending, err := b.Ask(aliceID, bobID, "anyone?", SendOptions{})
+	if err != nil {
+		t.Fatalf("ask: %v", err)
+	}
+	if _, err := pending.Wait(context.Background(), 50*time.Millisecond); !errors.Is(err, ErrReplyTimeout) {
+		t.Fatalf("expected ErrReplyTimeout, got %v", err)
+	}
+	pending.Close()
+
+	pending, err = b.Ask(aliceID, bobID, "still there?", SendOptions{})
+	if err != nil {
+		t.Fatalf("ask: %v", err)
+	}
+	defer pending.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := pending.Wait(ctx, time.Minute); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,414 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const channelBucket = "RELAY_CHANNELS"
+
+// Channel is a named topic agents join explicitly. Posts go to every
+// member at post time instead of to whoever matches a profile filter.
+type Channel struct {
+	Name        string    `json:"name"` // e.g. "my-app/backend-api"
+	Description string    `json:"description,omitempty"`
+	Subject     string    `json:"subject"`
+	CreatedBy   string    `json:"created_by"`
+	Members     []string  `json:"members"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// NormalizeChannelName lowercases a channel name and normalizes each
+// "/"-separated segment like a project name. Segments may only contain
+// letters, digits and hyphens after normalization.
+func NormalizeChannelName(name string) (string, error) {
+	name = strings.Trim(strings.TrimSpace(name), "/")
+	if name == "" {
+		return "", fmt.Errorf("channel name is required")
+	}
+	segments := strings.Split(name, "/")
+	for i, seg := range segments {
+		seg = normalizeProjectName(seg)
+		if seg == "" {
+			return "", fmt.Errorf("invalid channel name %q: empty segment", name)
+		}
+		for _, r := range seg {
+			if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') && r != '-' {
+				return "", fmt.Errorf("invalid channel name %q: segments may only contain letters, digits and hyphens", name)
+			}
+		}
+		segments[i] = seg
+	}
+	name = strings.Join(segments, "/")
+	if len(name) > 128 {
+		return "", fmt.Errorf("invalid channel name: longer than 128 characters")
+	}
+	return name, nil
+}
+
+func (b *Broker) channelSubject(name string) string {
+	return b.subjectPrefix + ".channel." + strings.ReplaceAll(name, "/", ".")
+}
+
+// channelProject is the project a channel belongs to: the first segment
+// of its normalized name.
+func channelProject(name string) string {
+	project, _, _ := strings.Cut(name, "/")
+	return project
+}
+
+// requireChannelAccess checks that agentID is a member of the channel's
+// project and, to post, that it satisfies the project's broadcast policy.
+// Caller holds b.mu.
+func (b *Broker) requireChannelAccess(agentID, name string, post bool) error {
+	p, err := b.requireProjectAccess(agentID, channelProject(name), AccessMembers)
+	if err != nil {
+		return err
+	}
+	if post {
+		return b.checkProjectPolicy(p, agentID, p.Policy.Broadcast, "post to channels")
+	}
+	return nil
+}
+
+// CreateChannel creates a channel and makes the creator its first member.
+// The creator must be allowed to post in the channel's project.
+func (b *Broker) CreateChannel(creator, name, description string) (Channel, error) {
+	name, err := NormalizeChannelName(name)
+	if err != nil {
+		return Channel{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	agent := b.agents[creator]
+	if agent == nil {
+		return Channel{}, fmt.Errorf("agent not found: %s", creator)
+	}
+	if err := b.requireChannelAccess(creator, name, true); err != nil {
+		return Channel{}, err
+	}
+	if _, ok := b.channels[name]; ok {
+		return Channel{}, fmt.Errorf("channel already exists: %s", name)
+	}
+	now := time.Now().UTC()
+	agent.LastSeen = now
+	c := &Channel{
+		Name:        name,
+		Description: strings.TrimSpace(description),
+		Subject:     b.channelSubject(name),
+		CreatedBy:   creator,
+		Members:     []string{creator},
+		CreatedAt:   now,
+	}
+	if err := b.saveChannel(c); err != nil {
+		return Channel{}, err
+	}
+	b.channels[name] = c
+	return copyChannel(c), nil
+}
+
+// JoinChannel adds agentID to a channel of a project it is a member of.
+// Joining twice is a no-op.
+func (b *Broker) JoinChannel(agentID, name string) (Channel, error) {
+	return b.updateMembership(agentID, name, func(c *Channel) (bool, error) {
+		if err := b.requireChannelAccess(agentID, c.Name, false); err != nil {
+			return false, err
+		}
+		for _, m := range c.Members {
+			if m == agentID {
+				return false, nil
+			}
+		}
+		c.Members = append(c.Members, agentID)
+		sort.Strings(c.Members)
+		return true, nil
+	})
+}
+
+// LeaveChannel removes agentID from a channel.
+func (b *Broker) LeaveChannel(agentID, name string) (Channel, error) {
+	return b.updateMembership(agentID, name, func(c *Channel) (bool, error) {
+		for i, m := range c.Members {
+			if m == agentID {
+				c.Members = append(c.Members[:i], c.Members[i+1:]...)
+				return true, nil
+			}
+		}
+		return false, fmt.Errorf("agent %s is not a member of %s", agentID, c.Name)
+	})
+}
+
+// updateMembership applies a membership change to a channel. apply runs
+// with b.mu held.
+func (b *Broker) updateMembership(agentID, name string, apply func(*Channel) (bool, error)) (Channel, error) {
+	name, err := NormalizeChannelName(name)
+	if err != nil {
+		return Channel{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	agent := b.agents[agentID]
+	if agent == nil {
+		return Channel{}, fmt.Errorf("agent not found: %s", agentID)
+	}
+	c, ok := b.channels[name]
+	if !ok {
+		return Channel{}, fmt.Errorf("channel not found: %s", name)
+	}
+	agent.LastSeen = time.Now().UTC()
+
+	prev := append([]string(nil), c.Members...)
+	changed, err := apply(c)
+	if err != nil {
+		return Channel{}, err
+	}
+	if changed {
+		if err := b.saveChannel(c); err != nil {
+			c.Members = prev
+			return Channel{}, err
+		}
+	}
+	return copyChannel(c), nil
+}
+
+// ListChannels returns the channels of projects viewer is a member of,
+// sorted by name. A non-empty project keeps only that project's channels;
+// naming a project the viewer is not a member of is forbidden.
+func (b *Broker) ListChannels(viewer, project string) ([]Channel, error) {
+	project = normalizeProjectName(project)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.agents[viewer] == nil {
+		return nil, fmt.Errorf("agent not found: %s", viewer)
+	}
+	if project != "" {
+		if _, err := b.requireProjectAccess(viewer, project, AccessMembers); err != nil {
+			return nil, err
+		}
+	}
+	out := make([]Channel, 0, len(b.channels))
+	for name, c := range b.channels {
+		if project != "" && channelProject(name) != project {
+			continue
+		}
+		p := b.projects[channelProject(name)]
+		if p == nil {
+			continue
+		}
+		if _, ok := p.Members[viewer]; !ok {
+			continue
+		}
+		out = append(out, copyChannel(c))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// PostToChannel records body on the channel's subject and delivers a copy
+// to every other member's inbox. Only members may post, and the project's
+// broadcast policy applies. It returns the
+// channel record and the per-member copies; all share one thread.
+func (b *Broker) PostToChannel(from, name, body string, opts SendOptions) (Message, []Message, error) {
+	name, err := NormalizeChannelName(name)
+	if err != nil {
+		return Message{}, nil, err
+	}
+	if strings.TrimSpace(body) == "" {
+		return Message{}, nil, fmt.Errorf("body is required")
+	}
+	priority, err := NormalizePriority(opts.Priority)
+	if err != nil {
+		return Message{}, nil, err
+	}
+	opts.Priority = priority
+
+	b.mu.Lock()
+	sender := b.agents[from]
+	c, ok := b.channels[name]
+	if sender == nil {
+		b.mu.Unlock()
+		return Message{}, nil, fmt.Errorf("sender agent not found: %s", from)
+	}
+	if !ok {
+		b.mu.Unlock()
+		return Message{}, nil, fmt.Errorf("channel not found: %s", name)
+	}
+	member := false
+	targets := make([]*agentState, 0, len(c.Members))
+	for _, id := range c.Members {
+		if id == from {
+			member = true
+			continue
+		}
+		if a := b.agents[id]; a != nil {
+			targets = append(targets, a)
+		}
+	}
+	if !member {
+		b.mu.Unlock()
+		return Message{}, nil, fmt.Errorf("agent %s is not a member of %s; join_channel first", from, name)
+	}
+	if err := b.requireChannelAccess(from, name, true); err != nil {
+		b.mu.Unlock()
+		return Message{}, nil, err
+	}
+	sender.LastSeen = time.Now().UTC()
+	subject := c.Subject
+	b.mu.Unlock()
+
+	threadID, err := b.resolveThread(opts)
+	if err != nil {
+		return Message{}, nil, err
+	}
+	opts.ThreadID = threadID
+	opts.channel = name
+
+	id, err := randomID("msg")
+	if err != nil {
+		return Message{}, nil, err
+	}
+	post := Message{
+		ID:        id,
+		From:      from,
+		Body:      body,
+		Priority:  opts.Priority,
+		ReplyTo:   strings.TrimSpace(opts.ReplyTo),
+		ThreadID:  threadID,
+		Channel:   name,
+		CreatedAt: time.Now().UTC(),
+	}
+	data, err := json.Marshal(post)
+	if err != nil {
+		return Message{}, nil, fmt.Errorf("marshal message: %w", err)
+	}
+	if _, err := b.js.Publish(subject, data); err != nil {
+		return Message{}, nil, fmt.Errorf("jetstream publish: %w", err)
+	}
+	b.mu.Lock()
+	b.threadIndex[id] = threadRef{threadID: threadID, at: post.CreatedAt}
+	b.mu.Unlock()
+
+	out := make([]Message, 0, len(targets))
+	for _, to := range targets {
+		copyID, err := randomID("msg")
+		if err != nil {
+			return post, out, err
+		}
+		msg, err := b.publish(copyID, from, to.ID, to.Subject, body, opts)
+		if err != nil {
+			return post, out, err
+		}
+		out = append(out, msg)
+	}
+	return post, out, nil
+}
+
+// ChannelHistory returns up to max posts from a channel's JetStream
+// subject, oldest first. viewer must be a member of the channel's project.
+func (b *Broker) ChannelHistory(viewer, name string, max int) ([]Message, error) {
+	name, err := NormalizeChannelName(name)
+	if err != nil {
+		return nil, err
+	}
+	if max <= 0 {
+		max = 20
+	}
+	b.mu.Lock()
+	_, ok := b.channels[name]
+	if !ok {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("channel not found: %s", name)
+	}
+	if err := b.requireChannelAccess(viewer, name, false); err != nil {
+		b.mu.Unlock()
+		return nil, err
+	}
+	b.mu.Unlock()
+	return b.scanMessages(max, func(m Message) bool { return m.Channel == name && m.To == "" })
+}
+
+// saveChannel persists a channel. Caller holds b.mu.
+func (b *Broker) saveChannel(c *Channel) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("marshal channel: %w", err)
+	}
+	if _, err := b.channelKV.Put(c.Name, data); err != nil {
+		return fmt.Errorf("persist channel: %w", err)
+	}
+	return nil
+}
+
+// dropChannelMember removes a pruned agent from every channel and returns
+// the names of the channels it left, for the caller to persist. Caller
+// holds b.mu.
+func (b *Broker) dropChannelMember(agentID string) []string {
+	var changed []string
+	for _, c := range b.channels {
+		for i, m := range c.Members {
+			if m == agentID {
+				c.Members = append(c.Members[:i], c.Members[i+1:]...)
+				changed = append(changed, c.Name)
+				break
+			}
+		}
+	}
+	return changed
+}
+
+// channelWrite renders a channel for a deferred save. Caller holds b.mu.
+func (b *Broker) channelWrite(name string) kvWrite {
+	return newKVWrite(b.channelKV, name, func() any {
+		if c := b.channels[name]; c != nil {
+			return c
+		}
+		return nil
+	})
+}
+
+// loadChannels restores channels from the channel bucket, dropping
+// members that are no longer registered. Caller holds b.mu.
+func (b *Broker) loadChannels() error {
+	keys, err := b.channelKV.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("list channel keys: %w", err)
+	}
+	for _, key := range keys {
+		entry, err := b.channelKV.Get(key)
+		if err != nil {
+			continue
+		}
+		var c Channel
+		if err := json.Unmarshal(entry.Value(), &c); err != nil || c.Name == "" {
+			continue
+		}
+		members := c.Members[:0]
+		for _, id := range c.Members {
+			if b.agents[id] != nil {
+				members = append(members, id)
+			}
+		}
+		c.Members = members
+		c.Subject = b.channelSubject(c.Name)
+		b.channels[c.Name] = &c
+	}
+	return nil
+}
+
+func copyChannel(c *Channel) Channel {
+	cp := *c
+	cp.Members = append([]string(nil), c.Members...)
+	return cp
+}
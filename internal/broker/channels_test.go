@@ -0,0 +1,183 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNormalizeChannelName(t *testing.T) {
+	cases := map[string]string{
+		"my-app/backend-api": "my-app/backend-api",
+		"MyApp/BackendAPI":   "my-app/backend-api",
+		" /team/ops/ ":       "team/ops",
+		"release_notes":      "release-notes",
+	}
+	for in, want := range cases {
+		got, err := NormalizeChannelName(in)
+		if err != nil {
+			t.Fatalf("normalize %q: %v", in, err)
+		}
+		if got != want {
+			t.Fatalf("normalize %q: expected %q, got %q", in, want, got)
+		}
+	}
+	for _, bad := range []string{"", "a//b", "a.b", "a/*", "a/>"} {
+		if _, err := NormalizeChannelName(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestChannelPostReachesMembersOnly(t *testing.T) {
+	b := newTestBroker(t)
+	alice, _ := b.RegisterAgent(testProfile("alice"))
+	bob, _ := b.RegisterAgent(testProfile("bob"))
+	carol, _ := b.RegisterAgent(testProfile("carol"))
+
+	c, err := b.CreateChannel(alice, "relay-mesh/backend", "backend chatter")
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if c.Subject != "relay.channel.relay-mesh.backend" {
+		t.Fatalf("unexpected subject: %s", c.Subject)
+	}
+	if _, err := b.CreateChannel(bob, "relay-mesh/backend", ""); err == nil {
+		t.Fatal("expected duplicate channel to be rejected")
+	}
+	if _, err := b.JoinChannel(bob, "relay-mesh/backend"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, _, err := b.PostToChannel(carol, "relay-mesh/backend", "hi", SendOptions{}); err == nil {
+		t.Fatal("expected non-member post to be rejected")
+	}
+
+	post, copies, err := b.PostToChannel(alice, "relay-mesh/backend", "schema is ready", SendOptions{})
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if len(copies) != 1 || copies[0].To != bob || copies[0].Channel != "relay-mesh/backend" {
+		t.Fatalf("expected one copy for bob, got %+v", copies)
+	}
+	if copies[0].ThreadID != post.ThreadID {
+		t.Fatalf("expected copies to share the post thread")
+	}
+	waitForQueuedMessages(t, b, bob, 1)
+	if n := b.UnreadCount(carol); n != 0 {
+		t.Fatalf("expected non-member to receive nothing, got %d", n)
+	}
+
+	if _, err := b.LeaveChannel(bob, "relay-mesh/backend"); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if _, err := b.LeaveChannel(bob, "relay-mesh/backend"); err == nil {
+		t.Fatal("expected leaving twice to fail")
+	}
+	if _, copies, _ = b.PostToChannel(alice, "relay-mesh/backend", "second", SendOptions{}); len(copies) != 0 {
+		t.Fatalf("expected no copies after bob left, got %d", len(copies))
+	}
+
+	history, err := b.ChannelHistory(bob, "relay-mesh/backend", 10)
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if len(history) != 2 || history[0].Body != "schema is ready" || history[1].Body != "second" {
+		t.Fatalf("unexpected channel history: %+v", history)
+	}
+	thread, err := b.GetThread(bob, post.ThreadID, 10)
+	if err != nil {
+		t.Fatalf("thread: %v", err)
+	}
+	if len(thread) != 1 {
+		t.Fatalf("expected channel post once in thread, got %d", len(thread))
+	}
+}
+
+func TestListChannelsByProject(t *testing.T) {
+	b := newTestBroker(t)
+	alice, _ := b.RegisterAgent(testProfile("alice"))
+	ops, _ := b.RegisterAgent(projectProfile("ops", "other", "developer"))
+	b.CreateChannel(alice, "relay-mesh/backend", "")
+	b.CreateChannel(alice, "relay-mesh/frontend", "")
+	if _, err := b.CreateChannel(ops, "other/ops", ""); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	got, err := b.ListChannels(alice, "")
+	if err != nil || len(got) != 2 {
+		t.Fatalf("expected alice to see her project's 2 channels, got %+v (%v)", got, err)
+	}
+	got, err = b.ListChannels(alice, "RelayMesh")
+	if err != nil || len(got) != 2 || got[0].Name != "relay-mesh/backend" || got[1].Name != "relay-mesh/frontend" {
+		t.Fatalf("unexpected project channels: %+v (%v)", got, err)
+	}
+	if _, err := b.ListChannels(alice, "other"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected listing another project to be forbidden, got %v", err)
+	}
+}
+
+func TestChannelsRequireProjectAccess(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(testProfile("lead"))
+	dev, _ := b.RegisterAgent(testProfile("dev"))
+	mallory, _ := b.RegisterAgent(projectProfile("mallory", "contractor", "developer"))
+	if _, err := b.CreateChannel(lead, "relay-mesh/backend", ""); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, _, err := b.PostToChannel(lead, "relay-mesh/backend", "secret plan", SendOptions{}); err != nil {
+		t.Fatalf("post: %v", err)
+	}
+
+	if _, err := b.CreateChannel(mallory, "relay-mesh/intrude", ""); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected non-member create to be forbidden, got %v", err)
+	}
+	if _, err := b.JoinChannel(mallory, "relay-mesh/backend"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected non-member join to be forbidden, got %v", err)
+	}
+	if _, err := b.ChannelHistory(mallory, "relay-mesh/backend", 10); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected non-member history to be forbidden, got %v", err)
+	}
+
+	// An admins-only broadcast policy covers channel posts too.
+	admins := AccessAdmins
+	if _, err := b.SetProjectPolicy(lead, "relay-mesh", ProjectPolicyPatch{Broadcast: &admins}); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+	if _, err := b.JoinChannel(dev, "relay-mesh/backend"); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+	if _, _, err := b.PostToChannel(dev, "relay-mesh/backend", "hi all", SendOptions{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected a non-admin post to be forbidden, got %v", err)
+	}
+	if _, err := b.CreateChannel(dev, "relay-mesh/frontend", ""); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected a non-admin create to be forbidden, got %v", err)
+	}
+	if _, _, err := b.PostToChannel(lead, "relay-mesh/backend", "from the lead", SendOptions{}); err != nil {
+		t.Fatalf("admin post: %v", err)
+	}
+}
+
+func TestChannelsSurviveRestartAndPrune(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+	alice, _ := b1.RegisterAgent(testProfile("alice"))
+	bob, _ := b1.RegisterAgent(testProfile("bob"))
+	b1.CreateChannel(alice, "relay-mesh/backend", "")
+	b1.JoinChannel(bob, "relay-mesh/backend")
+	b1.Close()
+
+	b2 := newTestBrokerOn(t, s)
+	got, _ := b2.ListChannels(alice, "")
+	if len(got) != 1 || len(got[0].Members) != 2 {
+		t.Fatalf("expected rehydrated channel with 2 members, got %+v", got)
+	}
+
+	b2.mu.Lock()
+	b2.agents[bob].LastSeen = time.Now().Add(-24 * time.Hour)
+	b2.mu.Unlock()
+	b2.PruneStaleAgents(time.Hour)
+	got, _ = b2.ListChannels(alice, "")
+	if len(got[0].Members) != 1 || got[0].Members[0] != alice {
+		t.Fatalf("expected pruned agent removed from channel, got %+v", got[0].Members)
+	}
+}
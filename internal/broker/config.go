@@ -0,0 +1,214 @@
+package broker
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Defaults for Config.
+const (
+	DefaultSubjectPrefix = "relay"
+	DefaultStreamName    = "RELAY_MESSAGES"
+)
+
+// Config tells New how to reach NATS and where to keep messages. At most
+// one of User, Token, NKeySeedFile and CredsFile may be set.
+type Config struct {
+	URL string // comma-separated server URLs; defaults to nats.DefaultURL
+	// InProcess connects to a server running in the same process, such as
+	// an embedded one, instead of dialing URL.
+	InProcess nats.InProcessConnProvider
+
+	User     string
+	Password string
+	Token    string
+	// NKeySeedFile is a file holding an NKey user seed.
+	NKeySeedFile string
+	// CredsFile is a decentralized-auth .creds file (user JWT and seed).
+	CredsFile string
+
+	// TLSCAFile verifies the server certificate; TLSCertFile and
+	// TLSKeyFile are the client certificate for servers that require one.
+	TLSCAFile   string
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// SubjectPrefix roots every subject the broker uses, e.g. agent inboxes
+	// on <prefix>.agent.<id>. Defaults to DefaultSubjectPrefix.
+	SubjectPrefix string
+	// StreamName is the JetStream stream holding messages. Defaults to
+	// DefaultStreamName.
+	StreamName string
+}
+
+func (c Config) withDefaults() Config {
+	c.URL = strings.TrimSpace(c.URL)
+	if c.URL == "" {
+		c.URL = nats.DefaultURL
+	}
+	c.SubjectPrefix = strings.Trim(strings.TrimSpace(c.SubjectPrefix), ".")
+	if c.SubjectPrefix == "" {
+		c.SubjectPrefix = DefaultSubjectPrefix
+	}
+	c.StreamName = strings.TrimSpace(c.StreamName)
+	if c.StreamName == "" {
+		c.StreamName = DefaultStreamName
+	}
+	return c
+}
+
+func (c Config) validate() error {
+	methods := 0
+	for _, set := range []bool{c.User != "", c.Token != "", c.NKeySeedFile != "", c.CredsFile != ""} {
+		if set {
+			methods++
+		}
+	}
+	if methods > 1 {
+		return fmt.Errorf("nats config: set only one of user, token, nkey seed and creds file")
+	}
+	if c.Password != "" && c.User == "" {
+		return fmt.Errorf("nats config: password requires a user")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("nats config: TLS client cert and key must be set together")
+	}
+	for _, tok := range strings.Split(c.SubjectPrefix, ".") {
+		if tok == "" || strings.ContainsAny(tok, "*> \t\r\n") {
+			return fmt.Errorf("nats config: invalid subject prefix %q", c.SubjectPrefix)
+		}
+	}
+	if strings.ContainsAny(c.StreamName, ".*>/\\ \t\r\n") {
+		return fmt.Errorf("nats config: invalid stream name %q", c.StreamName)
+	}
+	return nil
+}
+
+// options builds the nats.Connect options for c, reporting connection
+// changes to conn.
+func (c Config) options(conn *connState) ([]nats.Option, error) {
+	opts := []nats.Option{
+		nats.Name("relay-mesh"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(conn.disconnected),
+		nats.ReconnectHandler(conn.reconnected),
+		nats.ClosedHandler(conn.closed),
+		nats.ErrorHandler(conn.asyncError),
+	}
+	switch {
+	case c.User != "":
+		opts = append(opts, nats.UserInfo(c.User, c.Password))
+	case c.Token != "":
+		opts = append(opts, nats.Token(c.Token))
+	case c.NKeySeedFile != "":
+		opt, err := nats.NkeyOptionFromSeed(c.NKeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("load nkey seed: %w", err)
+		}
+		opts = append(opts, opt)
+	case c.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(c.CredsFile))
+	}
+	if c.InProcess != nil {
+		opts = append(opts, nats.InProcessServer(c.InProcess))
+	}
+	if c.TLSCAFile != "" {
+		opts = append(opts, nats.RootCAs(c.TLSCAFile))
+	}
+	if c.TLSCertFile != "" {
+		opts = append(opts, nats.ClientCert(c.TLSCertFile, c.TLSKeyFile))
+	}
+	return opts, nil
+}
+
+// connState records NATS connection events for Health. Its handlers run on
+// the NATS client's callback goroutine.
+type connState struct {
+	mu             sync.Mutex
+	lastDisconnect time.Time
+	lastReconnect  time.Time
+	lastError      string
+}
+
+func (s *connState) disconnected(nc *nats.Conn, err error) {
+	s.mu.Lock()
+	s.lastDisconnect = time.Now().UTC()
+	if err != nil {
+		s.lastError = err.Error()
+	}
+	s.mu.Unlock()
+	slog.Warn("nats disconnected", "error", err)
+}
+
+func (s *connState) reconnected(nc *nats.Conn) {
+	s.mu.Lock()
+	s.lastReconnect = time.Now().UTC()
+	s.mu.Unlock()
+	slog.Info("nats reconnected", "url", nc.ConnectedUrlRedacted())
+}
+
+func (s *connState) closed(nc *nats.Conn) {
+	if err := nc.LastError(); err != nil {
+		s.mu.Lock()
+		s.lastError = err.Error()
+		s.mu.Unlock()
+		slog.Warn("nats connection closed", "error", err)
+	}
+}
+
+func (s *connState) asyncError(nc *nats.Conn, sub *nats.Subscription, err error) {
+	s.mu.Lock()
+	s.lastError = err.Error()
+	s.mu.Unlock()
+	subject := ""
+	if sub != nil {
+		subject = sub.Subject
+	}
+	slog.Warn("nats async error", "subject", subject, "error", err)
+}
+
+// Health is a snapshot of the broker's NATS connection.
+type Health struct {
+	Status         string     `json:"status"` // CONNECTED, RECONNECTING, CLOSED, ...
+	Connected      bool       `json:"connected"`
+	ServerURL      string     `json:"server_url,omitempty"` // credentials redacted
+	ServerID       string     `json:"server_id,omitempty"`
+	Reconnects     uint64     `json:"reconnects"`
+	LastDisconnect *time.Time `json:"last_disconnect,omitempty"`
+	LastReconnect  *time.Time `json:"last_reconnect,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	SubjectPrefix  string     `json:"subject_prefix"`
+	Stream         string     `json:"stream"`
+	Agents         int        `json:"agents"`
+}
+
+// Health reports the state of the NATS connection.
+func (b *Broker) Health() Health {
+	h := Health{
+		Status:        b.nc.Status().String(),
+		Connected:     b.nc.IsConnected(),
+		ServerURL:     b.nc.ConnectedUrlRedacted(),
+		ServerID:      b.nc.ConnectedServerId(),
+		Reconnects:    b.nc.Stats().Reconnects,
+		SubjectPrefix: b.subjectPrefix,
+		Stream:        b.streamName,
+	}
+	b.conn.mu.Lock()
+	if t := b.conn.lastDisconnect; !t.IsZero() {
+		h.LastDisconnect = &t
+	}
+	if t := b.conn.lastReconnect; !t.IsZero() {
+		h.LastReconnect = &t
+	}
+	h.LastError = b.conn.lastError
+	b.conn.mu.Unlock()
+	b.mu.Lock()
+	h.Agents = len(b.agents)
+	b.mu.Unlock()
+	return h
+}
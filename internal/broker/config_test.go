@@ -0,0 +1,140 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+)
+
+func TestConfigValidate(t *testing.T) {
+	bad := []Config{
+		{User: "u", Token: "t"},
+		{Token: "t", CredsFile: "user.creds"},
+		{Password: "p"},
+		{TLSCertFile: "client.pem"},
+		{SubjectPrefix: "acme.*"},
+		{SubjectPrefix: "acme..relay"},
+		{StreamName: "ACME.MESSAGES"},
+	}
+	for i, cfg := range bad {
+		if err := cfg.withDefaults().validate(); err == nil {
+			t.Fatalf("case %d: expected %+v to be rejected", i, cfg)
+		}
+	}
+	cfg := Config{SubjectPrefix: " .acme.relay. "}.withDefaults()
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if cfg.SubjectPrefix != "acme.relay" || cfg.StreamName != DefaultStreamName || cfg.URL == "" {
+		t.Fatalf("unexpected defaults %+v", cfg)
+	}
+}
+
+func TestNewAuthenticatesWithToken(t *testing.T) {
+	s := runNATSServerWith(t, func(o *natsserver.Options) { o.Authorization = "s3cret" })
+
+	if _, err := New(Config{URL: s.ClientURL()}); err == nil {
+		t.Fatal("expected connecting without the token to fail")
+	}
+	b, err := New(Config{URL: s.ClientURL(), Token: "s3cret"})
+	if err != nil {
+		t.Fatalf("connect with token: %v", err)
+	}
+	defer b.Close()
+	if h := b.Health(); !h.Connected || h.Status != "CONNECTED" {
+		t.Fatalf("expected a connected broker, got %+v", h)
+	}
+}
+
+func TestNewAuthenticatesWithUserPassword(t *testing.T) {
+	s := runNATSServerWith(t, func(o *natsserver.Options) {
+		o.Username = "relay"
+		o.Password = "pw"
+	})
+
+	if _, err := New(Config{URL: s.ClientURL(), User: "relay", Password: "wrong"}); err == nil {
+		t.Fatal("expected a wrong password to fail")
+	}
+	b, err := New(Config{URL: s.ClientURL(), User: "relay", Password: "pw"})
+	if err != nil {
+		t.Fatalf("connect with user: %v", err)
+	}
+	b.Close()
+}
+
+func TestCustomSubjectPrefixAndStream(t *testing.T) {
+	s := runNATSServer(t)
+	b, err := New(Config{URL: s.ClientURL(), SubjectPrefix: "acme.relay", StreamName: "ACME_MESSAGES"})
+	if err != nil {
+		t.Fatalf("create broker: %v", err)
+	}
+	t.Cleanup(b.Close)
+
+	from, _ := b.RegisterAgent(testProfile("from"))
+	to, _ := b.RegisterAgent(testProfile("to"))
+	if _, err := b.Send(from, to, "hello", ""); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	got, err := b.Fetch(to, 10)
+	if err != nil || len(got) != 1 || got[0].Body != "hello" {
+		t.Fatalf("expected the message, got %v (%v)", got, err)
+	}
+
+	info, err := b.js.StreamInfo("ACME_MESSAGES")
+	if err != nil {
+		t.Fatalf("stream info: %v", err)
+	}
+	if subjects := info.Config.Subjects; len(subjects) == 0 || subjects[0] != "acme.relay.agent.>" {
+		t.Fatalf("unexpected stream subjects %v", subjects)
+	}
+	if _, err := b.js.StreamInfo(DefaultStreamName); err == nil {
+		t.Fatal("expected the default stream not to be created")
+	}
+	if h := b.Health(); h.SubjectPrefix != "acme.relay" || h.Stream != "ACME_MESSAGES" || h.Agents != 2 {
+		t.Fatalf("unexpected health %+v", h)
+	}
+}
+
+func TestInboxFollowsChangedSubjectPrefix(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+	from, _ := b1.RegisterAgent(testProfile("from"))
+	to, _ := b1.RegisterAgent(testProfile("to"))
+	b1.Close()
+
+	b2, err := New(Config{URL: s.ClientURL(), SubjectPrefix: "acme"})
+	if err != nil {
+		t.Fatalf("restart with new prefix: %v", err)
+	}
+	t.Cleanup(b2.Close)
+	if _, err := b2.Send(from, to, "after", ""); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	got, err := b2.Fetch(to, 10)
+	if err != nil || len(got) != 1 || got[0].Body != "after" {
+		t.Fatalf("expected the message on the new subject, got %v (%v)", got, err)
+	}
+}
+
+func TestHealthReportsDisconnect(t *testing.T) {
+	s := runNATSServer(t)
+	b := newTestBrokerOn(t, s)
+
+	s.Shutdown()
+	deadline := time.Now().Add(3 * time.Second)
+	for b.Health().Connected {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the broker to notice the server going away")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	h := b.Health()
+	for h.LastDisconnect == nil && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		h = b.Health()
+	}
+	if h.LastDisconnect == nil || h.Status == "CONNECTED" {
+		t.Fatalf("expected a recorded disconnect, got %+v", h)
+	}
+}
@@ -0,0 +1,80 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// deliveryBucket holds delivery records so message lifecycles survive a
+// restart along with the messages. Records expire with their messages.
+const deliveryBucket = "RELAY_DELIVERY"
+
+// deliveryTrimInterval bounds how often publishing sweeps delivery
+// records of expired messages from memory.
+const deliveryTrimInterval = time.Hour
+
+// threadRef is a threadIndex entry. at is when the message entered the
+// stream, so the entry can go once the message has aged out.
+type threadRef struct {
+	threadID string
+	at       time.Time
+}
+
+// saveDelivery persists a delivery record. Caller holds b.mu.
+func (b *Broker) saveDelivery(rec *DeliveryRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal delivery record: %w", err)
+	}
+	if _, err := b.deliveryKV.Put(rec.MessageID, data); err != nil {
+		return fmt.Errorf("persist delivery record: %w", err)
+	}
+	return nil
+}
+
+// loadDeliveries restores delivery records from the delivery bucket.
+// Caller holds b.mu.
+func (b *Broker) loadDeliveries() error {
+	keys, err := b.deliveryKV.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("list delivery keys: %w", err)
+	}
+	for _, key := range keys {
+		entry, err := b.deliveryKV.Get(key)
+		if err != nil {
+			continue
+		}
+		var rec DeliveryRecord
+		if err := json.Unmarshal(entry.Value(), &rec); err != nil || rec.MessageID == "" {
+			continue
+		}
+		b.deliveryLog[rec.MessageID] = &rec
+	}
+	b.trimDeliveries(time.Now().UTC())
+	return nil
+}
+
+// trimDeliveries drops delivery records and thread index entries of
+// messages older than messageRetention, which have left the stream. Their
+// bucket entries expire on the bucket's TTL. Caller holds b.mu.
+func (b *Broker) trimDeliveries(now time.Time) {
+	cutoff := now.Add(-messageRetention)
+	for id, rec := range b.deliveryLog {
+		if rec.SentAt.Before(cutoff) {
+			delete(b.deliveryLog, id)
+		}
+	}
+	for id, ref := range b.threadIndex {
+		if ref.at.Before(cutoff) {
+			delete(b.threadIndex, id)
+		}
+	}
+	b.deliveriesTrimmed = now
+}
@@ -0,0 +1,345 @@
+package broker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const lockBucket = "RELAY_LOCKS"
+
+// defaultLockTTL is how long a lock lasts without a heartbeat from the
+// holder.
+const defaultLockTTL = 10 * time.Minute
+
+// lockPollInterval is how often AcquireLockWait retries a held lock.
+const lockPollInterval = 250 * time.Millisecond
+
+// ErrLockHeld is returned when another agent holds an overlapping lock.
+var ErrLockHeld = errors.New("resource is locked")
+
+// Lock is an advisory lease on a resource path within a project. A lock on
+// a directory covers every path beneath it.
+type Lock struct {
+	Project    string    `json:"project"`
+	Resource   string    `json:"resource"` // cleaned path, e.g. "internal/broker/broker.go"
+	Holder     string    `json:"holder"`
+	Note       string    `json:"note,omitempty"`
+	TTL        string    `json:"ttl"` // lease as a duration, e.g. "10m0s"
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// NormalizeResource cleans a resource path so "./a//b/" and "a/b" name the
+// same lock. Absolute paths stay absolute.
+func NormalizeResource(resource string) (string, error) {
+	resource = strings.TrimSpace(resource)
+	if resource == "" {
+		return "", fmt.Errorf("resource is required")
+	}
+	resource = path.Clean(strings.ReplaceAll(resource, "\\", "/"))
+	if resource == "." || resource == ".." || strings.HasPrefix(resource, "../") {
+		return "", fmt.Errorf("invalid resource %q", resource)
+	}
+	return resource, nil
+}
+
+// lockCovers reports whether a lock on resource applies to p. Directory
+// locks cover their contents, and a relative lock matches any absolute
+// path that ends with it, since hooks see absolute file paths.
+func lockCovers(resource, p string) bool {
+	if resource == p || strings.HasPrefix(p, resource+"/") {
+		return true
+	}
+	if !path.IsAbs(resource) && path.IsAbs(p) {
+		return strings.HasSuffix(p, "/"+resource) || strings.Contains(p, "/"+resource+"/")
+	}
+	return false
+}
+
+func locksOverlap(a, b string) bool {
+	return lockCovers(a, b) || lockCovers(b, a)
+}
+
+// lockKey maps a project and resource to a KV-safe key.
+func lockKey(project, resource string) string {
+	sum := sha256.Sum256([]byte(project + "\n" + resource))
+	return "lock-" + hex.EncodeToString(sum[:16])
+}
+
+// AcquireLock takes an advisory lock on resource for ttl (default 10m).
+// The holder's heartbeats renew it; acquiring a lock the agent already
+// holds renews it too. If another agent holds an overlapping lock the
+// error wraps ErrLockHeld and names the holder.
+func (b *Broker) AcquireLock(agentID, project, resource string, ttl time.Duration, note string) (Lock, error) {
+	project = normalizeProjectName(project)
+	if project == "" {
+		return Lock{}, fmt.Errorf("project is required")
+	}
+	resource, err := NormalizeResource(resource)
+	if err != nil {
+		return Lock{}, err
+	}
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+
+	var expired []kvWrite
+	defer func() { _ = b.flushWrites(expired) }() // after the unlock below
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.requireProjectAccess(agentID, project, AccessMembers); err != nil {
+		return Lock{}, err
+	}
+	agent := b.agents[agentID]
+	now := time.Now().UTC()
+	expired = b.expireLocks(now)
+	for _, l := range b.locks {
+		if l.Project != project || !locksOverlap(l.Resource, resource) {
+			continue
+		}
+		if l.Holder != agentID {
+			return Lock{}, fmt.Errorf("%w: %s is held by %s until %s", ErrLockHeld, l.Resource, l.Holder, l.ExpiresAt.Format(time.RFC3339))
+		}
+	}
+
+	agent.LastSeen = now
+	key := lockKey(project, resource)
+	l, ok := b.locks[key]
+	if !ok {
+		l = &Lock{Project: project, Resource: resource, Holder: agentID, AcquiredAt: now}
+	}
+	prev := *l
+	l.Note = strings.TrimSpace(note)
+	l.TTL = ttl.String()
+	l.ExpiresAt = now.Add(ttl)
+	if err := b.saveLock(key, l); err != nil {
+		*l = prev
+		return Lock{}, err
+	}
+	b.locks[key] = l
+	return *l, nil
+}
+
+// AcquireLockWait is AcquireLock that keeps retrying while the resource is
+// held by someone else, for up to timeout.
+func (b *Broker) AcquireLockWait(agentID, project, resource string, ttl time.Duration, note string, timeout time.Duration) (Lock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		l, err := b.AcquireLock(agentID, project, resource, ttl, note)
+		if err == nil || !errors.Is(err, ErrLockHeld) || time.Now().After(deadline) {
+			return l, err
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// ReleaseLock drops a lock. Only the holder may release it.
+func (b *Broker) ReleaseLock(agentID, project, resource string) error {
+	project = normalizeProjectName(project)
+	resource, err := NormalizeResource(resource)
+	if err != nil {
+		return err
+	}
+
+	var expired []kvWrite
+	defer func() { _ = b.flushWrites(expired) }() // after the unlock below
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.requireProjectAccess(agentID, project, AccessMembers); err != nil {
+		return err
+	}
+	agent := b.agents[agentID]
+	expired = b.expireLocks(time.Now().UTC())
+	key := lockKey(project, resource)
+	l, ok := b.locks[key]
+	if !ok {
+		return fmt.Errorf("no lock on %s in %s", resource, project)
+	}
+	if l.Holder != agentID {
+		return fmt.Errorf("lock on %s is held by %s", resource, l.Holder)
+	}
+	agent.LastSeen = time.Now().UTC()
+	if err := b.lockKV.Delete(key); err != nil {
+		return fmt.Errorf("delete lock: %w", err)
+	}
+	delete(b.locks, key)
+	return nil
+}
+
+// ListLocks returns live locks, sorted by project and resource. An empty
+// project lists every project viewer belongs to.
+func (b *Broker) ListLocks(viewer, project string) ([]Lock, error) {
+	project = normalizeProjectName(project)
+
+	var expired []kvWrite
+	defer func() { _ = b.flushWrites(expired) }() // after the unlock below
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if project != "" {
+		if _, err := b.requireProjectAccess(viewer, project, AccessMembers); err != nil {
+			return nil, err
+		}
+	} else if b.agents[viewer] == nil {
+		return nil, fmt.Errorf("agent not found: %s", viewer)
+	}
+	expired = b.expireLocks(time.Now().UTC())
+	out := make([]Lock, 0)
+	for _, l := range b.locks {
+		if project != "" && l.Project != project {
+			continue
+		}
+		if _, err := b.requireProjectAccess(viewer, l.Project, AccessMembers); err != nil {
+			continue
+		}
+		out = append(out, *l)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Project != out[j].Project {
+			return out[i].Project < out[j].Project
+		}
+		return out[i].Resource < out[j].Resource
+	})
+	return out, nil
+}
+
+// CheckLock returns the live lock covering p in project that is held by
+// someone other than requester. An empty project uses the requester's
+// project. Requesters outside the project see no locks. Editor hooks call
+// this before writing a file.
+func (b *Broker) CheckLock(requester, project, p string) (Lock, bool) {
+	p, err := NormalizeResource(p)
+	if err != nil {
+		return Lock{}, false
+	}
+
+	var expired []kvWrite
+	defer func() { _ = b.flushWrites(expired) }() // after the unlock below
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	project = normalizeProjectName(project)
+	if project == "" {
+		if a := b.agents[requester]; a != nil {
+			project = a.Profile.Project
+		}
+	}
+	if _, err := b.requireProjectAccess(requester, project, AccessMembers); err != nil {
+		return Lock{}, false
+	}
+	expired = b.expireLocks(time.Now().UTC())
+	for _, l := range b.locks {
+		if l.Project != project || l.Holder == requester || !lockCovers(l.Resource, p) {
+			continue
+		}
+		return *l, true
+	}
+	return Lock{}, false
+}
+
+// AgentForSession returns the agent bound to a harness session.
+func (b *Broker) AgentForSession(sessionID string) (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id, ok := b.sessionIndex[strings.TrimSpace(sessionID)]
+	return id, ok
+}
+
+// renewAgentLocks extends every live lock held by agentID and returns the
+// writes that persist the renewals and any expiries, for the caller to
+// flush after releasing b.mu. Caller holds b.mu.
+func (b *Broker) renewAgentLocks(agentID string, now time.Time) []kvWrite {
+	writes := b.expireLocks(now)
+	for key, l := range b.locks {
+		if l.Holder != agentID {
+			continue
+		}
+		ttl, err := time.ParseDuration(l.TTL)
+		if err != nil || ttl <= 0 {
+			ttl = defaultLockTTL
+		}
+		l.ExpiresAt = now.Add(ttl)
+		writes = append(writes, b.lockWrite(key))
+	}
+	return writes
+}
+
+// releaseAgentLocks drops every lock held by a pruned agent and returns
+// their keys, for the caller to delete. Caller holds b.mu.
+func (b *Broker) releaseAgentLocks(agentID string) []string {
+	var released []string
+	for key, l := range b.locks {
+		if l.Holder == agentID {
+			delete(b.locks, key)
+			released = append(released, key)
+		}
+	}
+	return released
+}
+
+// lockWrite renders a lock for a deferred save, or its deletion once it is
+// gone. Caller holds b.mu.
+func (b *Broker) lockWrite(key string) kvWrite {
+	return newKVWrite(b.lockKV, key, func() any {
+		if l := b.locks[key]; l != nil {
+			return l
+		}
+		return nil
+	})
+}
+
+// expireLocks drops every lock whose lease has lapsed and returns their
+// deletions, for the caller to flush after releasing b.mu. Caller holds
+// b.mu.
+func (b *Broker) expireLocks(now time.Time) []kvWrite {
+	var writes []kvWrite
+	for key, l := range b.locks {
+		if !now.Before(l.ExpiresAt) {
+			delete(b.locks, key)
+			writes = append(writes, b.lockWrite(key))
+		}
+	}
+	return writes
+}
+
+// saveLock persists a lock. Caller holds b.mu.
+func (b *Broker) saveLock(key string, l *Lock) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("marshal lock: %w", err)
+	}
+	if _, err := b.lockKV.Put(key, data); err != nil {
+		return fmt.Errorf("persist lock: %w", err)
+	}
+	return nil
+}
+
+// loadLocks restores locks from the lock bucket. Caller holds b.mu.
+func (b *Broker) loadLocks() error {
+	keys, err := b.lockKV.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("list lock keys: %w", err)
+	}
+	for _, key := range keys {
+		entry, err := b.lockKV.Get(key)
+		if err != nil {
+			continue
+		}
+		var l Lock
+		if err := json.Unmarshal(entry.Value(), &l); err != nil || l.Resource == "" {
+			continue
+		}
+		b.locks[key] = &l
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestLockAcquireAndRelease(t *testing.T) {
+	b := newTestBroker(t)
+	dev, _ := b.RegisterAgent(testProfile("dev"))
+	other, _ := b.RegisterAgent(testProfile("other"))
+
+	l, err := b.AcquireLock(dev, "relay-mesh", "./internal//broker/", 0, "refactor")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if l.Resource != "internal/broker" || l.Holder != dev {
+		t.Fatalf("unexpected lock: %+v", l)
+	}
+	if _, err := b.AcquireLock(other, "relay-mesh", "internal/broker/broker.go", 0, ""); !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("expected overlapping lock to be held, got %v", err)
+	}
+	stranger, _ := b.RegisterAgent(projectProfile("stranger", "other-project", "developer"))
+	if _, err := b.AcquireLock(stranger, "other-project", "internal/broker", 0, ""); err != nil {
+		t.Fatalf("expected locks to be scoped by project: %v", err)
+	}
+	if _, err := b.AcquireLock(dev, "relay-mesh", "internal/broker", 0, ""); err != nil {
+		t.Fatalf("expected holder to renew: %v", err)
+	}
+
+	if held, ok := b.CheckLock(other, "", "/home/me/relay-mesh/internal/broker/locks.go"); !ok || held.Holder != dev {
+		t.Fatalf("expected absolute path to match relative lock, got %+v %v", held, ok)
+	}
+	if _, ok := b.CheckLock(dev, "", "/home/me/relay-mesh/internal/broker/locks.go"); ok {
+		t.Fatal("expected holder's own lock to be ignored")
+	}
+
+	if err := b.ReleaseLock(other, "relay-mesh", "internal/broker"); err == nil {
+		t.Fatal("expected non-holder release to fail")
+	}
+	if err := b.ReleaseLock(dev, "relay-mesh", "internal/broker"); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if locks, _ := b.ListLocks(dev, "relay-mesh"); len(locks) != 0 {
+		t.Fatalf("expected no locks after release, got %+v", locks)
+	}
+}
+
+func TestLockWaitAndExpiry(t *testing.T) {
+	b := newTestBroker(t)
+	dev, _ := b.RegisterAgent(testProfile("dev"))
+	other, _ := b.RegisterAgent(testProfile("other"))
+
+	if _, err := b.AcquireLock(dev, "relay-mesh", "README.md", 100*time.Millisecond, ""); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if _, err := b.AcquireLockWait(other, "relay-mesh", "README.md", 0, "", 0); !errors.Is(err, ErrLockHeld) {
+		t.Fatalf("expected immediate wait to fail, got %v", err)
+	}
+	l, err := b.AcquireLockWait(other, "relay-mesh", "README.md", 0, "", 2*time.Second)
+	if err != nil {
+		t.Fatalf("expected lock after lease lapsed: %v", err)
+	}
+	if l.Holder != other {
+		t.Fatalf("unexpected holder: %s", l.Holder)
+	}
+}
+
+func TestLocksRenewOnHeartbeatAndReleaseOnPrune(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+	dev, _ := b1.RegisterAgent(testProfile("dev"))
+	other, _ := b1.RegisterAgent(testProfile("other"))
+	if _, err := b1.AcquireLock(dev, "relay-mesh", "go.mod", 80*time.Millisecond, ""); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	b1.Heartbeat(dev)
+	time.Sleep(50 * time.Millisecond)
+	if _, err := b1.AcquireLock(other, "relay-mesh", "go.mod", 0, ""); err == nil {
+		t.Fatal("expected heartbeat to renew the lock")
+	}
+	b1.AcquireLock(dev, "relay-mesh", "go.mod", time.Hour, "")
+	b1.Close()
+
+	b2 := newTestBrokerOn(t, s)
+	if locks, _ := b2.ListLocks(other, ""); len(locks) != 1 || locks[0].Holder != dev {
+		t.Fatalf("expected lock after restart, got %+v", locks)
+	}
+	b2.mu.Lock()
+	b2.agents[dev].LastSeen = time.Now().Add(-24 * time.Hour)
+	b2.mu.Unlock()
+	b2.PruneStaleAgents(time.Hour)
+	if locks, _ := b2.ListLocks(other, ""); len(locks) != 0 {
+		t.Fatalf("expected pruned agent's lock to be released, got %+v", locks)
+	}
+}
+
+func TestHeartbeatPersistsLockRenewalsAndExpiries(t *testing.T) {
+	b := newTestBroker(t)
+	dev, _ := b.RegisterAgent(testProfile("dev"))
+	other, _ := b.RegisterAgent(testProfile("other"))
+	held, err := b.AcquireLock(dev, "relay-mesh", "go.mod", time.Hour, "")
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if _, err := b.AcquireLock(other, "relay-mesh", "README.md", time.Millisecond, ""); err != nil {
+		t.Fatalf("acquire short lock: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := b.Heartbeat(dev); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+
+	entry, err := b.lockKV.Get(lockKey("relay-mesh", "go.mod"))
+	if err != nil {
+		t.Fatalf("get renewed lock: %v", err)
+	}
+	var renewed Lock
+	if err := json.Unmarshal(entry.Value(), &renewed); err != nil {
+		t.Fatalf("decode lock: %v", err)
+	}
+	if !renewed.ExpiresAt.After(held.ExpiresAt) {
+		t.Fatalf("expected the renewal to be persisted, got expiry %v (was %v)", renewed.ExpiresAt, held.ExpiresAt)
+	}
+	if _, err := b.lockKV.Get(lockKey("relay-mesh", "README.md")); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("expected the lapsed lock to be deleted, got %v", err)
+	}
+}
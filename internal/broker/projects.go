@@ -0,0 +1,475 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const projectBucket = "RELAY_PROJECTS"
+
+// ErrForbidden is returned when an agent lacks the project access an
+// operation needs.
+var ErrForbidden = errors.New("forbidden")
+
+// Join policies: who becomes a member of a project.
+const (
+	JoinOpen   = "open"   // agents registering with the project join it
+	JoinInvite = "invite" // only admins add members
+)
+
+// Access levels for project operations.
+const (
+	AccessMembers = "members"
+	AccessAdmins  = "admins"
+)
+
+// ProjectPolicy controls who may do what in a project.
+type ProjectPolicy struct {
+	Join           string   `json:"join"`
+	Broadcast      string   `json:"broadcast"`
+	WriteContext   string   `json:"write_context"`
+	WriteArtifacts string   `json:"write_artifacts"`
+	AdminRoles     []string `json:"admin_roles"` // members with these roles are admins
+}
+
+// DefaultProjectPolicy applies to new projects.
+func DefaultProjectPolicy() ProjectPolicy {
+	return ProjectPolicy{
+		Join:           JoinOpen,
+		Broadcast:      AccessMembers,
+		WriteContext:   AccessMembers,
+		WriteArtifacts: AccessMembers,
+		AdminRoles:     []string{"team-lead"},
+	}
+}
+
+// ProjectPolicyPatch holds optional policy changes for SetProjectPolicy.
+// Nil fields are left unchanged.
+type ProjectPolicyPatch struct {
+	Join           *string
+	Broadcast      *string
+	WriteContext   *string
+	WriteArtifacts *string
+	AdminRoles     *[]string
+}
+
+// ProjectMember is an agent's membership in a project.
+type ProjectMember struct {
+	Admin    bool      `json:"admin,omitempty"` // granted explicitly, on top of AdminRoles
+	AddedBy  string    `json:"added_by,omitempty"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// Project is the access control record for a project name. It is created
+// by the first agent to name the project in its profile, who becomes its
+// admin, so whoever registers first owns the name; HTTP tokens scoped to
+// projects keep an agent from claiming names outside them.
+// Only members can see the project's agents, shared context and
+// artifacts.
+//
+// Roles are self-declared, so an agent cannot take on one of the policy's
+// AdminRoles by itself: it must already administer the project, and it
+// does not join an existing project through its profile with such a role.
+// An admin adding it with AddProjectMember confers the role's rights.
+type Project struct {
+	Name      string                   `json:"name"`
+	Policy    ProjectPolicy            `json:"policy"`
+	Members   map[string]ProjectMember `json:"members"` // agent_id → membership
+	CreatedBy string                   `json:"created_by"`
+	CreatedAt time.Time                `json:"created_at"`
+}
+
+// GetProject returns a project the viewer is a member of.
+func (b *Broker) GetProject(viewer, name string) (Project, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, err := b.requireProjectAccess(viewer, name, AccessMembers)
+	if err != nil {
+		return Project{}, err
+	}
+	return copyProject(p), nil
+}
+
+// ListProjects returns the projects agentID is a member of, by name.
+func (b *Broker) ListProjects(agentID string) []Project {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]Project, 0)
+	for _, p := range b.projects {
+		if _, ok := p.Members[agentID]; ok {
+			out = append(out, copyProject(p))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// SetProjectPolicy changes a project's policy. Only admins may do so.
+func (b *Broker) SetProjectPolicy(adminID, name string, patch ProjectPolicyPatch) (Project, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, err := b.requireProjectAccess(adminID, name, AccessAdmins)
+	if err != nil {
+		return Project{}, err
+	}
+	policy := p.Policy
+	if patch.Join != nil {
+		policy.Join = strings.TrimSpace(*patch.Join)
+	}
+	if patch.Broadcast != nil {
+		policy.Broadcast = strings.TrimSpace(*patch.Broadcast)
+	}
+	if patch.WriteContext != nil {
+		policy.WriteContext = strings.TrimSpace(*patch.WriteContext)
+	}
+	if patch.WriteArtifacts != nil {
+		policy.WriteArtifacts = strings.TrimSpace(*patch.WriteArtifacts)
+	}
+	if patch.AdminRoles != nil {
+		policy.AdminRoles = make([]string, 0, len(*patch.AdminRoles))
+		for _, r := range *patch.AdminRoles {
+			if r = strings.TrimSpace(r); r != "" {
+				policy.AdminRoles = append(policy.AdminRoles, r)
+			}
+		}
+	}
+	if err := validateProjectPolicy(policy); err != nil {
+		return Project{}, err
+	}
+	prev := p.Policy
+	p.Policy = policy
+	if err := b.saveProject(p); err != nil {
+		p.Policy = prev
+		return Project{}, err
+	}
+	return copyProject(p), nil
+}
+
+// AddProjectMember adds agentID to a project, or changes its explicit
+// admin grant. Only admins may do so.
+func (b *Broker) AddProjectMember(adminID, name, agentID string, admin bool) (Project, error) {
+	agentID = strings.TrimSpace(agentID)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, err := b.requireProjectAccess(adminID, name, AccessAdmins)
+	if err != nil {
+		return Project{}, err
+	}
+	if b.agents[agentID] == nil {
+		return Project{}, fmt.Errorf("agent not found: %s", agentID)
+	}
+	prev, had := p.Members[agentID]
+	m := prev
+	if !had {
+		m = ProjectMember{AddedBy: adminID, JoinedAt: time.Now().UTC()}
+	}
+	m.Admin = admin
+	p.Members[agentID] = m
+	if err := b.saveProject(p); err != nil {
+		if had {
+			p.Members[agentID] = prev
+		} else {
+			delete(p.Members, agentID)
+		}
+		return Project{}, err
+	}
+	return copyProject(p), nil
+}
+
+// RemoveProjectMember removes agentID from a project. Admins may remove
+// anyone; other members may only remove themselves.
+func (b *Broker) RemoveProjectMember(actorID, name, agentID string) (Project, error) {
+	agentID = strings.TrimSpace(agentID)
+	access := AccessAdmins
+	if agentID == strings.TrimSpace(actorID) {
+		access = AccessMembers
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, err := b.requireProjectAccess(actorID, name, access)
+	if err != nil {
+		return Project{}, err
+	}
+	prev, ok := p.Members[agentID]
+	if !ok {
+		return Project{}, fmt.Errorf("agent %s is not a member of project %s", agentID, p.Name)
+	}
+	delete(p.Members, agentID)
+	if err := b.saveProject(p); err != nil {
+		p.Members[agentID] = prev
+		return Project{}, err
+	}
+	return copyProject(p), nil
+}
+
+// requireProjectAccess returns the project if agentID has the given
+// access to it. Caller holds b.mu.
+func (b *Broker) requireProjectAccess(agentID, name, access string) (*Project, error) {
+	agentID = strings.TrimSpace(agentID)
+	if agentID == "" {
+		return nil, fmt.Errorf("agent_id is required")
+	}
+	if b.agents[agentID] == nil {
+		return nil, fmt.Errorf("agent not found: %s", agentID)
+	}
+	name = normalizeProjectName(name)
+	if name == "" {
+		return nil, fmt.Errorf("project is required")
+	}
+	p := b.projects[name]
+	if p == nil {
+		return nil, fmt.Errorf("%w: %s is not a member of project %s", ErrForbidden, agentID, name)
+	}
+	if _, ok := p.Members[agentID]; !ok {
+		return nil, fmt.Errorf("%w: %s is not a member of project %s", ErrForbidden, agentID, name)
+	}
+	if access == AccessAdmins && !b.isProjectAdmin(p, agentID) {
+		return nil, fmt.Errorf("%w: %s is not an admin of project %s", ErrForbidden, agentID, name)
+	}
+	return p, nil
+}
+
+// isProjectAdmin reports whether a member administers p, by grant or by
+// role. Caller holds b.mu.
+func (b *Broker) isProjectAdmin(p *Project, agentID string) bool {
+	m, ok := p.Members[agentID]
+	if !ok {
+		return false
+	}
+	if m.Admin {
+		return true
+	}
+	a := b.agents[agentID]
+	return a != nil && hasAdminRole(p, a.Profile.Role)
+}
+
+// isProjectMember reports whether agentID belongs to project name. Caller
+// holds b.mu.
+func (b *Broker) isProjectMember(name, agentID string) bool {
+	p := b.projects[name]
+	if p == nil {
+		return false
+	}
+	_, ok := p.Members[agentID]
+	return ok
+}
+
+// hasAdminRole reports whether role is one of p's admin roles.
+func hasAdminRole(p *Project, role string) bool {
+	for _, r := range p.Policy.AdminRoles {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkProjectChange refuses a profile change to an existing project the
+// agent would not join, so its profile never names a project it cannot
+// access. Caller holds b.mu.
+func (b *Broker) checkProjectChange(a *agentState, next AgentProfile) error {
+	if next.Project == "" || next.Project == a.Profile.Project {
+		return nil
+	}
+	p := b.projects[next.Project]
+	if p == nil {
+		return nil
+	}
+	if _, ok := p.Members[a.ID]; ok || joinsByProfile(p, next.Role) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s cannot join project %s by itself; ask a project admin to add you with add_project_member", ErrForbidden, a.ID, p.Name)
+}
+
+// joinsByProfile reports whether an agent with role joins p by naming it
+// in its profile: p must be open and role must not be an admin role.
+func joinsByProfile(p *Project, role string) bool {
+	return p.Policy.Join == JoinOpen && !hasAdminRole(p, role)
+}
+
+// checkRoleChange refuses a role change that would make agent a an admin
+// of a project it is a member of but does not administer. Caller holds
+// b.mu.
+func (b *Broker) checkRoleChange(a *agentState, role string) error {
+	if strings.EqualFold(role, a.Profile.Role) {
+		return nil
+	}
+	for _, p := range b.projects {
+		if _, ok := p.Members[a.ID]; ok && hasAdminRole(p, role) && !b.isProjectAdmin(p, a.ID) {
+			return fmt.Errorf("%w: role %s administers project %s; ask an admin to grant you admin with add_project_member", ErrForbidden, role, p.Name)
+		}
+	}
+	return nil
+}
+
+// checkProjectPolicy enforces one of p's policy access levels for a
+// member. Caller holds b.mu.
+func (b *Broker) checkProjectPolicy(p *Project, agentID, access, action string) error {
+	if access == AccessAdmins && !b.isProjectAdmin(p, agentID) {
+		return fmt.Errorf("%w: only admins of project %s may %s", ErrForbidden, p.Name, action)
+	}
+	return nil
+}
+
+// visibleTo reports whether viewer may see agent id: itself, or an agent
+// it shares a project with. Caller holds b.mu.
+func (b *Broker) visibleTo(viewer, id string) bool {
+	if viewer == id {
+		return true
+	}
+	for _, p := range b.projects {
+		_, okA := p.Members[viewer]
+		_, okB := p.Members[id]
+		if okA && okB {
+			return true
+		}
+	}
+	return false
+}
+
+// canBroadcastTo reports whether from may broadcast to agent to: they
+// share a project whose broadcast policy from satisfies. Caller holds
+// b.mu.
+func (b *Broker) canBroadcastTo(from, to string) bool {
+	for _, p := range b.projects {
+		_, okA := p.Members[from]
+		_, okB := p.Members[to]
+		if okA && okB && (p.Policy.Broadcast == AccessMembers || b.isProjectAdmin(p, from)) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinProfileProject makes an agent a member of its profile's project:
+// the first agent creates the project and administers it, later agents
+// join if the project is open and their role is not an admin role.
+// Caller holds b.mu.
+func (b *Broker) joinProfileProject(a *agentState) {
+	name := a.Profile.Project
+	if name == "" {
+		return
+	}
+	now := time.Now().UTC()
+	p := b.projects[name]
+	if p == nil {
+		p = &Project{
+			Name:      name,
+			Policy:    DefaultProjectPolicy(),
+			Members:   map[string]ProjectMember{a.ID: {Admin: true, JoinedAt: now}},
+			CreatedBy: a.ID,
+			CreatedAt: now,
+		}
+		b.projects[name] = p
+		_ = b.saveProject(p)
+		return
+	}
+	if _, ok := p.Members[a.ID]; ok || !joinsByProfile(p, a.Profile.Role) {
+		return
+	}
+	p.Members[a.ID] = ProjectMember{JoinedAt: now}
+	_ = b.saveProject(p)
+}
+
+// dropProjectMember removes a pruned agent from every project and returns
+// the names of the projects it left, for the caller to persist. Caller
+// holds b.mu.
+func (b *Broker) dropProjectMember(agentID string) []string {
+	var changed []string
+	for _, p := range b.projects {
+		if _, ok := p.Members[agentID]; ok {
+			delete(p.Members, agentID)
+			changed = append(changed, p.Name)
+		}
+	}
+	return changed
+}
+
+// projectWrite renders a project for a deferred save. Caller holds b.mu.
+func (b *Broker) projectWrite(name string) kvWrite {
+	return newKVWrite(b.projectKV, name, func() any {
+		if p := b.projects[name]; p != nil {
+			return p
+		}
+		return nil
+	})
+}
+
+func validateProjectPolicy(p ProjectPolicy) error {
+	if p.Join != JoinOpen && p.Join != JoinInvite {
+		return fmt.Errorf("invalid join policy %q: must be open or invite", p.Join)
+	}
+	for field, v := range map[string]string{"broadcast": p.Broadcast, "write_context": p.WriteContext, "write_artifacts": p.WriteArtifacts} {
+		if v != AccessMembers && v != AccessAdmins {
+			return fmt.Errorf("invalid %s policy %q: must be members or admins", field, v)
+		}
+	}
+	return nil
+}
+
+func copyProject(p *Project) Project {
+	cp := *p
+	cp.Policy.AdminRoles = append([]string(nil), p.Policy.AdminRoles...)
+	cp.Members = make(map[string]ProjectMember, len(p.Members))
+	for id, m := range p.Members {
+		cp.Members[id] = m
+	}
+	return cp
+}
+
+// saveProject persists a project. Caller holds b.mu.
+func (b *Broker) saveProject(p *Project) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshal project: %w", err)
+	}
+	if _, err := b.projectKV.Put(p.Name, data); err != nil {
+		return fmt.Errorf("persist project: %w", err)
+	}
+	return nil
+}
+
+// loadProjects restores projects from the project bucket, dropping members
+// that are no longer registered, then enrolls registered agents in their
+// profile projects so registrations from before access control keep
+// working. Caller holds b.mu.
+func (b *Broker) loadProjects() error {
+	keys, err := b.projectKV.Keys()
+	if err != nil && !errors.Is(err, nats.ErrNoKeysFound) {
+		return fmt.Errorf("list project keys: %w", err)
+	}
+	for _, key := range keys {
+		entry, err := b.projectKV.Get(key)
+		if err != nil {
+			continue
+		}
+		var p Project
+		if err := json.Unmarshal(entry.Value(), &p); err != nil || p.Name == "" {
+			continue
+		}
+		if p.Members == nil {
+			p.Members = make(map[string]ProjectMember)
+		}
+		for id := range p.Members {
+			if b.agents[id] == nil {
+				delete(p.Members, id)
+			}
+		}
+		b.projects[p.Name] = &p
+	}
+	ids := make([]string, 0, len(b.agents))
+	for id := range b.agents {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		b.joinProfileProject(b.agents[id])
+	}
+	return nil
+}
@@ -0,0 +1,348 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func projectProfile(name, project, role string) AgentProfile {
+	return AgentProfile{Name: name, Description: "test agent " + name, Project: project, Role: role, Specialization: "s"}
+}
+
+func TestProjectsIsolateAgents(t *testing.T) {
+	b := newTestBroker(t)
+	alice, _ := b.RegisterAgent(projectProfile("alice", "internal", "developer"))
+	bob, _ := b.RegisterAgent(projectProfile("bob", "internal", "developer"))
+	mallory, _ := b.RegisterAgent(projectProfile("mallory", "contractor", "developer"))
+
+	if err := b.SharedContextSet(alice, "internal", "db", "secret"); err != nil {
+		t.Fatalf("set context: %v", err)
+	}
+	if _, err := b.PublishArtifact(bob, "internal", "schema", "users", "{}"); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	if _, _, err := b.SharedContextGet(mallory, "internal", "db"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden context read, got %v", err)
+	}
+	if err := b.SharedContextSet(mallory, "internal", "db", "x"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden context write, got %v", err)
+	}
+	if _, err := b.ListArtifacts(mallory, "internal", ""); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden artifact list, got %v", err)
+	}
+	if _, err := b.GetTeamStatus(mallory, "internal"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden team status, got %v", err)
+	}
+	if _, err := b.Broadcast(mallory, "hi", "", AgentSearchFilter{Project: "internal"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden broadcast, got %v", err)
+	}
+
+	// Unfiltered views only show agents sharing a project.
+	statuses, err := b.GetTeamStatus(mallory, "")
+	if err != nil || len(statuses) != 1 || statuses[0].ID != mallory {
+		t.Fatalf("expected mallory to see only itself, got %v (%v)", statuses, err)
+	}
+	if found := b.FindAgents(AgentSearchFilter{Viewer: mallory}); len(found) != 1 {
+		t.Fatalf("expected mallory to find only itself, got %v", found)
+	}
+	msgs, err := b.Broadcast(mallory, "hi", "", AgentSearchFilter{})
+	if err != nil || len(msgs) != 0 {
+		t.Fatalf("expected an empty broadcast, got %d messages (%v)", len(msgs), err)
+	}
+	msgs, err = b.Broadcast(alice, "hi", "", AgentSearchFilter{})
+	if err != nil || len(msgs) != 1 || msgs[0].To != bob {
+		t.Fatalf("expected alice to reach only bob, got %v (%v)", msgs, err)
+	}
+}
+
+func TestProjectReadsRequireMembership(t *testing.T) {
+	b := newTestBroker(t)
+	alice, _ := b.RegisterAgent(projectProfile("alice", "internal", "developer"))
+	bob, _ := b.RegisterAgent(projectProfile("bob", "internal", "developer"))
+	mallory, _ := b.RegisterAgent(projectProfile("mallory", "contractor", "developer"))
+
+	if _, err := b.CreateTask(alice, "internal", "Rotate keys", "", nil); err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	if _, err := b.AcquireLock(alice, "internal", "secrets.env", 0, ""); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	msg, err := b.Send(alice, bob, "the key is in secrets.env", "")
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	if _, err := b.ListTasks(mallory, "internal", TaskFilter{}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden task list, got %v", err)
+	}
+	if _, err := b.ListLocks(mallory, "internal"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden lock list, got %v", err)
+	}
+	if locks, err := b.ListLocks(mallory, ""); err != nil || len(locks) != 0 {
+		t.Fatalf("expected mallory to see no locks, got %v (%v)", locks, err)
+	}
+	if locks, err := b.ListLocks(bob, ""); err != nil || len(locks) != 1 {
+		t.Fatalf("expected bob to see alice's lock, got %v (%v)", locks, err)
+	}
+	if _, ok := b.GetMessageStatus(mallory, msg.ID); ok {
+		t.Fatal("expected a third party to get no message status")
+	}
+	if _, ok := b.GetMessageStatus(bob, msg.ID); !ok {
+		t.Fatal("expected the recipient to get the message status")
+	}
+	thread, err := b.GetThread(mallory, msg.ThreadID, 0)
+	if err != nil || len(thread) != 0 {
+		t.Fatalf("expected an empty thread for a third party, got %v (%v)", thread, err)
+	}
+	thread, err = b.GetThread(bob, msg.ThreadID, 0)
+	if err != nil || len(thread) != 1 {
+		t.Fatalf("expected bob to read the thread, got %v (%v)", thread, err)
+	}
+}
+
+func TestProjectWritesRequireMembership(t *testing.T) {
+	b := newTestBroker(t)
+	alice, _ := b.RegisterAgent(projectProfile("alice", "internal", "developer"))
+	bob, _ := b.RegisterAgent(projectProfile("bob", "internal", "developer"))
+	mallory, _ := b.RegisterAgent(projectProfile("mallory", "contractor", "developer"))
+
+	task, err := b.CreateTask(alice, "internal", "Rotate keys", "", nil)
+	if err != nil {
+		t.Fatalf("create task: %v", err)
+	}
+	if _, err := b.AcquireLock(alice, "internal", "secrets.env", 0, ""); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+
+	if _, err := b.CreateTask(mallory, "internal", "Exfiltrate", "", nil); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden task create, got %v", err)
+	}
+	if _, err := b.ClaimTask(mallory, task.ID, 0); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden claim, got %v", err)
+	}
+	title := "Mine now"
+	if _, err := b.UpdateTask(mallory, task.ID, TaskPatch{Title: &title}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden update, got %v", err)
+	}
+	if _, err := b.CompleteTask(mallory, task.ID, "done"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden complete, got %v", err)
+	}
+	if _, err := b.AcquireLock(mallory, "internal", "src", 0, ""); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden lock, got %v", err)
+	}
+	if err := b.ReleaseLock(mallory, "internal", "secrets.env"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden release, got %v", err)
+	}
+	if _, ok := b.CheckLock(mallory, "internal", "secrets.env"); ok {
+		t.Fatal("expected a non-member to see no lock")
+	}
+	if _, ok := b.CheckLock(bob, "internal", "secrets.env"); !ok {
+		t.Fatal("expected a member to see alice's lock")
+	}
+	if _, err := b.Send(mallory, "role:developer@internal", "hi", ""); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected forbidden role send, got %v", err)
+	}
+	if _, err := b.Send(alice, "role:developer@internal", "hi", ""); err != nil {
+		t.Fatalf("expected a member to send to a role: %v", err)
+	}
+}
+
+func TestInviteOnlyProject(t *testing.T) {
+	b := newTestBroker(t)
+	owner, _ := b.RegisterAgent(projectProfile("owner", "secret", "developer"))
+	invite := JoinInvite
+	if _, err := b.SetProjectPolicy(owner, "secret", ProjectPolicyPatch{Join: &invite}); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+
+	guest, err := b.RegisterAgent(projectProfile("guest", "secret", "developer"))
+	if err != nil {
+		t.Fatalf("register guest: %v", err)
+	}
+	if _, err := b.GetProject(guest, "secret"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected guest to be kept out, got %v", err)
+	}
+	if _, err := b.AddProjectMember(guest, "secret", guest, false); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected non-member to be unable to add itself, got %v", err)
+	}
+
+	p, err := b.AddProjectMember(owner, "secret", guest, false)
+	if err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if m := p.Members[guest]; m.AddedBy != owner || m.Admin {
+		t.Fatalf("unexpected membership %+v", m)
+	}
+	if got := b.ListProjects(guest); len(got) != 1 || got[0].Name != "secret" {
+		t.Fatalf("expected guest to list secret, got %v", got)
+	}
+	if _, err := b.SetProjectPolicy(guest, "secret", ProjectPolicyPatch{Join: &invite}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected member to be unable to change policy, got %v", err)
+	}
+
+	// Members may leave on their own; removing others takes an admin.
+	if _, err := b.RemoveProjectMember(guest, "secret", owner); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected member to be unable to remove the owner, got %v", err)
+	}
+	if _, err := b.RemoveProjectMember(guest, "secret", guest); err != nil {
+		t.Fatalf("leave: %v", err)
+	}
+	if _, err := b.GetProject(guest, "secret"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected guest to be out after leaving, got %v", err)
+	}
+}
+
+func TestAdminRolesGateWrites(t *testing.T) {
+	b := newTestBroker(t)
+	first, _ := b.RegisterAgent(projectProfile("first", "app", "developer"))
+	dev, _ := b.RegisterAgent(projectProfile("dev", "app", "developer"))
+	lead, _ := b.RegisterAgent(projectProfile("lead", "app", "team-lead"))
+	if _, err := b.GetProject(lead, "app"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected a team-lead to need an admin to add it, got %v", err)
+	}
+	if _, err := b.AddProjectMember(first, "app", lead, false); err != nil {
+		t.Fatalf("add lead: %v", err)
+	}
+
+	admins := AccessAdmins
+	if _, err := b.SetProjectPolicy(dev, "app", ProjectPolicyPatch{WriteContext: &admins}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected developer to be refused, got %v", err)
+	}
+	if _, err := b.SetProjectPolicy(lead, "app", ProjectPolicyPatch{WriteContext: &admins, Broadcast: &admins}); err != nil {
+		t.Fatalf("team-lead set policy: %v", err)
+	}
+	if err := b.SharedContextSet(dev, "app", "k", "v"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected member write to be refused, got %v", err)
+	}
+	if err := b.SharedContextSet(first, "app", "k", "v"); err != nil {
+		t.Fatalf("creator write: %v", err)
+	}
+	if _, err := b.Broadcast(dev, "hi", "", AgentSearchFilter{Project: "app"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected member broadcast to be refused, got %v", err)
+	}
+	if v, _, err := b.SharedContextGet(dev, "app", "k"); err != nil || v != "v" {
+		t.Fatalf("expected members to still read, got %q (%v)", v, err)
+	}
+
+	bad := "everyone"
+	if _, err := b.SetProjectPolicy(lead, "app", ProjectPolicyPatch{WriteArtifacts: &bad}); err == nil {
+		t.Fatal("expected invalid policy to be rejected")
+	}
+}
+
+func TestMembersCannotPromoteThemselves(t *testing.T) {
+	b := newTestBroker(t)
+	creator, _ := b.RegisterAgent(projectProfile("creator", "app", "developer"))
+	contractor, _ := b.RegisterAgent(projectProfile("contractor", "app", "developer"))
+
+	if _, err := b.UpdateAgentProfile(contractor, AgentProfile{Role: "team-lead"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected self-promotion to be refused, got %v", err)
+	}
+	if p, _ := b.GetAgentProfile(contractor); p.Role != "developer" {
+		t.Fatalf("expected the role to stay developer, got %q", p.Role)
+	}
+	if _, err := b.RemoveProjectMember(contractor, "app", creator); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected the contractor to be unable to remove the creator, got %v", err)
+	}
+	invite := JoinInvite
+	if _, err := b.SetProjectPolicy(contractor, "app", ProjectPolicyPatch{Join: &invite}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected the contractor to be unable to change policy, got %v", err)
+	}
+
+	// An admin's grant lets the member take the role.
+	if _, err := b.AddProjectMember(creator, "app", contractor, true); err != nil {
+		t.Fatalf("grant admin: %v", err)
+	}
+	if _, err := b.UpdateAgentProfile(contractor, AgentProfile{Role: "team-lead"}); err != nil {
+		t.Fatalf("expected an admin to take the role, got %v", err)
+	}
+}
+
+func TestProfileCannotSwitchIntoClosedProject(t *testing.T) {
+	b := newTestBroker(t)
+	owner, _ := b.RegisterAgent(projectProfile("owner", "secret", "developer"))
+	invite := JoinInvite
+	if _, err := b.SetProjectPolicy(owner, "secret", ProjectPolicyPatch{Join: &invite}); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+	b.RegisterAgent(projectProfile("other", "open", "developer"))
+	guest, _ := b.RegisterAgent(projectProfile("guest", "public", "developer"))
+
+	if _, err := b.UpdateAgentProfile(guest, AgentProfile{Project: "secret"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected a switch into an invite-only project to be refused, got %v", err)
+	}
+	if _, err := b.UpdateAgentProfile(guest, AgentProfile{Project: "open", Role: "team-lead"}); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected a switch into a project as its admin role to be refused, got %v", err)
+	}
+	if p, _ := b.GetAgentProfile(guest); p.Project != "public" || p.Role != "developer" {
+		t.Fatalf("expected the profile to be unchanged, got %s/%s", p.Project, p.Role)
+	}
+
+	// Open projects still take members, and invited agents may switch.
+	if _, err := b.UpdateAgentProfile(guest, AgentProfile{Project: "open"}); err != nil {
+		t.Fatalf("switch into open project: %v", err)
+	}
+	if _, err := b.GetProject(guest, "open"); err != nil {
+		t.Fatalf("expected guest to join the open project: %v", err)
+	}
+	if _, err := b.AddProjectMember(owner, "secret", guest, false); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	if _, err := b.UpdateAgentProfile(guest, AgentProfile{Project: "secret"}); err != nil {
+		t.Fatalf("expected an invited agent to switch: %v", err)
+	}
+}
+
+func TestProjectsSurviveRestart(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+	owner, _ := b1.RegisterAgent(projectProfile("owner", "secret", "developer"))
+	guest, _ := b1.RegisterAgent(projectProfile("guest", "secret", "developer"))
+	invite := JoinInvite
+	if _, err := b1.SetProjectPolicy(owner, "secret", ProjectPolicyPatch{Join: &invite}); err != nil {
+		t.Fatalf("set policy: %v", err)
+	}
+	if _, err := b1.RemoveProjectMember(owner, "secret", guest); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	b1.Close()
+
+	b2 := newTestBrokerOn(t, s)
+	p, err := b2.GetProject(owner, "secret")
+	if err != nil {
+		t.Fatalf("get project after restart: %v", err)
+	}
+	if p.Policy.Join != JoinInvite || p.CreatedBy != owner {
+		t.Fatalf("unexpected project after restart: %+v", p)
+	}
+	if _, ok := p.Members[guest]; ok {
+		t.Fatal("expected removed member to stay out after restart")
+	}
+}
+
+func TestPruneLimitedToAdministeredProjects(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(projectProfile("lead", "internal", "team-lead"))
+	dev, _ := b.RegisterAgent(projectProfile("dev", "internal", "developer"))
+	outsider, _ := b.RegisterAgent(projectProfile("outsider", "contractor", "developer"))
+	other, _ := b.RegisterAgent(projectProfile("other", "contractor", "developer"))
+
+	time.Sleep(time.Millisecond)
+	if _, err := b.PruneProjectStaleAgents(dev, time.Nanosecond); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected a non-admin to be refused, got %v", err)
+	}
+	n, err := b.PruneProjectStaleAgents(lead, time.Nanosecond)
+	if err != nil || n != 1 {
+		t.Fatalf("expected only dev to be pruned, got %d (%v)", n, err)
+	}
+	for _, id := range []string{lead, outsider, other} {
+		if _, ok := b.GetAgentProfile(id); !ok {
+			t.Fatalf("expected %s to survive the prune", id)
+		}
+	}
+	if _, ok := b.GetAgentProfile(dev); ok {
+		t.Fatal("expected dev to be pruned")
+	}
+}
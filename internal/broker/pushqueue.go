@@ -0,0 +1,264 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const pushQueueBucket = "RELAY_PUSH_QUEUE"
+
+// Dead letters are kept as long as their message stays in the stream, and
+// at most maxDeadLetters per recipient; the oldest are dropped first.
+const (
+	deadLetterRetention = messageRetention
+	maxDeadLetters      = 100
+)
+
+// PushJob is a pending push of one message to one harness session. Jobs
+// stay queued across broker restarts until a push succeeds or the retry
+// policy gives up, at which point they are kept as dead letters.
+type PushJob struct {
+	ID          string     `json:"id"`
+	Harness     string     `json:"harness"`
+	SessionID   string     `json:"session_id"`
+	Message     Message    `json:"message"`
+	Attempts    int        `json:"attempts"`
+	LastError   string     `json:"last_error,omitempty"`
+	EnqueuedAt  time.Time  `json:"enqueued_at"`
+	NextAttempt time.Time  `json:"next_attempt"`
+	DeadAt      *time.Time `json:"dead_at,omitempty"`
+
+	claimed bool // handed to a worker; not persisted so restarts retry it
+}
+
+// EnqueuePush queues m for push delivery to a harness session and wakes
+// the push worker.
+func (b *Broker) EnqueuePush(m Message, harness, sessionID string) (PushJob, error) {
+	harness = strings.TrimSpace(harness)
+	if harness == "" || strings.TrimSpace(sessionID) == "" {
+		return PushJob{}, fmt.Errorf("harness and session_id are required")
+	}
+	id, err := randomID("push")
+	if err != nil {
+		return PushJob{}, err
+	}
+	now := time.Now().UTC()
+	job := &PushJob{
+		ID:          id,
+		Harness:     harness,
+		SessionID:   sessionID,
+		Message:     m,
+		EnqueuedAt:  now,
+		NextAttempt: now,
+	}
+
+	b.mu.Lock()
+	if err := b.savePushJob(job); err != nil {
+		b.mu.Unlock()
+		return PushJob{}, err
+	}
+	b.pushJobs[id] = job
+	b.mu.Unlock()
+
+	select {
+	case b.pushWake <- struct{}{}:
+	default:
+	}
+	return *job, nil
+}
+
+// PushWake is signalled whenever a push is queued, so a worker does not
+// have to wait for its next poll.
+func (b *Broker) PushWake() <-chan struct{} {
+	return b.pushWake
+}
+
+// ClaimDuePushes hands out up to max live jobs whose next attempt is due,
+// oldest first. A claimed job is not handed out again until it is
+// completed or rescheduled.
+func (b *Broker) ClaimDuePushes(now time.Time, max int) []PushJob {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	due := make([]*PushJob, 0)
+	for _, job := range b.pushJobs {
+		if job.claimed || job.DeadAt != nil || job.NextAttempt.After(now) {
+			continue
+		}
+		due = append(due, job)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+	if max > 0 && len(due) > max {
+		due = due[:max]
+	}
+	out := make([]PushJob, 0, len(due))
+	for _, job := range due {
+		job.claimed = true
+		out = append(out, *job)
+	}
+	return out
+}
+
+// CompletePush records a successful push: the job is dropped and the
+// message is marked as pushed.
+func (b *Broker) CompletePush(jobID string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.pushJobs[jobID]
+	if !ok {
+		return fmt.Errorf("push job not found: %s", jobID)
+	}
+	if err := b.pushQueueKV.Delete(jobID); err != nil {
+		// Leave the job to be handed out again rather than stuck claimed.
+		job.claimed = false
+		return fmt.Errorf("delete push job: %w", err)
+	}
+	delete(b.pushJobs, jobID)
+	if rec, ok := b.deliveryLog[job.Message.ID]; ok {
+		rec.PushAttempts++
+		rec.PushError = ""
+		rec.PushDelivered = true
+		rec.advance(StatePushed, job.Harness, time.Now().UTC())
+		_ = b.saveDelivery(rec)
+	}
+	return nil
+}
+
+// RetryPush records a failed attempt and schedules the next one at next.
+func (b *Broker) RetryPush(jobID, errText string, next time.Time) error {
+	return b.failPush(jobID, errText, next, false)
+}
+
+// DeadLetterPush records a failed final attempt. The job stays in the
+// queue as a dead letter and the message remains available to fetch.
+func (b *Broker) DeadLetterPush(jobID, errText string) error {
+	return b.failPush(jobID, errText, time.Time{}, true)
+}
+
+func (b *Broker) failPush(jobID, errText string, next time.Time, dead bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.pushJobs[jobID]
+	if !ok {
+		return fmt.Errorf("push job not found: %s", jobID)
+	}
+	now := time.Now().UTC()
+	job.claimed = false
+	job.Attempts++
+	job.LastError = errText
+	if dead {
+		job.DeadAt = &now
+	} else {
+		job.NextAttempt = next.UTC()
+	}
+	if rec, ok := b.deliveryLog[job.Message.ID]; ok {
+		rec.PushAttempts++
+		rec.PushError = errText
+		if dead {
+			note := fmt.Sprintf("%s push dead-lettered after %d attempts: %s", job.Harness, job.Attempts, errText)
+			rec.advance(StateQueued, note, now)
+		}
+		_ = b.saveDelivery(rec)
+	}
+	if err := b.savePushJob(job); err != nil {
+		return err
+	}
+	if dead {
+		b.trimDeadLetters(now)
+	}
+	return nil
+}
+
+// DeadLetters returns pushes that exhausted their retries, newest first.
+// An empty agentID lists every recipient.
+func (b *Broker) DeadLetters(agentID string) []PushJob {
+	agentID = strings.TrimSpace(agentID)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]PushJob, 0)
+	for _, job := range b.pushJobs {
+		if job.DeadAt == nil || (agentID != "" && job.Message.To != agentID) {
+			continue
+		}
+		out = append(out, *job)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DeadAt.After(*out[j].DeadAt) })
+	return out
+}
+
+// trimDeadLetters drops dead letters older than deadLetterRetention and
+// the oldest beyond maxDeadLetters per recipient. A job whose delete fails
+// is kept for the next trim. Caller holds b.mu.
+func (b *Broker) trimDeadLetters(now time.Time) {
+	byAgent := make(map[string][]*PushJob)
+	for _, job := range b.pushJobs {
+		if job.DeadAt == nil {
+			continue
+		}
+		if now.Sub(*job.DeadAt) > deadLetterRetention {
+			b.dropPushJob(job.ID)
+			continue
+		}
+		byAgent[job.Message.To] = append(byAgent[job.Message.To], job)
+	}
+	for _, jobs := range byAgent {
+		if len(jobs) <= maxDeadLetters {
+			continue
+		}
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].DeadAt.After(*jobs[j].DeadAt) })
+		for _, job := range jobs[maxDeadLetters:] {
+			b.dropPushJob(job.ID)
+		}
+	}
+}
+
+// dropPushJob deletes a push job. Caller holds b.mu.
+func (b *Broker) dropPushJob(jobID string) {
+	if err := b.pushQueueKV.Delete(jobID); err != nil {
+		slog.Warn("delete dead letter", "job_id", jobID, "error", err)
+		return
+	}
+	delete(b.pushJobs, jobID)
+}
+
+// savePushJob persists a push job. Caller holds b.mu.
+func (b *Broker) savePushJob(job *PushJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("marshal push job: %w", err)
+	}
+	if _, err := b.pushQueueKV.Put(job.ID, data); err != nil {
+		return fmt.Errorf("persist push job: %w", err)
+	}
+	return nil
+}
+
+// loadPushJobs restores the push queue and dead letters. Caller holds b.mu.
+func (b *Broker) loadPushJobs() error {
+	keys, err := b.pushQueueKV.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("list push queue keys: %w", err)
+	}
+	for _, key := range keys {
+		entry, err := b.pushQueueKV.Get(key)
+		if err != nil {
+			continue
+		}
+		var job PushJob
+		if err := json.Unmarshal(entry.Value(), &job); err != nil || job.ID == "" {
+			continue
+		}
+		b.pushJobs[job.ID] = &job
+	}
+	b.trimDeadLetters(time.Now().UTC())
+	return nil
+}
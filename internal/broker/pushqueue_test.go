@@ -0,0 +1,128 @@
+package broker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPushQueueRetryAndComplete(t *testing.T) {
+	b := newTestBroker(t)
+	from, _ := b.RegisterAgent(testProfile("from"))
+	to, _ := b.RegisterAgent(testProfile("to"))
+	msg, err := b.Send(from, to, "hello", "")
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	job, err := b.EnqueuePush(msg, "opencode", "sess-1")
+	if err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	select {
+	case <-b.PushWake():
+	default:
+		t.Fatal("expected enqueue to wake the worker")
+	}
+
+	now := time.Now()
+	due := b.ClaimDuePushes(now, 10)
+	if len(due) != 1 || due[0].ID != job.ID || due[0].Message.Body != "hello" {
+		t.Fatalf("unexpected due jobs: %+v", due)
+	}
+	if again := b.ClaimDuePushes(now, 10); len(again) != 0 {
+		t.Fatalf("expected claimed job to be skipped, got %+v", again)
+	}
+
+	if err := b.RetryPush(job.ID, "500 from opencode", now.Add(time.Hour)); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if due := b.ClaimDuePushes(now, 10); len(due) != 0 {
+		t.Fatalf("expected rescheduled job to wait, got %+v", due)
+	}
+	due = b.ClaimDuePushes(now.Add(2*time.Hour), 10)
+	if len(due) != 1 || due[0].Attempts != 1 || due[0].LastError != "500 from opencode" {
+		t.Fatalf("unexpected retried job: %+v", due)
+	}
+	rec, _ := b.GetMessageStatus(from, msg.ID)
+	if rec.PushAttempts != 1 || rec.PushError == "" || rec.PushDelivered {
+		t.Fatalf("unexpected record after failure: %+v", rec)
+	}
+
+	if err := b.CompletePush(job.ID); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	rec, _ = b.GetMessageStatus(from, msg.ID)
+	if rec.PushAttempts != 2 || rec.PushError != "" || !rec.PushDelivered || rec.State != StatePushed {
+		t.Fatalf("unexpected record after success: %+v", rec)
+	}
+	if due := b.ClaimDuePushes(now.Add(3*time.Hour), 10); len(due) != 0 {
+		t.Fatalf("expected completed job to be gone, got %+v", due)
+	}
+}
+
+func TestPushQueueDeadLettersSurviveRestart(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+	from, _ := b1.RegisterAgent(testProfile("from"))
+	to, _ := b1.RegisterAgent(testProfile("to"))
+	msg, _ := b1.Send(from, to, "hello", "")
+
+	dead, _ := b1.EnqueuePush(msg, "opencode", "sess-1")
+	pending, _ := b1.EnqueuePush(msg, "claude-code", "sess-2")
+	b1.ClaimDuePushes(time.Now(), 10)
+	if err := b1.DeadLetterPush(dead.ID, "connection refused"); err != nil {
+		t.Fatalf("dead letter: %v", err)
+	}
+	rec, _ := b1.GetMessageStatus(from, msg.ID)
+	if rec.State != StateQueued || rec.Timeline[len(rec.Timeline)-1].Note == "" {
+		t.Fatalf("expected dead letter note in timeline, got %+v", rec)
+	}
+	b1.Close()
+
+	b2 := newTestBrokerOn(t, s)
+	letters := b2.DeadLetters(to)
+	if len(letters) != 1 || letters[0].ID != dead.ID || letters[0].Attempts != 1 || letters[0].DeadAt == nil {
+		t.Fatalf("unexpected dead letters after restart: %+v", letters)
+	}
+	if other := b2.DeadLetters(from); len(other) != 0 {
+		t.Fatalf("expected dead letters filtered by recipient, got %+v", other)
+	}
+	due := b2.ClaimDuePushes(time.Now(), 10)
+	if len(due) != 1 || due[0].ID != pending.ID {
+		t.Fatalf("expected in-flight job to be retried after restart, got %+v", due)
+	}
+}
+
+func TestDeadLettersAreTrimmed(t *testing.T) {
+	b := newTestBroker(t)
+	from, _ := b.RegisterAgent(testProfile("from"))
+	to, _ := b.RegisterAgent(testProfile("to"))
+	msg, _ := b.Send(from, to, "hello", "")
+
+	old, _ := b.EnqueuePush(msg, "opencode", "sess-1")
+	b.ClaimDuePushes(time.Now(), 10)
+	if err := b.DeadLetterPush(old.ID, "connection refused"); err != nil {
+		t.Fatalf("dead letter: %v", err)
+	}
+	b.mu.Lock()
+	expired := time.Now().Add(-deadLetterRetention - time.Hour)
+	b.pushJobs[old.ID].DeadAt = &expired
+	b.mu.Unlock()
+
+	for i := 0; i < maxDeadLetters+1; i++ {
+		job, _ := b.EnqueuePush(msg, "opencode", "sess-1")
+		b.ClaimDuePushes(time.Now(), 10)
+		if err := b.DeadLetterPush(job.ID, "connection refused"); err != nil {
+			t.Fatalf("dead letter: %v", err)
+		}
+	}
+	letters := b.DeadLetters(to)
+	if len(letters) != maxDeadLetters {
+		t.Fatalf("expected %d dead letters, got %d", maxDeadLetters, len(letters))
+	}
+	for _, l := range letters {
+		if l.ID == old.ID {
+			t.Fatal("expected the expired dead letter to be purged")
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// roleActiveWithin is how recently an agent must have been seen to take
+// role-addressed messages. It matches the default prune window.
+const roleActiveWithin = 30 * time.Minute
+
+// Routing strategies for role-addressed messages.
+const (
+	RouteRoundRobin  = "round_robin"
+	RouteLeastUnread = "least_unread"
+	RouteMostRecent  = "most_recent"
+)
+
+// RoleAddress is a parsed "role:<role>@<project>" recipient.
+type RoleAddress struct {
+	Role    string
+	Project string
+}
+
+// String returns the canonical "role:<role>@<project>" form.
+func (r RoleAddress) String() string {
+	return "role:" + r.Role + "@" + r.Project
+}
+
+func (r RoleAddress) subject(prefix string) string {
+	return prefix + ".role." + subjectToken(r.Project) + "." + subjectToken(r.Role)
+}
+
+// IsRoleAddress reports whether to uses the role:<role>@<project> form.
+func IsRoleAddress(to string) bool {
+	return strings.HasPrefix(strings.TrimSpace(to), "role:")
+}
+
+// ParseRoleAddress parses "role:<role>@<project>". Role and project are
+// normalized like project names, so "role:Backend Engineer@MyApp" and
+// "role:backend-engineer@my-app" are the same mailbox.
+func ParseRoleAddress(to string) (RoleAddress, error) {
+	rest, ok := strings.CutPrefix(strings.TrimSpace(to), "role:")
+	if !ok {
+		return RoleAddress{}, fmt.Errorf("not a role address: %s", to)
+	}
+	role, project, ok := strings.Cut(rest, "@")
+	addr := RoleAddress{Role: normalizeProjectName(role), Project: normalizeProjectName(project)}
+	if !ok || addr.Role == "" || addr.Project == "" {
+		return RoleAddress{}, fmt.Errorf("invalid role address %q: expected role:<role>@<project>", to)
+	}
+	return addr, nil
+}
+
+// NormalizeRouteStrategy defaults an empty strategy to round_robin and
+// rejects unknown values.
+func NormalizeRouteStrategy(strategy string) (string, error) {
+	strategy = strings.ToLower(strings.TrimSpace(strategy))
+	switch strategy {
+	case "":
+		return RouteRoundRobin, nil
+	case RouteRoundRobin, RouteLeastUnread, RouteMostRecent:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("invalid strategy %q: must be round_robin, least_unread, or most_recent", strategy)
+}
+
+// subjectToken makes s safe for use as a single NATS subject token.
+func subjectToken(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, normalizeProjectName(s))
+}
+
+// servesRole reports whether a can take messages for addr right now.
+func (a *agentState) servesRole(addr RoleAddress) bool {
+	return a.Profile.Project == addr.Project &&
+		normalizeProjectName(a.Profile.Role) == addr.Role &&
+		a.Profile.Status != "done"
+}
+
+// routeRole picks one live member of addr's project with the role,
+// excluding the sender. It returns nil when nobody with the role is
+// active.
+func (b *Broker) routeRole(from string, addr RoleAddress, strategy string) *agentState {
+	b.mu.Lock()
+	cutoff := time.Now().Add(-roleActiveWithin)
+	candidates := make([]*agentState, 0)
+	for id, a := range b.agents {
+		if id != from && a.servesRole(addr) && b.isProjectMember(addr.Project, id) && a.LastSeen.After(cutoff) {
+			candidates = append(candidates, a)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].ID < candidates[j].ID })
+	if len(candidates) == 0 {
+		b.mu.Unlock()
+		return nil
+	}
+
+	switch strategy {
+	case RouteMostRecent:
+		best := candidates[0]
+		for _, a := range candidates[1:] {
+			if a.LastSeen.After(best.LastSeen) {
+				best = a
+			}
+		}
+		b.mu.Unlock()
+		return best
+	case RouteLeastUnread:
+		b.mu.Unlock()
+		best, bestUnread := candidates[0], -1
+		for _, a := range candidates {
+			n := b.UnreadCount(a.ID)
+			if bestUnread < 0 || n < bestUnread {
+				best, bestUnread = a, n
+			}
+		}
+		return best
+	default:
+		key := addr.String()
+		pick := candidates[b.roleCursor[key]%len(candidates)]
+		b.roleCursor[key]++
+		b.mu.Unlock()
+		return pick
+	}
+}
+
+// queueForRole stores a message on the role's durable queue subject until
+// an agent with that role registers.
+func (b *Broker) queueForRole(id, from string, addr RoleAddress, body string, opts SendOptions) (Message, error) {
+	return b.publish(id, from, addr.String(), addr.subject(b.subjectPrefix), body, opts)
+}
+
+// drainRoleQueue moves every message waiting on the agent's role queue
+// into its inbox, keeping message IDs and delivery records. Drains are
+// serialized so two agents registering at once cannot both take a message.
+func (b *Broker) drainRoleQueue(agentID string) (int, error) {
+	b.mu.Lock()
+	agent := b.agents[agentID]
+	var addr RoleAddress
+	var inbox string
+	if agent != nil {
+		addr = RoleAddress{Role: normalizeProjectName(agent.Profile.Role), Project: agent.Profile.Project}
+		inbox = agent.Subject
+	}
+	eligible := agent != nil && addr.Role != "" && addr.Project != "" && agent.servesRole(addr) && b.isProjectMember(addr.Project, agentID)
+	b.mu.Unlock()
+	if !eligible {
+		return 0, nil
+	}
+
+	b.roleDrainMu.Lock()
+	defer b.roleDrainMu.Unlock()
+
+	subject := addr.subject(b.subjectPrefix)
+	info, err := b.js.StreamInfo(b.streamName, &nats.StreamInfoRequest{SubjectsFilter: subject})
+	if err != nil {
+		return 0, fmt.Errorf("role queue info: %w", err)
+	}
+	waiting := int(info.State.Subjects[subject])
+	if waiting == 0 {
+		return 0, nil
+	}
+
+	sub, err := b.js.PullSubscribe(subject, "", nats.BindStream(b.streamName), nats.DeliverAll(), nats.AckExplicit())
+	if err != nil {
+		return 0, fmt.Errorf("subscribe role queue: %w", err)
+	}
+	defer func() { _ = sub.Unsubscribe() }()
+
+	batch, err := sub.Fetch(waiting, nats.MaxWait(fetchWait))
+	if err != nil && len(batch) == 0 {
+		return 0, fmt.Errorf("fetch role queue: %w", err)
+	}
+
+	moved := 0
+	for _, raw := range batch {
+		meta, err := raw.Metadata()
+		if err != nil {
+			continue
+		}
+		var msg Message
+		if err := json.Unmarshal(raw.Data, &msg); err != nil {
+			_ = b.js.DeleteMsg(b.streamName, meta.Sequence.Stream)
+			continue
+		}
+		msg.To = agentID
+		data, err := json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		if _, err := b.js.Publish(inbox, data); err != nil {
+			return moved, fmt.Errorf("jetstream publish: %w", err)
+		}
+		// The inbox copy replaces the queued one so history shows it once.
+		_ = b.js.DeleteMsg(b.streamName, meta.Sequence.Stream)
+		_ = raw.Ack()
+
+		b.mu.Lock()
+		now := time.Now().UTC()
+		if rec, ok := b.deliveryLog[msg.ID]; ok {
+			rec.To = agentID
+			rec.advance(StateQueued, "routed to "+agentID, now)
+			_ = b.saveDelivery(rec)
+		}
+		b.threadIndex[msg.ID] = threadRef{threadID: msg.ThreadID, at: now}
+		b.mu.Unlock()
+		moved++
+	}
+	return moved, nil
+}
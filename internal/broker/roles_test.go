@@ -0,0 +1,185 @@
+package broker
+
+import (
+	"testing"
+	"time"
+)
+
+func roleProfile(name, role string) AgentProfile {
+	p := testProfile(name)
+	p.Role = role
+	return p
+}
+
+func TestParseRoleAddress(t *testing.T) {
+	addr, err := ParseRoleAddress("role:Backend Engineer@MyApp")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if addr.String() != "role:backend-engineer@my-app" {
+		t.Fatalf("unexpected canonical address: %s", addr)
+	}
+	if addr.subject(DefaultSubjectPrefix) != "relay.role.my-app.backend-engineer" {
+		t.Fatalf("unexpected subject: %s", addr.subject(DefaultSubjectPrefix))
+	}
+	for _, bad := range []string{"role:reviewer", "role:@my-app", "role:reviewer@", "reviewer@my-app"} {
+		if _, err := ParseRoleAddress(bad); err == nil {
+			t.Fatalf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestSendToRoleRoundRobin(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(roleProfile("lead", "team-lead"))
+	r1, _ := b.RegisterAgent(roleProfile("r1", "reviewer"))
+	r2, _ := b.RegisterAgent(roleProfile("r2", "reviewer"))
+
+	got := map[string]int{}
+	for i := 0; i < 4; i++ {
+		m, err := b.Send(lead, "role:reviewer@relay-mesh", "review please", "")
+		if err != nil {
+			t.Fatalf("send: %v", err)
+		}
+		got[m.To]++
+	}
+	if got[r1] != 2 || got[r2] != 2 {
+		t.Fatalf("expected round-robin across reviewers, got %v", got)
+	}
+
+	if _, err := b.SendWithOptions(lead, "role:reviewer@relay-mesh", "x", SendOptions{Strategy: "random"}); err == nil {
+		t.Fatal("expected unknown strategy to be rejected")
+	}
+}
+
+func TestSendToRoleStrategies(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(roleProfile("lead", "team-lead"))
+	r1, _ := b.RegisterAgent(roleProfile("r1", "reviewer"))
+	r2, _ := b.RegisterAgent(roleProfile("r2", "reviewer"))
+
+	// r1 has a backlog, so least_unread picks r2.
+	b.Send(lead, r1, "backlog", "")
+	waitForQueuedMessages(t, b, r1, 1)
+	m, err := b.SendWithOptions(lead, "role:reviewer@relay-mesh", "x", SendOptions{Strategy: RouteLeastUnread})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if m.To != r2 {
+		t.Fatalf("expected least_unread to pick %s, got %s", r2, m.To)
+	}
+
+	b.mu.Lock()
+	b.agents[r2].LastSeen = time.Now().Add(-time.Minute)
+	b.agents[r1].LastSeen = time.Now()
+	b.mu.Unlock()
+	m, err = b.SendWithOptions(lead, "role:reviewer@relay-mesh", "x", SendOptions{Strategy: RouteMostRecent})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if m.To != r1 {
+		t.Fatalf("expected most_recent to pick %s, got %s", r1, m.To)
+	}
+}
+
+func TestRoleQueueWaitsForRegistration(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(roleProfile("lead", "team-lead"))
+
+	m, err := b.Send(lead, "role:reviewer@relay-mesh", "first review", "urgent")
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if m.To != "role:reviewer@relay-mesh" {
+		t.Fatalf("expected message to be queued for the role, got To=%s", m.To)
+	}
+	b.Send(lead, "role:reviewer@relay-mesh", "second review", "")
+
+	// A done reviewer does not count as live.
+	done := roleProfile("old", "reviewer")
+	done.Status = "done"
+	oldID, _ := b.RegisterAgent(done)
+	if n := b.UnreadCount(oldID); n != 0 {
+		t.Fatalf("expected done agent to get nothing, got %d", n)
+	}
+
+	reviewer, err := b.RegisterAgent(roleProfile("new", "reviewer"))
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	waitForQueuedMessages(t, b, reviewer, 2)
+	msgs, err := b.Fetch(reviewer, 10)
+	if err != nil {
+		t.Fatalf("fetch: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != m.ID || msgs[0].To != reviewer {
+		t.Fatalf("expected queued messages with original IDs, got %+v", msgs)
+	}
+
+	rec, _ := b.GetMessageStatus(lead, m.ID)
+	if rec.To != reviewer || rec.State != StateFetched {
+		t.Fatalf("expected delivery record to follow the message, got %+v", rec)
+	}
+
+	// The queue is empty now; a later reviewer gets nothing.
+	later, _ := b.RegisterAgent(roleProfile("later", "reviewer"))
+	if n := b.UnreadCount(later); n != 0 {
+		t.Fatalf("expected drained queue, got %d for later reviewer", n)
+	}
+	history, _ := b.FetchHistory(reviewer, 10)
+	if len(history) != 2 {
+		t.Fatalf("expected routed messages in history once, got %d", len(history))
+	}
+}
+
+func TestRoleQueueDrainsOnProfileUpdate(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(roleProfile("lead", "team-lead"))
+	dev, _ := b.RegisterAgent(roleProfile("dev", "developer"))
+
+	b.Send(lead, "role:reviewer@relay-mesh", "review", "")
+	if _, err := b.UpdateAgentProfile(dev, AgentProfile{Role: "reviewer"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	waitForQueuedMessages(t, b, dev, 1)
+}
+
+func TestRoleQueueDrainsOnSessionReRegistration(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(roleProfile("lead", "team-lead"))
+	dev, token, _, err := b.RegisterOrUpdateBySession("sess-dev", roleProfile("dev", "developer"), "", "")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	b.Send(lead, "role:reviewer@relay-mesh", "review", "")
+	again, _, created, err := b.RegisterOrUpdateBySession("sess-dev", roleProfile("dev", "reviewer"), token, "")
+	if err != nil || created || again != dev {
+		t.Fatalf("expected the session's agent to be updated, got %s (created=%v, %v)", again, created, err)
+	}
+	waitForQueuedMessages(t, b, dev, 1)
+}
+
+func TestRoleQueueDrainsAfterRestart(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+	lead, _ := b1.RegisterAgent(roleProfile("lead", "team-lead"))
+	idle := roleProfile("reviewer", "reviewer")
+	idle.Status = "done"
+	reviewer, _ := b1.RegisterAgent(idle)
+	b1.Send(lead, "role:reviewer@relay-mesh", "review", "")
+
+	// The reviewer comes back to work in a record the next broker loads,
+	// without a profile update to drain the queue.
+	b1.mu.Lock()
+	b1.agents[reviewer].Profile.Status = "idle"
+	err := b1.saveAgent(b1.agents[reviewer])
+	b1.mu.Unlock()
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	b1.Close()
+
+	b2 := newTestBrokerOn(t, s)
+	waitForQueuedMessages(t, b2, reviewer, 1)
+}
@@ -0,0 +1,461 @@
+package broker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const taskBucket = "RELAY_TASKS"
+
+// defaultTaskLease is how long a claim lasts without a heartbeat from the
+// claimer.
+const defaultTaskLease = 10 * time.Minute
+
+// Task states. Claimed tasks fall back to open when their lease expires.
+const (
+	TaskOpen      = "open"
+	TaskClaimed   = "claimed"
+	TaskDone      = "done"
+	TaskCancelled = "cancelled"
+)
+
+// Task is a unit of project work agents claim instead of negotiating over
+// broadcasts.
+type Task struct {
+	ID             string     `json:"id"`
+	Project        string     `json:"project"`
+	Title          string     `json:"title"`
+	Description    string     `json:"description,omitempty"`
+	Status         string     `json:"status"`
+	CreatedBy      string     `json:"created_by"`
+	Assignee       string     `json:"assignee,omitempty"`
+	DependsOn      []string   `json:"depends_on,omitempty"`
+	Lease          string     `json:"lease,omitempty"` // claim lease as a duration, e.g. "10m0s"
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty"`
+	Result         string     `json:"result,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	// BlockedBy lists unfinished dependencies. It is computed on read.
+	BlockedBy []string `json:"blocked_by,omitempty"`
+}
+
+// TaskPatch holds optional task changes for UpdateTask. Nil fields are
+// left unchanged.
+type TaskPatch struct {
+	Title       *string
+	Description *string
+	DependsOn   *[]string
+	Status      *string // open (release a claim) or cancelled
+}
+
+// CreateTask adds an open task to a project's board.
+func (b *Broker) CreateTask(creator, project, title, description string, dependsOn []string) (Task, error) {
+	project = normalizeProjectName(project)
+	title = strings.TrimSpace(title)
+	if project == "" || title == "" {
+		return Task{}, fmt.Errorf("project and title are required")
+	}
+	id, err := randomID("task")
+	if err != nil {
+		return Task{}, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.requireProjectAccess(creator, project, AccessMembers); err != nil {
+		return Task{}, err
+	}
+	agent := b.agents[creator]
+	now := time.Now().UTC()
+	agent.LastSeen = now
+	t := &Task{
+		ID:          id,
+		Project:     project,
+		Title:       title,
+		Description: strings.TrimSpace(description),
+		Status:      TaskOpen,
+		CreatedBy:   creator,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	deps, err := b.checkDependencies(t, dependsOn)
+	if err != nil {
+		return Task{}, err
+	}
+	t.DependsOn = deps
+	if err := b.saveTask(t); err != nil {
+		return Task{}, err
+	}
+	b.tasks[id] = t
+	return b.taskView(t), nil
+}
+
+// ClaimTask assigns an open task to agentID for lease (default 10m). The
+// lease is renewed by the claimer's heartbeats; if they stop, the task
+// returns to open. Tasks with unfinished dependencies cannot be claimed.
+func (b *Broker) ClaimTask(agentID, taskID string, lease time.Duration) (Task, error) {
+	if lease <= 0 {
+		lease = defaultTaskLease
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	agent := b.agents[agentID]
+	if agent == nil {
+		return Task{}, fmt.Errorf("agent not found: %s", agentID)
+	}
+	t, ok := b.tasks[taskID]
+	if !ok {
+		return Task{}, fmt.Errorf("task not found: %s", taskID)
+	}
+	if _, err := b.requireProjectAccess(agentID, t.Project, AccessMembers); err != nil {
+		return Task{}, err
+	}
+	now := time.Now().UTC()
+	b.expireLease(t, now)
+	switch {
+	case t.Status == TaskClaimed && t.Assignee != agentID:
+		return Task{}, fmt.Errorf("task %s is claimed by %s until %s", t.ID, t.Assignee, t.LeaseExpiresAt.Format(time.RFC3339))
+	case t.Status == TaskDone || t.Status == TaskCancelled:
+		return Task{}, fmt.Errorf("task %s is already %s", t.ID, t.Status)
+	}
+	if blocked := b.blockedBy(t); len(blocked) > 0 {
+		return Task{}, fmt.Errorf("task %s is blocked by unfinished tasks: %s", t.ID, strings.Join(blocked, ", "))
+	}
+
+	agent.LastSeen = now
+	expires := now.Add(lease)
+	prev := *t
+	t.Status = TaskClaimed
+	t.Assignee = agentID
+	t.Lease = lease.String()
+	t.LeaseExpiresAt = &expires
+	t.UpdatedAt = now
+	if err := b.saveTask(t); err != nil {
+		*t = prev
+		return Task{}, err
+	}
+	return b.taskView(t), nil
+}
+
+// UpdateTask edits a task. The creator or current assignee may edit it;
+// status "open" releases a claim and "cancelled" withdraws the task.
+func (b *Broker) UpdateTask(agentID, taskID string, patch TaskPatch) (Task, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	agent := b.agents[agentID]
+	if agent == nil {
+		return Task{}, fmt.Errorf("agent not found: %s", agentID)
+	}
+	t, ok := b.tasks[taskID]
+	if !ok {
+		return Task{}, fmt.Errorf("task not found: %s", taskID)
+	}
+	if _, err := b.requireProjectAccess(agentID, t.Project, AccessMembers); err != nil {
+		return Task{}, err
+	}
+	now := time.Now().UTC()
+	b.expireLease(t, now)
+	if agentID != t.CreatedBy && agentID != t.Assignee {
+		return Task{}, fmt.Errorf("only the creator or assignee can update task %s", t.ID)
+	}
+	if t.Status == TaskDone || t.Status == TaskCancelled {
+		return Task{}, fmt.Errorf("task %s is already %s", t.ID, t.Status)
+	}
+
+	prev := *t
+	if patch.Title != nil {
+		title := strings.TrimSpace(*patch.Title)
+		if title == "" {
+			return Task{}, fmt.Errorf("title cannot be empty")
+		}
+		t.Title = title
+	}
+	if patch.Description != nil {
+		t.Description = strings.TrimSpace(*patch.Description)
+	}
+	if patch.DependsOn != nil {
+		deps, err := b.checkDependencies(t, *patch.DependsOn)
+		if err != nil {
+			*t = prev
+			return Task{}, err
+		}
+		t.DependsOn = deps
+	}
+	if patch.Status != nil {
+		switch strings.TrimSpace(*patch.Status) {
+		case TaskOpen:
+			releaseTask(t)
+		case TaskCancelled:
+			releaseTask(t)
+			t.Status = TaskCancelled
+		default:
+			*t = prev
+			return Task{}, fmt.Errorf("invalid status %q: use open to release or cancelled; complete_task finishes a task", *patch.Status)
+		}
+	}
+	agent.LastSeen = now
+	if t.Status == TaskClaimed && t.Assignee == agentID {
+		b.renewLease(t, now)
+	}
+	t.UpdatedAt = now
+	if err := b.saveTask(t); err != nil {
+		*t = prev
+		return Task{}, err
+	}
+	return b.taskView(t), nil
+}
+
+// CompleteTask marks a task the agent holds as done.
+func (b *Broker) CompleteTask(agentID, taskID, result string) (Task, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	agent := b.agents[agentID]
+	if agent == nil {
+		return Task{}, fmt.Errorf("agent not found: %s", agentID)
+	}
+	t, ok := b.tasks[taskID]
+	if !ok {
+		return Task{}, fmt.Errorf("task not found: %s", taskID)
+	}
+	if _, err := b.requireProjectAccess(agentID, t.Project, AccessMembers); err != nil {
+		return Task{}, err
+	}
+	now := time.Now().UTC()
+	b.expireLease(t, now)
+	if t.Status != TaskClaimed || t.Assignee != agentID {
+		return Task{}, fmt.Errorf("task %s is not claimed by %s; claim_task first", t.ID, agentID)
+	}
+
+	agent.LastSeen = now
+	prev := *t
+	t.Status = TaskDone
+	t.LeaseExpiresAt = nil
+	t.Result = strings.TrimSpace(result)
+	t.UpdatedAt = now
+	if err := b.saveTask(t); err != nil {
+		*t = prev
+		return Task{}, err
+	}
+	return b.taskView(t), nil
+}
+
+// TaskFilter narrows ListTasks. Empty fields match everything.
+type TaskFilter struct {
+	Status   string
+	Assignee string
+}
+
+// ListTasks returns a project's tasks, oldest first.
+func (b *Broker) ListTasks(viewer, project string, filter TaskFilter) ([]Task, error) {
+	project = normalizeProjectName(project)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.requireProjectAccess(viewer, project, AccessMembers); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	out := make([]Task, 0)
+	for _, t := range b.tasks {
+		if project != "" && t.Project != project {
+			continue
+		}
+		if b.expireLease(t, now) {
+			_ = b.saveTask(t)
+		}
+		if filter.Status != "" && t.Status != filter.Status {
+			continue
+		}
+		if filter.Assignee != "" && t.Assignee != filter.Assignee {
+			continue
+		}
+		out = append(out, b.taskView(t))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].ID < out[j].ID
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out, nil
+}
+
+// renewAgentLeases extends every claim held by agentID. Caller holds b.mu.
+func (b *Broker) renewAgentLeases(agentID string, now time.Time) {
+	for _, t := range b.tasks {
+		if t.Status == TaskClaimed && t.Assignee == agentID {
+			b.renewLease(t, now)
+			_ = b.saveTask(t)
+		}
+	}
+}
+
+// releaseAgentTasks reopens every claim held by a pruned agent and returns
+// the IDs of the reopened tasks, for the caller to persist. Caller holds
+// b.mu.
+func (b *Broker) releaseAgentTasks(agentID string) []string {
+	var changed []string
+	for _, t := range b.tasks {
+		if t.Status == TaskClaimed && t.Assignee == agentID {
+			releaseTask(t)
+			t.UpdatedAt = time.Now().UTC()
+			changed = append(changed, t.ID)
+		}
+	}
+	return changed
+}
+
+// taskWrite renders a task for a deferred save. Caller holds b.mu.
+func (b *Broker) taskWrite(id string) kvWrite {
+	return newKVWrite(b.taskKV, id, func() any {
+		t := b.tasks[id]
+		if t == nil {
+			return nil
+		}
+		cp := *t
+		cp.BlockedBy = nil
+		return cp
+	})
+}
+
+func (b *Broker) renewLease(t *Task, now time.Time) {
+	lease, err := time.ParseDuration(t.Lease)
+	if err != nil || lease <= 0 {
+		lease = defaultTaskLease
+	}
+	expires := now.Add(lease)
+	t.LeaseExpiresAt = &expires
+}
+
+// expireLease reopens t if its claim lapsed and reports whether it did.
+// Caller holds b.mu and persists the change.
+func (b *Broker) expireLease(t *Task, now time.Time) bool {
+	if t.Status != TaskClaimed || t.LeaseExpiresAt == nil || now.Before(*t.LeaseExpiresAt) {
+		return false
+	}
+	releaseTask(t)
+	t.UpdatedAt = now
+	return true
+}
+
+func releaseTask(t *Task) {
+	t.Status = TaskOpen
+	t.Assignee = ""
+	t.LeaseExpiresAt = nil
+}
+
+// checkDependencies normalizes deps for t: they must exist, belong to the
+// same project and not create a cycle. Caller holds b.mu.
+func (b *Broker) checkDependencies(t *Task, deps []string) ([]string, error) {
+	seen := make(map[string]bool)
+	out := make([]string, 0, len(deps))
+	for _, id := range deps {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		dep, ok := b.tasks[id]
+		if !ok {
+			return nil, fmt.Errorf("dependency not found: %s", id)
+		}
+		if dep.Project != t.Project {
+			return nil, fmt.Errorf("dependency %s belongs to project %s", id, dep.Project)
+		}
+		if id == t.ID || b.dependsOn(dep, t.ID, map[string]bool{}) {
+			return nil, fmt.Errorf("dependency %s would create a cycle", id)
+		}
+		out = append(out, id)
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out, nil
+}
+
+// dependsOn reports whether t transitively depends on target.
+func (b *Broker) dependsOn(t *Task, target string, visited map[string]bool) bool {
+	if visited[t.ID] {
+		return false
+	}
+	visited[t.ID] = true
+	for _, id := range t.DependsOn {
+		if id == target {
+			return true
+		}
+		if dep, ok := b.tasks[id]; ok && b.dependsOn(dep, target, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockedBy lists t's dependencies that are not done. Caller holds b.mu.
+func (b *Broker) blockedBy(t *Task) []string {
+	var out []string
+	for _, id := range t.DependsOn {
+		if dep, ok := b.tasks[id]; !ok || dep.Status != TaskDone {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// taskView returns a copy of t with computed fields filled in.
+func (b *Broker) taskView(t *Task) Task {
+	cp := *t
+	cp.DependsOn = append([]string(nil), t.DependsOn...)
+	if t.LeaseExpiresAt != nil {
+		expires := *t.LeaseExpiresAt
+		cp.LeaseExpiresAt = &expires
+	}
+	if t.Status == TaskOpen {
+		cp.BlockedBy = b.blockedBy(t)
+	}
+	return cp
+}
+
+// saveTask persists a task. Caller holds b.mu.
+func (b *Broker) saveTask(t *Task) error {
+	cp := *t
+	cp.BlockedBy = nil
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return fmt.Errorf("marshal task: %w", err)
+	}
+	if _, err := b.taskKV.Put(t.ID, data); err != nil {
+		return fmt.Errorf("persist task: %w", err)
+	}
+	return nil
+}
+
+// loadTasks restores the task board from the task bucket. Caller holds b.mu.
+func (b *Broker) loadTasks() error {
+	keys, err := b.taskKV.Keys()
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("list task keys: %w", err)
+	}
+	for _, key := range keys {
+		entry, err := b.taskKV.Get(key)
+		if err != nil {
+			continue
+		}
+		var t Task
+		if err := json.Unmarshal(entry.Value(), &t); err != nil || t.ID == "" {
+			continue
+		}
+		b.tasks[t.ID] = &t
+	}
+	return nil
+}
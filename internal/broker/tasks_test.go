@@ -0,0 +1,144 @@
+package broker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTaskClaimAndComplete(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(testProfile("lead"))
+	dev, _ := b.RegisterAgent(testProfile("dev"))
+	other, _ := b.RegisterAgent(testProfile("other"))
+
+	schema, err := b.CreateTask(lead, "relay-mesh", "Design schema", "", nil)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	api, err := b.CreateTask(lead, "relay-mesh", "Build API", "", []string{schema.ID})
+	if err != nil {
+		t.Fatalf("create dependent: %v", err)
+	}
+	if len(api.BlockedBy) != 1 || api.BlockedBy[0] != schema.ID {
+		t.Fatalf("expected api blocked by schema, got %v", api.BlockedBy)
+	}
+	if _, err := b.ClaimTask(dev, api.ID, 0); err == nil {
+		t.Fatal("expected blocked task claim to fail")
+	}
+
+	claimed, err := b.ClaimTask(dev, schema.ID, 0)
+	if err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+	if claimed.Status != TaskClaimed || claimed.Assignee != dev || claimed.LeaseExpiresAt == nil {
+		t.Fatalf("unexpected claimed task: %+v", claimed)
+	}
+	if _, err := b.ClaimTask(other, schema.ID, 0); err == nil {
+		t.Fatal("expected second claim to fail")
+	}
+	if _, err := b.CompleteTask(other, schema.ID, ""); err == nil {
+		t.Fatal("expected non-assignee completion to fail")
+	}
+	if _, err := b.UpdateTask(other, schema.ID, TaskPatch{}); err == nil {
+		t.Fatal("expected unrelated agent update to fail")
+	}
+
+	done, err := b.CompleteTask(dev, schema.ID, "schema in db/schema.sql")
+	if err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if done.Status != TaskDone || done.Result == "" {
+		t.Fatalf("unexpected completed task: %+v", done)
+	}
+
+	if _, err := b.ClaimTask(other, api.ID, 0); err != nil {
+		t.Fatalf("expected unblocked task to be claimable: %v", err)
+	}
+	open, _ := b.ListTasks(lead, "relay-mesh", TaskFilter{Status: TaskClaimed})
+	if len(open) != 1 || open[0].ID != api.ID {
+		t.Fatalf("unexpected claimed tasks: %+v", open)
+	}
+}
+
+func TestTaskLeaseExpiresWithoutHeartbeat(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(testProfile("lead"))
+	dev, _ := b.RegisterAgent(testProfile("dev"))
+	other, _ := b.RegisterAgent(testProfile("other"))
+
+	task, _ := b.CreateTask(lead, "relay-mesh", "Write docs", "", nil)
+	if _, err := b.ClaimTask(dev, task.ID, 50*time.Millisecond); err != nil {
+		t.Fatalf("claim: %v", err)
+	}
+
+	// Heartbeats renew the lease.
+	time.Sleep(30 * time.Millisecond)
+	if err := b.Heartbeat(dev); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, err := b.ClaimTask(other, task.ID, 0); err == nil {
+		t.Fatal("expected renewed lease to still hold")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	tasks, _ := b.ListTasks(lead, "relay-mesh", TaskFilter{})
+	if tasks[0].Status != TaskOpen || tasks[0].Assignee != "" {
+		t.Fatalf("expected lapsed claim to reopen, got %+v", tasks[0])
+	}
+	if _, err := b.ClaimTask(other, task.ID, 0); err != nil {
+		t.Fatalf("expected reclaim after lease expiry: %v", err)
+	}
+}
+
+func TestTaskDependencyValidation(t *testing.T) {
+	b := newTestBroker(t)
+	lead, _ := b.RegisterAgent(testProfile("lead"))
+
+	a, _ := b.CreateTask(lead, "relay-mesh", "A", "", nil)
+	c, _ := b.CreateTask(lead, "relay-mesh", "C", "", []string{a.ID})
+	if _, err := b.UpdateTask(lead, a.ID, TaskPatch{DependsOn: &[]string{c.ID}}); err == nil {
+		t.Fatal("expected dependency cycle to be rejected")
+	}
+	if _, err := b.CreateTask(lead, "relay-mesh", "D", "", []string{"task-missing"}); err == nil {
+		t.Fatal("expected missing dependency to be rejected")
+	}
+	stranger, _ := b.RegisterAgent(projectProfile("stranger", "other", "developer"))
+	other, _ := b.CreateTask(stranger, "other", "X", "", nil)
+	if _, err := b.CreateTask(lead, "relay-mesh", "E", "", []string{other.ID}); err == nil {
+		t.Fatal("expected cross-project dependency to be rejected")
+	}
+
+	cancelled := TaskCancelled
+	if _, err := b.UpdateTask(lead, c.ID, TaskPatch{Status: &cancelled}); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	if _, err := b.UpdateTask(lead, c.ID, TaskPatch{Status: &cancelled}); err == nil {
+		t.Fatal("expected cancelled task to be final")
+	}
+}
+
+func TestTasksSurviveRestartAndPrune(t *testing.T) {
+	s := runNATSServer(t)
+	b1 := newTestBrokerOn(t, s)
+	lead, _ := b1.RegisterAgent(testProfile("lead"))
+	dev, _ := b1.RegisterAgent(testProfile("dev"))
+	task, _ := b1.CreateTask(lead, "relay-mesh", "Ship it", "", nil)
+	b1.ClaimTask(dev, task.ID, time.Hour)
+	b1.Close()
+
+	b2 := newTestBrokerOn(t, s)
+	tasks, _ := b2.ListTasks(lead, "relay-mesh", TaskFilter{})
+	if len(tasks) != 1 || tasks[0].Assignee != dev {
+		t.Fatalf("expected claimed task after restart, got %+v", tasks)
+	}
+
+	b2.mu.Lock()
+	b2.agents[dev].LastSeen = time.Now().Add(-24 * time.Hour)
+	b2.mu.Unlock()
+	b2.PruneStaleAgents(time.Hour)
+	tasks, _ = b2.ListTasks(lead, "relay-mesh", TaskFilter{})
+	if tasks[0].Status != TaskOpen {
+		t.Fatalf("expected pruned agent's claim to be released, got %+v", tasks[0])
+	}
+}
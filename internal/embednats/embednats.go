@@ -0,0 +1,101 @@
+// Package embednats runs a JetStream-enabled NATS server inside the relay
+// process so relay-mesh works without Docker or a separate nats-server.
+package embednats
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	natsserver "github.com/nats-io/nats-server/v2/server"
+)
+
+// DefaultHost is the interface the client port binds to. The embedded
+// server has no authentication, so it stays on loopback by default.
+const DefaultHost = "127.0.0.1"
+
+// Options configure the embedded server.
+type Options struct {
+	// StoreDir holds JetStream state across restarts. It is created if
+	// missing. Only one server may use a store dir at a time.
+	StoreDir string
+	// Host and Port open a client port so other processes, e.g. relay-mesh
+	// instances on the stdio transport, can connect. Port 0 keeps the
+	// server reachable only in-process; -1 picks a free port.
+	Host string
+	Port int
+	// ReadyTimeout bounds how long Start waits for the server. Defaults to
+	// 10s.
+	ReadyTimeout time.Duration
+}
+
+// Server is a running embedded NATS server. It satisfies
+// nats.InProcessConnProvider, so clients in the same process connect
+// without a socket.
+type Server struct {
+	ns        *natsserver.Server
+	listening bool
+}
+
+// Start launches the server and waits until it accepts connections.
+func Start(opts Options) (*Server, error) {
+	storeDir := strings.TrimSpace(opts.StoreDir)
+	if storeDir == "" {
+		return nil, fmt.Errorf("embedded nats: store dir is required")
+	}
+	if err := os.MkdirAll(storeDir, 0o700); err != nil {
+		return nil, fmt.Errorf("embedded nats: create store dir: %w", err)
+	}
+	host := strings.TrimSpace(opts.Host)
+	if host == "" {
+		host = DefaultHost
+	}
+	timeout := opts.ReadyTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	ns, err := natsserver.NewServer(&natsserver.Options{
+		ServerName: "relay-mesh-embedded",
+		Host:       host,
+		Port:       opts.Port,
+		DontListen: opts.Port == 0,
+		NoLog:      true,
+		NoSigs:     true,
+		JetStream:  true,
+		StoreDir:   storeDir,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("embedded nats: %w", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(timeout) {
+		ns.Shutdown()
+		ns.WaitForShutdown()
+		return nil, fmt.Errorf("embedded nats: not ready after %s (is port %d in use?)", timeout, opts.Port)
+	}
+	return &Server{ns: ns, listening: opts.Port != 0}, nil
+}
+
+// InProcessConn opens a connection to the server without a socket.
+func (s *Server) InProcessConn() (net.Conn, error) {
+	return s.ns.InProcessConn()
+}
+
+// ClientURL is the URL other processes use to connect, or "" when the
+// server has no client port.
+func (s *Server) ClientURL() string {
+	if !s.listening {
+		return ""
+	}
+	return s.ns.ClientURL()
+}
+
+// Shutdown stops the server and waits for JetStream to flush its state.
+// Close clients first so they do not log a disconnect.
+func (s *Server) Shutdown() {
+	s.ns.Shutdown()
+	s.ns.WaitForShutdown()
+}
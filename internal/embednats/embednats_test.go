@@ -0,0 +1,122 @@
+package embednats
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/tanwa/relay-mesh/internal/broker"
+)
+
+func profile(name string) broker.AgentProfile {
+	return broker.AgentProfile{
+		Name:           name,
+		Description:    "test agent " + name,
+		Project:        "relay-mesh",
+		Role:           "developer",
+		Specialization: "messaging",
+	}
+}
+
+func startServer(t *testing.T, opts Options) *Server {
+	t.Helper()
+
+	s, err := Start(opts)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func TestStartRequiresStoreDir(t *testing.T) {
+	if _, err := Start(Options{}); err == nil {
+		t.Fatal("expected a missing store dir to be rejected")
+	}
+}
+
+func TestInProcessOnlyByDefault(t *testing.T) {
+	s := startServer(t, Options{StoreDir: t.TempDir()})
+	if url := s.ClientURL(); url != "" {
+		t.Fatalf("expected no client port, got %q", url)
+	}
+
+	nc, err := nats.Connect("", nats.InProcessServer(s))
+	if err != nil {
+		t.Fatalf("connect in-process: %v", err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("jetstream: %v", err)
+	}
+	if _, err := js.AccountInfo(); err != nil {
+		t.Fatalf("expected JetStream to be enabled: %v", err)
+	}
+}
+
+func TestClientPortServesOtherInstances(t *testing.T) {
+	s := startServer(t, Options{StoreDir: t.TempDir(), Port: -1})
+	if s.ClientURL() == "" {
+		t.Fatal("expected a client URL")
+	}
+
+	local, err := broker.New(broker.Config{InProcess: s})
+	if err != nil {
+		t.Fatalf("in-process broker: %v", err)
+	}
+	defer local.Close()
+	from, _ := local.RegisterAgent(profile("from"))
+	to, err := local.RegisterAgent(profile("to"))
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := local.Send(from, to, "hello", ""); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	remote, err := broker.New(broker.Config{URL: s.ClientURL()})
+	if err != nil {
+		t.Fatalf("broker over client port: %v", err)
+	}
+	defer remote.Close()
+	got, err := remote.Fetch(to, 10)
+	if err != nil || len(got) != 1 || got[0].Body != "hello" {
+		t.Fatalf("expected the message across instances, got %v (%v)", got, err)
+	}
+}
+
+func TestStateSurvivesRestart(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nats")
+
+	s, err := Start(Options{StoreDir: dir})
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	b, err := broker.New(broker.Config{InProcess: s})
+	if err != nil {
+		t.Fatalf("broker: %v", err)
+	}
+	from, _ := b.RegisterAgent(profile("from"))
+	to, err := b.RegisterAgent(profile("to"))
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if _, err := b.Send(from, to, "queued", ""); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	b.Close()
+	s.Shutdown()
+
+	s = startServer(t, Options{StoreDir: dir})
+	b, err = broker.New(broker.Config{InProcess: s})
+	if err != nil {
+		t.Fatalf("broker after restart: %v", err)
+	}
+	defer b.Close()
+	got, err := b.Fetch(to, 10)
+	if err != nil || len(got) != 1 || got[0].Body != "queued" {
+		t.Fatalf("expected the queued message after restart, got %v (%v)", got, err)
+	}
+}
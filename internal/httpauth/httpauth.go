@@ -0,0 +1,233 @@
+// Package httpauth protects the streamable HTTP MCP endpoint with bearer
+// tokens and optional TLS client certificates.
+package httpauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tokenPrefix marks HTTP bearer tokens so they are recognizable in configs
+// and logs, and distinct from agent tokens.
+const tokenPrefix = "rmh-"
+
+// Token is a bearer token accepted by the HTTP endpoint. Projects scopes
+// the token: requests carrying it may only register and act as agents in
+// those projects. An empty Projects allows every project.
+type Token struct {
+	Label    string   `json:"label"`
+	Token    string   `json:"token"`
+	Projects []string `json:"projects,omitempty"`
+}
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Label    string
+	Projects []string
+}
+
+// Scoped reports whether the identity is limited to some projects.
+func (id Identity) Scoped() bool {
+	return len(id.Projects) > 0
+}
+
+type identityKey struct{}
+
+// FromContext returns the identity the middleware attached to a request
+// context. It reports false when bearer auth is off.
+func FromContext(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// Authenticator checks bearer tokens. A nil *Authenticator accepts every
+// request.
+type Authenticator struct {
+	tokens []hashedToken
+}
+
+type hashedToken struct {
+	hash     []byte
+	identity Identity
+}
+
+// New builds an Authenticator from tokens. Labels must be unique and
+// tokens non-empty.
+func New(tokens []Token) (*Authenticator, error) {
+	a := &Authenticator{}
+	labels := make(map[string]bool, len(tokens))
+	for i, t := range tokens {
+		label := strings.TrimSpace(t.Label)
+		if label == "" {
+			return nil, fmt.Errorf("token %d: label is required", i)
+		}
+		if labels[label] {
+			return nil, fmt.Errorf("token %s: duplicate label", label)
+		}
+		labels[label] = true
+		secret := strings.TrimSpace(t.Token)
+		if secret == "" {
+			return nil, fmt.Errorf("token %s: token is required", label)
+		}
+		projects := make([]string, 0, len(t.Projects))
+		for _, p := range t.Projects {
+			if p = strings.TrimSpace(p); p != "" {
+				projects = append(projects, p)
+			}
+		}
+		sum := sha256.Sum256([]byte(secret))
+		a.tokens = append(a.tokens, hashedToken{hash: sum[:], identity: Identity{Label: label, Projects: projects}})
+	}
+	return a, nil
+}
+
+// Load reads tokens from a JSON file holding an array of tokens, e.g.
+//
+//	[{"label": "ci", "token": "rmh-...", "projects": ["billing"]}]
+//
+// A missing file turns bearer auth off and yields a nil Authenticator.
+// An empty array keeps auth on with no accepted tokens.
+func Load(path string) (*Authenticator, error) {
+	tokens, err := ReadTokens(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return New(tokens)
+}
+
+// ReadTokens reads the tokens file. A missing file returns an error
+// matching fs.ErrNotExist.
+func ReadTokens(path string) ([]Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read http tokens: %w", err)
+	}
+	var tokens []Token
+	if strings.TrimSpace(string(data)) == "" {
+		return tokens, nil
+	}
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("parse http tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// EnsureToken returns the token labeled label from the tokens file,
+// generating an unscoped one and saving it if the label is missing. It
+// returns "" without creating anything when the file does not exist,
+// since then the server does not require tokens.
+func EnsureToken(path, label string) (string, error) {
+	tokens, err := ReadTokens(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tokens {
+		if t.Label == label {
+			return t.Token, nil
+		}
+	}
+	secret, err := NewToken()
+	if err != nil {
+		return "", err
+	}
+	tokens = append(tokens, Token{Label: label, Token: secret})
+	out, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	out = append(out, '\n')
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, out, 0o600); err != nil {
+		return "", fmt.Errorf("write http tokens: %w", err)
+	}
+	return secret, nil
+}
+
+// NewToken generates a random bearer token.
+func NewToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return tokenPrefix + hex.EncodeToString(buf), nil
+}
+
+// Authenticate returns the identity of the token in an Authorization
+// header value.
+func (a *Authenticator) Authenticate(header string) (Identity, bool) {
+	scheme, secret, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return Identity{}, false
+	}
+	sum := sha256.Sum256([]byte(strings.TrimSpace(secret)))
+	var (
+		found Identity
+		match bool
+	)
+	// Compare against every token so timing does not reveal which matched.
+	for _, t := range a.tokens {
+		if subtle.ConstantTimeCompare(t.hash, sum[:]) == 1 {
+			found, match = t.identity, true
+		}
+	}
+	return found, match
+}
+
+// Middleware rejects requests without a valid bearer token and attaches
+// the caller's Identity to the rest.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := a.Authenticate(r.Header.Get("Authorization"))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="relay-mesh"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
+
+// ServerTLSConfig is the TLS config for the HTTP endpoint. With a
+// clientCAFile, clients must present a certificate signed by one of its
+// CAs (mTLS). The server certificate itself is loaded by the caller.
+func ServerTLSConfig(clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("client CA %s: no certificates found", clientCAFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
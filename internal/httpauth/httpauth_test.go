@@ -0,0 +1,141 @@
+package httpauth
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareRequiresBearerToken(t *testing.T) {
+	a, err := New([]Token{
+		{Label: "ops", Token: "secret-ops"},
+		{Label: "contractor", Token: "secret-contractor", Projects: []string{"billing", " "}},
+	})
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	var got Identity
+	var seen bool
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, seen = FromContext(r.Context())
+	}))
+
+	for _, header := range []string{"", "Bearer wrong", "Basic secret-ops", "secret-ops"} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Fatalf("header %q: expected 401 with challenge, got %d", header, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/mcp", nil)
+	req.Header.Set("Authorization", "bearer secret-contractor")
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || !seen {
+		t.Fatalf("expected the request through, got %d", rec.Code)
+	}
+	if got.Label != "contractor" || !got.Scoped() || len(got.Projects) != 1 || got.Projects[0] != "billing" {
+		t.Fatalf("unexpected identity %+v", got)
+	}
+}
+
+func TestNilAuthenticatorAllowsAll(t *testing.T) {
+	var a *Authenticator
+	called := false
+	h := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if _, ok := FromContext(r.Context()); ok {
+			t.Fatal("expected no identity without auth")
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/mcp", nil))
+	if !called {
+		t.Fatal("expected the request through")
+	}
+}
+
+func TestNewRejectsBadTokens(t *testing.T) {
+	cases := [][]Token{
+		{{Label: "", Token: "x"}},
+		{{Label: "a", Token: ""}},
+		{{Label: "a", Token: "x"}, {Label: "a", Token: "y"}},
+	}
+	for i, tokens := range cases {
+		if _, err := New(tokens); err == nil {
+			t.Fatalf("case %d: expected an error", i)
+		}
+	}
+}
+
+func TestLoadAndEnsureToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "http-tokens.json")
+
+	a, err := Load(path)
+	if err != nil || a != nil {
+		t.Fatalf("expected auth off without a file, got %v (%v)", a, err)
+	}
+	if tok, err := EnsureToken(path, "claude-code"); err != nil || tok != "" {
+		t.Fatalf("expected no token without a file, got %q (%v)", tok, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected EnsureToken not to create the file, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("[]\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	a, err = Load(path)
+	if err != nil || a == nil {
+		t.Fatalf("expected auth on with an empty file, got %v (%v)", a, err)
+	}
+	if _, ok := a.Authenticate("Bearer anything"); ok {
+		t.Fatal("expected no token to be accepted")
+	}
+
+	tok, err := EnsureToken(path, "claude-code")
+	if err != nil || !strings.HasPrefix(tok, tokenPrefix) {
+		t.Fatalf("expected a generated token, got %q (%v)", tok, err)
+	}
+	again, err := EnsureToken(path, "claude-code")
+	if err != nil || again != tok {
+		t.Fatalf("expected the same token back, got %q (%v)", again, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected a private tokens file, got %v (%v)", info.Mode(), err)
+	}
+
+	a, err = Load(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if id, ok := a.Authenticate("Bearer " + tok); !ok || id.Label != "claude-code" || id.Scoped() {
+		t.Fatalf("expected the saved token to authenticate, got %+v %v", id, ok)
+	}
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	cfg, err := ServerTLSConfig("")
+	if err != nil || cfg.ClientAuth != tls.NoClientCert {
+		t.Fatalf("expected plain TLS without a client CA, got %v (%v)", cfg, err)
+	}
+	bad := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(bad, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ServerTLSConfig(bad); err == nil {
+		t.Fatal("expected a CA file without certificates to be rejected")
+	}
+	if _, err := ServerTLSConfig(filepath.Join(t.TempDir(), "missing.pem")); err == nil {
+		t.Fatal("expected a missing CA file to be rejected")
+	}
+}
@@ -30,13 +30,13 @@ func TestClaudeCodePushWritesStateFile(t *testing.T) {
 		t.Fatalf("push failed: %v", err)
 	}
 
-	stateFile := filepath.Join(dir, "pending-messages.json")
+	stateFile := a.PendingFile("sess-1")
 	data, err := os.ReadFile(stateFile)
 	if err != nil {
 		t.Fatalf("read state file: %v", err)
 	}
 
-	var pending []pendingMessage
+	var pending []PendingMessage
 	if err := json.Unmarshal(data, &pending); err != nil {
 		t.Fatalf("unmarshal state file: %v", err)
 	}
@@ -74,13 +74,13 @@ func TestClaudeCodePushAppendsMultiple(t *testing.T) {
 		}
 	}
 
-	stateFile := filepath.Join(dir, "pending-messages.json")
+	stateFile := a.PendingFile("sess-1")
 	data, err := os.ReadFile(stateFile)
 	if err != nil {
 		t.Fatalf("read state file: %v", err)
 	}
 
-	var pending []pendingMessage
+	var pending []PendingMessage
 	if err := json.Unmarshal(data, &pending); err != nil {
 		t.Fatalf("unmarshal state file: %v", err)
 	}
@@ -108,7 +108,7 @@ func TestClaudeCodePushCreatesDirectory(t *testing.T) {
 		t.Fatalf("push failed: %v", err)
 	}
 
-	stateFile := filepath.Join(nested, "pending-messages.json")
+	stateFile := a.PendingFile("sess-1")
 	if _, err := os.Stat(stateFile); err != nil {
 		t.Fatalf("state file should exist after push: %v", err)
 	}
@@ -118,12 +118,12 @@ func TestClaudeCodeStateFileMatchesStopHookFormat(t *testing.T) {
 	dir := t.TempDir()
 	a := NewClaudeCodeAdapter(dir)
 
-	msg := Message{ID: "msg-42", From: "agent-alpha", To: "agent-beta", Body: "relay payload here"}
+	msg := Message{ID: "msg-42", From: "agent-alpha", To: "agent-beta", Body: "relay payload here", Priority: "urgent", ReplyTo: "msg-41", ThreadID: "th-7"}
 	if err := a.Push("sess-1", "agent-beta", msg); err != nil {
 		t.Fatalf("push failed: %v", err)
 	}
 
-	stateFile := filepath.Join(dir, "pending-messages.json")
+	stateFile := a.PendingFile("sess-1")
 	data, err := os.ReadFile(stateFile)
 	if err != nil {
 		t.Fatalf("read state file: %v", err)
@@ -146,6 +146,12 @@ func TestClaudeCodeStateFileMatchesStopHookFormat(t *testing.T) {
 		t.Fatalf("expected body 'relay payload here', got %v", entry["body"])
 	}
 	// Verify additional fields are present.
+	if entry["thread_id"] != "th-7" || entry["reply_to"] != "msg-41" {
+		t.Fatalf("expected thread info, got thread_id=%v reply_to=%v", entry["thread_id"], entry["reply_to"])
+	}
+	if entry["priority"] != "urgent" {
+		t.Fatalf("expected priority urgent, got %v", entry["priority"])
+	}
 	if entry["message_id"] != "msg-42" {
 		t.Fatalf("expected message_id 'msg-42', got %v", entry["message_id"])
 	}
@@ -155,15 +161,17 @@ func TestClaudeCodeStateFileMatchesStopHookFormat(t *testing.T) {
 }
 
 func TestClaudeCodePushHandlesCorruptedStateFile(t *testing.T) {
-	dir := t.TempDir()
-	stateFile := filepath.Join(dir, "pending-messages.json")
+	a := NewClaudeCodeAdapter(t.TempDir())
+	stateFile := a.PendingFile("sess-1")
 
 	// Write garbage to the state file.
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0o755); err != nil {
+		t.Fatalf("create state dir: %v", err)
+	}
 	if err := os.WriteFile(stateFile, []byte("not json"), 0o644); err != nil {
 		t.Fatalf("write corrupt file: %v", err)
 	}
 
-	a := NewClaudeCodeAdapter(dir)
 	msg := Message{ID: "msg-1", From: "ag-a", To: "ag-b", Body: "recovery"}
 	if err := a.Push("sess-1", "ag-b", msg); err != nil {
 		t.Fatalf("push should recover from corrupted state: %v", err)
@@ -173,7 +181,7 @@ func TestClaudeCodePushHandlesCorruptedStateFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("read state file: %v", err)
 	}
-	var pending []pendingMessage
+	var pending []PendingMessage
 	if err := json.Unmarshal(data, &pending); err != nil {
 		t.Fatalf("unmarshal state file: %v", err)
 	}
@@ -184,3 +192,47 @@ func TestClaudeCodePushHandlesCorruptedStateFile(t *testing.T) {
 		t.Fatalf("expected body 'recovery', got %q", pending[0].Body)
 	}
 }
+
+func TestClaudeCodePendingIsPerSession(t *testing.T) {
+	a := NewClaudeCodeAdapter(t.TempDir())
+
+	if err := a.Push("sess-1", "ag-b", Message{ID: "msg-1", From: "ag-a", Body: "for b"}); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if err := a.Push("sess-2", "ag-c", Message{ID: "msg-2", From: "ag-a", Body: "for c"}); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+
+	got, err := a.DrainPending("sess-1")
+	if err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	if len(got) != 1 || got[0].MessageID != "msg-1" {
+		t.Fatalf("expected only sess-1's message, got %+v", got)
+	}
+	if again, _ := a.DrainPending("sess-1"); len(again) != 0 {
+		t.Fatalf("expected drain to clear the session, got %+v", again)
+	}
+
+	other, err := a.DrainPending("sess-2")
+	if err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	if len(other) != 1 || other[0].AgentID != "ag-c" {
+		t.Fatalf("expected sess-2's message untouched, got %+v", other)
+	}
+}
+
+func TestClaudeCodePushRequiresSession(t *testing.T) {
+	a := NewClaudeCodeAdapter(t.TempDir())
+	if err := a.Push("", "ag-b", Message{ID: "msg-1", Body: "x"}); err == nil {
+		t.Fatal("expected push without session to fail")
+	}
+}
+
+func TestClaudeCodePendingFileSanitizesSession(t *testing.T) {
+	a := NewClaudeCodeAdapter("/state")
+	if got := a.PendingFile("../../etc/passwd"); got != filepath.Join("/state", "pending", ".._.._etc_passwd.json") {
+		t.Fatalf("unexpected pending file: %s", got)
+	}
+}
@@ -0,0 +1,48 @@
+package push
+
+import "fmt"
+
+// CodexAdapter implements push delivery for the Codex CLI.
+// Codex has no inbound hooks or prompt injection API, so this adapter:
+// 1. Writes pending messages to a per-thread state file
+// 2. Sends a desktop notification via notify-send (Linux) or osascript (macOS)
+//
+// Codex runs its configured notify program after every agent turn;
+// "relay-mesh codex notify" drains the thread's file there and tells the
+// user how many messages are waiting for the agent to fetch.
+type CodexAdapter struct {
+	pendingStore
+}
+
+// NewCodexAdapter creates an adapter that writes pending messages to stateDir.
+func NewCodexAdapter(stateDir string) *CodexAdapter {
+	return &CodexAdapter{pendingStore{dir: stateDir}}
+}
+
+func (a *CodexAdapter) HarnessType() string { return "codex" }
+
+func (a *CodexAdapter) Enabled() bool { return true }
+
+// Push records msg for the Codex thread bound as sessionID.
+func (a *CodexAdapter) Push(sessionID, agentID string, msg Message) error {
+	return a.push(a.HarnessType(), sessionID, agentID, msg)
+}
+
+// NotifyTurnComplete runs after a Codex turn ends. It drains threadID's
+// pending messages and shows one desktop notification summarizing them,
+// since the agent only sees them once it calls fetch_messages.
+func (a *CodexAdapter) NotifyTurnComplete(threadID string) ([]PendingMessage, error) {
+	pending, err := a.DrainPending(threadID)
+	if err != nil || len(pending) == 0 {
+		return pending, err
+	}
+	text := fmt.Sprintf("%d relay-mesh message(s) waiting for %s; ask Codex to fetch_messages", len(pending), pending[0].AgentID)
+	for _, m := range pending {
+		if m.Priority == "blocking" || m.Priority == "urgent" {
+			text = fmt.Sprintf("%d relay-mesh message(s) waiting for %s, including %s from %s; ask Codex to fetch_messages", len(pending), m.AgentID, m.Priority, m.From)
+			break
+		}
+	}
+	notifyDesktop(text)
+	return pending, nil
+}
@@ -0,0 +1,72 @@
+package push
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestCodexHarnessType(t *testing.T) {
+	a := NewCodexAdapter(t.TempDir())
+	if got := a.HarnessType(); got != "codex" {
+		t.Fatalf("expected harness type 'codex', got %q", got)
+	}
+	if !a.Enabled() {
+		t.Fatal("expected adapter to always be enabled")
+	}
+}
+
+func TestCodexPushWritesThreadFile(t *testing.T) {
+	a := NewCodexAdapter(t.TempDir())
+
+	msg := Message{ID: "msg-1", From: "ag-a", To: "ag-b", Body: "hello codex", Priority: "urgent", CreatedAt: "2026-02-18T10:00:00Z"}
+	if err := a.Push("thread-1", "ag-b", msg); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+
+	data, err := os.ReadFile(a.PendingFile("thread-1"))
+	if err != nil {
+		t.Fatalf("read state file: %v", err)
+	}
+	var pending []PendingMessage
+	if err := json.Unmarshal(data, &pending); err != nil {
+		t.Fatalf("unmarshal state file: %v", err)
+	}
+	if len(pending) != 1 {
+		t.Fatalf("expected 1 pending message, got %d", len(pending))
+	}
+	if pending[0].From != "ag-a" || pending[0].Body != "hello codex" || pending[0].AgentID != "ag-b" || pending[0].Priority != "urgent" {
+		t.Fatalf("unexpected pending message: %+v", pending[0])
+	}
+}
+
+func TestCodexDrainPendingIsPerThread(t *testing.T) {
+	a := NewCodexAdapter(t.TempDir())
+
+	for _, push := range []struct{ thread, id string }{{"thread-1", "msg-1"}, {"thread-1", "msg-2"}, {"thread-2", "msg-3"}} {
+		if err := a.Push(push.thread, "ag-b", Message{ID: push.id, From: "ag-a", Body: push.id}); err != nil {
+			t.Fatalf("push failed: %v", err)
+		}
+	}
+
+	got, err := a.DrainPending("thread-1")
+	if err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	if len(got) != 2 || got[0].MessageID != "msg-1" || got[1].MessageID != "msg-2" {
+		t.Fatalf("expected thread-1's messages in order, got %+v", got)
+	}
+	if again, _ := a.DrainPending("thread-1"); len(again) != 0 {
+		t.Fatalf("expected drain to clear the thread, got %+v", again)
+	}
+	if other, _ := a.DrainPending("thread-2"); len(other) != 1 {
+		t.Fatalf("expected thread-2 untouched, got %+v", other)
+	}
+}
+
+func TestCodexPushRequiresThread(t *testing.T) {
+	a := NewCodexAdapter(t.TempDir())
+	if err := a.Push(" ", "ag-b", Message{ID: "msg-1", Body: "x"}); err == nil {
+		t.Fatal("expected push without thread id to fail")
+	}
+}
@@ -0,0 +1,24 @@
+package push
+
+// CursorAdapter implements push delivery for Cursor.
+// Cursor has no prompt injection API, so this adapter writes pending
+// messages to a per-conversation state file. The stop hook installed by
+// install-cursor drains it and hands the messages back to the agent as a
+// followup message.
+type CursorAdapter struct {
+	pendingStore
+}
+
+// NewCursorAdapter creates an adapter that writes pending messages to stateDir.
+func NewCursorAdapter(stateDir string) *CursorAdapter {
+	return &CursorAdapter{pendingStore{dir: stateDir}}
+}
+
+func (a *CursorAdapter) HarnessType() string { return "cursor" }
+
+func (a *CursorAdapter) Enabled() bool { return true }
+
+// Push records msg for the Cursor conversation bound as sessionID.
+func (a *CursorAdapter) Push(sessionID, agentID string, msg Message) error {
+	return a.push(a.HarnessType(), sessionID, agentID, msg)
+}
@@ -0,0 +1,41 @@
+package push
+
+import "testing"
+
+func TestCursorHarnessType(t *testing.T) {
+	a := NewCursorAdapter(t.TempDir())
+	if got := a.HarnessType(); got != "cursor" {
+		t.Fatalf("expected harness type 'cursor', got %q", got)
+	}
+	if !a.Enabled() {
+		t.Fatal("expected adapter to always be enabled")
+	}
+}
+
+func TestCursorPushAndDrainPerSession(t *testing.T) {
+	a := NewCursorAdapter(t.TempDir())
+
+	if err := a.Push("conv-1", "ag-b", Message{ID: "msg-1", From: "ag-a", Body: "hello", Priority: "blocking"}); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if err := a.Push("conv-2", "ag-c", Message{ID: "msg-2", From: "ag-a", Body: "other"}); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+
+	got, err := a.DrainPending("conv-1")
+	if err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	if len(got) != 1 || got[0].MessageID != "msg-1" || got[0].Priority != "blocking" || got[0].AgentID != "ag-b" {
+		t.Fatalf("expected only conv-1's message, got %+v", got)
+	}
+	if again, _ := a.DrainPending("conv-1"); len(again) != 0 {
+		t.Fatalf("expected drain to clear the session, got %+v", again)
+	}
+	if other, _ := a.DrainPending("conv-2"); len(other) != 1 {
+		t.Fatalf("expected conv-2 untouched, got %+v", other)
+	}
+	if err := a.Push("", "ag-b", Message{ID: "msg-3"}); err == nil {
+		t.Fatal("expected push without session to fail")
+	}
+}
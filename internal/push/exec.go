@@ -0,0 +1,259 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// execOutputLimit caps how much command output is kept for logs and errors.
+const execOutputLimit = 4096
+
+// ExecCommand is the command run for each message pushed to an agent.
+//
+// Command is a sh -c script. The placeholders {agent_id}, {session_id},
+// {message_id}, {from}, {priority}, {channel} and {thread_id} are replaced
+// with shell-quoted values. The body is never substituted; read it from
+// $RELAY_BODY or from the message JSON on stdin.
+type ExecCommand struct {
+	Command string `json:"command"`
+	Timeout string `json:"timeout,omitempty"` // as a duration; empty uses the adapter default
+}
+
+// ExecAdapter implements push delivery by running a per-agent command, so
+// tmux send-keys, editor RPCs or custom scripts can be wired in without
+// code changes. Commands are registered at bind_session time and kept in
+// stateDir. Running commands for any agent that asks is only safe on a
+// trusted host, so the adapter is disabled unless the server opts in.
+type ExecAdapter struct {
+	path           string
+	enabled        bool
+	defaultTimeout time.Duration
+	slots          chan struct{} // caps concurrent commands
+
+	mu        sync.Mutex
+	commands  map[string]ExecCommand // agent ID -> command
+	agentRuns map[string]*sync.Mutex // agent ID -> serializes its commands
+}
+
+// NewExecAdapter creates an adapter that keeps commands in stateDir and
+// runs at most maxConcurrent at a time. A missing commands file is not an
+// error.
+func NewExecAdapter(stateDir string, enabled bool, defaultTimeout time.Duration, maxConcurrent int) (*ExecAdapter, error) {
+	if defaultTimeout <= 0 {
+		defaultTimeout = 10 * time.Second
+	}
+	if maxConcurrent <= 0 {
+		maxConcurrent = 4
+	}
+	a := &ExecAdapter{
+		path:           filepath.Join(stateDir, "commands.json"),
+		enabled:        enabled,
+		defaultTimeout: defaultTimeout,
+		slots:          make(chan struct{}, maxConcurrent),
+		commands:       make(map[string]ExecCommand),
+		agentRuns:      make(map[string]*sync.Mutex),
+	}
+	if err := loadJSONFile(a.path, &a.commands); err != nil {
+		return a, fmt.Errorf("load exec commands: %w", err)
+	}
+	return a, nil
+}
+
+func (a *ExecAdapter) HarnessType() string { return "exec" }
+
+func (a *ExecAdapter) Enabled() bool { return a.enabled }
+
+// RetryPolicy retries sparingly, since a command may have side effects
+// before it fails.
+func (a *ExecAdapter) RetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+}
+
+// Register sets agentID's command and returns the stored value.
+func (a *ExecAdapter) Register(agentID string, c ExecCommand) (ExecCommand, error) {
+	agentID = strings.TrimSpace(agentID)
+	if agentID == "" {
+		return ExecCommand{}, fmt.Errorf("agent_id is required")
+	}
+	if !a.enabled {
+		return ExecCommand{}, fmt.Errorf("exec push is disabled on this server")
+	}
+	c.Command = strings.TrimSpace(c.Command)
+	if c.Command == "" {
+		return ExecCommand{}, fmt.Errorf("command is required")
+	}
+	if c.Timeout != "" {
+		if d, err := time.ParseDuration(c.Timeout); err != nil || d <= 0 {
+			return ExecCommand{}, fmt.Errorf("invalid exec timeout %q", c.Timeout)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	prev, had := a.commands[agentID]
+	a.commands[agentID] = c
+	if err := saveJSONFile(a.path, a.commands); err != nil {
+		if had {
+			a.commands[agentID] = prev
+		} else {
+			delete(a.commands, agentID)
+		}
+		return ExecCommand{}, err
+	}
+	return c, nil
+}
+
+// Unregister forgets agentID's command.
+func (a *ExecAdapter) Unregister(agentID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.commands[agentID]; !ok {
+		return
+	}
+	delete(a.commands, agentID)
+	_ = saveJSONFile(a.path, a.commands)
+}
+
+// Command returns agentID's registered command.
+func (a *ExecAdapter) Command(agentID string) (ExecCommand, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.commands[agentID]
+	return c, ok
+}
+
+// Push runs agentID's command for msg. Commands for one agent run one at
+// a time, in push order; a full concurrency limit counts against the
+// command's timeout.
+func (a *ExecAdapter) Push(sessionID, agentID string, msg Message) error {
+	c, ok := a.Command(agentID)
+	if !ok {
+		return fmt.Errorf("no exec command registered for %s", agentID)
+	}
+	timeout := a.defaultTimeout
+	if d, err := time.ParseDuration(c.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	run := a.agentLock(agentID)
+	run.Lock()
+	defer run.Unlock()
+	select {
+	case a.slots <- struct{}{}:
+		defer func() { <-a.slots }()
+	case <-ctx.Done():
+		return fmt.Errorf("exec concurrency limit reached")
+	}
+
+	stdin, err := json.Marshal(newPendingMessage(agentID, msg))
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", expandExecCommand(c.Command, sessionID, agentID, msg))
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Env = append(os.Environ(), execEnv(sessionID, agentID, msg)...)
+	out := &limitedBuffer{max: execOutputLimit}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// Don't let a background child holding the pipes outlive the timeout.
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	output := strings.TrimSpace(out.String())
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		if output != "" {
+			return fmt.Errorf("exec command: %w: %s", err, output)
+		}
+		return fmt.Errorf("exec command: %w", err)
+	}
+	slog.Info("exec push ran", "agent_id", agentID, "message_id", msg.ID, "output", output)
+	return nil
+}
+
+func (a *ExecAdapter) agentLock(agentID string) *sync.Mutex {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	l, ok := a.agentRuns[agentID]
+	if !ok {
+		l = &sync.Mutex{}
+		a.agentRuns[agentID] = l
+	}
+	return l
+}
+
+// expandExecCommand fills in the command placeholders with shell-quoted
+// values.
+func expandExecCommand(command, sessionID, agentID string, msg Message) string {
+	return strings.NewReplacer(
+		"{agent_id}", shellQuote(agentID),
+		"{session_id}", shellQuote(sessionID),
+		"{message_id}", shellQuote(msg.ID),
+		"{from}", shellQuote(msg.From),
+		"{priority}", shellQuote(msg.Priority),
+		"{channel}", shellQuote(msg.Channel),
+		"{thread_id}", shellQuote(msg.ThreadID),
+	).Replace(command)
+}
+
+// execEnv exposes the message to the command as RELAY_* variables.
+func execEnv(sessionID, agentID string, msg Message) []string {
+	return []string{
+		"RELAY_AGENT_ID=" + agentID,
+		"RELAY_SESSION_ID=" + sessionID,
+		"RELAY_MESSAGE_ID=" + msg.ID,
+		"RELAY_FROM=" + msg.From,
+		"RELAY_PRIORITY=" + msg.Priority,
+		"RELAY_CHANNEL=" + msg.Channel,
+		"RELAY_THREAD_ID=" + msg.ThreadID,
+		"RELAY_REPLY_TO=" + msg.ReplyTo,
+		"RELAY_REQUEST=" + strconv.FormatBool(msg.Request),
+		"RELAY_CREATED_AT=" + msg.CreatedAt,
+		"RELAY_BODY=" + msg.Body,
+	}
+}
+
+// shellQuote wraps s in single quotes for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// limitedBuffer keeps the first max bytes written to it.
+type limitedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.max - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *limitedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,112 @@
+package push
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExecPushPassesMessage(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	a, err := NewExecAdapter(dir, true, 5*time.Second, 2)
+	if err != nil {
+		t.Fatalf("new adapter: %v", err)
+	}
+	if a.HarnessType() != "exec" || !a.Enabled() {
+		t.Fatalf("unexpected adapter state: %q %v", a.HarnessType(), a.Enabled())
+	}
+	if _, err := a.Register("ag-b", ExecCommand{
+		Command: `{ echo {session_id} {from}; echo "$RELAY_PRIORITY $RELAY_BODY"; cat; } > ` + shellQuote(out),
+	}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	msg := Message{ID: "msg-1", From: "ag-a; rm -rf /", Body: "hello $(id)", Priority: "urgent"}
+	if err := a.Push("dev:1", "ag-b", msg); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("read output: %v", err)
+	}
+	lines := strings.SplitN(string(data), "\n", 3)
+	if lines[0] != "dev:1 ag-a; rm -rf /" {
+		t.Fatalf("expected quoted placeholders, got %q", lines[0])
+	}
+	if lines[1] != "urgent hello $(id)" {
+		t.Fatalf("expected env vars, got %q", lines[1])
+	}
+	if !strings.Contains(lines[2], `"message_id":"msg-1"`) || !strings.Contains(lines[2], `"agent_id":"ag-b"`) {
+		t.Fatalf("expected message JSON on stdin, got %q", lines[2])
+	}
+}
+
+func TestExecPushFailureAndTimeout(t *testing.T) {
+	a, _ := NewExecAdapter(t.TempDir(), true, 5*time.Second, 1)
+	if err := a.Push("s", "ag-b", Message{ID: "msg-1"}); err == nil {
+		t.Fatal("expected push without a command to fail")
+	}
+
+	a.Register("ag-b", ExecCommand{Command: "echo boom >&2; exit 3"})
+	err := a.Push("s", "ag-b", Message{ID: "msg-1"})
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected captured output in error, got %v", err)
+	}
+
+	a.Register("ag-b", ExecCommand{Command: "sleep 5", Timeout: "100ms"})
+	start := time.Now()
+	if err := a.Push("s", "ag-b", Message{ID: "msg-1"}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Fatal("expected timeout to stop the command")
+	}
+}
+
+func TestExecConcurrencyLimit(t *testing.T) {
+	a, _ := NewExecAdapter(t.TempDir(), true, 5*time.Second, 1)
+	a.Register("ag-a", ExecCommand{Command: "sleep 0.3"})
+	a.Register("ag-b", ExecCommand{Command: "true", Timeout: "50ms"})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.Push("s", "ag-a", Message{ID: "msg-1"})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := a.Push("s", "ag-b", Message{ID: "msg-2"}); err == nil || !strings.Contains(err.Error(), "concurrency limit") {
+		t.Fatalf("expected concurrency limit error, got %v", err)
+	}
+	wg.Wait()
+	if err := a.Push("s", "ag-b", Message{ID: "msg-3"}); err != nil {
+		t.Fatalf("expected push after slot freed: %v", err)
+	}
+}
+
+func TestExecRegisterRequiresOptIn(t *testing.T) {
+	dir := t.TempDir()
+	off, _ := NewExecAdapter(dir, false, 0, 0)
+	if _, err := off.Register("ag-b", ExecCommand{Command: "true"}); err == nil {
+		t.Fatal("expected disabled adapter to reject commands")
+	}
+
+	on, _ := NewExecAdapter(dir, true, 0, 0)
+	if _, err := on.Register("ag-b", ExecCommand{Command: "true", Timeout: "never"}); err == nil {
+		t.Fatal("expected invalid timeout to be rejected")
+	}
+	if _, err := on.Register("ag-b", ExecCommand{Command: "true"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	reloaded, err := NewExecAdapter(dir, true, 0, 0)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if c, ok := reloaded.Command("ag-b"); !ok || c.Command != "true" {
+		t.Fatalf("expected command after reload, got %+v %v", c, ok)
+	}
+}
@@ -0,0 +1,134 @@
+package push
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MCPMessageNotification is the JSON-RPC method of the notification sent to
+// an agent's MCP client when a message is delivered to it.
+const MCPMessageNotification = "notifications/relay-mesh/message"
+
+// mcpResourceUpdated is the standard MCP notification for a changed resource.
+const mcpResourceUpdated = "notifications/resources/updated"
+
+// inboxURIPrefix is the scheme and path of the per-agent inbox resource.
+const inboxURIPrefix = "relay-mesh://inbox/"
+
+// ErrNoMCPSession is returned when the agent has no connected MCP client
+// session to notify.
+var ErrNoMCPSession = errors.New("agent has no connected MCP session")
+
+// InboxURI returns the MCP resource URI of an agent's inbox.
+func InboxURI(agentID string) string { return inboxURIPrefix + agentID }
+
+// InboxAgentID extracts the agent ID from an inbox resource URI.
+func InboxAgentID(uri string) (string, bool) {
+	agentID, ok := strings.CutPrefix(uri, inboxURIPrefix)
+	return agentID, ok && agentID != ""
+}
+
+// MCPNotifier sends a notification to one connected MCP client session.
+// *server.MCPServer satisfies it.
+type MCPNotifier interface {
+	SendNotificationToSpecificClient(sessionID, method string, params map[string]any) error
+}
+
+// MCPAdapter implements push delivery over the MCP connection itself. It
+// works for any harness whose client keeps its session open (stdio, or
+// streamable HTTP with a GET stream), including the generic harness.
+// Agents are mapped to the MCP client session that called register_agent.
+type MCPAdapter struct {
+	notifier MCPNotifier
+
+	mu       sync.Mutex
+	sessions map[string]string // agent ID -> MCP client session ID
+}
+
+// NewMCPAdapter creates an adapter that sends notifications through n.
+func NewMCPAdapter(n MCPNotifier) *MCPAdapter {
+	return &MCPAdapter{notifier: n, sessions: make(map[string]string)}
+}
+
+func (a *MCPAdapter) HarnessType() string { return "mcp" }
+
+func (a *MCPAdapter) Enabled() bool { return a.notifier != nil }
+
+// RetryPolicy gives up quickly: a notification only helps while the
+// client is connected.
+func (a *MCPAdapter) RetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+}
+
+// Bind maps agentID to the MCP client session it registered from.
+func (a *MCPAdapter) Bind(agentID, mcpSessionID string) {
+	if agentID == "" || mcpSessionID == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sessions[agentID] = mcpSessionID
+}
+
+// UnbindSession forgets every agent bound to a disconnected MCP session.
+func (a *MCPAdapter) UnbindSession(mcpSessionID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for agentID, sid := range a.sessions {
+		if sid == mcpSessionID {
+			delete(a.sessions, agentID)
+		}
+	}
+}
+
+// SessionFor returns the MCP client session bound to agentID.
+func (a *MCPAdapter) SessionFor(agentID string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sid, ok := a.sessions[agentID]
+	return sid, ok
+}
+
+// Push notifies the agent's MCP client of msg and marks its inbox resource
+// as updated. An empty sessionID uses the session bound at register time;
+// ErrNoMCPSession is returned if there is none.
+func (a *MCPAdapter) Push(sessionID, agentID string, msg Message) error {
+	if sessionID == "" {
+		sid, ok := a.SessionFor(agentID)
+		if !ok {
+			return ErrNoMCPSession
+		}
+		sessionID = sid
+	}
+	params := map[string]any{
+		"message_id": msg.ID,
+		"agent_id":   agentID,
+		"from":       msg.From,
+		"body":       msg.Body,
+		"priority":   msg.Priority,
+		"inbox":      InboxURI(agentID),
+		"created_at": msg.CreatedAt,
+	}
+	if msg.Channel != "" {
+		params["channel"] = msg.Channel
+	}
+	if msg.ThreadID != "" {
+		params["thread_id"] = msg.ThreadID
+	}
+	if msg.ReplyTo != "" {
+		params["reply_to"] = msg.ReplyTo
+	}
+	if msg.Request {
+		params["request"] = true
+	}
+	if err := a.notifier.SendNotificationToSpecificClient(sessionID, MCPMessageNotification, params); err != nil {
+		return fmt.Errorf("notify session %s: %w", sessionID, err)
+	}
+	if err := a.notifier.SendNotificationToSpecificClient(sessionID, mcpResourceUpdated, map[string]any{"uri": InboxURI(agentID)}); err != nil {
+		return fmt.Errorf("notify session %s: %w", sessionID, err)
+	}
+	return nil
+}
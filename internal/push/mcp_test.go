@@ -0,0 +1,98 @@
+package push
+
+import (
+	"errors"
+	"testing"
+)
+
+type notification struct {
+	SessionID string
+	Method    string
+	Params    map[string]any
+}
+
+// fakeNotifier records notifications instead of writing to a client.
+type fakeNotifier struct {
+	sent []notification
+	err  error
+}
+
+func (f *fakeNotifier) SendNotificationToSpecificClient(sessionID, method string, params map[string]any) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, notification{SessionID: sessionID, Method: method, Params: params})
+	return nil
+}
+
+func TestMCPAdapterPushesToBoundSession(t *testing.T) {
+	n := &fakeNotifier{}
+	a := NewMCPAdapter(n)
+	if a.HarnessType() != "mcp" || !a.Enabled() {
+		t.Fatalf("unexpected adapter state: %q %v", a.HarnessType(), a.Enabled())
+	}
+
+	if err := a.Push("", "ag-b", Message{ID: "msg-1"}); !errors.Is(err, ErrNoMCPSession) {
+		t.Fatalf("expected ErrNoMCPSession before bind, got %v", err)
+	}
+
+	a.Bind("ag-b", "mcp-sess-1")
+	msg := Message{ID: "msg-1", From: "ag-a", Body: "hello", Priority: "urgent", ThreadID: "th-1", Request: true}
+	if err := a.Push("", "ag-b", msg); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if len(n.sent) != 2 {
+		t.Fatalf("expected message and resource notifications, got %+v", n.sent)
+	}
+	got := n.sent[0]
+	if got.SessionID != "mcp-sess-1" || got.Method != MCPMessageNotification {
+		t.Fatalf("unexpected notification: %+v", got)
+	}
+	if got.Params["body"] != "hello" || got.Params["priority"] != "urgent" || got.Params["request"] != true || got.Params["thread_id"] != "th-1" {
+		t.Fatalf("unexpected params: %+v", got.Params)
+	}
+	if _, ok := got.Params["channel"]; ok {
+		t.Fatalf("expected empty channel to be omitted: %+v", got.Params)
+	}
+	updated := n.sent[1]
+	if updated.Method != "notifications/resources/updated" || updated.Params["uri"] != "relay-mesh://inbox/ag-b" {
+		t.Fatalf("unexpected resource notification: %+v", updated)
+	}
+}
+
+func TestMCPAdapterUnbindSession(t *testing.T) {
+	a := NewMCPAdapter(&fakeNotifier{})
+	a.Bind("ag-a", "mcp-sess-1")
+	a.Bind("ag-b", "mcp-sess-1")
+	a.Bind("ag-c", "mcp-sess-2")
+
+	a.UnbindSession("mcp-sess-1")
+	if _, ok := a.SessionFor("ag-a"); ok {
+		t.Fatal("expected ag-a to be unbound")
+	}
+	if _, ok := a.SessionFor("ag-b"); ok {
+		t.Fatal("expected ag-b to be unbound")
+	}
+	if sid, ok := a.SessionFor("ag-c"); !ok || sid != "mcp-sess-2" {
+		t.Fatalf("expected ag-c to stay bound, got %q %v", sid, ok)
+	}
+}
+
+func TestMCPAdapterNotifyError(t *testing.T) {
+	a := NewMCPAdapter(&fakeNotifier{err: errors.New("session not found")})
+	if err := a.Push("mcp-sess-1", "ag-b", Message{ID: "msg-1"}); err == nil {
+		t.Fatal("expected notifier error to be returned")
+	}
+}
+
+func TestInboxURIRoundTrip(t *testing.T) {
+	if id, ok := InboxAgentID(InboxURI("ag-b")); !ok || id != "ag-b" {
+		t.Fatalf("unexpected round trip: %q %v", id, ok)
+	}
+	if _, ok := InboxAgentID("relay-mesh://inbox/"); ok {
+		t.Fatal("expected empty agent id to be rejected")
+	}
+	if _, ok := InboxAgentID("file:///tmp/x"); ok {
+		t.Fatal("expected foreign uri to be rejected")
+	}
+}
@@ -17,6 +17,8 @@ type OpenCodeAdapter struct {
 	client   *http.Client
 	noReply  bool
 	disabled bool
+
+	templates *Templates
 }
 
 // NewOpenCodeAdapter creates an adapter for OpenCode push delivery.
@@ -40,6 +42,15 @@ func (a *OpenCodeAdapter) HarnessType() string { return "opencode" }
 
 func (a *OpenCodeAdapter) Enabled() bool { return !a.disabled }
 
+// SetTemplates sets the templates for the prompt and toast text.
+func (a *OpenCodeAdapter) SetTemplates(t *Templates) { a.templates = t }
+
+// RetryPolicy allows for OpenCode restarting: a server that is briefly
+// down or returning 5xx gets several minutes to come back.
+func (a *OpenCodeAdapter) RetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 6, BaseDelay: 2 * time.Second, MaxDelay: 5 * time.Minute}
+}
+
 func (a *OpenCodeAdapter) Push(sessionID, agentID string, msg Message) error {
 	if a.disabled {
 		return nil
@@ -49,18 +60,19 @@ func (a *OpenCodeAdapter) Push(sessionID, agentID string, msg Message) error {
 		return fmt.Errorf("session id is required")
 	}
 
+	text := a.templates.Render(TemplatePrompt, a.HarnessType(), sessionID, agentID, msg)
+
+	// Blocking messages always start a turn so the agent handles them now.
+	noReply := a.noReply
+	if msg.Priority == "blocking" {
+		noReply = false
+	}
 	body := map[string]any{
-		"noReply": a.noReply,
+		"noReply": noReply,
 		"parts": []map[string]string{
 			{
 				"type": "text",
-				"text": fmt.Sprintf(
-					"New relay-mesh message for %s.\nfrom: %s\nmessage_id: %s\nbody:\n%s",
-					agentID,
-					msg.From,
-					msg.ID,
-					msg.Body,
-				),
+				"text": text,
 			},
 		},
 	}
@@ -77,8 +89,8 @@ func (a *OpenCodeAdapter) Push(sessionID, agentID string, msg Message) error {
 	// Best-effort UI visibility signal in OpenCode TUI.
 	toast := map[string]any{
 		"title":   "relay-mesh",
-		"message": fmt.Sprintf("New message for %s from %s", agentID, msg.From),
-		"variant": "info",
+		"message": a.templates.Render(TemplateNotification, a.HarnessType(), sessionID, agentID, msg),
+		"variant": toastVariant(msg.Priority),
 	}
 	toastData, _ := json.Marshal(toast)
 	toastURL := fmt.Sprintf("%s/tui/show-toast", a.baseURL)
@@ -90,6 +102,18 @@ func (a *OpenCodeAdapter) Push(sessionID, agentID string, msg Message) error {
 	return nil
 }
 
+// toastVariant maps message priority to an OpenCode toast variant.
+func toastVariant(priority string) string {
+	switch priority {
+	case "blocking":
+		return "error"
+	case "urgent":
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
 func (a *OpenCodeAdapter) sessionDirectory(sessionID string) (string, error) {
 	sessionURL := fmt.Sprintf("%s/session/%s", a.baseURL, sessionID)
 	req, err := http.NewRequest(http.MethodGet, sessionURL, nil)
@@ -0,0 +1,203 @@
+package push
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpenCodeSessionResolver finds the OpenCode session an agent registers
+// from by listing the server's sessions.
+type OpenCodeSessionResolver struct {
+	baseURL string
+	client  *http.Client
+	window  time.Duration
+}
+
+type openCodeSession struct {
+	ID        string `json:"id"`
+	Directory string `json:"directory"`
+	Time      struct {
+		Updated int64 `json:"updated"`
+	} `json:"time"`
+}
+
+// NewOpenCodeSessionResolver creates a resolver that considers sessions
+// updated within window. An empty baseURL disables it.
+func NewOpenCodeSessionResolver(baseURL string, timeout, window time.Duration) *OpenCodeSessionResolver {
+	baseURL = strings.TrimSpace(strings.TrimRight(baseURL, "/"))
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	if window <= 0 {
+		window = 15 * time.Minute
+	}
+	if baseURL == "" {
+		return &OpenCodeSessionResolver{}
+	}
+	return &OpenCodeSessionResolver{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: timeout},
+		window:  window,
+	}
+}
+
+func (r *OpenCodeSessionResolver) HarnessType() string { return "opencode" }
+
+func (r *OpenCodeSessionResolver) Enabled() bool { return r.baseURL != "" }
+
+// ResolveSession picks among unbound sessions updated within the window:
+//
+//   - with hint.Directory, sessions in that directory win, then sessions
+//     nested inside it or containing it; no match means no binding
+//   - otherwise sessions whose directory is named after hint.Project win
+//   - otherwise the only candidate, if there is just one
+//
+// Several sessions tied for the best match return an
+// *AmbiguousSessionError listing them rather than a guess.
+func (r *OpenCodeSessionResolver) ResolveSession(hint SessionHint, bound map[string]struct{}) (string, error) {
+	if !r.Enabled() {
+		return "", nil
+	}
+	sessions, err := r.listSessions()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	candidates := make([]openCodeSession, 0, len(sessions))
+	for _, s := range sessions {
+		if strings.TrimSpace(s.ID) == "" {
+			continue
+		}
+		if _, used := bound[s.ID]; used {
+			continue
+		}
+		updatedAt := unixMaybeMillis(s.Time.Updated)
+		if updatedAt.IsZero() || now.Sub(updatedAt) > r.window {
+			continue
+		}
+		candidates = append(candidates, s)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Time.Updated > candidates[j].Time.Updated
+	})
+
+	if dir := cleanDirectory(hint.Directory); dir != "" {
+		var exact, nested []openCodeSession
+		for _, s := range candidates {
+			switch sessionDir := cleanDirectory(s.Directory); {
+			case sessionDir == "":
+			case sessionDir == dir:
+				exact = append(exact, s)
+			case isWithin(sessionDir, dir) || isWithin(dir, sessionDir):
+				nested = append(nested, s)
+			}
+		}
+		if len(exact) > 0 {
+			return r.pick(exact)
+		}
+		if len(nested) > 0 {
+			return r.pick(nested)
+		}
+		return "", nil
+	}
+	if project := strings.TrimSpace(hint.Project); project != "" {
+		var matches []openCodeSession
+		for _, s := range candidates {
+			if directoryMatchesProject(s.Directory, project) {
+				matches = append(matches, s)
+			}
+		}
+		if len(matches) > 0 {
+			return r.pick(matches)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+	return r.pick(candidates)
+}
+
+// pick returns the session if there is exactly one, and an ambiguity
+// error listing them otherwise.
+func (r *OpenCodeSessionResolver) pick(sessions []openCodeSession) (string, error) {
+	if len(sessions) == 1 {
+		return sessions[0].ID, nil
+	}
+	err := &AmbiguousSessionError{Harness: r.HarnessType()}
+	for _, s := range sessions {
+		err.Candidates = append(err.Candidates, SessionCandidate{
+			ID:        s.ID,
+			Directory: s.Directory,
+			UpdatedAt: unixMaybeMillis(s.Time.Updated).UTC(),
+		})
+	}
+	return "", err
+}
+
+func (r *OpenCodeSessionResolver) listSessions() ([]openCodeSession, error) {
+	req, err := http.NewRequest(http.MethodGet, r.baseURL+"/session", nil)
+	if err != nil {
+		return nil, fmt.Errorf("build session list request: %w", err)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request session list: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("session list status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	var sessions []openCodeSession
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		return nil, fmt.Errorf("decode session list: %w", err)
+	}
+	return sessions, nil
+}
+
+// directoryMatchesProject reports whether dir's last element names project,
+// ignoring case and treating spaces, dashes and underscores alike.
+func directoryMatchesProject(dir, project string) bool {
+	dir = cleanDirectory(dir)
+	if dir == "" {
+		return false
+	}
+	return projectKey(path.Base(dir)) == projectKey(project)
+}
+
+// cleanDirectory normalizes a directory path for comparison.
+func cleanDirectory(dir string) string {
+	dir = strings.TrimSpace(strings.ReplaceAll(dir, "\\", "/"))
+	if dir == "" {
+		return ""
+	}
+	return path.Clean(dir)
+}
+
+// isWithin reports whether dir is strictly inside parent.
+func isWithin(dir, parent string) bool {
+	return strings.HasPrefix(dir, strings.TrimSuffix(parent, "/")+"/")
+}
+
+func projectKey(s string) string {
+	return strings.NewReplacer(" ", "-", "_", "-").Replace(strings.ToLower(strings.TrimSpace(s)))
+}
+
+func unixMaybeMillis(v int64) time.Time {
+	if v <= 0 {
+		return time.Time{}
+	}
+	// OpenCode session timestamps are milliseconds since epoch.
+	if v > 1_000_000_000_000 {
+		return time.UnixMilli(v)
+	}
+	return time.Unix(v, 0)
+}
@@ -0,0 +1,126 @@
+package push
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openCodeSessionServer serves GET /session from id -> directory pairs,
+// each updated a second before the previous one.
+func openCodeSessionServer(t *testing.T, sessions [][2]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/session" {
+			http.NotFound(w, r)
+			return
+		}
+		now := time.Now().UnixMilli()
+		w.Write([]byte("["))
+		for i, s := range sessions {
+			if i > 0 {
+				w.Write([]byte(","))
+			}
+			fmt.Fprintf(w, `{"id":%q,"directory":%q,"time":{"updated":%d}}`, s[0], s[1], now-int64(i)*1000)
+		}
+		w.Write([]byte("]"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOpenCodeResolverPrefersProjectDirectory(t *testing.T) {
+	srv := openCodeSessionServer(t, [][2]string{
+		{"ses-web", "/home/me/web-app"},
+		{"ses-relay", "/home/me/relay_mesh"},
+		{"ses-relay-old", "/home/me/relay_mesh"},
+	})
+	r := NewOpenCodeSessionResolver(srv.URL, time.Second, time.Hour)
+
+	got, err := r.ResolveSession(SessionHint{Project: "web-app"}, nil)
+	if err != nil || got != "ses-web" {
+		t.Fatalf("expected project session, got %q %v", got, err)
+	}
+	got, _ = r.ResolveSession(SessionHint{Project: "Relay-Mesh"}, map[string]struct{}{"ses-relay": {}})
+	if got != "ses-relay-old" {
+		t.Fatalf("expected the unbound project session, got %q", got)
+	}
+
+	_, err = r.ResolveSession(SessionHint{Project: "relay-mesh"}, nil)
+	var ambiguous *AmbiguousSessionError
+	if !errors.As(err, &ambiguous) || len(ambiguous.Candidates) != 2 || ambiguous.Candidates[0].ID != "ses-relay" {
+		t.Fatalf("expected two project sessions to be ambiguous, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "ses-relay-old (/home/me/relay_mesh)") {
+		t.Fatalf("expected candidates in error, got %q", err)
+	}
+	if _, err := r.ResolveSession(SessionHint{Project: "other"}, nil); !errors.As(err, &ambiguous) || len(ambiguous.Candidates) != 3 {
+		t.Fatalf("expected no guess between several sessions, got %v", err)
+	}
+}
+
+func TestOpenCodeResolverMatchesDirectory(t *testing.T) {
+	srv := openCodeSessionServer(t, [][2]string{
+		{"ses-web", "/home/me/web-app"},
+		{"ses-api", "/home/me/mono/services/api"},
+		{"ses-mono", "/home/me/mono"},
+		{"ses-ui", "/home/me/mono/ui"},
+	})
+	r := NewOpenCodeSessionResolver(srv.URL, time.Second, time.Hour)
+
+	if got, err := r.ResolveSession(SessionHint{Directory: "/home/me/mono/", Project: "web-app"}, nil); err != nil || got != "ses-mono" {
+		t.Fatalf("expected exact directory to beat project and nesting, got %q %v", got, err)
+	}
+	if got, err := r.ResolveSession(SessionHint{Directory: "/home/me/mono/services/api/internal"}, map[string]struct{}{"ses-mono": {}}); err != nil || got != "ses-api" {
+		t.Fatalf("expected session containing the directory, got %q %v", got, err)
+	}
+	var ambiguous *AmbiguousSessionError
+	if _, err := r.ResolveSession(SessionHint{Directory: "/home/me/mono"}, map[string]struct{}{"ses-mono": {}}); !errors.As(err, &ambiguous) || len(ambiguous.Candidates) != 2 {
+		t.Fatalf("expected nested sessions to be ambiguous, got %v", err)
+	}
+	if got, err := r.ResolveSession(SessionHint{Directory: "/srv/elsewhere"}, nil); err != nil || got != "" {
+		t.Fatalf("expected no binding for an unknown directory, got %q %v", got, err)
+	}
+}
+
+func TestOpenCodeResolverSoleCandidate(t *testing.T) {
+	srv := openCodeSessionServer(t, [][2]string{{"ses-1", "/tmp/scratch"}})
+	r := NewOpenCodeSessionResolver(srv.URL, time.Second, time.Hour)
+	if got, _ := r.ResolveSession(SessionHint{Project: "anything"}, nil); got != "ses-1" {
+		t.Fatalf("expected sole session, got %q", got)
+	}
+	if got, _ := r.ResolveSession(SessionHint{}, map[string]struct{}{"ses-1": {}}); got != "" {
+		t.Fatalf("expected bound session to be skipped, got %q", got)
+	}
+	if NewOpenCodeSessionResolver("", 0, 0).Enabled() {
+		t.Fatal("expected empty base URL to disable the resolver")
+	}
+}
+
+type stubResolver struct {
+	harness string
+	session string
+}
+
+func (s *stubResolver) HarnessType() string { return s.harness }
+func (s *stubResolver) Enabled() bool       { return true }
+func (s *stubResolver) ResolveSession(SessionHint, map[string]struct{}) (string, error) {
+	return s.session, nil
+}
+
+func TestRegistryResolveSessionPerHarness(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterResolver(&stubResolver{harness: "opencode"})
+	r.RegisterResolver(&stubResolver{harness: "other", session: "sess-other"})
+
+	if sid, h, _ := r.ResolveSession("opencode", SessionHint{}, nil); sid != "" || h != "" {
+		t.Fatalf("expected only the opencode resolver to be asked, got %q %q", sid, h)
+	}
+	if sid, h, _ := r.ResolveSession("generic", SessionHint{}, nil); sid != "sess-other" || h != "other" {
+		t.Fatalf("expected generic to try every resolver, got %q %q", sid, h)
+	}
+}
@@ -0,0 +1,263 @@
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Harnesses without a prompt injection API (Claude Code, Codex) get pushes
+// as per-session pending files that their hooks drain, plus a desktop
+// notification.
+
+// PendingMessage is the JSON structure written to a session's pending file.
+type PendingMessage struct {
+	From      string `json:"from"`
+	Body      string `json:"body"`
+	MessageID string `json:"message_id"`
+	AgentID   string `json:"agent_id"`
+	Priority  string `json:"priority,omitempty"`
+	Channel   string `json:"channel,omitempty"`
+	ThreadID  string `json:"thread_id,omitempty"`
+	ReplyTo   string `json:"reply_to,omitempty"`
+	Request   bool   `json:"request,omitempty"`
+	CreatedAt string `json:"created_at"`
+	Text      string `json:"text,omitempty"` // rendered prompt template, shown by hooks
+}
+
+// pendingLockTimeout bounds how long a writer or drainer waits for the
+// session lock; staleLockAge is when a leftover lock file is broken.
+const (
+	pendingLockTimeout = 5 * time.Second
+	staleLockAge       = 30 * time.Second
+)
+
+// pendingStore keeps one pending file per session under dir. Adapters for
+// hook-driven harnesses embed it.
+type pendingStore struct {
+	dir       string // e.g., ~/.relay-mesh/claude-code/
+	mu        sync.Mutex
+	templates *Templates
+}
+
+// SetTemplates sets the templates for pending entries and notifications.
+func (s *pendingStore) SetTemplates(t *Templates) { s.templates = t }
+
+// PendingFile returns the state file holding sessionID's pending messages.
+func (s *pendingStore) PendingFile(sessionID string) string {
+	return filepath.Join(s.dir, "pending", sessionFileName(sessionID)+".json")
+}
+
+// RetryPolicy retries quickly: the only transient failure of a local
+// state file is lock contention with a draining hook.
+func (s *pendingStore) RetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 3, BaseDelay: 500 * time.Millisecond, MaxDelay: 5 * time.Second}
+}
+
+// DrainPending returns sessionID's pending messages and clears them in one
+// locked step, so a message is reported by exactly one hook run.
+func (s *pendingStore) DrainPending(sessionID string) ([]PendingMessage, error) {
+	if strings.TrimSpace(sessionID) == "" {
+		return nil, fmt.Errorf("session_id is required")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return drainPending(s.PendingFile(sessionID))
+}
+
+// push appends msg to sessionID's file and sends a desktop notification.
+func (s *pendingStore) push(harness, sessionID, agentID string, msg Message) error {
+	if strings.TrimSpace(sessionID) == "" {
+		return fmt.Errorf("session_id is required for %s push", harness)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := newPendingMessage(agentID, msg)
+	entry.Text = s.templates.Render(TemplatePrompt, harness, sessionID, agentID, msg)
+	if err := appendPending(s.PendingFile(sessionID), entry); err != nil {
+		return err
+	}
+
+	// Best-effort desktop notification.
+	notifyDesktop(s.templates.Render(TemplateNotification, harness, sessionID, agentID, msg))
+
+	return nil
+}
+
+func newPendingMessage(agentID string, msg Message) PendingMessage {
+	return PendingMessage{
+		From:      msg.From,
+		Body:      msg.Body,
+		MessageID: msg.ID,
+		AgentID:   agentID,
+		Priority:  msg.Priority,
+		Channel:   msg.Channel,
+		ThreadID:  msg.ThreadID,
+		ReplyTo:   msg.ReplyTo,
+		Request:   msg.Request,
+		CreatedAt: msg.CreatedAt,
+	}
+}
+
+// appendPending adds m to stateFile under the file lock.
+func appendPending(stateFile string, m PendingMessage) error {
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0o755); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+	unlock, err := lockPendingFile(stateFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return writePending(stateFile, append(readPending(stateFile), m))
+}
+
+// drainPending reads and removes stateFile under the file lock. A missing
+// file drains nothing.
+func drainPending(stateFile string) ([]PendingMessage, error) {
+	if _, err := os.Stat(stateFile); os.IsNotExist(err) {
+		return nil, nil
+	}
+	unlock, err := lockPendingFile(stateFile)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	pending := readPending(stateFile)
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	if err := os.Remove(stateFile); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("clear pending messages: %w", err)
+	}
+	return pending, nil
+}
+
+// sessionFileName makes a session ID safe to use as a file name.
+func sessionFileName(sessionID string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, strings.TrimSpace(sessionID))
+}
+
+// lockPendingFile takes an exclusive lock file next to stateFile. A lock
+// older than staleLockAge is assumed to belong to a crashed process.
+func lockPendingFile(stateFile string) (func(), error) {
+	lockFile := stateFile + ".lock"
+	deadline := time.Now().Add(pendingLockTimeout)
+	for {
+		f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lockFile) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("lock pending file: %w", err)
+		}
+		if info, statErr := os.Stat(lockFile); statErr == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lockFile)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("lock pending file: timed out waiting for %s", lockFile)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readPending loads a pending file. A missing or corrupted file reads as
+// empty.
+func readPending(stateFile string) []PendingMessage {
+	var pending []PendingMessage
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil
+	}
+	return pending
+}
+
+// writePending replaces a pending file atomically: temp file in the same
+// dir, then rename.
+func writePending(stateFile string, pending []PendingMessage) error {
+	out, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal pending messages: %w", err)
+	}
+	return writeFileAtomic(stateFile, out)
+}
+
+// loadJSONFile decodes path into v. A missing file leaves v unchanged.
+func loadJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveJSONFile writes v to path atomically, creating a private state dir.
+func saveJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal %s: %w", filepath.Base(path), err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("create state dir: %w", err)
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces path with data via a temp file and rename, so
+// readers never see a partial write. The file is created 0600.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("write temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("close temp file: %w", err)
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("rename temp to state file: %w", err)
+	}
+	return nil
+}
+
+// notifyDesktop shows text via notify-send (Linux) or osascript (macOS).
+func notifyDesktop(text string) {
+	switch runtime.GOOS {
+	case "linux":
+		_ = exec.Command("notify-send", "relay-mesh", text).Run()
+	case "darwin":
+		script := fmt.Sprintf(`display notification %q with title "relay-mesh"`, text)
+		_ = exec.Command("osascript", "-e", script).Run()
+	}
+}
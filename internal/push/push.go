@@ -1,6 +1,13 @@
 package push
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrAdapterDisabled is returned by Registry.Push when the harness adapter
+// is not configured, so nothing was delivered.
+var ErrAdapterDisabled = errors.New("push adapter disabled")
 
 // Adapter handles push delivery for a specific harness type.
 type Adapter interface {
@@ -16,14 +23,26 @@ type Adapter interface {
 type Message struct {
 	ID        string
 	From      string
+	FromName  string // sender's profile name, if known
+	FromRole  string // sender's profile role, if known
 	To        string
+	ToName    string // recipient's profile name, if known
 	Body      string
+	Priority  string // "normal" | "urgent" | "blocking"
+	Channel   string // channel name for channel posts
+	ReplyTo   string // message_id this message answers, if any
+	ThreadID  string
+	Request   bool // sender is blocked in ask_agent until this is answered
 	CreatedAt string
 }
 
 // Registry holds adapters indexed by harness type and dispatches push calls.
+// It also holds the session resolvers used to auto-bind new agents and the
+// templates adapters render push text with.
 type Registry struct {
-	adapters map[string]Adapter
+	adapters  map[string]Adapter
+	resolvers []SessionResolver
+	templates *Templates
 }
 
 // NewRegistry returns an empty Registry ready for adapter registration.
@@ -34,6 +53,9 @@ func NewRegistry() *Registry {
 // Register adds an adapter to the registry, keyed by its HarnessType.
 func (r *Registry) Register(a Adapter) {
 	r.adapters[a.HarnessType()] = a
+	if s, ok := a.(templateSetter); ok && r.templates != nil {
+		s.SetTemplates(r.templates)
+	}
 }
 
 // Push dispatches a push to the adapter matching the given harness type.
@@ -43,20 +65,7 @@ func (r *Registry) Push(harness, sessionID, agentID string, msg Message) error {
 		return fmt.Errorf("unknown harness type: %s", harness)
 	}
 	if !a.Enabled() {
-		return nil
+		return fmt.Errorf("%w: %s", ErrAdapterDisabled, harness)
 	}
 	return a.Push(sessionID, agentID, msg)
 }
-
-// PushAny tries all enabled adapters and returns the first error encountered.
-func (r *Registry) PushAny(sessionID, agentID string, msg Message) error {
-	for _, a := range r.adapters {
-		if !a.Enabled() {
-			continue
-		}
-		if err := a.Push(sessionID, agentID, msg); err != nil {
-			return fmt.Errorf("%s push: %w", a.HarnessType(), err)
-		}
-	}
-	return nil
-}
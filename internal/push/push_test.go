@@ -2,6 +2,7 @@ package push
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -68,46 +69,14 @@ func TestRegistryPushSkipsDisabled(t *testing.T) {
 	r.Register(a)
 
 	msg := Message{ID: "m1", From: "ag-a", To: "ag-b", Body: "hello"}
-	if err := r.Push("off", "sess-1", "ag-b", msg); err != nil {
-		t.Fatalf("push failed: %v", err)
+	if err := r.Push("off", "sess-1", "ag-b", msg); !errors.Is(err, ErrAdapterDisabled) {
+		t.Fatalf("expected ErrAdapterDisabled, got %v", err)
 	}
 	if len(a.calls) != 0 {
 		t.Fatal("expected no calls for disabled adapter")
 	}
 }
 
-func TestRegistryPushAny(t *testing.T) {
-	r := NewRegistry()
-	a1 := &stubAdapter{harness: "h1", enabled: true}
-	a2 := &stubAdapter{harness: "h2", enabled: false}
-	a3 := &stubAdapter{harness: "h3", enabled: true}
-	r.Register(a1)
-	r.Register(a2)
-	r.Register(a3)
-
-	msg := Message{ID: "m1", From: "ag-a", To: "ag-b", Body: "hello"}
-	if err := r.PushAny("sess-1", "ag-b", msg); err != nil {
-		t.Fatalf("push any failed: %v", err)
-	}
-	if len(a1.calls) != 1 {
-		t.Fatalf("expected 1 call on h1, got %d", len(a1.calls))
-	}
-	if len(a2.calls) != 0 {
-		t.Fatal("expected no calls on disabled h2")
-	}
-	if len(a3.calls) != 1 {
-		t.Fatalf("expected 1 call on h3, got %d", len(a3.calls))
-	}
-}
-
-func TestRegistryPushAnyNoAdapters(t *testing.T) {
-	r := NewRegistry()
-	msg := Message{ID: "m1", From: "ag-a", To: "ag-b", Body: "hello"}
-	if err := r.PushAny("sess-1", "ag-b", msg); err != nil {
-		t.Fatalf("push any with no adapters should succeed, got: %v", err)
-	}
-}
-
 func TestOpenCodeAdapterPush(t *testing.T) {
 	var paths []string
 	var bodies []map[string]any
@@ -212,3 +181,74 @@ func TestOpenCodeAdapterEmptySessionID(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestOpenCodeAdapterPushIncludesThread(t *testing.T) {
+	var prompt string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/session/sess-1/prompt_async" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		var body struct {
+			Parts []struct {
+				Text string `json:"text"`
+			} `json:"parts"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if len(body.Parts) > 0 {
+			prompt = body.Parts[0].Text
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	a := NewOpenCodeAdapter(srv.URL, 5*time.Second, false)
+	msg := Message{ID: "msg-2", From: "ag-a", To: "ag-b", Body: "8080", ReplyTo: "msg-1", ThreadID: "th-1", Channel: "app/backend"}
+	if err := a.Push("sess-1", "ag-b", msg); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if !strings.Contains(prompt, "thread_id: th-1") || !strings.Contains(prompt, "reply_to: msg-1") || !strings.Contains(prompt, "channel: app/backend") {
+		t.Fatalf("expected thread info in prompt, got %q", prompt)
+	}
+}
+
+func TestOpenCodeAdapterPushBlocking(t *testing.T) {
+	var noReply any
+	var variant string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]any{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Path {
+		case "/session/sess-1/prompt_async":
+			noReply = body["noReply"]
+			w.WriteHeader(http.StatusNoContent)
+		case "/tui/show-toast":
+			variant, _ = body["variant"].(string)
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	// noReply=true is overridden so blocking messages start a turn.
+	a := NewOpenCodeAdapter(srv.URL, 5*time.Second, true)
+	msg := Message{ID: "msg-1", From: "ag-a", To: "ag-b", Body: "stop", Priority: "blocking"}
+	if err := a.Push("sess-1", "ag-b", msg); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if noReply != false {
+		t.Fatalf("expected noReply=false for blocking message, got %v", noReply)
+	}
+	if variant != "error" {
+		t.Fatalf("expected error toast for blocking message, got %q", variant)
+	}
+
+	msg.Priority = "normal"
+	if err := a.Push("sess-1", "ag-b", msg); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if noReply != true || variant != "info" {
+		t.Fatalf("expected configured noReply and info toast for normal message, got %v %q", noReply, variant)
+	}
+}
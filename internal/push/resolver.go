@@ -0,0 +1,82 @@
+package push
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SessionHint describes the agent that is registering, so a resolver can
+// tell its session apart from other live sessions of the same harness.
+type SessionHint struct {
+	Project   string // the agent's project name
+	Directory string // the agent's working directory or git root, if known
+}
+
+// SessionCandidate is a live session a resolver could not rule out.
+type SessionCandidate struct {
+	ID        string    `json:"id"`
+	Directory string    `json:"directory,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// AmbiguousSessionError is returned when several sessions match a hint
+// equally well. The agent should pass session_id (or a more specific
+// directory) instead of having one guessed for it.
+type AmbiguousSessionError struct {
+	Harness    string
+	Candidates []SessionCandidate
+}
+
+func (e *AmbiguousSessionError) Error() string {
+	ids := make([]string, 0, len(e.Candidates))
+	for _, c := range e.Candidates {
+		if c.Directory != "" {
+			ids = append(ids, fmt.Sprintf("%s (%s)", c.ID, c.Directory))
+		} else {
+			ids = append(ids, c.ID)
+		}
+	}
+	return fmt.Sprintf("%d %s sessions match; pass session_id to pick one: %s", len(e.Candidates), e.Harness, strings.Join(ids, ", "))
+}
+
+// SessionResolver finds the harness session an agent is registering from
+// when the harness did not pass a session id.
+type SessionResolver interface {
+	// HarnessType returns the harness whose sessions this resolver lists.
+	HarnessType() string
+	// Enabled returns whether this resolver is configured and ready.
+	Enabled() bool
+	// ResolveSession returns the unbound session matching hint, or "" if
+	// none matches. bound holds session ids already bound to agents. If
+	// several match equally well it returns an *AmbiguousSessionError.
+	ResolveSession(hint SessionHint, bound map[string]struct{}) (string, error)
+}
+
+// RegisterResolver adds a session resolver. Resolvers are consulted in
+// registration order.
+func (r *Registry) RegisterResolver(res SessionResolver) {
+	r.resolvers = append(r.resolvers, res)
+}
+
+// ResolveSession asks the resolver for harness for the agent's session.
+// An empty or "generic" harness asks every enabled resolver in turn. It
+// returns the session id and the harness it belongs to, or "" if no
+// resolver found one.
+func (r *Registry) ResolveSession(harness string, hint SessionHint, bound map[string]struct{}) (string, string, error) {
+	harness = strings.TrimSpace(harness)
+	anyHarness := harness == "" || harness == "generic"
+	for _, res := range r.resolvers {
+		if !res.Enabled() || (!anyHarness && res.HarnessType() != harness) {
+			continue
+		}
+		sessionID, err := res.ResolveSession(hint, bound)
+		if err != nil {
+			return "", "", fmt.Errorf("%s session resolver: %w", res.HarnessType(), err)
+		}
+		if sessionID != "" {
+			return sessionID, res.HarnessType(), nil
+		}
+	}
+	return "", "", nil
+}
@@ -0,0 +1,63 @@
+package push
+
+import "time"
+
+// RetryPolicy controls how often a failed push is retried before it is
+// dead-lettered.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts, including the first
+	BaseDelay   time.Duration // delay after the first failure; doubles each retry
+	MaxDelay    time.Duration // cap on the delay between attempts
+}
+
+// DefaultRetryPolicy applies to adapters that do not declare their own.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: 2 * time.Second, MaxDelay: 2 * time.Minute}
+
+// Retrier is implemented by adapters that need a different retry policy
+// than DefaultRetryPolicy.
+type Retrier interface {
+	RetryPolicy() RetryPolicy
+}
+
+// Backoff returns the delay before the next attempt after attempts failures.
+func (p RetryPolicy) Backoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	d := p.BaseDelay
+	for i := 1; i < attempts && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// Exhausted reports whether no attempts remain after attempts failures.
+func (p RetryPolicy) Exhausted(attempts int) bool {
+	return attempts >= p.MaxAttempts
+}
+
+// RetryPolicy returns the retry policy for a harness type.
+func (r *Registry) RetryPolicy(harness string) RetryPolicy {
+	if a, ok := r.adapters[harness].(Retrier); ok {
+		return a.RetryPolicy()
+	}
+	return DefaultRetryPolicy
+}
+
+// SessionTracker is implemented by adapters that keep their own agent to
+// session mapping instead of using the broker's harness binding.
+type SessionTracker interface {
+	SessionFor(agentID string) (string, bool)
+}
+
+// TrackedSession returns the session an adapter tracks for agentID.
+func (r *Registry) TrackedSession(harness, agentID string) (string, bool) {
+	a, ok := r.adapters[harness].(SessionTracker)
+	if !ok || !r.adapters[harness].Enabled() {
+		return "", false
+	}
+	return a.SessionFor(agentID)
+}
@@ -0,0 +1,54 @@
+package push
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 4, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.Backoff(i + 1); got != w {
+			t.Fatalf("backoff after %d failures: expected %s, got %s", i+1, w, got)
+		}
+	}
+	if p.Exhausted(3) || !p.Exhausted(4) {
+		t.Fatal("expected policy to be exhausted after MaxAttempts failures")
+	}
+}
+
+func TestRegistryRetryPolicyPerHarness(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&stubAdapter{harness: "test", enabled: true})
+	r.Register(NewClaudeCodeAdapter(t.TempDir()))
+	r.Register(NewOpenCodeAdapter("http://127.0.0.1:1", time.Second, false))
+
+	if got := r.RetryPolicy("test"); got != DefaultRetryPolicy {
+		t.Fatalf("expected default policy, got %+v", got)
+	}
+	if got := r.RetryPolicy("claude-code"); got.MaxAttempts != 3 {
+		t.Fatalf("expected pending-file policy, got %+v", got)
+	}
+	if got := r.RetryPolicy("opencode"); got.MaxAttempts != 6 {
+		t.Fatalf("expected opencode policy, got %+v", got)
+	}
+}
+
+func TestRegistryTrackedSession(t *testing.T) {
+	r := NewRegistry()
+	mcp := NewMCPAdapter(&fakeNotifier{})
+	r.Register(mcp)
+	r.Register(&stubAdapter{harness: "test", enabled: true})
+
+	if _, ok := r.TrackedSession("mcp", "ag-b"); ok {
+		t.Fatal("expected no session before bind")
+	}
+	mcp.Bind("ag-b", "mcp-sess-1")
+	if sid, ok := r.TrackedSession("mcp", "ag-b"); !ok || sid != "mcp-sess-1" {
+		t.Fatalf("unexpected tracked session: %q %v", sid, ok)
+	}
+	if _, ok := r.TrackedSession("test", "ag-b"); ok {
+		t.Fatal("expected adapters without tracking to report nothing")
+	}
+}
@@ -0,0 +1,229 @@
+package push
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Template kinds an adapter renders.
+const (
+	// TemplatePrompt is the text handed to the agent: the OpenCode prompt,
+	// or the pending-file entry a hook-driven harness shows on its next turn.
+	TemplatePrompt = "prompt"
+	// TemplateNotification is the one-line desktop or TUI notification.
+	TemplateNotification = "notification"
+)
+
+// templateDefaultHarness holds templates that apply to every harness
+// without its own.
+const templateDefaultHarness = "default"
+
+// excerptLimit is how many runes of the body TemplateData.Excerpt keeps.
+const excerptLimit = 100
+
+// TemplateData is what push templates can reference.
+type TemplateData struct {
+	Harness   string
+	SessionID string
+	AgentID   string
+	Recipient string // recipient's name, or AgentID if it has none
+	MessageID string
+	From      string
+	FromName  string
+	FromRole  string
+	Sender    string // FromName, or From if the sender has no name
+	Priority  string
+	Urgent    bool // priority is "urgent" or "blocking"
+	Channel   string
+	ThreadID  string
+	ReplyTo   string
+	Request   bool
+	Body      string
+	Excerpt   string // Body cut to 100 runes
+	CreatedAt string
+}
+
+func newTemplateData(harness, sessionID, agentID string, msg Message) TemplateData {
+	d := TemplateData{
+		Harness:   harness,
+		SessionID: sessionID,
+		AgentID:   agentID,
+		Recipient: agentID,
+		MessageID: msg.ID,
+		From:      msg.From,
+		FromName:  msg.FromName,
+		FromRole:  msg.FromRole,
+		Sender:    msg.From,
+		Priority:  msg.Priority,
+		Urgent:    msg.Priority == "urgent" || msg.Priority == "blocking",
+		Channel:   msg.Channel,
+		ThreadID:  msg.ThreadID,
+		ReplyTo:   msg.ReplyTo,
+		Request:   msg.Request,
+		Body:      msg.Body,
+		Excerpt:   truncateRunes(excerptLimit, msg.Body),
+		CreatedAt: msg.CreatedAt,
+	}
+	if msg.ToName != "" {
+		d.Recipient = msg.ToName
+	}
+	if msg.FromName != "" {
+		d.Sender = msg.FromName
+	}
+	return d
+}
+
+// defaultPromptTemplate reproduces the prompt OpenCode has always received.
+const defaultPromptTemplate = `New relay-mesh message for {{.Recipient}}.
+from: {{.Sender}}{{with .FromRole}} ({{.}}){{end}}{{if ne .Sender .From}} [{{.From}}]{{end}}
+message_id: {{.MessageID}}
+{{if and .Priority (ne .Priority "normal")}}priority: {{.Priority}}
+{{end}}{{with .Channel}}channel: {{.}}
+{{end}}{{with .ThreadID}}thread_id: {{.}}
+{{end}}{{with .ReplyTo}}reply_to: {{.}}
+{{end}}{{if .Request}}The sender is waiting for your answer: reply with send_message reply_to={{.MessageID}}.
+{{end}}body:
+{{.Body}}`
+
+// pendingEntryTemplate is a one-line summary, since hook-driven harnesses
+// list pending entries one per line.
+const pendingEntryTemplate = `{{if .Urgent}}[{{upper .Priority}}] {{end}}From: {{.Sender}}{{with .FromRole}} ({{.}}){{end}}{{with .ThreadID}} | Thread: {{.}}{{end}} | Message: {{oneline .Excerpt}}`
+
+const defaultNotificationTemplate = `New {{if .Urgent}}{{.Priority}} {{end}}message for {{.Recipient}} from {{.Sender}}`
+
+// builtins are used for any harness and kind the config leaves out.
+var builtins = mustParseTemplates(map[string]map[string]string{
+	templateDefaultHarness: {TemplatePrompt: defaultPromptTemplate, TemplateNotification: defaultNotificationTemplate},
+	"claude-code":          {TemplatePrompt: pendingEntryTemplate},
+	"codex":                {TemplatePrompt: pendingEntryTemplate},
+	"cursor":               {TemplatePrompt: pendingEntryTemplate},
+	"vscode":               {TemplatePrompt: pendingEntryTemplate},
+})
+
+var templateFuncs = template.FuncMap{
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"truncate": truncateRunes,
+	"oneline":  func(s string) string { return strings.Join(strings.Fields(s), " ") },
+}
+
+// Templates renders push text per harness and kind. A nil *Templates
+// renders the built-in templates.
+type Templates struct {
+	sets map[string]map[string]*template.Template // harness -> kind -> template
+}
+
+// LoadTemplates reads templates from a JSON file mapping harness type (or
+// "default", for every harness) to kind to Go text/template source, e.g.
+//
+//	{"opencode": {"prompt": "{{.Sender}} says: {{.Body}}"}}
+//
+// Templates are checked against sample data so mistakes surface at load
+// time. A missing file yields the built-in templates.
+func LoadTemplates(path string) (*Templates, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read push templates: %w", err)
+	}
+	var raw map[string]map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse push templates: %w", err)
+	}
+	return ParseTemplates(raw)
+}
+
+// ParseTemplates parses harness -> kind -> template source.
+func ParseTemplates(raw map[string]map[string]string) (*Templates, error) {
+	t := &Templates{sets: make(map[string]map[string]*template.Template)}
+	sample := newTemplateData("sample", "ses-1", "ag-1", Message{
+		ID: "msg-1", From: "ag-2", FromName: "sample", FromRole: "role", Body: "body",
+		Priority: "urgent", Channel: "chan", ThreadID: "thr-1", ReplyTo: "msg-0", Request: true,
+	})
+	for harness, kinds := range raw {
+		for kind, src := range kinds {
+			if kind != TemplatePrompt && kind != TemplateNotification {
+				return nil, fmt.Errorf("push template %s: unknown kind %q", harness, kind)
+			}
+			tmpl, err := template.New(harness + "/" + kind).Funcs(templateFuncs).Parse(src)
+			if err != nil {
+				return nil, fmt.Errorf("push template %s/%s: %w", harness, kind, err)
+			}
+			if err := tmpl.Execute(&strings.Builder{}, sample); err != nil {
+				return nil, fmt.Errorf("push template %s/%s: %w", harness, kind, err)
+			}
+			if t.sets[harness] == nil {
+				t.sets[harness] = make(map[string]*template.Template)
+			}
+			t.sets[harness][kind] = tmpl
+		}
+	}
+	return t, nil
+}
+
+func mustParseTemplates(raw map[string]map[string]string) *Templates {
+	t, err := ParseTemplates(raw)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// Render renders kind for a push of msg to agentID's session. A configured
+// template that fails falls back to the built-in one.
+func (t *Templates) Render(kind, harness, sessionID, agentID string, msg Message) string {
+	data := newTemplateData(harness, sessionID, agentID, msg)
+	if tmpl := t.lookup(harness, kind); tmpl != nil {
+		var sb strings.Builder
+		err := tmpl.Execute(&sb, data)
+		if err == nil {
+			return sb.String()
+		}
+		slog.Warn("push template failed, using built-in", "template", tmpl.Name(), "error", err)
+	}
+	var sb strings.Builder
+	_ = builtins.lookup(harness, kind).Execute(&sb, data)
+	return sb.String()
+}
+
+func (t *Templates) lookup(harness, kind string) *template.Template {
+	if t == nil {
+		return nil
+	}
+	if tmpl := t.sets[harness][kind]; tmpl != nil {
+		return tmpl
+	}
+	return t.sets[templateDefaultHarness][kind]
+}
+
+// templateSetter is implemented by adapters that render templates.
+type templateSetter interface {
+	SetTemplates(t *Templates)
+}
+
+// SetTemplates hands t to every registered adapter that renders
+// templates, and to adapters registered later.
+func (r *Registry) SetTemplates(t *Templates) {
+	r.templates = t
+	for _, a := range r.adapters {
+		if s, ok := a.(templateSetter); ok {
+			s.SetTemplates(t)
+		}
+	}
+}
+
+func truncateRunes(n int, s string) string {
+	r := []rune(s)
+	if n < 0 || len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "..."
+}
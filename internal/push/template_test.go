@@ -0,0 +1,140 @@
+package push
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuiltinPromptUsesSenderProfile(t *testing.T) {
+	var tmpl *Templates
+	msg := Message{ID: "msg-1", From: "ag-a", FromName: "alice", FromRole: "reviewer", ToName: "bob", Body: "hi", Priority: "urgent", ThreadID: "thr-1"}
+	got := tmpl.Render(TemplatePrompt, "opencode", "ses-1", "ag-b", msg)
+	for _, want := range []string{"for bob.", "from: alice (reviewer) [ag-a]", "priority: urgent", "thread_id: thr-1", "body:\nhi"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("prompt missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestBuiltinPendingEntryIsOneLine(t *testing.T) {
+	var tmpl *Templates
+	msg := Message{ID: "msg-1", From: "ag-a", Body: strings.Repeat("line\n", 50), Priority: "blocking"}
+	got := tmpl.Render(TemplatePrompt, "claude-code", "ses-1", "ag-b", msg)
+	if strings.Contains(got, "\n") {
+		t.Fatalf("expected a single line, got %q", got)
+	}
+	if !strings.HasPrefix(got, "[BLOCKING] From: ag-a | Message: line line") || !strings.HasSuffix(got, "...") {
+		t.Fatalf("unexpected entry %q", got)
+	}
+}
+
+func TestConfiguredTemplatesOverrideBuiltins(t *testing.T) {
+	tmpl, err := ParseTemplates(map[string]map[string]string{
+		"opencode": {TemplatePrompt: "{{.Sender}}: {{truncate 3 .Body}}"},
+		"default":  {TemplateNotification: "ping {{.Recipient}}"},
+	})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	msg := Message{ID: "msg-1", From: "ag-a", FromName: "alice", Body: "hello"}
+	if got := tmpl.Render(TemplatePrompt, "opencode", "ses-1", "ag-b", msg); got != "alice: hel..." {
+		t.Fatalf("unexpected opencode prompt %q", got)
+	}
+	if got := tmpl.Render(TemplateNotification, "codex", "ses-1", "ag-b", msg); got != "ping ag-b" {
+		t.Fatalf("expected default notification, got %q", got)
+	}
+	// Kinds the config leaves out keep the built-in template.
+	if got := tmpl.Render(TemplatePrompt, "claude-code", "ses-1", "ag-b", msg); got != "From: alice | Message: hello" {
+		t.Fatalf("expected built-in pending entry, got %q", got)
+	}
+}
+
+func TestParseTemplatesRejectsBadTemplates(t *testing.T) {
+	cases := []map[string]map[string]string{
+		{"opencode": {TemplatePrompt: "{{.Sender"}},
+		{"opencode": {TemplatePrompt: "{{.NoSuchField}}"}},
+		{"opencode": {"subject": "x"}},
+	}
+	for _, raw := range cases {
+		if _, err := ParseTemplates(raw); err == nil {
+			t.Fatalf("expected error for %v", raw)
+		}
+	}
+}
+
+func TestLoadTemplatesMissingFile(t *testing.T) {
+	tmpl, err := LoadTemplates(filepath.Join(t.TempDir(), "templates.json"))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	got := tmpl.Render(TemplateNotification, "opencode", "ses-1", "ag-b", Message{From: "ag-a", Priority: "urgent"})
+	if got != "New urgent message for ag-b from ag-a" {
+		t.Fatalf("unexpected notification %q", got)
+	}
+}
+
+func TestRegistryAppliesTemplates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	if err := os.WriteFile(path, []byte(`{"claude-code": {"prompt": "{{.Sender}} ({{.FromRole}}) wrote"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := LoadTemplates(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	r := NewRegistry()
+	r.SetTemplates(tmpl)
+	a := NewClaudeCodeAdapter(t.TempDir())
+	r.Register(a)
+
+	if err := r.Push("claude-code", "ses-1", "ag-b", Message{ID: "msg-1", From: "ag-a", FromName: "alice", FromRole: "lead", Body: "x"}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	pending, err := a.DrainPending("ses-1")
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("expected one pending entry, got %v (%v)", pending, err)
+	}
+	if pending[0].Text != "alice (lead) wrote" {
+		t.Fatalf("unexpected entry text %q", pending[0].Text)
+	}
+}
+
+func TestOpenCodePushRendersTemplate(t *testing.T) {
+	var prompt string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/prompt_async") {
+			var body struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			}
+			data, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(data, &body)
+			prompt = body.Parts[0].Text
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	tmpl, err := ParseTemplates(map[string]map[string]string{"opencode": {TemplatePrompt: "[{{.Priority}}] {{.Sender}}: {{.Body}}"}})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	a := NewOpenCodeAdapter(srv.URL, time.Second, false)
+	a.SetTemplates(tmpl)
+	if err := a.Push("ses-1", "ag-b", Message{ID: "msg-1", From: "ag-a", FromName: "alice", Priority: "urgent", Body: "hello"}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if prompt != "[urgent] alice: hello" {
+		t.Fatalf("unexpected prompt %q", prompt)
+	}
+}
@@ -0,0 +1,23 @@
+package push
+
+// VSCodeAdapter implements push delivery for GitHub Copilot agent mode in
+// VS Code. Like Claude Code it has no prompt injection API; pending
+// messages go to a per-session state file that the Stop hook installed by
+// install-vscode drains.
+type VSCodeAdapter struct {
+	pendingStore
+}
+
+// NewVSCodeAdapter creates an adapter that writes pending messages to stateDir.
+func NewVSCodeAdapter(stateDir string) *VSCodeAdapter {
+	return &VSCodeAdapter{pendingStore{dir: stateDir}}
+}
+
+func (a *VSCodeAdapter) HarnessType() string { return "vscode" }
+
+func (a *VSCodeAdapter) Enabled() bool { return true }
+
+// Push records msg for the Copilot chat session bound as sessionID.
+func (a *VSCodeAdapter) Push(sessionID, agentID string, msg Message) error {
+	return a.push(a.HarnessType(), sessionID, agentID, msg)
+}
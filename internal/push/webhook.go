@@ -0,0 +1,190 @@
+package push
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	urlpkg "net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebhookSignatureHeader carries "sha256=<hex>", an HMAC-SHA256 of the
+// request body keyed with the agent's webhook secret.
+const WebhookSignatureHeader = "X-Relay-Mesh-Signature"
+
+// webhookEnvelopeType identifies relay-mesh message deliveries to receivers.
+const webhookEnvelopeType = "relay-mesh.message"
+
+// WebhookEndpoint is where and how an agent's messages are delivered.
+type WebhookEndpoint struct {
+	URL          string `json:"url"`
+	Secret       string `json:"secret"`
+	Timeout      string `json:"timeout,omitempty"`       // request timeout as a duration; empty uses the adapter default
+	ExpectStatus int    `json:"expect_status,omitempty"` // required response status; 0 accepts any 2xx
+}
+
+// WebhookEnvelope is the JSON body POSTed to a webhook endpoint.
+type WebhookEnvelope struct {
+	Type      string         `json:"type"`
+	AgentID   string         `json:"agent_id"`
+	Message   PendingMessage `json:"message"`
+	Delivered string         `json:"delivered_at"`
+}
+
+// WebhookAdapter implements push delivery for custom harnesses that expose
+// an HTTP endpoint. Endpoints are registered per agent at bind_session
+// time and kept in stateDir so they survive a server restart.
+type WebhookAdapter struct {
+	path           string
+	defaultTimeout time.Duration
+
+	mu        sync.Mutex
+	endpoints map[string]WebhookEndpoint // agent ID -> endpoint
+}
+
+// NewWebhookAdapter creates an adapter that keeps endpoints in stateDir.
+// A missing endpoints file is not an error.
+func NewWebhookAdapter(stateDir string, defaultTimeout time.Duration) (*WebhookAdapter, error) {
+	if defaultTimeout <= 0 {
+		defaultTimeout = 10 * time.Second
+	}
+	a := &WebhookAdapter{
+		path:           filepath.Join(stateDir, "endpoints.json"),
+		defaultTimeout: defaultTimeout,
+		endpoints:      make(map[string]WebhookEndpoint),
+	}
+	if err := loadJSONFile(a.path, &a.endpoints); err != nil {
+		return a, fmt.Errorf("load webhook endpoints: %w", err)
+	}
+	return a, nil
+}
+
+func (a *WebhookAdapter) HarnessType() string { return "webhook" }
+
+func (a *WebhookAdapter) Enabled() bool { return true }
+
+// Register sets agentID's endpoint, generating a secret if ep has none,
+// and returns the stored endpoint.
+func (a *WebhookAdapter) Register(agentID string, ep WebhookEndpoint) (WebhookEndpoint, error) {
+	agentID = strings.TrimSpace(agentID)
+	if agentID == "" {
+		return WebhookEndpoint{}, fmt.Errorf("agent_id is required")
+	}
+	ep.URL = strings.TrimSpace(ep.URL)
+	u, err := urlpkg.Parse(ep.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return WebhookEndpoint{}, fmt.Errorf("invalid webhook url %q: must be http or https", ep.URL)
+	}
+	if ep.Timeout != "" {
+		if d, err := time.ParseDuration(ep.Timeout); err != nil || d <= 0 {
+			return WebhookEndpoint{}, fmt.Errorf("invalid webhook timeout %q", ep.Timeout)
+		}
+	}
+	if ep.ExpectStatus != 0 && (ep.ExpectStatus < 100 || ep.ExpectStatus > 599) {
+		return WebhookEndpoint{}, fmt.Errorf("invalid expected status %d", ep.ExpectStatus)
+	}
+	if ep.Secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return WebhookEndpoint{}, fmt.Errorf("generate webhook secret: %w", err)
+		}
+		ep.Secret = hex.EncodeToString(buf)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	prev, had := a.endpoints[agentID]
+	a.endpoints[agentID] = ep
+	if err := saveJSONFile(a.path, a.endpoints); err != nil {
+		if had {
+			a.endpoints[agentID] = prev
+		} else {
+			delete(a.endpoints, agentID)
+		}
+		return WebhookEndpoint{}, err
+	}
+	return ep, nil
+}
+
+// Unregister forgets agentID's endpoint.
+func (a *WebhookAdapter) Unregister(agentID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.endpoints[agentID]; !ok {
+		return
+	}
+	delete(a.endpoints, agentID)
+	_ = saveJSONFile(a.path, a.endpoints)
+}
+
+// Endpoint returns agentID's registered endpoint.
+func (a *WebhookAdapter) Endpoint(agentID string) (WebhookEndpoint, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ep, ok := a.endpoints[agentID]
+	return ep, ok
+}
+
+// Push POSTs a signed envelope for msg to agentID's endpoint. sessionID is
+// the endpoint URL the agent was bound with.
+func (a *WebhookAdapter) Push(sessionID, agentID string, msg Message) error {
+	ep, ok := a.Endpoint(agentID)
+	if !ok {
+		return fmt.Errorf("no webhook endpoint registered for %s", agentID)
+	}
+	body, err := json.Marshal(WebhookEnvelope{
+		Type:      webhookEnvelopeType,
+		AgentID:   agentID,
+		Message:   newPendingMessage(agentID, msg),
+		Delivered: time.Now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal webhook envelope: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, SignWebhookBody(ep.Secret, body))
+	req.Header.Set("X-Relay-Mesh-Agent", agentID)
+	req.Header.Set("X-Relay-Mesh-Message-Id", msg.ID)
+
+	timeout := a.defaultTimeout
+	if d, err := time.ParseDuration(ep.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return fmt.Errorf("http post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	ok = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if ep.ExpectStatus != 0 {
+		ok = resp.StatusCode == ep.ExpectStatus
+	}
+	if !ok {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 2048))
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// SignWebhookBody returns the signature header value for body, so
+// receivers can verify deliveries with the same secret.
+func SignWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,114 @@
+package push
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWebhookPushSignsEnvelope(t *testing.T) {
+	var gotBody []byte
+	var gotSig, gotAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSig = r.Header.Get(WebhookSignatureHeader)
+		gotAgent = r.Header.Get("X-Relay-Mesh-Agent")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	a, err := NewWebhookAdapter(t.TempDir(), time.Second)
+	if err != nil {
+		t.Fatalf("new adapter: %v", err)
+	}
+	if a.HarnessType() != "webhook" || !a.Enabled() {
+		t.Fatalf("unexpected adapter state: %q %v", a.HarnessType(), a.Enabled())
+	}
+	if err := a.Push(srv.URL, "ag-b", Message{ID: "msg-1"}); err == nil {
+		t.Fatal("expected push without a registered endpoint to fail")
+	}
+	ep, err := a.Register("ag-b", WebhookEndpoint{URL: srv.URL, Secret: "s3cret"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	msg := Message{ID: "msg-1", From: "ag-a", Body: "hello", Priority: "urgent", ThreadID: "th-1"}
+	if err := a.Push(ep.URL, "ag-b", msg); err != nil {
+		t.Fatalf("push failed: %v", err)
+	}
+	if gotSig != SignWebhookBody("s3cret", gotBody) {
+		t.Fatalf("signature mismatch: %s", gotSig)
+	}
+	if gotAgent != "ag-b" {
+		t.Fatalf("unexpected agent header: %s", gotAgent)
+	}
+	var env WebhookEnvelope
+	if err := json.Unmarshal(gotBody, &env); err != nil {
+		t.Fatalf("decode envelope: %v", err)
+	}
+	if env.Type != "relay-mesh.message" || env.AgentID != "ag-b" || env.Message.MessageID != "msg-1" || env.Message.Body != "hello" || env.Message.Priority != "urgent" {
+		t.Fatalf("unexpected envelope: %+v", env)
+	}
+}
+
+func TestWebhookExpectedStatusAndTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	a, _ := NewWebhookAdapter(t.TempDir(), time.Second)
+	a.Register("ag-b", WebhookEndpoint{URL: srv.URL, ExpectStatus: http.StatusNoContent})
+	if err := a.Push(srv.URL, "ag-b", Message{ID: "msg-1"}); err == nil {
+		t.Fatal("expected unexpected status to fail")
+	}
+	a.Register("ag-b", WebhookEndpoint{URL: srv.URL + "/slow", Timeout: "50ms"})
+	if err := a.Push(srv.URL, "ag-b", Message{ID: "msg-1"}); err == nil {
+		t.Fatal("expected timeout to fail")
+	}
+	a.Register("ag-b", WebhookEndpoint{URL: srv.URL})
+	if err := a.Push(srv.URL, "ag-b", Message{ID: "msg-1"}); err != nil {
+		t.Fatalf("expected any 2xx to succeed: %v", err)
+	}
+}
+
+func TestWebhookRegisterValidatesAndPersists(t *testing.T) {
+	dir := t.TempDir()
+	a, _ := NewWebhookAdapter(dir, time.Second)
+	for _, ep := range []WebhookEndpoint{
+		{URL: "ftp://example.com/hook"},
+		{URL: "not a url"},
+		{URL: "https://example.com/hook", Timeout: "soon"},
+		{URL: "https://example.com/hook", ExpectStatus: 42},
+	} {
+		if _, err := a.Register("ag-b", ep); err == nil {
+			t.Fatalf("expected %+v to be rejected", ep)
+		}
+	}
+	ep, err := a.Register("ag-b", WebhookEndpoint{URL: "https://example.com/hook"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if len(ep.Secret) != 64 {
+		t.Fatalf("expected a generated secret, got %q", ep.Secret)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "endpoints.json")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected private endpoints file, got %v %v", info, err)
+	}
+
+	b, err := NewWebhookAdapter(dir, time.Second)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got, ok := b.Endpoint("ag-b"); !ok || got != ep {
+		t.Fatalf("expected endpoint after reload, got %+v %v", got, ok)
+	}
+}